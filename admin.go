@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/gavinc95/go-blog/authz"
+	"github.com/gavinc95/go-blog/config"
+	"github.com/gavinc95/go-blog/db"
+)
+
+const adminUsage = `usage: go-blog admin <command> <email> [role]
+
+commands:
+  grant   grant the user a role, admin if none is given
+  revoke  take a role away from the user, admin if none is given
+  roles   list the user's roles
+
+Once there is an admin, roles can also be managed through the API.
+`
+
+// runAdmin implements the `admin` subcommand, mainly to create the first
+// admin
+func runAdmin(cfg config.Config, args []string) {
+	if len(args) < 2 || len(args) > 3 {
+		fmt.Fprint(os.Stderr, adminUsage)
+		os.Exit(2)
+	}
+	command, email := args[0], args[1]
+	role := authz.RoleAdmin
+	if len(args) == 3 {
+		role = args[2]
+	}
+
+	pg := MustDB(cfg.Database)
+	defer pg.Close()
+	store := db.NewBlogStore(pg, &db.GenID{})
+
+	user, err := store.GetUserByEmail(email)
+	if err != nil {
+		log.Fatalf("admin %s failed: %+v", command, err)
+	}
+	if user == nil {
+		log.Fatalf("no user with email %s", email)
+	}
+
+	switch command {
+	case "grant":
+		err = store.GrantRole(user.ID, role)
+	case "revoke":
+		err = store.RevokeRole(user.ID, role)
+	case "roles":
+	default:
+		fmt.Fprint(os.Stderr, adminUsage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("admin %s failed: %+v", command, err)
+	}
+
+	roles, err := store.GetUserRoles(user.ID)
+	if err != nil {
+		log.Fatalf("admin %s failed: %+v", command, err)
+	}
+	fmt.Printf("%s (%s) roles: %s\n", user.Email, user.ID, strings.Join(roles, ", "))
+}
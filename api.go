@@ -2,15 +2,18 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
+	"io"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/gavinc95/go-blog/authz"
 	"github.com/gavinc95/go-blog/db/models"
+	"github.com/gorilla/mux"
 )
 
-var (
-	ErrBadRequest = fmt.Errorf("Invalid request: missing required parameters")
-)
+// The ID fields of the requests below are only read from the body by the
+// legacy routes - the RESTful routes take them from the URL path instead.
 
 type GetUserRequest struct {
 	ID string `json:"id"` // required
@@ -20,6 +23,11 @@ type GetUserResponse struct {
 	User *models.User `json:"user"`
 }
 
+type GetAllUsersResponse struct {
+	Users []*models.User `json:"users"`
+	Page  PageInfo       `json:"page"`
+}
+
 type CreateUserRequest struct {
 	Email string `json:"email"` // required
 	Name  string `json:"name"`
@@ -48,9 +56,14 @@ type DeleteUserResponse struct {
 }
 
 type CreatePostRequest struct {
-	UserID  string `json:"user_id"` // required
-	Title   string `json:"title"`
-	Content string `json:"content"`
+	UserID  string               `json:"user_id"` // required
+	Title   string               `json:"title"`
+	Content string               `json:"content"`
+	Format  models.ContentFormat `json:"format"` // plain (the default), markdown or html
+	// Status is draft, scheduled or published (the default). Scheduled posts
+	// need a PublishAt in the future.
+	Status    models.PostStatus `json:"status"`
+	PublishAt *time.Time        `json:"publish_at"`
 }
 
 type CreatePostResponse struct {
@@ -58,15 +71,25 @@ type CreatePostResponse struct {
 }
 
 type UpdatePostRequest struct {
-	ID      string `json:"id"` // required
-	Title   string `json:"title"`
-	Content string `json:"content"`
+	ID      string               `json:"id"` // required
+	Title   string               `json:"title"`
+	Content string               `json:"content"`
+	Format  models.ContentFormat `json:"format"`
 }
 
 type UpdatePostResponse struct {
 	ID string `json:"id"`
 }
 
+type SetPostStatusRequest struct {
+	Status    models.PostStatus `json:"status"`     // required
+	PublishAt *time.Time        `json:"publish_at"` // required when scheduling
+}
+
+type SetPostStatusResponse struct {
+	ID string `json:"id"`
+}
+
 type GetPostRequest struct {
 	ID string `json:"id"` // required
 }
@@ -81,6 +104,7 @@ type GetAllPostsRequest struct {
 
 type GetAllPostsResponse struct {
 	Posts []*models.Post `json:"posts"`
+	Page  PageInfo       `json:"page"`
 }
 
 type DeletePostRequest struct {
@@ -91,253 +115,510 @@ type DeletePostResponse struct {
 	ID string `json:"id"`
 }
 
+// publicStatuses are the statuses of posts that anyone can read. Drafts and
+// scheduled posts are only shown to those who can edit them.
+var publicStatuses = []models.PostStatus{models.PostPublished, models.PostArchived}
+
+func isPublic(status models.PostStatus) bool {
+	return status == models.PostPublished || status == models.PostArchived
+}
+
+// contentFormat checks an optional content format
+func (v *validator) contentFormat(field string, format models.ContentFormat) {
+	switch format {
+	case "", models.FormatPlain, models.FormatMarkdown, models.FormatHTML:
+	default:
+		v.add(field, "must be one of plain, markdown, html")
+	}
+}
+
+// postStatus checks that status is one of allowed, and that publishAt is set
+// for scheduled posts and only for them
+func (v *validator) postStatus(status models.PostStatus, publishAt *time.Time, allowed ...models.PostStatus) {
+	known := false
+	names := make([]string, len(allowed))
+	for i, s := range allowed {
+		names[i] = string(s)
+		known = known || s == status
+	}
+	if !known {
+		v.add("status", "must be one of "+strings.Join(names, ", "))
+	}
+
+	switch {
+	case status == models.PostScheduled && publishAt == nil:
+		v.add("publish_at", "is required for scheduled posts")
+	case status != models.PostScheduled && publishAt != nil:
+		v.add("publish_at", "is only allowed for scheduled posts")
+	}
+}
+
+// decodeRequest fills req from the JSON body. An empty body is allowed, since
+// the RESTful routes don't need one for GET and DELETE.
+func decodeRequest(r *http.Request, req interface{}) error {
+	if r.Body == nil {
+		return nil
+	}
+
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// pathID returns the {id} route variable, or the ID from the body for legacy routes
+func pathID(r *http.Request, bodyID string) string {
+	if id, ok := mux.Vars(r)["id"]; ok {
+		return id
+	}
+	return bodyID
+}
+
+// isLegacyRoute reports whether the request came in on a route that takes
+// its ID from the body
+func isLegacyRoute(r *http.Request) bool {
+	_, ok := mux.Vars(r)["id"]
+	return !ok
+}
+
+// setLocation points the Location header at a newly created resource
+func (a *App) setLocation(w http.ResponseWriter, routeName, id string) {
+	if u, err := a.Router.Get(routeName).URL("id", id); err == nil {
+		w.Header().Set("Location", u.String())
+	}
+}
+
+// authorizePost checks that the caller may perform action on an existing
+// post, writing a 404 if there is no such post
+func (a *App) authorizePost(w http.ResponseWriter, r *http.Request, action authz.Action, postID string) bool {
+	post, err := a.BlogStore.GetPost(postID)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return false
+	}
+	if post == nil {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "post not found")
+		return false
+	}
+	return a.authorize(w, r, action, authz.Post(post))
+}
+
 func (a *App) HandleGetUser(w http.ResponseWriter, r *http.Request) {
 	var req GetUserRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+	err := decodeRequest(r, &req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeDecodeError(w, r, err)
 		return
 	}
 
+	req.ID = pathID(r, req.ID)
+
 	// validate the request
-	if req.ID == "" {
-		http.Error(w, ErrBadRequest.Error(), http.StatusBadRequest)
+	var v validator
+	v.required("id", req.ID)
+	if v.failed() {
+		writeValidationError(w, r, v.details)
 		return
 	}
 
 	user, err := a.BlogStore.GetUser(req.ID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStoreError(w, r, err)
 		return
 	}
+	if user == nil {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "user not found")
+		return
+	}
+
 	res := GetUserResponse{user}
-	err = json.NewEncoder(w).Encode(res)
+	writeJSON(w, r, http.StatusOK, res)
+}
+
+// HandleGetAllUsers lists every user for admins, sorted by name or email and
+// optionally narrowed down to those whose name or email starts with search
+func (a *App) HandleGetAllUsers(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r, authz.List, authz.AllUsers()) {
+		return
+	}
+
+	// validate the request
+	var v validator
+	opts, from := a.userListOptions(r, &v)
+	if v.failed() {
+		writeValidationError(w, r, v.details)
+		return
+	}
+
+	page, err := a.BlogStore.GetAllUsers(opts)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStoreError(w, r, err)
 		return
 	}
+
+	res := GetAllUsersResponse{Users: page.Users, Page: userPageInfo(page, opts, from)}
+	writeJSON(w, r, http.StatusOK, res)
 }
 
+// HandleCreateUser creates a user without a password, which only admins may
+// do. Everyone else signs up through /auth/register.
 func (a *App) HandleCreateUser(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r, authz.Create, authz.AllUsers()) {
+		return
+	}
+
 	var req CreateUserRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+	err := decodeRequest(r, &req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeDecodeError(w, r, err)
 		return
 	}
 
 	// validate the request
-	if req.Email == "" {
-		http.Error(w, ErrBadRequest.Error(), http.StatusBadRequest)
+	var v validator
+	v.email("email", req.Email, true)
+	if v.failed() {
+		writeValidationError(w, r, v.details)
 		return
 	}
 
-	userID, err := a.BlogStore.CreateUser(req.Name, req.Email)
+	userID, err := a.BlogStore.CreateUser(req.Name, req.Email, a.Config.Auth.DefaultRole)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStoreError(w, r, err)
 		return
 	}
 
 	res := CreateUserResponse{ID: userID}
-	err = json.NewEncoder(w).Encode(res)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+	a.setLocation(w, "user", userID)
+	writeJSON(w, r, http.StatusCreated, res)
 }
 
 func (a *App) HandleUpdateUser(w http.ResponseWriter, r *http.Request) {
 	var req UpdateUserRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+	err := decodeRequest(r, &req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeDecodeError(w, r, err)
 		return
 	}
 
+	req.ID = pathID(r, req.ID)
+
 	// validate the request
-	if req.ID == "" {
-		http.Error(w, ErrBadRequest.Error(), http.StatusBadRequest)
+	var v validator
+	v.required("id", req.ID)
+	v.email("email", req.Email, false)
+	if v.failed() {
+		writeValidationError(w, r, v.details)
 		return
 	}
 
-	userID, err := a.BlogStore.UpdateUser(req.ID, req.Name, req.Email)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if !a.authorize(w, r, authz.Update, authz.User(req.ID)) {
 		return
 	}
 
-	res := UpdateUserResponse{ID: userID}
-	err = json.NewEncoder(w).Encode(res)
+	userID, err := a.BlogStore.UpdateUser(req.ID, req.Name, req.Email)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStoreError(w, r, err)
 		return
 	}
+
+	res := UpdateUserResponse{ID: userID}
+	writeJSON(w, r, http.StatusOK, res)
 }
 
 func (a *App) HandleDeleteUser(w http.ResponseWriter, r *http.Request) {
 	var req DeleteUserRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+	err := decodeRequest(r, &req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeDecodeError(w, r, err)
 		return
 	}
 
+	req.ID = pathID(r, req.ID)
+
 	// validate the request
-	if req.ID == "" {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	var v validator
+	v.required("id", req.ID)
+	if v.failed() {
+		writeValidationError(w, r, v.details)
+		return
+	}
+
+	if !a.authorize(w, r, authz.Delete, authz.User(req.ID)) {
 		return
 	}
 
 	id, err := a.BlogStore.DeleteUser(req.ID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStoreError(w, r, err)
 		return
 	}
 
-	res := DeleteUserResponse{ID: id}
-	err = json.NewEncoder(w).Encode(res)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if !isLegacyRoute(r) {
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
+
+	res := DeleteUserResponse{ID: id}
+	writeJSON(w, r, http.StatusOK, res)
 }
 
 func (a *App) HandleGetAllPosts(w http.ResponseWriter, r *http.Request) {
 	var req GetAllPostsRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+	err := decodeRequest(r, &req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeDecodeError(w, r, err)
 		return
 	}
 
-	// validate the request
-	if req.UserID == "" {
-		http.Error(w, ErrBadRequest.Error(), http.StatusBadRequest)
-		return
-	}
+	req.UserID = pathID(r, req.UserID)
+	status := models.PostStatus(r.URL.Query().Get("status"))
 
-	posts, err := a.BlogStore.GetAllPosts(req.UserID)
+	// validate the request
+	var v validator
+	v.required("user_id", req.UserID)
+	if status != "" {
+		v.postStatus(status, nil, models.PostDraft, models.PostScheduled, models.PostPublished, models.PostArchived)
+	}
+	opts, from := a.postListOptions(r, &v)
+	if v.failed() {
+		writeValidationError(w, r, v.details)
+		return
+	}
+
+	// drafts and scheduled posts are only listed for those who can edit them
+	var statuses []models.PostStatus
+	if status != "" {
+		statuses = []models.PostStatus{status}
+		if !isPublic(status) && !a.authorize(w, r, authz.Update, authz.UserPosts(req.UserID)) {
+			return
+		}
+	} else {
+		editor, err := a.can(r, authz.Update, authz.UserPosts(req.UserID))
+		if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		if !editor {
+			statuses = publicStatuses
+		}
+	}
+
+	page, err := a.BlogStore.GetAllPosts(req.UserID, opts, statuses...)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStoreError(w, r, err)
 		return
 	}
 
-	res := GetAllPostsResponse{Posts: posts}
-	err = json.NewEncoder(w).Encode(res)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+	res := GetAllPostsResponse{Posts: page.Posts, Page: postPageInfo(page, opts, from)}
+	writeJSON(w, r, http.StatusOK, res)
 }
 
 func (a *App) HandleGetPost(w http.ResponseWriter, r *http.Request) {
 	var req GetPostRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+	err := decodeRequest(r, &req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeDecodeError(w, r, err)
 		return
 	}
 
+	req.ID = pathID(r, req.ID)
+
 	// validate the request
-	if req.ID == "" {
-		http.Error(w, ErrBadRequest.Error(), http.StatusBadRequest)
+	var v validator
+	v.required("id", req.ID)
+	if v.failed() {
+		writeValidationError(w, r, v.details)
 		return
 	}
 
-	post, err := a.BlogStore.GetPost(req.ID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	post, ok := a.readablePost(w, r, req.ID)
+	if !ok {
 		return
 	}
 
 	res := GetPostResponse{Post: post}
-	err = json.NewEncoder(w).Encode(res)
+	writeJSON(w, r, http.StatusOK, res)
+}
+
+// readablePost fetches a post the caller may read. If there isn't one, it
+// writes the error response and returns false.
+func (a *App) readablePost(w http.ResponseWriter, r *http.Request, postID string) (*models.Post, bool) {
+	post, err := a.BlogStore.GetPost(postID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+		writeStoreError(w, r, err)
+		return nil, false
+	}
+	if post == nil {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "post not found")
+		return nil, false
+	}
+
+	// to anyone who can't edit it, an unpublished post doesn't exist
+	if !isPublic(post.Status) {
+		editor, err := a.can(r, authz.Update, authz.Post(post))
+		if err != nil {
+			writeStoreError(w, r, err)
+			return nil, false
+		}
+		if !editor {
+			writeError(w, r, http.StatusNotFound, CodeNotFound, "post not found")
+			return nil, false
+		}
+	}
+	return post, true
 }
 
 func (a *App) HandleCreatePost(w http.ResponseWriter, r *http.Request) {
 	var req CreatePostRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+	err := decodeRequest(r, &req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeDecodeError(w, r, err)
 		return
 	}
 
+	if req.Status == "" {
+		req.Status = models.PostPublished
+	}
+	if req.Format == "" {
+		req.Format = models.FormatPlain
+	}
+
 	// validate the request
-	if req.UserID == "" {
-		http.Error(w, ErrBadRequest.Error(), http.StatusBadRequest)
+	var v validator
+	v.required("user_id", req.UserID)
+	v.contentFormat("format", req.Format)
+	v.postStatus(req.Status, req.PublishAt, models.PostDraft, models.PostScheduled, models.PostPublished)
+	if v.failed() {
+		writeValidationError(w, r, v.details)
 		return
 	}
 
-	postID, err := a.BlogStore.CreatePost(req.UserID, req.Title, req.Content)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if !a.authorize(w, r, authz.Create, authz.NewPost(req.UserID)) {
 		return
 	}
 
-	res := CreatePostResponse{ID: postID}
-	err = json.NewEncoder(w).Encode(res)
+	var publishAt time.Time
+	if req.PublishAt != nil {
+		publishAt = *req.PublishAt
+	}
+	postID, err := a.BlogStore.CreatePost(req.UserID, req.Title, req.Content, req.Format, req.Status, publishAt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStoreError(w, r, err)
 		return
 	}
+
+	res := CreatePostResponse{ID: postID}
+	a.setLocation(w, "post", postID)
+	writeJSON(w, r, http.StatusCreated, res)
 }
 
 func (a *App) HandleUpdatePost(w http.ResponseWriter, r *http.Request) {
 	var req UpdatePostRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+	err := decodeRequest(r, &req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeDecodeError(w, r, err)
 		return
 	}
 
+	req.ID = pathID(r, req.ID)
+
 	// validate the request
-	if req.ID == "" {
-		http.Error(w, ErrBadRequest.Error(), http.StatusBadRequest)
+	var v validator
+	v.required("id", req.ID)
+	v.contentFormat("format", req.Format)
+	if v.failed() {
+		writeValidationError(w, r, v.details)
+		return
+	}
+
+	if !a.authorizePost(w, r, authz.Update, req.ID) {
 		return
 	}
 
-	postID, err := a.BlogStore.UpdatePost(req.ID, req.Title, req.Content)
+	postID, err := a.BlogStore.UpdatePost(req.ID, currentUserID(r), req.Title, req.Content, req.Format)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStoreError(w, r, err)
 		return
 	}
 
 	res := UpdatePostResponse{ID: postID}
-	err = json.NewEncoder(w).Encode(res)
+	writeJSON(w, r, http.StatusOK, res)
+}
+
+// HandleSetPostStatus moves a post through its lifecycle: drafts can be
+// scheduled or published, published posts archived, and so on, as allowed by
+// the store
+func (a *App) HandleSetPostStatus(w http.ResponseWriter, r *http.Request) {
+	var req SetPostStatusRequest
+	err := decodeRequest(r, &req)
+	if err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+
+	// validate the request
+	var v validator
+	v.postStatus(req.Status, req.PublishAt, models.PostDraft, models.PostScheduled, models.PostPublished, models.PostArchived)
+	if v.failed() {
+		writeValidationError(w, r, v.details)
+		return
+	}
+
+	if !a.authorizePost(w, r, authz.Update, id) {
+		return
+	}
+
+	var publishAt time.Time
+	if req.PublishAt != nil {
+		publishAt = *req.PublishAt
+	}
+	postID, err := a.BlogStore.SetPostStatus(id, currentUserID(r), req.Status, publishAt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStoreError(w, r, err)
 		return
 	}
+
+	res := SetPostStatusResponse{ID: postID}
+	writeJSON(w, r, http.StatusOK, res)
 }
 
 func (a *App) HandleDeletePost(w http.ResponseWriter, r *http.Request) {
 	var req DeletePostRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+	err := decodeRequest(r, &req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeDecodeError(w, r, err)
 		return
 	}
 
+	req.ID = pathID(r, req.ID)
+
 	// validate the request
-	if req.ID == "" {
-		http.Error(w, ErrBadRequest.Error(), http.StatusBadRequest)
+	var v validator
+	v.required("id", req.ID)
+	if v.failed() {
+		writeValidationError(w, r, v.details)
+		return
+	}
+
+	if !a.authorizePost(w, r, authz.Delete, req.ID) {
 		return
 	}
 
 	postID, err := a.BlogStore.DeletePost(req.ID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStoreError(w, r, err)
 		return
 	}
 
-	res := DeletePostResponse{ID: postID}
-	err = json.NewEncoder(w).Encode(res)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if !isLegacyRoute(r) {
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
+
+	res := DeletePostResponse{ID: postID}
+	writeJSON(w, r, http.StatusOK, res)
 }
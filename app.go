@@ -1,101 +1,317 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"html/template"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 
+	"github.com/gavinc95/go-blog/auth"
+	"github.com/gavinc95/go-blog/config"
 	"github.com/gavinc95/go-blog/db"
+	"github.com/gavinc95/go-blog/db/migrations"
+	"github.com/gavinc95/go-blog/moderation"
 	"github.com/gorilla/mux"
 	_ "github.com/lib/pq"
+	"golang.org/x/xerrors"
 )
 
 type App struct {
 	BlogStore db.BlogStore
-	Addr      string
+	Config    config.Config
 	Router    *mux.Router
+
+	// TokenKeys signs and verifies bearer access tokens. NewApp loads it from
+	// Config.Auth.JWTKeysFile; bearer tokens are disabled while it is nil.
+	TokenKeys *auth.KeySet
+
+	// Classifier flags spam among new comments. NewApp sets up the built-in
+	// heuristic from Config.Moderation; replace it to use another filter.
+	Classifier moderation.Classifier
+
+	// pages are the frontend's templates, by name
+	pages map[string]*template.Template
 }
 
-func NewApp(addr string, idManager db.IDManager) *App {
-	pg := MustDB()
+// StoreStrategy builds the BlogStore for an app that wasn't given one
+type StoreStrategy func(cfg config.Config) (db.BlogStore, error)
+
+// StoreStrategies maps config.DatabaseConfig.Store to the strategy that builds it
+var StoreStrategies = map[string]StoreStrategy{
+	config.StorePostgres: PostgresStore,
+	config.StoreMemory:   MemoryStore,
+}
+
+// PostgresStore connects to Postgres using the database config
+func PostgresStore(cfg config.Config) (db.BlogStore, error) {
+	return db.NewBlogStore(MustDB(cfg.Database), &db.GenID{}), nil
+}
+
+// MemoryStore keeps everything in memory, which is handy for local development
+func MemoryStore(cfg config.Config) (db.BlogStore, error) {
+	return db.NewMemoryStore(&db.GenID{}), nil
+}
+
+// NewApp builds the app and registers its routes. If store is nil, one is
+// built using the strategy named by cfg.Database.Store.
+//
+// App is an http.Handler, so it can also be mounted inside a larger server
+// instead of calling Run.
+func NewApp(cfg config.Config, store db.BlogStore) (*App, error) {
+	if store == nil {
+		strategy, ok := StoreStrategies[cfg.Database.Store]
+		if !ok {
+			return nil, fmt.Errorf("unknown store %q", cfg.Database.Store)
+		}
+
+		var err error
+		store, err = strategy(cfg)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to create %s store: %w", cfg.Database.Store, err)
+		}
+	}
+
 	app := &App{
-		BlogStore: db.NewBlogStore(pg, idManager),
-		Addr:      addr,
-		Router:    mux.NewRouter(),
+		BlogStore:  store,
+		Config:     cfg,
+		Router:     mux.NewRouter(),
+		Classifier: moderation.NewHeuristic(cfg.Moderation.SpamKeywords, cfg.Moderation.MaxLinks),
+	}
+
+	if cfg.Auth.JWTKeysFile != "" {
+		keys, err := auth.LoadKeySet(cfg.Auth.JWTKeysFile, cfg.Auth.JWTSigningKey)
+		if err != nil {
+			return nil, err
+		}
+		app.TokenKeys = keys
+	}
+
+	if cfg.Features.Frontend {
+		pages, err := loadPages(cfg.Frontend.TemplatesDir)
+		if err != nil {
+			return nil, err
+		}
+		app.pages = pages
 	}
 
-	app.Router.HandleFunc("/users", app.HandleGetUser).Methods("GET")
+	app.Router.NotFoundHandler = http.HandlerFunc(notFoundHandler)
+	app.Router.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowedHandler)
+
+	// a bearer token takes precedence over a session cookie
+	app.Router.Use(app.withSession, app.withBearerToken)
+
+	// legacy routes go first, so that /posts/all isn't taken for /posts/{id}
+	if cfg.Features.LegacyRoutes {
+		app.registerLegacyRoutes()
+	}
+
+	app.Router.HandleFunc("/users", app.HandleGetAllUsers).Methods("GET")
 	app.Router.HandleFunc("/users", app.HandleCreateUser).Methods("POST")
-	app.Router.HandleFunc("/users", app.HandleUpdateUser).Methods("PUT")
-	app.Router.HandleFunc("/users", app.HandleDeleteUser).Methods("DELETE")
+	app.Router.HandleFunc("/users/{id}", app.HandleGetUser).Methods("GET").Name("user")
+	app.Router.HandleFunc("/users/{id}", app.HandleUpdateUser).Methods("PUT")
+	app.Router.HandleFunc("/users/{id}", app.HandleDeleteUser).Methods("DELETE")
+	app.Router.HandleFunc("/users/{id}/restore", app.HandleRestoreUser).Methods("POST")
+	app.Router.HandleFunc("/users/{id}/posts", app.HandleGetAllPosts).Methods("GET")
+	app.Router.HandleFunc("/users/{id}/roles", app.HandleGetUserRoles).Methods("GET")
+	app.Router.HandleFunc("/users/{id}/roles/{role}", app.HandleGrantRole).Methods("PUT")
+	app.Router.HandleFunc("/users/{id}/roles/{role}", app.HandleRevokeRole).Methods("DELETE")
+	app.Router.HandleFunc("/roles", app.HandleGetRoles).Methods("GET")
+
+	app.Router.HandleFunc("/auth/register", app.HandleRegister).Methods("POST")
+	app.Router.HandleFunc("/auth/login", app.HandleLogin).Methods("POST")
+	app.Router.HandleFunc("/auth/logout", app.HandleLogout).Methods("POST")
+	app.Router.HandleFunc("/auth/me", app.HandleMe).Methods("GET")
+	app.Router.HandleFunc("/auth/token", app.HandleToken).Methods("POST")
+	app.Router.HandleFunc("/auth/token/refresh", app.HandleRefreshToken).Methods("POST")
+	app.Router.HandleFunc("/auth/token/revoke", app.HandleRevokeToken).Methods("POST")
 
-	app.Router.HandleFunc("/posts", app.HandleGetPost).Methods("GET")
-	app.Router.HandleFunc("/posts/all", app.HandleGetAllPosts).Methods("GET")
 	app.Router.HandleFunc("/posts", app.HandleCreatePost).Methods("POST")
-	app.Router.HandleFunc("/posts", app.HandleUpdatePost).Methods("PUT")
-	app.Router.HandleFunc("/posts", app.HandleDeletePost).Methods("DELETE")
-	return app
+	app.Router.HandleFunc("/posts/{id}", app.HandleGetPost).Methods("GET").Name("post")
+	app.Router.HandleFunc("/posts/{id}", app.HandleUpdatePost).Methods("PUT")
+	app.Router.HandleFunc("/posts/{id}", app.HandleDeletePost).Methods("DELETE")
+	app.Router.HandleFunc("/posts/{id}/status", app.HandleSetPostStatus).Methods("PUT")
+	app.Router.HandleFunc("/posts/{id}/revisions", app.HandleGetRevisions).Methods("GET")
+	app.Router.HandleFunc("/posts/{id}/revisions/{number:[0-9]+}", app.HandleGetRevision).Methods("GET")
+	app.Router.HandleFunc("/posts/{id}/revisions/{number:[0-9]+}/restore", app.HandleRestoreRevision).Methods("POST")
+	app.Router.HandleFunc("/posts/{id}/diff", app.HandleGetRevisionDiff).Methods("GET")
+	app.Router.HandleFunc("/posts/{id}/restore", app.HandleRestorePost).Methods("POST")
+	app.Router.HandleFunc("/posts/{id}/tags/{tag}", app.HandleTagPost).Methods("PUT")
+	app.Router.HandleFunc("/posts/{id}/tags/{tag}", app.HandleUntagPost).Methods("DELETE")
+	app.Router.HandleFunc("/posts/{id}/categories", app.HandleGetPostCategories).Methods("GET")
+	app.Router.HandleFunc("/posts/{id}/categories/{category}", app.HandleCategorizePost).Methods("PUT")
+	app.Router.HandleFunc("/posts/{id}/categories/{category}", app.HandleUncategorizePost).Methods("DELETE")
+	app.Router.HandleFunc("/posts/{id}/comments", app.HandleGetComments).Methods("GET")
+	app.Router.HandleFunc("/posts/{id}/comments", app.HandleCreateComment).Methods("POST")
+	app.Router.HandleFunc("/posts/{id}/comments/{comment}", app.HandleGetComment).Methods("GET").Name("comment")
+	app.Router.HandleFunc("/posts/{id}/comments/{comment}", app.HandleUpdateComment).Methods("PUT")
+	app.Router.HandleFunc("/posts/{id}/comments/{comment}", app.HandleDeleteComment).Methods("DELETE")
+	app.Router.HandleFunc("/posts/{id}/comments/{comment}/replies", app.HandleGetReplies).Methods("GET")
+	app.Router.HandleFunc("/moderation/comments", app.HandleGetModerationQueue).Methods("GET")
+	app.Router.HandleFunc("/moderation/comments", app.HandleModerateComments).Methods("POST")
+	app.Router.HandleFunc("/feed", app.HandleGetFeed).Methods("GET")
+	app.Router.HandleFunc("/search", app.HandleSearch).Methods("GET")
+	app.Router.HandleFunc("/tags", app.HandleGetTags).Methods("GET")
+	app.Router.HandleFunc("/tags/{tag}/posts", app.HandleGetTagPosts).Methods("GET")
+	app.Router.HandleFunc("/categories", app.HandleGetCategories).Methods("GET")
+	app.Router.HandleFunc("/categories", app.HandleCreateCategory).Methods("POST")
+	app.Router.HandleFunc("/categories/{id}", app.HandleGetCategory).Methods("GET").Name("category")
+	app.Router.HandleFunc("/categories/{id}", app.HandleDeleteCategory).Methods("DELETE")
+	app.Router.HandleFunc("/categories/{id}/posts", app.HandleGetCategoryPosts).Methods("GET")
+	app.Router.HandleFunc("/trash", app.HandleGetTrash).Methods("GET")
+
+	if cfg.Features.Frontend {
+		app.registerFrontendRoutes()
+	}
+	return app, nil
 }
 
-const (
-	usersTableCreationQuery = `CREATE TABLE IF NOT EXISTS users
-	(
-		id UUID NOT NULL,
-		name varchar,
-		email varchar,
-
-		PRIMARY KEY (id),
-		UNIQUE (email)
-	)
-	`
-
-	postsTableCreationQuery = `CREATE TABLE IF NOT EXISTS posts
-	(
-		id UUID NOT NULL,
-		user_id UUID NOT NULL, 
-		title varchar NOT NULL,
-	 	content TEXT,
-
-		PRIMARY KEY (id),
-		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_user_id ON posts(user_id);
-	`
-)
+// registerLegacyRoutes adds the original routes, which take IDs from the JSON
+// body, for clients that haven't moved to the RESTful routes yet
+func (a *App) registerLegacyRoutes() {
+	// GET /users without a body is the user listing
+	a.Router.HandleFunc("/users", a.HandleGetUser).Methods("GET").MatcherFunc(hasBody)
+	a.Router.HandleFunc("/users", a.HandleUpdateUser).Methods("PUT")
+	a.Router.HandleFunc("/users", a.HandleDeleteUser).Methods("DELETE")
 
-func (a *App) ensureTablesExists() {
-	log.Printf("creating Users table")
-	if _, err := a.BlogStore.GetDB().Exec(usersTableCreationQuery); err != nil {
-		log.Fatal(err)
+	a.Router.HandleFunc("/posts", a.HandleGetPost).Methods("GET")
+	a.Router.HandleFunc("/posts/all", a.HandleGetAllPosts).Methods("GET")
+	a.Router.HandleFunc("/posts", a.HandleUpdatePost).Methods("PUT")
+	a.Router.HandleFunc("/posts", a.HandleDeletePost).Methods("DELETE")
+}
+
+func hasBody(r *http.Request, _ *mux.RouteMatch) bool {
+	return r.ContentLength != 0
+}
+
+// ServeHTTP runs the router behind the middleware that every route shares
+func (a *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	withRequestID(withRecovery(a.Router)).ServeHTTP(w, r)
+}
+
+// migrate brings the schema up to date, refusing to start if the database
+// has been migrated by a newer version of the app
+func (a *App) migrate() error {
+	if a.BlogStore.GetDB() == nil {
+		// nothing to migrate, e.g. the memory store
+		return nil
 	}
 
-	log.Printf("creating Post table")
-	if _, err := a.BlogStore.GetDB().Exec(postsTableCreationQuery); err != nil {
-		log.Fatal(err)
+	migrator, err := migrations.NewMigrator(a.BlogStore.GetDB(), migrations.All)
+	if err != nil {
+		return err
 	}
-}
 
-func (a *App) Run() {
-	defer a.Close()
+	applied, err := migrator.Up()
+	if err != nil {
+		return xerrors.Errorf("failed to migrate database: %w", err)
+	}
+	for _, m := range applied {
+		log.Printf("applied migration %d (%s)", m.Version, m.Name)
+	}
+	return nil
+}
 
-	// create the relevant DB tables
-	a.ensureTablesExists()
+// checkSchema refuses to run against a database migrated by a newer version
+// of the app, for when migrations aren't applied automatically
+func (a *App) checkSchema() error {
+	if a.BlogStore.GetDB() == nil {
+		return nil
+	}
 
-	// start the HTTP server
-	log.Printf("HTTP server listening on port: %s", a.Addr)
-	log.Fatal(http.ListenAndServe(a.Addr, a.Router))
+	migrator, err := migrations.NewMigrator(a.BlogStore.GetDB(), migrations.All)
+	if err != nil {
+		return err
+	}
+	return migrator.Check()
 }
 
-func (a *App) Close() error {
-	if _, err := a.BlogStore.GetDB().Exec("DROP TABLE posts;"); err != nil {
+// Run serves HTTP, and publishes scheduled posts, until the server fails or
+// the process receives SIGINT or SIGTERM, in which case in-flight requests
+// are given the configured shutdown timeout to finish before the database is
+// closed.
+func (a *App) Run() error {
+	defer a.Close()
+
+	// create or update the relevant DB tables
+	if a.Config.Features.AutoMigrate {
+		if err := a.migrate(); err != nil {
+			return err
+		}
+	} else if err := a.checkSchema(); err != nil {
 		return err
 	}
 
-	if _, err := a.BlogStore.GetDB().Exec("DROP TABLE users;"); err != nil {
+	// re-indexes every post if the language has changed since the last run
+	if err := a.BlogStore.SetSearchLanguage(a.Config.Search.Language); err != nil {
+		return xerrors.Errorf("failed to set the search language: %w", err)
+	}
+	// posts written before they had formats are rendered once
+	if n, err := a.BlogStore.RenderPosts(); err != nil {
+		return xerrors.Errorf("failed to render posts: %w", err)
+	} else if n > 0 {
+		log.Printf("rendered %d posts", n)
+	}
+
+	srv := &http.Server{
+		Addr:         a.Config.Server.Addr,
+		Handler:      a,
+		ReadTimeout:  a.Config.Server.ReadTimeout,
+		WriteTimeout: a.Config.Server.WriteTimeout,
+		IdleTimeout:  a.Config.Server.IdleTimeout,
+	}
+
+	// the background jobs are stopped before the database is closed
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	var jobs sync.WaitGroup
+	for _, job := range []func(context.Context){a.RunScheduler, a.RunPurger} {
+		jobs.Add(1)
+		go func(job func(context.Context)) {
+			defer jobs.Done()
+			job(jobsCtx)
+		}(job)
+	}
+	defer func() {
+		stopJobs()
+		jobs.Wait()
+	}()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("HTTP server listening on port: %s", srv.Addr)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	select {
+	case err := <-serveErr:
 		return err
+	case sig := <-stop:
+		log.Printf("received %s, shutting down (timeout %s)", sig, a.Config.Server.ShutdownTimeout)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.Config.Server.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		return xerrors.Errorf("failed to drain in-flight requests: %w", err)
+	}
+
+	log.Printf("HTTP server stopped")
+	return nil
+}
+
+// Close releases the database connection pool. It never modifies any data.
+func (a *App) Close() error {
+	if a.BlogStore.GetDB() == nil {
+		return nil
 	}
 
 	if err := a.BlogStore.GetDB().Close(); err != nil {
@@ -105,25 +321,15 @@ func (a *App) Close() error {
 	return nil
 }
 
-func MustDB() *sql.DB {
-	user := getEnvWithDefault("POSTGRES_USER", "postgres")
-	password := getEnvWithDefault("POSTGRES_PASSWORD", "password")
-	dbname := getEnvWithDefault("APP_DB_NAME", "postgres")
-	connectionString :=
-		fmt.Sprintf("user=%s password=%s dbname=%s sslmode=disable", user, password, dbname)
-
-	db, err := sql.Open("postgres", connectionString)
+// MustDB opens a Postgres connection pool using the database config
+func MustDB(cfg config.DatabaseConfig) *sql.DB {
+	db, err := sql.Open("postgres", cfg.ConnString())
 	if err != nil {
 		log.Panicf("failed to open postgres: %+v", err)
 	}
 
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
 	return db
 }
-
-func getEnvWithDefault(name, defaultValue string) string {
-	val := os.Getenv(name)
-	if val == "" {
-		val = defaultValue
-	}
-	return val
-}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gavinc95/go-blog/auth"
+	"github.com/gavinc95/go-blog/authz"
+	"github.com/gavinc95/go-blog/db"
+	"github.com/gavinc95/go-blog/db/models"
+	"golang.org/x/xerrors"
+)
+
+type RegisterRequest struct {
+	Email    string `json:"email"`    // required
+	Password string `json:"password"` // required
+	Name     string `json:"name"`
+}
+
+type RegisterResponse struct {
+	ID string `json:"id"`
+}
+
+type LoginRequest struct {
+	Email    string `json:"email"`    // required
+	Password string `json:"password"` // required
+}
+
+type LoginResponse struct {
+	UserID    string    `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+const userIDKey contextKey = "user_id"
+
+// currentUserID returns the ID of the logged-in user, or "" for anonymous
+// requests
+func currentUserID(r *http.Request) string {
+	id, _ := r.Context().Value(userIDKey).(string)
+	return id
+}
+
+func withUserID(r *http.Request, userID string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userIDKey, userID))
+}
+
+// subject describes the caller for authorization checks
+func (a *App) subject(r *http.Request) (authz.Subject, error) {
+	sub := authz.Subject{UserID: currentUserID(r)}
+	if sub.UserID == "" {
+		return sub, nil
+	}
+
+	permissions, err := a.BlogStore.GetUserPermissions(sub.UserID)
+	if err != nil {
+		return sub, err
+	}
+	sub.Permissions = permissions
+	return sub, nil
+}
+
+// authorize checks that the caller may perform action on res. If not, it
+// writes the error response and returns false.
+func (a *App) authorize(w http.ResponseWriter, r *http.Request, action authz.Action, res authz.Resource) bool {
+	sub, err := a.subject(r)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return false
+	}
+
+	if err := authz.Authorize(sub, action, res); err != nil {
+		writeAuthzError(w, r, err)
+		return false
+	}
+	return true
+}
+
+// can reports whether the caller may perform action on res, for responses
+// that show some callers more rather than turning the others away
+func (a *App) can(r *http.Request, action authz.Action, res authz.Resource) (bool, error) {
+	sub, err := a.subject(r)
+	if err != nil {
+		return false, err
+	}
+	return authz.Authorize(sub, action, res) == nil, nil
+}
+
+// password checks a new password against the limits in package auth
+func (v *validator) password(field, val string) {
+	switch {
+	case val == "":
+		v.add(field, "is required")
+	case len(val) < auth.MinPasswordLength:
+		v.add(field, fmt.Sprintf("must be at least %d characters", auth.MinPasswordLength))
+	case len(val) > auth.MaxPasswordLength:
+		v.add(field, fmt.Sprintf("must be at most %d bytes", auth.MaxPasswordLength))
+	}
+}
+
+// withSession loads the session named by the request's cookie, if any, and
+// marks the request as coming from its user. Expired sessions are dropped and
+// old ones are rotated to a new ID.
+func (a *App) withSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(a.Config.Auth.CookieName)
+		if err != nil || cookie.Value == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		sessionID := auth.TokenID(cookie.Value)
+		session, err := a.BlogStore.GetSession(sessionID)
+		if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+
+		now := time.Now()
+		if session == nil || !now.Before(session.ExpiresAt) {
+			if session != nil {
+				a.deleteSession(r, sessionID)
+			}
+			a.clearSessionCookie(w)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rotateAfter := a.Config.Auth.SessionRotateAfter
+		if rotateAfter > 0 && now.Sub(session.CreatedAt) >= rotateAfter {
+			session, err = a.rotateSession(w, sessionID, session.UserID)
+			if xerrors.Is(err, errSessionGone) {
+				// a concurrent request rotated it first, and the client
+				// will have the new cookie from that response
+				next.ServeHTTP(w, r)
+				return
+			}
+			if err != nil {
+				writeStoreError(w, r, err)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, withUserID(r, session.UserID))
+	})
+}
+
+var errSessionGone = xerrors.New("session was rotated or deleted")
+
+// startSession logs the user in, replacing any session the client already has
+// so that a session ID set before login can't be reused after it
+func (a *App) startSession(w http.ResponseWriter, r *http.Request, userID string) (*models.Session, error) {
+	if cookie, err := r.Cookie(a.Config.Auth.CookieName); err == nil && cookie.Value != "" {
+		a.deleteSession(r, auth.TokenID(cookie.Value))
+	}
+
+	token, err := auth.NewToken()
+	if err != nil {
+		return nil, err
+	}
+
+	session := a.newSession(token, userID)
+	if err := a.BlogStore.CreateSession(session); err != nil {
+		return nil, err
+	}
+	a.setSessionCookie(w, token, session)
+	return session, nil
+}
+
+func (a *App) rotateSession(w http.ResponseWriter, oldID, userID string) (*models.Session, error) {
+	token, err := auth.NewToken()
+	if err != nil {
+		return nil, err
+	}
+
+	session := a.newSession(token, userID)
+	if err := a.BlogStore.RotateSession(oldID, session); err != nil {
+		if xerrors.Is(err, db.ErrNotFound) {
+			return nil, errSessionGone
+		}
+		return nil, err
+	}
+	a.setSessionCookie(w, token, session)
+	return session, nil
+}
+
+func (a *App) newSession(token, userID string) *models.Session {
+	now := time.Now().UTC()
+	return &models.Session{
+		ID:        auth.TokenID(token),
+		UserID:    userID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(a.Config.Auth.SessionTTL),
+	}
+}
+
+// deleteSession removes a session that the client is done with. Failing to
+// remove it isn't worth failing the request for - it will expire anyway.
+func (a *App) deleteSession(r *http.Request, sessionID string) {
+	err := a.BlogStore.DeleteSession(sessionID)
+	if err != nil && !xerrors.Is(err, db.ErrNotFound) {
+		logError(r, xerrors.Errorf("failed to delete session: %w", err))
+	}
+}
+
+func (a *App) setSessionCookie(w http.ResponseWriter, token string, session *models.Session) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     a.Config.Auth.CookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+		MaxAge:   int(time.Until(session.ExpiresAt).Seconds()),
+		Secure:   a.Config.Auth.CookieSecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (a *App) clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     a.Config.Auth.CookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   a.Config.Auth.CookieSecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (a *App) HandleRegister(w http.ResponseWriter, r *http.Request) {
+	var req RegisterRequest
+	err := decodeRequest(r, &req)
+	if err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	// validate the request
+	var v validator
+	v.email("email", req.Email, true)
+	v.password("password", req.Password)
+	if v.failed() {
+		writeValidationError(w, r, v.details)
+		return
+	}
+
+	hash, err := auth.HashPassword(req.Password, a.Config.Auth.BcryptCost)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	userID, err := a.BlogStore.CreateUserWithPassword(req.Name, req.Email, hash, a.Config.Auth.DefaultRole)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	if _, err := a.startSession(w, r, userID); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	res := RegisterResponse{ID: userID}
+	a.setLocation(w, "user", userID)
+	writeJSON(w, r, http.StatusCreated, res)
+}
+
+func (a *App) HandleLogin(w http.ResponseWriter, r *http.Request) {
+	var req LoginRequest
+	err := decodeRequest(r, &req)
+	if err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	// validate the request
+	var v validator
+	v.required("email", req.Email)
+	v.required("password", req.Password)
+	if v.failed() {
+		writeValidationError(w, r, v.details)
+		return
+	}
+
+	user, ok := a.checkCredentials(w, r, req.Email, req.Password)
+	if !ok {
+		return
+	}
+
+	session, err := a.startSession(w, r, user.ID)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	res := LoginResponse{UserID: user.ID, ExpiresAt: session.ExpiresAt}
+	writeJSON(w, r, http.StatusOK, res)
+}
+
+// checkCredentials looks up the user with the given email and password. If
+// there isn't one, it writes the error response and returns false.
+func (a *App) checkCredentials(w http.ResponseWriter, r *http.Request, email, password string) (*models.User, bool) {
+	user, err := a.BlogStore.GetUserByEmail(email)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return nil, false
+	}
+
+	// unknown emails are checked against an empty hash, so they take as
+	// long as a wrong password and get the same response
+	var hash string
+	if user != nil {
+		hash = user.PasswordHash
+	}
+	if err := auth.CheckPassword(hash, password, a.Config.Auth.BcryptCost); err != nil {
+		writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, auth.ErrInvalidCredentials.Error())
+		return nil, false
+	}
+	return user, true
+}
+
+func (a *App) HandleLogout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(a.Config.Auth.CookieName); err == nil && cookie.Value != "" {
+		a.deleteSession(r, auth.TokenID(cookie.Value))
+	}
+
+	a.clearSessionCookie(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleMe returns the logged-in user
+func (a *App) HandleMe(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+	if userID == "" {
+		writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, "not logged in")
+		return
+	}
+
+	user, err := a.BlogStore.GetUser(userID)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if user == nil {
+		writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, "not logged in")
+		return
+	}
+
+	res := GetUserResponse{User: user}
+	writeJSON(w, r, http.StatusOK, res)
+}
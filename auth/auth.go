@@ -0,0 +1,90 @@
+// Package auth holds the credential handling shared by the API: password
+// hashing, the opaque tokens behind sessions and refresh tokens, and signed
+// access tokens (JWTs).
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/xerrors"
+)
+
+// bcrypt ignores anything past the first 72 bytes of a password
+const (
+	MinPasswordLength = 8
+	MaxPasswordLength = 72
+)
+
+var ErrInvalidCredentials = xerrors.New("invalid email or password")
+
+// HashPassword returns the bcrypt hash of password to store in place of it
+func HashPassword(password string, cost int) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	if err != nil {
+		return "", xerrors.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// CheckPassword returns ErrInvalidCredentials unless password matches hash.
+// An empty hash never matches - pass one for users created without a password
+// and for unknown emails, so that they take as long to reject as a bad password
+// hashed at cost, the cost new passwords are hashed at.
+func CheckPassword(hash, password string, cost int) error {
+	noHash := hash == ""
+	if noHash {
+		hash = dummyHash(cost)
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err != nil || noHash {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+var (
+	dummyMu sync.Mutex
+	dummies = make(map[int]string)
+)
+
+// dummyHash returns a hash of a random password at cost, made the first time
+// it is asked for
+func dummyHash(cost int) string {
+	dummyMu.Lock()
+	defer dummyMu.Unlock()
+
+	if hash, ok := dummies[cost]; ok {
+		return hash
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	hash, err := bcrypt.GenerateFromPassword(b, cost)
+	if err != nil {
+		panic(err)
+	}
+	dummies[cost] = string(hash)
+	return string(hash)
+}
+
+// NewToken returns a random token to hand to the client. Only its TokenID is
+// stored, so a leaked sessions or refresh_tokens table can't be used to log in.
+func NewToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", xerrors.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// TokenID returns the ID under which the session or refresh token for token
+// is stored
+func TokenID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
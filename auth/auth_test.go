@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/xerrors"
+)
+
+func TestPasswords(t *testing.T) {
+	hash, err := HashPassword("correct horse", bcrypt.MinCost)
+	require.NoError(t, err)
+	require.NotEqual(t, "correct horse", hash)
+
+	require.NoError(t, CheckPassword(hash, "correct horse", bcrypt.MinCost))
+	err = CheckPassword(hash, "battery staple", bcrypt.MinCost)
+	require.True(t, xerrors.Is(err, ErrInvalidCredentials), "got %v", err)
+
+	// no hash never matches, whatever the password
+	err = CheckPassword("", "", bcrypt.MinCost)
+	require.True(t, xerrors.Is(err, ErrInvalidCredentials), "got %v", err)
+
+	// and takes as long as a hash at the cost asked for
+	for _, cost := range []int{bcrypt.MinCost, bcrypt.MinCost + 1} {
+		dummyCost, err := bcrypt.Cost([]byte(dummyHash(cost)))
+		require.NoError(t, err)
+		require.Equal(t, cost, dummyCost)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	token, err := NewToken()
+	require.NoError(t, err)
+	other, err := NewToken()
+	require.NoError(t, err)
+	require.NotEqual(t, token, other)
+
+	require.Equal(t, TokenID(token), TokenID(token))
+	require.NotEqual(t, TokenID(token), TokenID(other))
+	require.NotContains(t, TokenID(token), token)
+}
@@ -0,0 +1,248 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/xerrors"
+)
+
+// Supported signing algorithms for access tokens
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+)
+
+// MinHMACKeyLength is the shortest HS256 secret accepted, in bytes
+const MinHMACKeyLength = 32
+
+var ErrInvalidToken = xerrors.New("invalid or expired token")
+
+// Key is one access token key. RS256 keys loaded without their private half
+// can only verify tokens, e.g. ones signed by a key that has been retired.
+type Key struct {
+	ID        string
+	Algorithm string
+
+	secret  []byte
+	private *rsa.PrivateKey
+	public  *rsa.PublicKey
+}
+
+func NewHMACKey(id string, secret []byte) (*Key, error) {
+	if len(secret) < MinHMACKeyLength {
+		return nil, fmt.Errorf("key %q: HS256 secrets must be at least %d bytes", id, MinHMACKeyLength)
+	}
+	return &Key{ID: id, Algorithm: HS256, secret: secret}, nil
+}
+
+// NewRSAKey returns an RS256 key. private may be nil for a verify-only key.
+func NewRSAKey(id string, private *rsa.PrivateKey, public *rsa.PublicKey) (*Key, error) {
+	if private != nil {
+		public = &private.PublicKey
+	}
+	if public == nil {
+		return nil, fmt.Errorf("key %q: RS256 keys need a private or public key", id)
+	}
+	return &Key{ID: id, Algorithm: RS256, private: private, public: public}, nil
+}
+
+func (k *Key) canSign() bool {
+	return k.secret != nil || k.private != nil
+}
+
+func (k *Key) signingKey() interface{} {
+	if k.Algorithm == HS256 {
+		return k.secret
+	}
+	return k.private
+}
+
+func (k *Key) verifyingKey() interface{} {
+	if k.Algorithm == HS256 {
+		return k.secret
+	}
+	return k.public
+}
+
+// KeySet signs access tokens with one key and accepts tokens signed by any of
+// them, picked by the token's kid header. To rotate keys, add the new key,
+// sign with it, and drop the old one once its last tokens have expired.
+type KeySet struct {
+	keys    map[string]*Key
+	signing *Key
+}
+
+func NewKeySet(signingKeyID string, keys ...*Key) (*KeySet, error) {
+	ks := &KeySet{keys: make(map[string]*Key, len(keys))}
+	for _, k := range keys {
+		if k.ID == "" {
+			return nil, xerrors.New("every key needs a kid")
+		}
+		if _, ok := ks.keys[k.ID]; ok {
+			return nil, fmt.Errorf("duplicate key %q", k.ID)
+		}
+		ks.keys[k.ID] = k
+	}
+
+	ks.signing = ks.keys[signingKeyID]
+	if ks.signing == nil {
+		return nil, fmt.Errorf("signing key %q is not in the key set", signingKeyID)
+	}
+	if !ks.signing.canSign() {
+		return nil, fmt.Errorf("signing key %q has no private key", signingKeyID)
+	}
+	return ks, nil
+}
+
+// keyFile is the format read by LoadKeySet, e.g.
+//
+//	{"keys": [
+//	  {"kid": "2020-06", "alg": "RS256", "private_key_file": "2020-06.pem"},
+//	  {"kid": "2020-01", "alg": "HS256", "secret": "<base64>"}
+//	]}
+//
+// Key file paths are relative to the key set file.
+type keyFile struct {
+	Keys []struct {
+		ID             string `json:"kid"`
+		Algorithm      string `json:"alg"`
+		Secret         string `json:"secret"`
+		PrivateKeyFile string `json:"private_key_file"`
+		PublicKeyFile  string `json:"public_key_file"`
+	} `json:"keys"`
+}
+
+// LoadKeySet reads a JSON key set file, see keyFile for the format
+func LoadKeySet(path, signingKeyID string) (*KeySet, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read key set: %w", err)
+	}
+
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, xerrors.Errorf("failed to parse key set %s: %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	readPEM := func(name string) ([]byte, error) {
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dir, name)
+		}
+		return ioutil.ReadFile(name)
+	}
+
+	var keys []*Key
+	for _, k := range file.Keys {
+		var key *Key
+		switch k.Algorithm {
+		case HS256:
+			secret, err := base64.StdEncoding.DecodeString(k.Secret)
+			if err != nil {
+				return nil, xerrors.Errorf("key %q: secret must be base64: %w", k.ID, err)
+			}
+			key, err = NewHMACKey(k.ID, secret)
+			if err != nil {
+				return nil, err
+			}
+		case RS256:
+			var private *rsa.PrivateKey
+			var public *rsa.PublicKey
+			if k.PrivateKeyFile != "" {
+				pem, err := readPEM(k.PrivateKeyFile)
+				if err != nil {
+					return nil, xerrors.Errorf("key %q: %w", k.ID, err)
+				}
+				if private, err = jwt.ParseRSAPrivateKeyFromPEM(pem); err != nil {
+					return nil, xerrors.Errorf("key %q: %w", k.ID, err)
+				}
+			} else if k.PublicKeyFile != "" {
+				pem, err := readPEM(k.PublicKeyFile)
+				if err != nil {
+					return nil, xerrors.Errorf("key %q: %w", k.ID, err)
+				}
+				if public, err = jwt.ParseRSAPublicKeyFromPEM(pem); err != nil {
+					return nil, xerrors.Errorf("key %q: %w", k.ID, err)
+				}
+			}
+			key, err = NewRSAKey(k.ID, private, public)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("key %q: unsupported alg %q, must be %s or %s", k.ID, k.Algorithm, HS256, RS256)
+		}
+		keys = append(keys, key)
+	}
+
+	ks, err := NewKeySet(signingKeyID, keys...)
+	if err != nil {
+		return nil, xerrors.Errorf("key set %s: %w", path, err)
+	}
+	return ks, nil
+}
+
+// IssueAccessToken returns a JWT naming userID as its subject, signed with
+// the key set's signing key
+func (ks *KeySet) IssueAccessToken(userID, issuer string, ttl time.Duration) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+
+	jti, err := NewToken()
+	if err != nil {
+		return "", expiresAt, err
+	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(ks.signing.Algorithm), jwt.RegisteredClaims{
+		ID:        jti,
+		Issuer:    issuer,
+		Subject:   userID,
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+	})
+	token.Header["kid"] = ks.signing.ID
+
+	signed, err := token.SignedString(ks.signing.signingKey())
+	if err != nil {
+		return "", expiresAt, xerrors.Errorf("failed to sign access token: %w", err)
+	}
+	return signed, expiresAt, nil
+}
+
+// VerifyAccessToken checks the token's signature, expiry and issuer and
+// returns the user ID it was issued for. Any problem is reported as
+// ErrInvalidToken, wrapping the reason.
+func (ks *KeySet) VerifyAccessToken(tokenString, issuer string) (string, error) {
+	var claims jwt.RegisteredClaims
+	_, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := ks.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown kid %q", kid)
+		}
+		// the key decides the algorithm, never the token, so that e.g. an
+		// RS256 public key can't be used as an HS256 secret
+		if token.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("kid %q is %s, token is %s", kid, key.Algorithm, token.Method.Alg())
+		}
+		return key.verifyingKey(), nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	if !claims.VerifyIssuer(issuer, true) {
+		return "", fmt.Errorf("%w: wrong issuer %q", ErrInvalidToken, claims.Issuer)
+	}
+	if claims.Subject == "" || claims.ExpiresAt == nil {
+		return "", fmt.Errorf("%w: missing sub or exp", ErrInvalidToken)
+	}
+	return claims.Subject, nil
+}
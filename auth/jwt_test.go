@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+const issuer = "go-blog-test"
+
+func hmacKey(t *testing.T, id string) *Key {
+	key, err := NewHMACKey(id, []byte(id+"-0123456789abcdef0123456789abcdef"))
+	require.NoError(t, err)
+	return key
+}
+
+func rsaKey(t *testing.T) *rsa.PrivateKey {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return private
+}
+
+func requireInvalid(t *testing.T, ks *KeySet, token string) {
+	_, err := ks.VerifyAccessToken(token, issuer)
+	require.True(t, xerrors.Is(err, ErrInvalidToken), "got %v", err)
+}
+
+func TestAccessTokens_HS256(t *testing.T) {
+	ks, err := NewKeySet("one", hmacKey(t, "one"))
+	require.NoError(t, err)
+
+	token, expiresAt, err := ks.IssueAccessToken("user-1", issuer, time.Minute)
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(time.Minute), expiresAt, time.Second)
+
+	userID, err := ks.VerifyAccessToken(token, issuer)
+	require.NoError(t, err)
+	require.Equal(t, "user-1", userID)
+
+	_, err = ks.VerifyAccessToken(token, "someone-else")
+	require.True(t, xerrors.Is(err, ErrInvalidToken), "got %v", err)
+
+	expired, _, err := ks.IssueAccessToken("user-1", issuer, -time.Minute)
+	require.NoError(t, err)
+	requireInvalid(t, ks, expired)
+
+	requireInvalid(t, ks, token+"x")
+	requireInvalid(t, ks, "not a token")
+
+	_, err = NewHMACKey("short", []byte("too short"))
+	require.Error(t, err)
+}
+
+func TestAccessTokens_RS256(t *testing.T) {
+	private := rsaKey(t)
+	key, err := NewRSAKey("rsa", private, nil)
+	require.NoError(t, err)
+	ks, err := NewKeySet("rsa", key)
+	require.NoError(t, err)
+
+	token, _, err := ks.IssueAccessToken("user-1", issuer, time.Minute)
+	require.NoError(t, err)
+	userID, err := ks.VerifyAccessToken(token, issuer)
+	require.NoError(t, err)
+	require.Equal(t, "user-1", userID)
+
+	// a verify-only key can't sign
+	public, err := NewRSAKey("public", nil, &private.PublicKey)
+	require.NoError(t, err)
+	_, err = NewKeySet("public", public)
+	require.Error(t, err)
+
+	// the public key must not be accepted as an HS256 secret
+	der, err := x509.MarshalPKIXPublicKey(&private.PublicKey)
+	require.NoError(t, err)
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Issuer:    issuer,
+		Subject:   "admin",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	})
+	forged.Header["kid"] = "rsa"
+	forgedToken, err := forged.SignedString(publicPEM)
+	require.NoError(t, err)
+	requireInvalid(t, ks, forgedToken)
+}
+
+func TestAccessTokens_KeyRotation(t *testing.T) {
+	old := hmacKey(t, "old")
+	oldSet, err := NewKeySet("old", old)
+	require.NoError(t, err)
+	oldToken, _, err := oldSet.IssueAccessToken("user-1", issuer, time.Minute)
+	require.NoError(t, err)
+
+	// tokens from the old key are still accepted after switching keys
+	rotated, err := NewKeySet("new", old, hmacKey(t, "new"))
+	require.NoError(t, err)
+	_, err = rotated.VerifyAccessToken(oldToken, issuer)
+	require.NoError(t, err)
+	newToken, _, err := rotated.IssueAccessToken("user-1", issuer, time.Minute)
+	require.NoError(t, err)
+	parsed, _, err := new(jwt.Parser).ParseUnverified(newToken, &jwt.RegisteredClaims{})
+	require.NoError(t, err)
+	require.Equal(t, "new", parsed.Header["kid"])
+
+	// and rejected once the old key is dropped
+	retired, err := NewKeySet("new", hmacKey(t, "new"))
+	require.NoError(t, err)
+	requireInvalid(t, retired, oldToken)
+	_, err = retired.VerifyAccessToken(newToken, issuer)
+	require.NoError(t, err)
+}
+
+func TestLoadKeySet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-blog-keys")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	private := rsaKey(t)
+	privatePEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(private)})
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "rsa.pem"), privatePEM, 0600))
+
+	secret := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	path := filepath.Join(dir, "keys.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"keys": [
+		{"kid": "rsa", "alg": "RS256", "private_key_file": "rsa.pem"},
+		{"kid": "hmac", "alg": "HS256", "secret": "`+secret+`"}
+	]}`), 0600))
+
+	ks, err := LoadKeySet(path, "rsa")
+	require.NoError(t, err)
+	token, _, err := ks.IssueAccessToken("user-1", issuer, time.Minute)
+	require.NoError(t, err)
+	_, err = ks.VerifyAccessToken(token, issuer)
+	require.NoError(t, err)
+
+	_, err = LoadKeySet(path, "missing")
+	require.Error(t, err)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"keys": [{"kid": "none", "alg": "none"}]}`), 0600))
+	_, err = LoadKeySet(path, "none")
+	require.Error(t, err)
+}
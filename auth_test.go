@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gavinc95/go-blog/auth"
+	"github.com/gavinc95/go-blog/db/models"
+	"github.com/stretchr/testify/require"
+)
+
+func authRequest(t *testing.T, method, path string, body interface{}, cookie *http.Cookie) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		require.NoError(t, json.NewEncoder(&buf).Encode(body))
+	}
+	req, err := http.NewRequest(method, path, &buf)
+	require.NoError(t, err)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	return executeRequest(req)
+}
+
+// sessionCookie returns the session cookie set by resp, if any
+func sessionCookie(resp *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range resp.Result().Cookies() {
+		if c.Name == app.Config.Auth.CookieName {
+			return c
+		}
+	}
+	return nil
+}
+
+func registerTestUser(t *testing.T) *http.Cookie {
+	uuidGenerator.shouldGenUserID = true
+	defer func() { uuidGenerator.shouldGenUserID = false }()
+
+	resp := authRequest(t, "POST", "/auth/register", &RegisterRequest{
+		Name:     "tiny cat",
+		Email:    "tiny@cat.com",
+		Password: "correct horse",
+	}, nil)
+	checkResponseCode(t, http.StatusCreated, resp.Code)
+
+	cookie := sessionCookie(resp)
+	require.NotNil(t, cookie)
+	require.True(t, cookie.HttpOnly)
+	return cookie
+}
+
+func TestRegisterLoginLogout(t *testing.T) {
+	clearTable()
+
+	cookie := registerTestUser(t)
+	resp := authRequest(t, "GET", "/auth/me", nil, cookie)
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	var me GetUserResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &me))
+	require.Equal(t, sampleUserID, me.User.ID)
+	require.NotContains(t, resp.Body.String(), "password")
+
+	// logging in replaces the existing session
+	resp = authRequest(t, "POST", "/auth/login", &LoginRequest{Email: "tiny@cat.com", Password: "correct horse"}, cookie)
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	var login LoginResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &login))
+	require.Equal(t, sampleUserID, login.UserID)
+	newCookie := sessionCookie(resp)
+	require.NotNil(t, newCookie)
+	require.NotEqual(t, cookie.Value, newCookie.Value)
+
+	resp = authRequest(t, "GET", "/auth/me", nil, cookie)
+	checkResponseCode(t, http.StatusUnauthorized, resp.Code)
+
+	resp = authRequest(t, "POST", "/auth/logout", nil, newCookie)
+	checkResponseCode(t, http.StatusNoContent, resp.Code)
+	require.Equal(t, -1, sessionCookie(resp).MaxAge)
+
+	resp = authRequest(t, "GET", "/auth/me", nil, newCookie)
+	checkResponseCode(t, http.StatusUnauthorized, resp.Code)
+	requireErrorCode(t, resp, CodeUnauthorized)
+}
+
+func TestRegister_Invalid(t *testing.T) {
+	clearTable()
+
+	resp := authRequest(t, "POST", "/auth/register", &RegisterRequest{Email: "tiny@cat.com", Password: "short"}, nil)
+	checkResponseCode(t, http.StatusBadRequest, resp.Code)
+	var res ErrorResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &res))
+	require.Equal(t, "password", res.Error.Details[0].Field)
+
+	registerTestUser(t)
+	resp = authRequest(t, "POST", "/auth/register", &RegisterRequest{Email: "tiny@cat.com", Password: "correct horse"}, nil)
+	checkResponseCode(t, http.StatusConflict, resp.Code)
+	requireErrorCode(t, resp, CodeConflict)
+}
+
+func TestLogin_BadCredentials(t *testing.T) {
+	clearTable()
+	registerTestUser(t)
+
+	// a user created without a password can't log in either
+	createTestUser(t, "other cat", "other@cat.com")
+
+	for _, req := range []LoginRequest{
+		{Email: "tiny@cat.com", Password: "battery staple"},
+		{Email: "nobody@cat.com", Password: "correct horse"},
+		{Email: "other@cat.com", Password: ""},
+	} {
+		resp := authRequest(t, "POST", "/auth/login", &req, nil)
+		if req.Password == "" {
+			checkResponseCode(t, http.StatusBadRequest, resp.Code)
+			continue
+		}
+		checkResponseCode(t, http.StatusUnauthorized, resp.Code)
+		requireErrorCode(t, resp, CodeUnauthorized)
+		require.Nil(t, sessionCookie(resp))
+	}
+}
+
+func TestSession_ExpiryAndRotation(t *testing.T) {
+	clearTable()
+	registerTestUser(t)
+
+	addSession := func(token string, age, ttl time.Duration) *http.Cookie {
+		created := time.Now().Add(-age)
+		require.NoError(t, app.BlogStore.CreateSession(&models.Session{
+			ID:        auth.TokenID(token),
+			UserID:    sampleUserID,
+			CreatedAt: created,
+			ExpiresAt: created.Add(ttl),
+		}))
+		return &http.Cookie{Name: app.Config.Auth.CookieName, Value: token}
+	}
+
+	// expired sessions are removed and their cookie cleared
+	expired := addSession("expired", 2*time.Hour, time.Hour)
+	resp := authRequest(t, "GET", "/auth/me", nil, expired)
+	checkResponseCode(t, http.StatusUnauthorized, resp.Code)
+	require.Equal(t, -1, sessionCookie(resp).MaxAge)
+	session, err := app.BlogStore.GetSession(auth.TokenID("expired"))
+	require.NoError(t, err)
+	require.Nil(t, session)
+
+	// old sessions keep working, under a new ID
+	old := addSession("old", app.Config.Auth.SessionRotateAfter+time.Minute, 24*time.Hour)
+	resp = authRequest(t, "GET", "/auth/me", nil, old)
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	rotated := sessionCookie(resp)
+	require.NotNil(t, rotated)
+	require.NotEqual(t, old.Value, rotated.Value)
+
+	resp = authRequest(t, "GET", "/auth/me", nil, old)
+	checkResponseCode(t, http.StatusUnauthorized, resp.Code)
+	resp = authRequest(t, "GET", "/auth/me", nil, rotated)
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	require.Nil(t, sessionCookie(resp))
+}
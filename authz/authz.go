@@ -0,0 +1,177 @@
+// Package authz decides who may do what to the blog's users and posts. It
+// knows nothing about HTTP, so policies can be tested on their own.
+package authz
+
+import (
+	"fmt"
+
+	"github.com/gavinc95/go-blog/db/models"
+	"golang.org/x/xerrors"
+)
+
+// Sentinel errors returned by Authorize. Check for them with xerrors.Is.
+var (
+	ErrUnauthenticated = xerrors.New("authentication required")
+	ErrForbidden       = xerrors.New("forbidden")
+)
+
+// Error is a denied request, with a message that is safe to show to clients
+type Error struct {
+	Kind error
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return e.Msg
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// The built-in roles. Which permissions each one grants is stored in the
+// database, see the migration that creates the roles table.
+const (
+	RoleAdmin  = "admin"  // manages users and roles, and can do anything
+	RoleEditor = "editor" // edits and deletes any post
+	RoleAuthor = "author" // writes their own posts
+	RoleReader = "reader" // reads, and manages their own account
+)
+
+// Subject is whoever is making a request, with the permissions granted by
+// their roles
+type Subject struct {
+	UserID      string // empty for anonymous requests
+	Permissions []string
+}
+
+// Can reports whether the subject has been granted permission
+func (s Subject) Can(permission string) bool {
+	for _, p := range s.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+type Action string
+
+const (
+	Read   Action = "read"
+	List   Action = "list"
+	Create Action = "create"
+	Update Action = "update"
+	Delete Action = "delete"
+	Grant  Action = "grant"
+	Revoke Action = "revoke"
+	// Moderate approves or rejects comments waiting in the moderation queue
+	Moderate Action = "moderate"
+)
+
+// Scopes of a permission: resources the subject owns, or any resource
+const (
+	Own = "own"
+	Any = "any"
+)
+
+// Permission names the right to perform action on a kind of resource, e.g.
+// "post.update.any"
+func Permission(kind string, action Action, scope string) string {
+	return fmt.Sprintf("%s.%s.%s", kind, action, scope)
+}
+
+// Resource is what a request acts on
+type Resource struct {
+	Kind    string
+	OwnerID string // the user the resource belongs to
+}
+
+// User is the resource for a user account, which belongs to that user
+func User(id string) Resource {
+	return Resource{Kind: "user", OwnerID: id}
+}
+
+// Post is the resource for an existing post
+func Post(post *models.Post) Resource {
+	return Resource{Kind: "post", OwnerID: post.UserID}
+}
+
+// NewPost is the resource for a post about to be created for userID
+func NewPost(userID string) Resource {
+	return Resource{Kind: "post", OwnerID: userID}
+}
+
+// UserPosts is the resource for all of a user's posts, e.g. to list their
+// drafts
+func UserPosts(userID string) Resource {
+	return Resource{Kind: "post", OwnerID: userID}
+}
+
+// AllPosts is the resource for every user's posts, which nobody owns
+func AllPosts() Resource {
+	return Resource{Kind: "post"}
+}
+
+// Comment is the resource for an existing comment, which belongs to the user
+// who wrote it rather than to the post's author
+func Comment(comment *models.Comment) Resource {
+	return Resource{Kind: "comment", OwnerID: comment.UserID}
+}
+
+// NewComment is the resource for a comment about to be written by userID
+func NewComment(userID string) Resource {
+	return Resource{Kind: "comment", OwnerID: userID}
+}
+
+// PostComments is the resource for moderating the comments on a post, which
+// its author does
+func PostComments(post *models.Post) Resource {
+	return Resource{Kind: "comment", OwnerID: post.UserID}
+}
+
+// UserPostComments is the resource for moderating the comments on all of a
+// user's posts, e.g. to list their queue
+func UserPostComments(userID string) Resource {
+	return Resource{Kind: "comment", OwnerID: userID}
+}
+
+// AllComments is the resource for every post's comments, which nobody owns
+func AllComments() Resource {
+	return Resource{Kind: "comment"}
+}
+
+// AllUsers is the resource for every user, e.g. to list them or create one,
+// which nobody owns
+func AllUsers() Resource {
+	return Resource{Kind: "user"}
+}
+
+// Categories is the resource for the tree of categories, which nobody owns
+func Categories() Resource {
+	return Resource{Kind: "category"}
+}
+
+// UserRoles is the resource for the roles a user has been granted
+func UserRoles(userID string) Resource {
+	return Resource{Kind: "role", OwnerID: userID}
+}
+
+// Authorize returns nil if sub may perform action on res: either they have
+// the "any" permission for it, or they own res and have the "own" one.
+func Authorize(sub Subject, action Action, res Resource) error {
+	if sub.UserID == "" {
+		return &Error{Kind: ErrUnauthenticated, Msg: fmt.Sprintf("log in to %s a %s", action, res.Kind)}
+	}
+	if sub.Can(Permission(res.Kind, action, Any)) {
+		return nil
+	}
+
+	if sub.UserID == res.OwnerID {
+		if sub.Can(Permission(res.Kind, action, Own)) {
+			return nil
+		}
+		return &Error{Kind: ErrForbidden, Msg: fmt.Sprintf("your roles don't allow you to %s a %s", action, res.Kind)}
+	}
+	return &Error{Kind: ErrForbidden, Msg: fmt.Sprintf("you may not %s a %s that isn't yours", action, res.Kind)}
+}
@@ -0,0 +1,79 @@
+package authz
+
+import (
+	"testing"
+
+	"github.com/gavinc95/go-blog/db/models"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestAuthorize(t *testing.T) {
+	post := &models.Post{ID: "post-1", UserID: "owner"}
+	comment := &models.Comment{ID: "comment-1", PostID: post.ID, UserID: "other"}
+
+	reader := []string{"user.update.own", "user.delete.own", "role.read.own", "comment.create.own", "comment.update.own", "comment.delete.own"}
+	author := append([]string{"post.create.own", "post.update.own", "post.delete.own", "comment.moderate.own"}, reader...)
+	editor := append([]string{"post.update.any", "post.delete.any", "comment.delete.any", "comment.moderate.any"}, author...)
+	admin := append([]string{"user.update.any", "user.delete.any", "role.read.any", "role.grant.any"}, editor...)
+
+	tests := []struct {
+		name    string
+		sub     Subject
+		action  Action
+		res     Resource
+		wantErr error
+	}{
+		{"owner updates post", Subject{UserID: "owner", Permissions: author}, Update, Post(post), nil},
+		{"owner deletes post", Subject{UserID: "owner", Permissions: author}, Delete, Post(post), nil},
+		{"reader owner updates post", Subject{UserID: "owner", Permissions: reader}, Update, Post(post), ErrForbidden},
+		{"other user updates post", Subject{UserID: "other", Permissions: author}, Update, Post(post), ErrForbidden},
+		{"other user deletes post", Subject{UserID: "other", Permissions: author}, Delete, Post(post), ErrForbidden},
+		{"editor updates post", Subject{UserID: "editor", Permissions: editor}, Update, Post(post), nil},
+		{"admin deletes post", Subject{UserID: "admin", Permissions: admin}, Delete, Post(post), nil},
+		{"anonymous updates post", Subject{}, Update, Post(post), ErrUnauthenticated},
+		{"anonymous permissions are ignored", Subject{Permissions: admin}, Delete, Post(post), ErrUnauthenticated},
+
+		{"author creates own post", Subject{UserID: "owner", Permissions: author}, Create, NewPost("owner"), nil},
+		{"reader creates own post", Subject{UserID: "owner", Permissions: reader}, Create, NewPost("owner"), ErrForbidden},
+		{"user creates post for someone else", Subject{UserID: "other", Permissions: author}, Create, NewPost("owner"), ErrForbidden},
+		{"editor creates post for someone else", Subject{UserID: "editor", Permissions: editor}, Create, NewPost("owner"), ErrForbidden},
+
+		{"reader comments", Subject{UserID: "owner", Permissions: reader}, Create, NewComment("owner"), nil},
+		{"commenter updates own comment", Subject{UserID: "other", Permissions: reader}, Update, Comment(comment), nil},
+		{"post author deletes comment", Subject{UserID: "owner", Permissions: author}, Delete, Comment(comment), ErrForbidden},
+		{"editor updates comment", Subject{UserID: "editor", Permissions: editor}, Update, Comment(comment), ErrForbidden},
+		{"editor deletes comment", Subject{UserID: "editor", Permissions: editor}, Delete, Comment(comment), nil},
+		{"post author moderates comments", Subject{UserID: "owner", Permissions: author}, Moderate, PostComments(post), nil},
+		{"commenter moderates comments", Subject{UserID: "other", Permissions: author}, Moderate, PostComments(post), ErrForbidden},
+		{"reader moderates own post's comments", Subject{UserID: "owner", Permissions: reader}, Moderate, UserPostComments("owner"), ErrForbidden},
+		{"author lists every queue", Subject{UserID: "owner", Permissions: author}, Moderate, AllComments(), ErrForbidden},
+		{"editor lists every queue", Subject{UserID: "editor", Permissions: editor}, Moderate, AllComments(), nil},
+
+		{"reader updates self", Subject{UserID: "owner", Permissions: reader}, Update, User("owner"), nil},
+		{"user deletes someone else", Subject{UserID: "other", Permissions: author}, Delete, User("owner"), ErrForbidden},
+		{"editor deletes someone else", Subject{UserID: "editor", Permissions: editor}, Delete, User("owner"), ErrForbidden},
+		{"admin updates someone else", Subject{UserID: "admin", Permissions: admin}, Update, User("owner"), nil},
+
+		{"user reads own roles", Subject{UserID: "owner", Permissions: reader}, Read, UserRoles("owner"), nil},
+		{"user reads someone else's roles", Subject{UserID: "other", Permissions: editor}, Read, UserRoles("owner"), ErrForbidden},
+		{"user grants self a role", Subject{UserID: "owner", Permissions: editor}, Grant, UserRoles("owner"), ErrForbidden},
+		{"admin grants a role", Subject{UserID: "admin", Permissions: admin}, Grant, UserRoles("owner"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Authorize(tt.sub, tt.action, tt.res)
+			if tt.wantErr == nil {
+				require.NoError(t, err)
+				return
+			}
+			require.True(t, xerrors.Is(err, tt.wantErr), "got %v", err)
+			require.NotEmpty(t, err.Error())
+		})
+	}
+}
+
+func TestPermission(t *testing.T) {
+	require.Equal(t, "post.update.any", Permission("post", Update, Any))
+	require.Equal(t, "role.grant.own", Permission(UserRoles("x").Kind, Grant, Own))
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gavinc95/go-blog/authz"
+	"github.com/gavinc95/go-blog/config"
+	"github.com/gavinc95/go-blog/db"
+	"github.com/gavinc95/go-blog/db/models"
+	"github.com/gavinc95/go-blog/moderation"
+	"github.com/gorilla/mux"
+)
+
+type GetCommentsResponse struct {
+	Comments []*models.Comment `json:"comments"`
+	Page     PageInfo          `json:"page"`
+}
+
+type GetCommentResponse struct {
+	Comment *models.Comment `json:"comment"`
+}
+
+type CreateCommentRequest struct {
+	Content  string `json:"content"`   // required
+	ParentID string `json:"parent_id"` // the comment to reply to, if any
+}
+
+type CreateCommentResponse struct {
+	ID     string               `json:"id"`
+	Status models.CommentStatus `json:"status"` // pending or spam until a moderator approves it
+}
+
+type UpdateCommentRequest struct {
+	Content string `json:"content"` // required
+}
+
+type UpdateCommentResponse struct {
+	ID     string               `json:"id"`
+	Status models.CommentStatus `json:"status"`
+}
+
+const maxCommentLength = 10000
+
+func (v *validator) commentContent(field, val string) {
+	v.required(field, val)
+	if utf8.RuneCountInString(val) > maxCommentLength {
+		v.add(field, "must be at most 10000 characters")
+	}
+}
+
+// Comments can be read by anyone who can read their post once they are
+// approved, and are written as the caller. Until then only their writer and
+// the post's moderators see them.
+
+// HandleGetComments lists a post's top-level comments, a page at a time. Each
+// comment says how many replies it has, which HandleGetReplies lists.
+func (a *App) HandleGetComments(w http.ResponseWriter, r *http.Request) {
+	// validate the request
+	var v validator
+	opts, from := a.commentListOptions(r, &v)
+	if v.failed() {
+		writeValidationError(w, r, v.details)
+		return
+	}
+
+	post, ok := a.readablePost(w, r, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	a.writeComments(w, r, post.ID, opts, from)
+}
+
+func (a *App) HandleGetReplies(w http.ResponseWriter, r *http.Request) {
+	// validate the request
+	var v validator
+	opts, from := a.commentListOptions(r, &v)
+	if v.failed() {
+		writeValidationError(w, r, v.details)
+		return
+	}
+
+	_, comment, ok := a.postComment(w, r)
+	if !ok {
+		return
+	}
+	opts.ParentID = comment.ID
+
+	a.writeComments(w, r, comment.PostID, opts, from)
+}
+
+func (a *App) writeComments(w http.ResponseWriter, r *http.Request, postID string, opts db.CommentListOptions, from *cursor) {
+	opts.Statuses = []models.CommentStatus{models.CommentApproved}
+	page, err := a.BlogStore.GetComments(postID, opts)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	res := GetCommentsResponse{Comments: page.Comments, Page: commentPageInfo(page, opts, from)}
+	if res.Comments == nil {
+		res.Comments = []*models.Comment{}
+	}
+	writeJSON(w, r, http.StatusOK, res)
+}
+
+func (a *App) HandleGetComment(w http.ResponseWriter, r *http.Request) {
+	_, comment, ok := a.postComment(w, r)
+	if !ok {
+		return
+	}
+
+	res := GetCommentResponse{Comment: comment}
+	writeJSON(w, r, http.StatusOK, res)
+}
+
+// HandleCreateComment comments on a published post, or replies to one of its
+// approved comments. The comment waits for a moderator as the moderation
+// policy and spam filter decide, unless the caller is one.
+func (a *App) HandleCreateComment(w http.ResponseWriter, r *http.Request) {
+	var req CreateCommentRequest
+	err := decodeRequest(r, &req)
+	if err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	// validate the request
+	var v validator
+	req.Content = strings.TrimSpace(req.Content)
+	v.commentContent("content", req.Content)
+	v.id("parent_id", req.ParentID)
+	if v.failed() {
+		writeValidationError(w, r, v.details)
+		return
+	}
+
+	userID := currentUserID(r)
+	if !a.authorize(w, r, authz.Create, authz.NewComment(userID)) {
+		return
+	}
+	post, ok := a.readablePost(w, r, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	status, err := a.newCommentStatus(r, post, &models.Comment{PostID: post.ID, UserID: userID, ParentID: req.ParentID, Content: req.Content})
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	id, err := a.BlogStore.CreateComment(post.ID, userID, req.ParentID, req.Content, status)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	res := CreateCommentResponse{ID: id, Status: status}
+	if u, err := a.Router.Get("comment").URL("id", post.ID, "comment", id); err == nil {
+		w.Header().Set("Location", u.String())
+	}
+	writeJSON(w, r, http.StatusCreated, res)
+}
+
+func (a *App) HandleUpdateComment(w http.ResponseWriter, r *http.Request) {
+	var req UpdateCommentRequest
+	err := decodeRequest(r, &req)
+	if err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	// validate the request
+	var v validator
+	req.Content = strings.TrimSpace(req.Content)
+	v.commentContent("content", req.Content)
+	if v.failed() {
+		writeValidationError(w, r, v.details)
+		return
+	}
+
+	post, comment, ok := a.postComment(w, r)
+	if !ok {
+		return
+	}
+	if !a.authorize(w, r, authz.Update, authz.Comment(comment)) {
+		return
+	}
+
+	// edits are moderated again
+	comment.Content = req.Content
+	status, err := a.editedCommentStatus(r, post, comment)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	id, err := a.BlogStore.UpdateComment(comment.ID, req.Content, status)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	res := UpdateCommentResponse{ID: id, Status: comment.Status}
+	if status != "" {
+		res.Status = status
+	}
+	writeJSON(w, r, http.StatusOK, res)
+}
+
+// HandleDeleteComment deletes a comment along with its replies
+func (a *App) HandleDeleteComment(w http.ResponseWriter, r *http.Request) {
+	_, comment, ok := a.postComment(w, r)
+	if !ok {
+		return
+	}
+	if !a.authorize(w, r, authz.Delete, authz.Comment(comment)) {
+		return
+	}
+
+	if _, err := a.BlogStore.DeleteComment(comment.ID); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// postComment fetches the {comment} on the {id} post, which the caller must be
+// able to read, along with the post. If there isn't one, it writes the error
+// response and returns false.
+func (a *App) postComment(w http.ResponseWriter, r *http.Request) (*models.Post, *models.Comment, bool) {
+	post, ok := a.readablePost(w, r, mux.Vars(r)["id"])
+	if !ok {
+		return nil, nil, false
+	}
+
+	comment, err := a.BlogStore.GetComment(mux.Vars(r)["comment"])
+	if err != nil {
+		writeStoreError(w, r, err)
+		return nil, nil, false
+	}
+	if comment == nil || comment.PostID != post.ID {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "comment not found")
+		return nil, nil, false
+	}
+
+	// to anyone else, a comment that hasn't been approved doesn't exist
+	if comment.Status != models.CommentApproved && comment.UserID != currentUserID(r) {
+		moderator, err := a.can(r, authz.Moderate, authz.PostComments(post))
+		if err != nil {
+			writeStoreError(w, r, err)
+			return nil, nil, false
+		}
+		if !moderator {
+			writeError(w, r, http.StatusNotFound, CodeNotFound, "comment not found")
+			return nil, nil, false
+		}
+	}
+	return post, comment, true
+}
+
+// newCommentStatus decides which status a comment about to be written on post
+// starts in. Comments by the post's moderators are approved straight away.
+func (a *App) newCommentStatus(r *http.Request, post *models.Post, comment *models.Comment) (models.CommentStatus, error) {
+	moderator, err := a.can(r, authz.Moderate, authz.PostComments(post))
+	if err != nil {
+		return "", err
+	}
+	if moderator {
+		return models.CommentApproved, nil
+	}
+	return a.reviewCommentStatus(r, comment)
+}
+
+// editedCommentStatus decides which status an edited comment moves to,
+// returning an empty status if it stays where it is. Edits are reviewed like
+// new comments, except that they never approve a comment that wasn't already,
+// and a moderator's edits leave the status alone.
+func (a *App) editedCommentStatus(r *http.Request, post *models.Post, comment *models.Comment) (models.CommentStatus, error) {
+	moderator, err := a.can(r, authz.Moderate, authz.PostComments(post))
+	if err != nil || moderator {
+		return "", err
+	}
+	status, err := a.reviewCommentStatus(r, comment)
+	if err != nil || status == comment.Status {
+		return "", err
+	}
+	if status == models.CommentApproved {
+		return "", nil
+	}
+	return status, nil
+}
+
+// reviewCommentStatus decides the status of a comment by someone who can't
+// moderate it, by the moderation policy and the spam filter
+func (a *App) reviewCommentStatus(r *http.Request, comment *models.Comment) (models.CommentStatus, error) {
+	returning := false
+	if a.Config.Moderation.Policy == config.ModerateFirst {
+		var err error
+		returning, err = a.BlogStore.HasApprovedComment(comment.UserID)
+		if err != nil {
+			return "", err
+		}
+	}
+	verdict, ok := a.classify(r, comment)
+	if !ok {
+		return models.CommentPending, nil
+	}
+	return moderation.Status(a.Config.Moderation.Policy, verdict, returning), nil
+}
+
+// classify runs the spam filter over a comment. It returns false if the
+// filter failed, so that the comment can wait for a person to look at it
+// rather than be refused.
+func (a *App) classify(r *http.Request, comment *models.Comment) (moderation.Verdict, bool) {
+	if a.Classifier == nil {
+		return moderation.Verdict{}, true
+	}
+	verdict, err := a.Classifier.Classify(r.Context(), comment)
+	if err != nil {
+		log.Printf("request %s: failed to classify comment: %+v", requestID(r), err)
+		return moderation.Verdict{}, false
+	}
+	if verdict.Spam {
+		log.Printf("request %s: comment by %s held as spam: %s", requestID(r), comment.UserID, verdict.Reason)
+	}
+	return verdict, true
+}
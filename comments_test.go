@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gavinc95/go-blog/config"
+	"github.com/gavinc95/go-blog/db/models"
+	"github.com/stretchr/testify/require"
+)
+
+func getComments(t *testing.T, path string) GetCommentsResponse {
+	resp := authedRequest(t, "GET", path, "", nil)
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	var res GetCommentsResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &res))
+	return res
+}
+
+func createTestComment(t *testing.T, postID, userID, parentID, content string) string {
+	resp := authedRequest(t, "POST", "/posts/"+postID+"/comments", userID,
+		CreateCommentRequest{Content: content, ParentID: parentID})
+	checkResponseCode(t, http.StatusCreated, resp.Code)
+	var res CreateCommentResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &res))
+	require.Equal(t, "/posts/"+postID+"/comments/"+res.ID, resp.Header().Get("Location"))
+	return res.ID
+}
+
+func TestComments(t *testing.T) {
+	clearTable()
+
+	// moderation is tested on its own
+	policy := app.Config.Moderation.Policy
+	app.Config.Moderation.Policy = config.ModerateNone
+	defer func() { app.Config.Moderation.Policy = policy }()
+
+	authorID, err := app.BlogStore.CreateUser("tiny cat", "tiny@cat.com", "author")
+	require.NoError(t, err)
+	readerID, err := app.BlogStore.CreateUser("big cat", "big@cat.com", "reader")
+	require.NoError(t, err)
+	editorID, err := app.BlogStore.CreateUser("fat cat", "fat@cat.com", "editor")
+	require.NoError(t, err)
+	postID, err := app.BlogStore.CreatePost(authorID, "title", "content", models.FormatPlain, models.PostPublished, time.Time{})
+	require.NoError(t, err)
+	draftID, err := app.BlogStore.CreatePost(authorID, "draft", "content", models.FormatPlain, models.PostDraft, time.Time{})
+	require.NoError(t, err)
+
+	resp := authedRequest(t, "POST", "/posts/"+postID+"/comments", "", CreateCommentRequest{Content: "hi"})
+	checkResponseCode(t, http.StatusUnauthorized, resp.Code)
+	resp = authedRequest(t, "POST", "/posts/"+postID+"/comments", readerID, CreateCommentRequest{Content: "  "})
+	checkResponseCode(t, http.StatusBadRequest, resp.Code)
+	requireErrorCode(t, resp, CodeInvalidRequest)
+	resp = authedRequest(t, "POST", "/posts/"+draftID+"/comments", readerID, CreateCommentRequest{Content: "hi"})
+	checkResponseCode(t, http.StatusNotFound, resp.Code)
+	resp = authedRequest(t, "POST", "/posts/"+draftID+"/comments", authorID, CreateCommentRequest{Content: "hi"})
+	checkResponseCode(t, http.StatusConflict, resp.Code)
+
+	var topIDs []string
+	for i := 0; i < 3; i++ {
+		topIDs = append(topIDs, createTestComment(t, postID, readerID, "", fmt.Sprintf("comment %d", i)))
+	}
+	replyID := createTestComment(t, postID, authorID, topIDs[0], "reply")
+
+	// threads are paged oldest first, with replies listed under their comment
+	res := getComments(t, "/posts/"+postID+"/comments?limit=2")
+	require.Len(t, res.Comments, 2)
+	require.Equal(t, topIDs[0], res.Comments[0].ID)
+	require.Equal(t, 1, res.Comments[0].Replies)
+	require.Equal(t, "big cat", res.Comments[0].Author.Name)
+	res = getComments(t, "/posts/"+postID+"/comments?limit=2&cursor="+res.Page.NextCursor)
+	require.Len(t, res.Comments, 1)
+	require.Equal(t, topIDs[2], res.Comments[0].ID)
+	require.Empty(t, res.Page.NextCursor)
+	require.NotEmpty(t, res.Page.PrevCursor)
+	res = getComments(t, "/posts/"+postID+"/comments/"+topIDs[0]+"/replies")
+	require.Len(t, res.Comments, 1)
+	require.Equal(t, replyID, res.Comments[0].ID)
+	require.Equal(t, topIDs[0], res.Comments[0].ParentID)
+
+	resp = authedRequest(t, "GET", "/posts/"+draftID+"/comments/"+replyID, "", nil)
+	checkResponseCode(t, http.StatusNotFound, resp.Code)
+
+	// commenters edit their own comments; editors can delete anyone's
+	resp = authedRequest(t, "PUT", "/posts/"+postID+"/comments/"+replyID, readerID, UpdateCommentRequest{Content: "mine now"})
+	checkResponseCode(t, http.StatusForbidden, resp.Code)
+	resp = authedRequest(t, "PUT", "/posts/"+postID+"/comments/"+replyID, authorID, UpdateCommentRequest{Content: "edited"})
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	resp = authedRequest(t, "GET", "/posts/"+postID+"/comments/"+replyID, "", nil)
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	var got GetCommentResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &got))
+	require.Equal(t, "edited", got.Comment.Content)
+
+	resp = authedRequest(t, "DELETE", "/posts/"+postID+"/comments/"+topIDs[0], authorID, nil)
+	checkResponseCode(t, http.StatusForbidden, resp.Code)
+	resp = authedRequest(t, "DELETE", "/posts/"+postID+"/comments/"+topIDs[0], editorID, nil)
+	checkResponseCode(t, http.StatusNoContent, resp.Code)
+	resp = authedRequest(t, "GET", "/posts/"+postID+"/comments/"+replyID, "", nil)
+	checkResponseCode(t, http.StatusNotFound, resp.Code)
+	require.Len(t, getComments(t, "/posts/"+postID+"/comments").Comments, 2)
+
+	// the rest go with the post, and come back with it
+	resp = authedRequest(t, "DELETE", "/posts/"+postID, authorID, nil)
+	checkResponseCode(t, http.StatusNoContent, resp.Code)
+	resp = authedRequest(t, "GET", "/posts/"+postID+"/comments", "", nil)
+	checkResponseCode(t, http.StatusNotFound, resp.Code)
+	resp = authedRequest(t, "POST", "/posts/"+postID+"/restore", authorID, nil)
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	require.Len(t, getComments(t, "/posts/"+postID+"/comments").Comments, 2)
+}
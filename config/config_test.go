@@ -0,0 +1,192 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// setEnv sets an environment variable for the duration of the test
+func setEnv(t *testing.T, name, val string) {
+	old, had := os.LookupEnv(name)
+	require.NoError(t, os.Setenv(name, val))
+	t.Cleanup(func() {
+		if had {
+			os.Setenv(name, old)
+		} else {
+			os.Unsetenv(name)
+		}
+	})
+}
+
+func writeFile(t *testing.T, name, contents string) string {
+	dir, err := ioutil.TempDir("", "go-blog-config")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(path, []byte(contents), 0600))
+	return path
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	cfg, args, err := Load([]string{"migrate", "status"})
+	require.NoError(t, err)
+	require.Equal(t, Default(), cfg)
+	require.Equal(t, []string{"migrate", "status"}, args)
+}
+
+func TestLoad_FileFormats(t *testing.T) {
+	files := map[string]string{
+		"blog.yaml": `
+server:
+  addr: ":9000"
+  shutdown_timeout: 30s
+database:
+  host: db.internal
+  port: 6543
+  max_open_conns: 50
+moderation:
+  spam_keywords: [casino, "free money"]
+`,
+		"blog.toml": `
+[server]
+addr = ":9000"
+shutdown_timeout = "30s"
+
+[database]
+host = "db.internal"
+port = 6543
+max_open_conns = 50
+
+[moderation]
+spam_keywords = ["casino", "free money"]
+`,
+		"blog.json": `{
+	"server": {"addr": ":9000", "shutdown_timeout": "30s"},
+	"database": {"host": "db.internal", "port": 6543, "max_open_conns": 50},
+	"moderation": {"spam_keywords": ["casino", "free money"]}
+}`,
+	}
+
+	for name, contents := range files {
+		t.Run(name, func(t *testing.T) {
+			cfg, _, err := Load([]string{"-config", writeFile(t, name, contents)})
+			require.NoError(t, err)
+			require.Equal(t, ":9000", cfg.Server.Addr)
+			require.Equal(t, 30*time.Second, cfg.Server.ShutdownTimeout)
+			require.Equal(t, "db.internal", cfg.Database.Host)
+			require.Equal(t, 6543, cfg.Database.Port)
+			require.Equal(t, 50, cfg.Database.MaxOpenConns)
+			require.Equal(t, []string{"casino", "free money"}, cfg.Moderation.SpamKeywords)
+
+			// untouched settings keep their defaults
+			require.Equal(t, Default().Database.Name, cfg.Database.Name)
+		})
+	}
+}
+
+func TestLoad_UnknownFileSetting(t *testing.T) {
+	path := writeFile(t, "blog.yaml", "server:\n  adr: \":9000\"\n")
+	_, _, err := Load([]string{"-config", path})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "server.adr")
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := writeFile(t, "blog.yaml", `
+server:
+  addr: ":9000"
+database:
+  host: from-file
+  name: from-file
+  user: from-file
+`)
+	setEnv(t, "BLOG_CONFIG", path)
+	setEnv(t, "BLOG_DATABASE_HOST", "from-env")
+	setEnv(t, "POSTGRES_USER", "from-legacy-env")
+	setEnv(t, "BLOG_DATABASE_NAME", "from-env")
+
+	cfg, _, err := Load([]string{"-database-name", "from-flag"})
+	require.NoError(t, err)
+	require.Equal(t, ":9000", cfg.Server.Addr)
+	require.Equal(t, "from-env", cfg.Database.Host)
+	require.Equal(t, "from-legacy-env", cfg.Database.User)
+	require.Equal(t, "from-flag", cfg.Database.Name)
+}
+
+func TestLoad_InvalidValues(t *testing.T) {
+	_, _, err := Load([]string{"-database-port", "not-a-port"})
+	require.Error(t, err)
+
+	setEnv(t, "BLOG_SERVER_SHUTDOWN_TIMEOUT", "soon")
+	_, _, err = Load(nil)
+	require.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Default()
+	require.NoError(t, cfg.Validate())
+
+	cfg.Server.Addr = "8010"
+	cfg.Database.SSLMode = "sometimes"
+	cfg.Database.Store = "carrier-pigeon"
+	cfg.Database.MaxIdleConns = 100
+	err := cfg.Validate()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "server.addr")
+	require.Contains(t, err.Error(), "database.store")
+	require.Contains(t, err.Error(), "max_idle_conns")
+
+	cfg = Default()
+	cfg.Auth.BcryptCost = 2
+	cfg.Auth.SessionTTL = 0
+	cfg.Auth.JWTIssuer = ""
+	err = cfg.Validate()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "auth.bcrypt_cost")
+	require.Contains(t, err.Error(), "auth.session_ttl")
+	require.Contains(t, err.Error(), "auth.jwt_issuer")
+
+	cfg = Default()
+	cfg.Trash.Retention = 0
+	err = cfg.Validate()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "trash.retention")
+
+	cfg = Default()
+	cfg.Moderation.Policy = "some"
+	err = cfg.Validate()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "moderation.policy")
+
+	cfg = Default()
+	cfg.Search.Language = ""
+	err = cfg.Validate()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "search.language")
+
+	cfg = Default()
+	cfg.Database.SSLMode = "verify-full"
+	cfg.Database.SSLCert = "client.crt"
+	require.Error(t, cfg.Validate())
+	cfg.Database.SSLKey = "client.key"
+	require.NoError(t, cfg.Validate())
+}
+
+func TestConnString(t *testing.T) {
+	db := Default().Database
+	db.Password = `it's a secret`
+	db.SSLMode = "verify-full"
+	db.SSLRootCert = "/etc/ssl/ca.pem"
+	require.Equal(t,
+		`host='localhost' port=5432 user='postgres' password='it\'s a secret' dbname='postgres' sslmode='verify-full' sslrootcert='/etc/ssl/ca.pem'`,
+		db.ConnString())
+
+	db.DSN = "postgres://blog:pw@db.internal:5432/blog?sslmode=require"
+	require.Equal(t, db.DSN, db.ConnString())
+}
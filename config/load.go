@@ -0,0 +1,311 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v2"
+)
+
+// setting ties one config field to its key in a config file, its environment
+// variables and its command-line flag
+type setting struct {
+	key   string   // dotted path in the config file, e.g. server.addr
+	envs  []string // checked in order, the first one set wins
+	usage string
+	ptr   interface{}
+}
+
+// flagName is the setting's key as a command-line flag, e.g. -server-addr
+func (s setting) flagName() string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(s.key)
+}
+
+func (s setting) set(val string) error {
+	var err error
+	switch p := s.ptr.(type) {
+	case *string:
+		*p = val
+	case *int:
+		*p, err = strconv.Atoi(val)
+	case *bool:
+		*p, err = strconv.ParseBool(val)
+	case *time.Duration:
+		*p, err = time.ParseDuration(val)
+	case *[]string:
+		*p = splitList(val)
+	default:
+		err = fmt.Errorf("unsupported setting type %T", s.ptr)
+	}
+	if err != nil {
+		return xerrors.Errorf("invalid value %q for %s: %w", val, s.key, err)
+	}
+	return nil
+}
+
+func (s setting) String() string {
+	switch p := s.ptr.(type) {
+	case *string:
+		return *p
+	case *int:
+		return strconv.Itoa(*p)
+	case *bool:
+		return strconv.FormatBool(*p)
+	case *time.Duration:
+		return p.String()
+	case *[]string:
+		return strings.Join(*p, ",")
+	}
+	return ""
+}
+
+// splitList parses a comma-separated list, dropping empty items
+func splitList(val string) []string {
+	var list []string
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{"server.addr", []string{"BLOG_SERVER_ADDR"}, "address to listen on", &c.Server.Addr},
+		{"server.read_timeout", []string{"BLOG_SERVER_READ_TIMEOUT"}, "maximum time to read a request", &c.Server.ReadTimeout},
+		{"server.write_timeout", []string{"BLOG_SERVER_WRITE_TIMEOUT"}, "maximum time to write a response", &c.Server.WriteTimeout},
+		{"server.idle_timeout", []string{"BLOG_SERVER_IDLE_TIMEOUT"}, "how long to keep idle connections open", &c.Server.IdleTimeout},
+		{"server.shutdown_timeout", []string{"BLOG_SERVER_SHUTDOWN_TIMEOUT", "SHUTDOWN_TIMEOUT"}, "how long to wait for in-flight requests on shutdown", &c.Server.ShutdownTimeout},
+		{"server.default_page_size", []string{"BLOG_SERVER_DEFAULT_PAGE_SIZE"}, "items per page when a listing doesn't ask", &c.Server.DefaultPageSize},
+		{"server.max_page_size", []string{"BLOG_SERVER_MAX_PAGE_SIZE"}, "most items a listing can ask for per page", &c.Server.MaxPageSize},
+		{"server.scheduler_interval", []string{"BLOG_SERVER_SCHEDULER_INTERVAL"}, "how often to publish scheduled posts that are due, 0 to turn off", &c.Server.SchedulerInterval},
+
+		{"database.store", []string{"BLOG_DATABASE_STORE"}, "store implementation: postgres or memory", &c.Database.Store},
+		{"database.dsn", []string{"BLOG_DATABASE_DSN", "DATABASE_URL"}, "full Postgres connection string or URL, overrides the other connection settings", &c.Database.DSN},
+		{"database.host", []string{"BLOG_DATABASE_HOST", "PGHOST"}, "Postgres host", &c.Database.Host},
+		{"database.port", []string{"BLOG_DATABASE_PORT", "PGPORT"}, "Postgres port", &c.Database.Port},
+		{"database.user", []string{"BLOG_DATABASE_USER", "POSTGRES_USER"}, "Postgres user", &c.Database.User},
+		{"database.password", []string{"BLOG_DATABASE_PASSWORD", "POSTGRES_PASSWORD"}, "Postgres password", &c.Database.Password},
+		{"database.name", []string{"BLOG_DATABASE_NAME", "APP_DB_NAME"}, "Postgres database name", &c.Database.Name},
+		{"database.sslmode", []string{"BLOG_DATABASE_SSLMODE", "PGSSLMODE"}, "TLS mode: disable, require, verify-ca or verify-full", &c.Database.SSLMode},
+		{"database.sslrootcert", []string{"BLOG_DATABASE_SSLROOTCERT"}, "CA certificate used to verify the server", &c.Database.SSLRootCert},
+		{"database.sslcert", []string{"BLOG_DATABASE_SSLCERT"}, "client certificate", &c.Database.SSLCert},
+		{"database.sslkey", []string{"BLOG_DATABASE_SSLKEY"}, "client certificate key", &c.Database.SSLKey},
+		{"database.max_open_conns", []string{"BLOG_DATABASE_MAX_OPEN_CONNS"}, "maximum open connections, 0 for unlimited", &c.Database.MaxOpenConns},
+		{"database.max_idle_conns", []string{"BLOG_DATABASE_MAX_IDLE_CONNS"}, "maximum idle connections", &c.Database.MaxIdleConns},
+		{"database.conn_max_lifetime", []string{"BLOG_DATABASE_CONN_MAX_LIFETIME"}, "maximum time a connection is reused, 0 for forever", &c.Database.ConnMaxLifetime},
+
+		{"auth.session_ttl", []string{"BLOG_AUTH_SESSION_TTL"}, "how long an unused session lasts", &c.Auth.SessionTTL},
+		{"auth.session_rotate_after", []string{"BLOG_AUTH_SESSION_ROTATE_AFTER"}, "age at which a session is given a new ID, 0 to never rotate", &c.Auth.SessionRotateAfter},
+		{"auth.cookie_name", []string{"BLOG_AUTH_COOKIE_NAME"}, "name of the session cookie", &c.Auth.CookieName},
+		{"auth.cookie_secure", []string{"BLOG_AUTH_COOKIE_SECURE"}, "only send the session cookie over HTTPS", &c.Auth.CookieSecure},
+		{"auth.bcrypt_cost", []string{"BLOG_AUTH_BCRYPT_COST"}, "bcrypt cost for password hashes", &c.Auth.BcryptCost},
+		{"auth.jwt_keys_file", []string{"BLOG_AUTH_JWT_KEYS_FILE"}, "JSON key set for bearer access tokens, bearer tokens are disabled without one", &c.Auth.JWTKeysFile},
+		{"auth.jwt_signing_key", []string{"BLOG_AUTH_JWT_SIGNING_KEY"}, "kid of the key that signs new access tokens", &c.Auth.JWTSigningKey},
+		{"auth.jwt_issuer", []string{"BLOG_AUTH_JWT_ISSUER"}, "iss claim of access tokens", &c.Auth.JWTIssuer},
+		{"auth.access_token_ttl", []string{"BLOG_AUTH_ACCESS_TOKEN_TTL"}, "lifetime of bearer access tokens", &c.Auth.AccessTokenTTL},
+		{"auth.refresh_token_ttl", []string{"BLOG_AUTH_REFRESH_TOKEN_TTL"}, "lifetime of refresh tokens", &c.Auth.RefreshTokenTTL},
+		{"auth.default_role", []string{"BLOG_AUTH_DEFAULT_ROLE"}, "role granted to new users", &c.Auth.DefaultRole},
+
+		{"trash.retention", []string{"BLOG_TRASH_RETENTION"}, "how long deleted users and posts can be restored", &c.Trash.Retention},
+		{"trash.purge_interval", []string{"BLOG_TRASH_PURGE_INTERVAL"}, "how often to purge the trash of what is past retention, 0 to turn off", &c.Trash.PurgeInterval},
+
+		{"search.language", []string{"BLOG_SEARCH_LANGUAGE"}, "Postgres text search configuration to index posts with, e.g. english", &c.Search.Language},
+
+		{"moderation.policy", []string{"BLOG_MODERATION_POLICY"}, "which new comments are held for moderation: all, first or none", &c.Moderation.Policy},
+		{"moderation.spam_keywords", []string{"BLOG_MODERATION_SPAM_KEYWORDS"}, "comma-separated words that mark a comment as spam", &c.Moderation.SpamKeywords},
+		{"moderation.max_links", []string{"BLOG_MODERATION_MAX_LINKS"}, "most links a comment can have before it is spam, 0 to not count them", &c.Moderation.MaxLinks},
+
+		{"frontend.title", []string{"BLOG_FRONTEND_TITLE"}, "name of the blog shown on its pages", &c.Frontend.Title},
+		{"frontend.templates_dir", []string{"BLOG_FRONTEND_TEMPLATES_DIR"}, "directory of templates to use instead of the built-in ones", &c.Frontend.TemplatesDir},
+
+		{"features.auto_migrate", []string{"BLOG_FEATURES_AUTO_MIGRATE"}, "apply pending migrations on startup", &c.Features.AutoMigrate},
+		{"features.legacy_routes", []string{"BLOG_FEATURES_LEGACY_ROUTES"}, "serve the original routes that take IDs from the JSON body", &c.Features.LegacyRoutes},
+		{"features.frontend", []string{"BLOG_FEATURES_FRONTEND"}, "serve the blog as HTML pages", &c.Features.Frontend},
+	}
+}
+
+// pendingFlag records a flag until the file and environment have been applied,
+// so that flags always take precedence regardless of parse order
+type pendingFlag struct {
+	setting
+	scratch setting // the same setting on a throwaway config, to check values early
+	vals    *[]func() error
+}
+
+func (f pendingFlag) Set(val string) error {
+	if err := f.scratch.set(val); err != nil {
+		return err
+	}
+	*f.vals = append(*f.vals, func() error { return f.setting.set(val) })
+	return nil
+}
+
+func (f pendingFlag) IsBoolFlag() bool {
+	_, ok := f.ptr.(*bool)
+	return ok
+}
+
+// Load builds the config from, in increasing order of precedence: defaults,
+// the config file named by -config or BLOG_CONFIG, environment variables and
+// command-line flags. It returns the arguments left after the flags, e.g. a
+// subcommand, and an error if the result doesn't validate.
+func Load(args []string) (Config, []string, error) {
+	cfg := Default()
+	settings := cfg.settings()
+
+	fs := flag.NewFlagSet("go-blog", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("BLOG_CONFIG"),
+		"config file (.yaml, .yml, .toml or .json) [$BLOG_CONFIG]")
+	var flagVals []func() error
+	scratch := Default()
+	scratchSettings := scratch.settings()
+	for i, s := range settings {
+		usage := fmt.Sprintf("%s [$%s]", s.usage, strings.Join(s.envs, ", $"))
+		fs.Var(pendingFlag{setting: s, scratch: scratchSettings[i], vals: &flagVals}, s.flagName(), usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, nil, err
+	}
+
+	if *configPath != "" {
+		if err := loadFile(*configPath, settings); err != nil {
+			return cfg, nil, err
+		}
+	}
+
+	for _, s := range settings {
+		for _, env := range s.envs {
+			if val, ok := os.LookupEnv(env); ok && val != "" {
+				if err := s.set(val); err != nil {
+					return cfg, nil, xerrors.Errorf("$%s: %w", env, err)
+				}
+				break
+			}
+		}
+	}
+
+	for _, apply := range flagVals {
+		if err := apply(); err != nil {
+			return cfg, nil, err
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return cfg, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+// loadFile applies the settings found in a YAML, TOML or JSON file. Unknown
+// keys are rejected so that typos don't go unnoticed.
+func loadFile(path string, settings []setting) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return xerrors.Errorf("failed to read config file: %w", err)
+	}
+
+	var raw map[string]interface{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		var m map[interface{}]interface{}
+		err = yaml.Unmarshal(data, &m)
+		raw = stringKeys(m)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	case ".json":
+		err = json.Unmarshal(data, &raw)
+	default:
+		return fmt.Errorf("unsupported config file format %q", ext)
+	}
+	if err != nil {
+		return xerrors.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string)
+	if err := flatten("", raw, values); err != nil {
+		return xerrors.Errorf("config file %s: %w", path, err)
+	}
+
+	byKey := make(map[string]setting, len(settings))
+	for _, s := range settings {
+		byKey[s.key] = s
+	}
+
+	// apply in a stable order so errors are deterministic
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s, ok := byKey[k]
+		if !ok {
+			return fmt.Errorf("config file %s: unknown setting %q", path, k)
+		}
+		if err := s.set(values[k]); err != nil {
+			return xerrors.Errorf("config file %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// flatten turns nested sections into dotted keys, e.g. server.addr
+func flatten(prefix string, raw map[string]interface{}, out map[string]string) error {
+	for k, v := range raw {
+		key := strings.ToLower(k)
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		switch val := v.(type) {
+		case map[string]interface{}:
+			if err := flatten(key, val, out); err != nil {
+				return err
+			}
+		case map[interface{}]interface{}:
+			if err := flatten(key, stringKeys(val), out); err != nil {
+				return err
+			}
+		case []interface{}:
+			// lists of values are written like they are in the environment
+			items := make([]string, len(val))
+			for i, item := range val {
+				switch item.(type) {
+				case map[string]interface{}, map[interface{}]interface{}, []interface{}:
+					return fmt.Errorf("setting %q must be a list of values", key)
+				}
+				items[i] = fmt.Sprint(item)
+			}
+			out[key] = strings.Join(items, ",")
+		case nil:
+			// an empty value leaves the default in place
+		case float64:
+			// JSON numbers
+			out[key] = strconv.FormatFloat(val, 'f', -1, 64)
+		default:
+			out[key] = fmt.Sprint(val)
+		}
+	}
+	return nil
+}
+
+// stringKeys converts the maps produced by the YAML decoder
+func stringKeys(m map[interface{}]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		out[fmt.Sprint(k)] = v
+	}
+	return out
+}
@@ -0,0 +1,1407 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gavinc95/go-blog/db/migrations"
+	"github.com/gavinc95/go-blog/db/models"
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+// seqID hands out predictable, valid UUIDs
+type seqID struct {
+	mu sync.Mutex
+	n  int
+}
+
+func (s *seqID) UUID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.n++
+	return fmt.Sprintf("00000000-0000-4000-8000-%012d", s.n)
+}
+
+const missingID = "8440fc74-16f3-47b1-8b27-eb2851d2afaa"
+
+func TestMemoryStore(t *testing.T) {
+	testBlogStore(t, func(t *testing.T) BlogStore {
+		return NewMemoryStore(&seqID{})
+	})
+}
+
+// TestPostgresStore runs the same suite against a real database, using the
+// same environment variables as the app. It is skipped if Postgres can't be
+// reached.
+func TestPostgresStore(t *testing.T) {
+	connectionString := fmt.Sprintf("user=%s password=%s dbname=%s sslmode=disable",
+		getEnv("POSTGRES_USER", "postgres"),
+		getEnv("POSTGRES_PASSWORD", "password"),
+		getEnv("APP_DB_NAME", "postgres"))
+	pg, err := sql.Open("postgres", connectionString)
+	require.NoError(t, err)
+	defer pg.Close()
+	if err := pg.Ping(); err != nil {
+		t.Skipf("postgres unavailable: %v", err)
+	}
+
+	migrator, err := migrations.NewMigrator(pg, migrations.All)
+	require.NoError(t, err)
+	_, err = migrator.Up()
+	require.NoError(t, err)
+
+	ids := &seqID{}
+	testBlogStore(t, func(t *testing.T) BlogStore {
+		_, err := pg.Exec("DELETE FROM users; DELETE FROM categories; DELETE FROM tags")
+		require.NoError(t, err)
+		return NewBlogStore(pg, ids)
+	})
+}
+
+func getEnv(name, defaultValue string) string {
+	if val := os.Getenv(name); val != "" {
+		return val
+	}
+	return defaultValue
+}
+
+func postIDs(posts []*models.Post) []string {
+	var ids []string
+	for _, p := range posts {
+		ids = append(ids, p.ID)
+	}
+	return ids
+}
+
+// testBlogStore is the conformance suite every BlogStore implementation must
+// pass. newStore must return an empty store.
+func testBlogStore(t *testing.T, newStore func(t *testing.T) BlogStore) {
+	t.Run("GetMissingUser", func(t *testing.T) {
+		s := newStore(t)
+		user, err := s.GetUser(missingID)
+		require.NoError(t, err)
+		require.Nil(t, user)
+	})
+
+	t.Run("CreateAndGetUser", func(t *testing.T) {
+		s := newStore(t)
+		id, err := s.CreateUser("tiny cat", "tiny@cat.com")
+		require.NoError(t, err)
+
+		user, err := s.GetUser(id)
+		require.NoError(t, err)
+		require.NotNil(t, user)
+		require.Equal(t, id, user.ID)
+		require.Equal(t, "tiny cat", user.Name)
+		require.Equal(t, "tiny@cat.com", user.Email)
+	})
+
+	t.Run("UniqueEmail", func(t *testing.T) {
+		s := newStore(t)
+		_, err := s.CreateUser("tiny cat", "tiny@cat.com")
+		require.NoError(t, err)
+		_, err = s.CreateUser("other cat", "tiny@cat.com")
+		require.True(t, xerrors.Is(err, ErrConflict), "got %v", err)
+
+		otherID, err := s.CreateUser("other cat", "other@cat.com")
+		require.NoError(t, err)
+		_, err = s.UpdateUser(otherID, "", "tiny@cat.com")
+		require.True(t, xerrors.Is(err, ErrConflict), "got %v", err)
+	})
+
+	t.Run("UpdateUser", func(t *testing.T) {
+		s := newStore(t)
+		id, err := s.CreateUser("tiny cat", "tiny@cat.com")
+		require.NoError(t, err)
+
+		// empty fields are left untouched
+		_, err = s.UpdateUser(id, "", "tiny@enterprisecatz.com")
+		require.NoError(t, err)
+		user, err := s.GetUser(id)
+		require.NoError(t, err)
+		require.Equal(t, "tiny cat", user.Name)
+		require.Equal(t, "tiny@enterprisecatz.com", user.Email)
+
+		_, err = s.UpdateUser(missingID, "nobody", "")
+		require.True(t, xerrors.Is(err, ErrNotFound), "got %v", err)
+	})
+
+	t.Run("Roles", func(t *testing.T) {
+		s := newStore(t)
+		roles, err := s.GetRoles()
+		require.NoError(t, err)
+		var names []string
+		for _, role := range roles {
+			names = append(names, role.Name)
+		}
+		require.Equal(t, []string{"admin", "author", "editor", "reader"}, names)
+		require.Contains(t, roles[2].Permissions, "post.update.any")
+		require.NotContains(t, roles[3].Permissions, "post.create.own")
+
+		id, err := s.CreateUser("tiny cat", "tiny@cat.com", "reader")
+		require.NoError(t, err)
+		userRoles, err := s.GetUserRoles(id)
+		require.NoError(t, err)
+		require.Equal(t, []string{"reader"}, userRoles)
+
+		// granting is idempotent, and permissions are merged across roles
+		require.NoError(t, s.GrantRole(id, "author"))
+		require.NoError(t, s.GrantRole(id, "author"))
+		userRoles, err = s.GetUserRoles(id)
+		require.NoError(t, err)
+		require.Equal(t, []string{"author", "reader"}, userRoles)
+		permissions, err := s.GetUserPermissions(id)
+		require.NoError(t, err)
+		require.Equal(t, []string{"comment.create.own", "comment.delete.own", "comment.moderate.own", "comment.update.own",
+			"post.create.own", "post.delete.own", "post.update.own",
+			"role.read.own", "user.delete.own", "user.update.own"}, permissions)
+
+		require.NoError(t, s.RevokeRole(id, "author"))
+		require.NoError(t, s.RevokeRole(id, "author"))
+		permissions, err = s.GetUserPermissions(id)
+		require.NoError(t, err)
+		require.NotContains(t, permissions, "post.create.own")
+
+		err = s.GrantRole(id, "overlord")
+		require.True(t, xerrors.Is(err, ErrForeignKey), "got %v", err)
+		err = s.GrantRole(missingID, "author")
+		require.True(t, xerrors.Is(err, ErrForeignKey), "got %v", err)
+		_, err = s.CreateUser("other cat", "other@cat.com", "overlord")
+		require.True(t, xerrors.Is(err, ErrForeignKey), "got %v", err)
+		user, err := s.GetUserByEmail("other@cat.com")
+		require.NoError(t, err)
+		require.Nil(t, user)
+
+		// roles go with the user
+		_, err = s.DeleteUser(id)
+		require.NoError(t, err)
+		userRoles, err = s.GetUserRoles(id)
+		require.NoError(t, err)
+		require.Empty(t, userRoles)
+	})
+
+	t.Run("GetAllUsers", func(t *testing.T) {
+		s := newStore(t)
+		var byName []string
+		for _, u := range [][2]string{
+			{"Alley cat", "zed@cat.com"},
+			{"big cat", "big@cat.com"},
+			{"tiny cat", "tiny@cat.com"},
+			{"tiny_dog", "dog@cat.com"},
+		} {
+			id, err := s.CreateUser(u[0], u[1])
+			require.NoError(t, err)
+			byName = append(byName, id)
+		}
+		ids := func(page *UserPage) []string {
+			var ids []string
+			for _, user := range page.Users {
+				ids = append(ids, user.ID)
+			}
+			return ids
+		}
+
+		page, err := s.GetAllUsers(UserListOptions{Limit: 3})
+		require.NoError(t, err)
+		require.Equal(t, byName[:3], ids(page))
+		require.True(t, page.More)
+		page, err = s.GetAllUsers(UserListOptions{Limit: 3, After: &UserKey{Name: "tiny cat", ID: byName[2]}})
+		require.NoError(t, err)
+		require.Equal(t, byName[3:], ids(page))
+		require.False(t, page.More)
+		page, err = s.GetAllUsers(UserListOptions{Limit: 2, Before: &UserKey{Name: "tiny_dog", ID: byName[3]}})
+		require.NoError(t, err)
+		require.Equal(t, byName[1:3], ids(page))
+		require.True(t, page.More)
+
+		page, err = s.GetAllUsers(UserListOptions{Sort: UserSortEmail})
+		require.NoError(t, err)
+		require.Equal(t, []string{byName[1], byName[3], byName[2], byName[0]}, ids(page))
+
+		// searches ignore case, and don't treat _ as a wildcard
+		page, err = s.GetAllUsers(UserListOptions{Search: "TINY"})
+		require.NoError(t, err)
+		require.Equal(t, byName[2:], ids(page))
+		page, err = s.GetAllUsers(UserListOptions{Search: "tiny_"})
+		require.NoError(t, err)
+		require.Equal(t, byName[3:], ids(page))
+		page, err = s.GetAllUsers(UserListOptions{Search: "zed@"})
+		require.NoError(t, err)
+		require.Equal(t, byName[:1], ids(page))
+
+		_, err = s.GetAllUsers(UserListOptions{Sort: "age"})
+		require.True(t, xerrors.Is(err, ErrValidation))
+	})
+
+	t.Run("DeleteUserCascadesPosts", func(t *testing.T) {
+		s := newStore(t)
+		userID, err := s.CreateUser("tiny cat", "tiny@cat.com")
+		require.NoError(t, err)
+		postID, err := s.CreatePost(userID, "title", "content", models.FormatPlain, models.PostPublished, time.Time{})
+		require.NoError(t, err)
+
+		_, err = s.DeleteUser(userID)
+		require.NoError(t, err)
+
+		user, err := s.GetUser(userID)
+		require.NoError(t, err)
+		require.Nil(t, user)
+		post, err := s.GetPost(postID)
+		require.NoError(t, err)
+		require.Nil(t, post)
+
+		_, err = s.DeleteUser(userID)
+		require.True(t, xerrors.Is(err, ErrNotFound), "got %v", err)
+	})
+
+	t.Run("UserWithPassword", func(t *testing.T) {
+		s := newStore(t)
+		id, err := s.CreateUserWithPassword("tiny cat", "tiny@cat.com", "$2a$04$hash")
+		require.NoError(t, err)
+		_, err = s.CreateUserWithPassword("other cat", "tiny@cat.com", "$2a$04$hash")
+		require.True(t, xerrors.Is(err, ErrConflict), "got %v", err)
+
+		user, err := s.GetUserByEmail("tiny@cat.com")
+		require.NoError(t, err)
+		require.Equal(t, id, user.ID)
+		require.Equal(t, "$2a$04$hash", user.PasswordHash)
+
+		// users created without a password have none
+		_, err = s.CreateUser("other cat", "other@cat.com")
+		require.NoError(t, err)
+		user, err = s.GetUserByEmail("other@cat.com")
+		require.NoError(t, err)
+		require.Empty(t, user.PasswordHash)
+
+		user, err = s.GetUserByEmail("nobody@cat.com")
+		require.NoError(t, err)
+		require.Nil(t, user)
+	})
+
+	t.Run("Sessions", func(t *testing.T) {
+		s := newStore(t)
+		userID, err := s.CreateUser("tiny cat", "tiny@cat.com")
+		require.NoError(t, err)
+
+		now := time.Now().UTC().Truncate(time.Second)
+		session := &models.Session{ID: "session-1", UserID: userID, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+		require.NoError(t, s.CreateSession(session))
+		err = s.CreateSession(&models.Session{ID: "session-2", UserID: missingID, CreatedAt: now, ExpiresAt: now})
+		require.True(t, xerrors.Is(err, ErrForeignKey), "got %v", err)
+
+		got, err := s.GetSession("session-1")
+		require.NoError(t, err)
+		require.Equal(t, userID, got.UserID)
+		require.True(t, now.Equal(got.CreatedAt))
+		require.True(t, now.Add(time.Hour).Equal(got.ExpiresAt))
+
+		rotated := &models.Session{ID: "session-3", UserID: userID, CreatedAt: now, ExpiresAt: now.Add(2 * time.Hour)}
+		require.NoError(t, s.RotateSession("session-1", rotated))
+		got, err = s.GetSession("session-1")
+		require.NoError(t, err)
+		require.Nil(t, got)
+		got, err = s.GetSession("session-3")
+		require.NoError(t, err)
+		require.NotNil(t, got)
+
+		// a session can only be rotated once
+		err = s.RotateSession("session-1", &models.Session{ID: "session-4", UserID: userID, CreatedAt: now, ExpiresAt: now})
+		require.True(t, xerrors.Is(err, ErrNotFound), "got %v", err)
+
+		require.NoError(t, s.DeleteSession("session-3"))
+		err = s.DeleteSession("session-3")
+		require.True(t, xerrors.Is(err, ErrNotFound), "got %v", err)
+
+		// sessions go with their user
+		require.NoError(t, s.CreateSession(session))
+		_, err = s.DeleteUser(userID)
+		require.NoError(t, err)
+		got, err = s.GetSession("session-1")
+		require.NoError(t, err)
+		require.Nil(t, got)
+	})
+
+	t.Run("RefreshTokens", func(t *testing.T) {
+		s := newStore(t)
+		userID, err := s.CreateUser("tiny cat", "tiny@cat.com")
+		require.NoError(t, err)
+
+		now := time.Now().UTC().Truncate(time.Second)
+		newToken := func(id string) *models.RefreshToken {
+			return &models.RefreshToken{ID: id, UserID: userID, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+		}
+		require.NoError(t, s.CreateRefreshToken(newToken("token-1")))
+		err = s.CreateRefreshToken(&models.RefreshToken{ID: "token-x", UserID: missingID, CreatedAt: now, ExpiresAt: now})
+		require.True(t, xerrors.Is(err, ErrForeignKey), "got %v", err)
+
+		token, err := s.GetRefreshToken("token-1")
+		require.NoError(t, err)
+		require.Equal(t, userID, token.UserID)
+		require.True(t, now.Add(time.Hour).Equal(token.ExpiresAt))
+		require.Nil(t, token.RevokedAt)
+
+		// rotating revokes the old token, and only works once
+		require.NoError(t, s.RotateRefreshToken("token-1", newToken("token-2"), now))
+		token, err = s.GetRefreshToken("token-1")
+		require.NoError(t, err)
+		require.NotNil(t, token.RevokedAt)
+		require.True(t, now.Equal(*token.RevokedAt))
+		err = s.RotateRefreshToken("token-1", newToken("token-3"), now)
+		require.True(t, xerrors.Is(err, ErrNotFound), "got %v", err)
+		token, err = s.GetRefreshToken("token-3")
+		require.NoError(t, err)
+		require.Nil(t, token)
+
+		require.NoError(t, s.RevokeRefreshToken("token-2", now))
+		err = s.RevokeRefreshToken("token-2", now)
+		require.True(t, xerrors.Is(err, ErrNotFound), "got %v", err)
+
+		require.NoError(t, s.CreateRefreshToken(newToken("token-4")))
+		require.NoError(t, s.CreateRefreshToken(newToken("token-5")))
+		require.NoError(t, s.RevokeUserRefreshTokens(userID, now))
+		for _, id := range []string{"token-4", "token-5"} {
+			token, err := s.GetRefreshToken(id)
+			require.NoError(t, err)
+			require.NotNil(t, token.RevokedAt, id)
+		}
+
+		missing, err := s.GetRefreshToken("nope")
+		require.NoError(t, err)
+		require.Nil(t, missing)
+	})
+
+	t.Run("InvalidIDs", func(t *testing.T) {
+		s := newStore(t)
+		_, err := s.GetUser("not-a-uuid")
+		require.True(t, xerrors.Is(err, ErrValidation), "got %v", err)
+		_, err = s.GetPost("not-a-uuid")
+		require.True(t, xerrors.Is(err, ErrValidation), "got %v", err)
+		_, err = s.DeleteUser("not-a-uuid")
+		require.True(t, xerrors.Is(err, ErrValidation), "got %v", err)
+	})
+
+	t.Run("GetMissingPost", func(t *testing.T) {
+		s := newStore(t)
+		post, err := s.GetPost(missingID)
+		require.NoError(t, err)
+		require.Nil(t, post)
+	})
+
+	t.Run("CreatePostRequiresUser", func(t *testing.T) {
+		s := newStore(t)
+		_, err := s.CreatePost(missingID, "title", "content", models.FormatPlain, models.PostPublished, time.Time{})
+		require.True(t, xerrors.Is(err, ErrForeignKey), "got %v", err)
+	})
+
+	t.Run("CreateUpdateAndListPosts", func(t *testing.T) {
+		s := newStore(t)
+		userID, err := s.CreateUser("tiny cat", "tiny@cat.com")
+		require.NoError(t, err)
+		otherID, err := s.CreateUser("other cat", "other@cat.com")
+		require.NoError(t, err)
+
+		postID, err := s.CreatePost(userID, "title", "content", models.FormatPlain, models.PostPublished, time.Time{})
+		require.NoError(t, err)
+		postID2, err := s.CreatePost(userID, "title 2", "content 2", models.FormatPlain, models.PostPublished, time.Time{})
+		require.NoError(t, err)
+		_, err = s.CreatePost(otherID, "not mine", "content", models.FormatPlain, models.PostPublished, time.Time{})
+		require.NoError(t, err)
+
+		_, err = s.UpdatePost(postID, otherID, "updated title", "", "")
+		require.NoError(t, err)
+		post, err := s.GetPost(postID)
+		require.NoError(t, err)
+		require.Equal(t, postID, post.ID)
+		require.Equal(t, userID, post.UserID)
+		require.Equal(t, "updated title", post.Title)
+		require.Equal(t, "content", post.Content)
+		require.Equal(t, otherID, post.UpdatedBy)
+
+		page, err := s.GetAllPosts(userID, PostListOptions{})
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{postID, postID2}, postIDs(page.Posts))
+		require.False(t, page.More)
+
+		page, err = s.GetAllPosts(missingID, PostListOptions{})
+		require.NoError(t, err)
+		require.Empty(t, page.Posts)
+
+		_, err = s.UpdatePost(missingID, userID, "title", "content", "")
+		require.True(t, xerrors.Is(err, ErrNotFound), "got %v", err)
+	})
+
+	t.Run("PostTimestamps", func(t *testing.T) {
+		s := newStore(t)
+		userID, err := s.CreateUser("tiny cat", "tiny@cat.com")
+		require.NoError(t, err)
+		editorID, err := s.CreateUser("editor cat", "editor@cat.com")
+		require.NoError(t, err)
+
+		before := time.Now().Add(-time.Second)
+		postID, err := s.CreatePost(userID, "title", "content", models.FormatPlain, models.PostPublished, time.Time{})
+		require.NoError(t, err)
+		post, err := s.GetPost(postID)
+		require.NoError(t, err)
+		require.True(t, post.CreatedAt.After(before), "created_at %v", post.CreatedAt)
+		require.Equal(t, time.UTC, post.CreatedAt.Location())
+		require.True(t, post.UpdatedAt.Equal(post.CreatedAt))
+		require.NotNil(t, post.PublishedAt)
+		require.True(t, post.PublishedAt.Equal(post.CreatedAt))
+		require.Empty(t, post.UpdatedBy)
+
+		// empty updates change nothing, not even updated_at
+		_, err = s.UpdatePost(postID, editorID, "", "", "")
+		require.NoError(t, err)
+		unchanged, err := s.GetPost(postID)
+		require.NoError(t, err)
+		require.Equal(t, post, unchanged)
+
+		time.Sleep(10 * time.Millisecond)
+		_, err = s.UpdatePost(postID, editorID, "", "edited", "")
+		require.NoError(t, err)
+		edited, err := s.GetPost(postID)
+		require.NoError(t, err)
+		require.True(t, edited.CreatedAt.Equal(post.CreatedAt))
+		require.True(t, edited.UpdatedAt.After(post.UpdatedAt))
+		require.Equal(t, editorID, edited.UpdatedBy)
+
+		_, err = s.UpdatePost(postID, missingID, "", "edited again", "")
+		require.True(t, xerrors.Is(err, ErrForeignKey), "got %v", err)
+
+		// the author's posts outlive the editor, once they are purged
+		_, err = s.DeleteUser(editorID)
+		require.NoError(t, err)
+		_, _, err = s.PurgeDeleted(time.Now().Add(time.Second))
+		require.NoError(t, err)
+		edited, err = s.GetPost(postID)
+		require.NoError(t, err)
+		require.Empty(t, edited.UpdatedBy)
+	})
+
+	t.Run("PostLifecycle", func(t *testing.T) {
+		s := newStore(t)
+		userID, err := s.CreateUser("tiny cat", "tiny@cat.com")
+		require.NoError(t, err)
+
+		_, err = s.CreatePost(userID, "title", "content", models.FormatPlain, models.PostArchived, time.Time{})
+		require.True(t, xerrors.Is(err, ErrConflict), "got %v", err)
+		_, err = s.CreatePost(userID, "title", "content", models.FormatPlain, models.PostScheduled, time.Now().Add(-time.Minute))
+		require.True(t, xerrors.Is(err, ErrValidation), "got %v", err)
+		_, err = s.CreatePost(userID, "title", "content", models.FormatPlain, "lost", time.Time{})
+		require.True(t, xerrors.Is(err, ErrValidation), "got %v", err)
+
+		draftID, err := s.CreatePost(userID, "draft", "content", models.FormatPlain, models.PostDraft, time.Time{})
+		require.NoError(t, err)
+		draft, err := s.GetPost(draftID)
+		require.NoError(t, err)
+		require.Equal(t, models.PostDraft, draft.Status)
+		require.Nil(t, draft.PublishedAt)
+
+		// drafts only show up in listings that ask for them
+		page, err := s.GetAllPosts(userID, PostListOptions{}, models.PostPublished)
+		require.NoError(t, err)
+		require.Empty(t, page.Posts)
+		page, err = s.GetAllPosts(userID, PostListOptions{}, models.PostDraft, models.PostPublished)
+		require.NoError(t, err)
+		require.Equal(t, []string{draftID}, postIDs(page.Posts))
+		page, err = s.GetFeed(FeedFilter{}, PostListOptions{})
+		require.NoError(t, err)
+		require.Empty(t, page.Posts)
+
+		_, err = s.SetPostStatus(draftID, userID, models.PostArchived, time.Time{})
+		require.True(t, xerrors.Is(err, ErrConflict), "got %v", err)
+		_, err = s.SetPostStatus(missingID, userID, models.PostPublished, time.Time{})
+		require.True(t, xerrors.Is(err, ErrNotFound), "got %v", err)
+
+		// scheduled posts are published by PublishDuePosts once they are due
+		publishAt := time.Now().Add(50 * time.Millisecond)
+		_, err = s.SetPostStatus(draftID, userID, models.PostScheduled, publishAt)
+		require.NoError(t, err)
+		scheduled, err := s.GetPost(draftID)
+		require.NoError(t, err)
+		require.Equal(t, models.PostScheduled, scheduled.Status)
+		require.WithinDuration(t, publishAt, *scheduled.PublishedAt, time.Millisecond)
+
+		ids, err := s.PublishDuePosts()
+		require.NoError(t, err)
+		require.Empty(t, ids)
+		time.Sleep(60 * time.Millisecond)
+		ids, err = s.PublishDuePosts()
+		require.NoError(t, err)
+		require.Equal(t, []string{draftID}, ids)
+		published, err := s.GetPost(draftID)
+		require.NoError(t, err)
+		require.Equal(t, models.PostPublished, published.Status)
+		require.True(t, published.PublishedAt.Equal(*scheduled.PublishedAt))
+		page, err = s.GetFeed(FeedFilter{}, PostListOptions{})
+		require.NoError(t, err)
+		require.Equal(t, []string{draftID}, postIDs(page.Posts))
+
+		// archiving takes the post out of the feed, and restoring it keeps
+		// its publication time
+		_, err = s.SetPostStatus(draftID, userID, models.PostArchived, time.Time{})
+		require.NoError(t, err)
+		page, err = s.GetFeed(FeedFilter{}, PostListOptions{})
+		require.NoError(t, err)
+		require.Empty(t, page.Posts)
+		_, err = s.SetPostStatus(draftID, userID, models.PostPublished, time.Time{})
+		require.NoError(t, err)
+		restored, err := s.GetPost(draftID)
+		require.NoError(t, err)
+		require.True(t, restored.PublishedAt.Equal(*published.PublishedAt))
+
+		_, err = s.SetPostStatus(draftID, userID, models.PostDraft, time.Time{})
+		require.NoError(t, err)
+		unpublished, err := s.GetPost(draftID)
+		require.NoError(t, err)
+		require.Nil(t, unpublished.PublishedAt)
+	})
+
+	t.Run("Revisions", func(t *testing.T) {
+		s := newStore(t)
+		userID, err := s.CreateUser("tiny cat", "tiny@cat.com")
+		require.NoError(t, err)
+		editorID, err := s.CreateUser("editor cat", "editor@cat.com")
+		require.NoError(t, err)
+
+		postID, err := s.CreatePost(userID, "first", "one", models.FormatPlain, models.PostDraft, time.Time{})
+		require.NoError(t, err)
+		_, err = s.UpdatePost(postID, editorID, "second", "", "")
+		require.NoError(t, err)
+		// empty updates and status changes leave no trace
+		_, err = s.UpdatePost(postID, editorID, "", "", "")
+		require.NoError(t, err)
+		_, err = s.SetPostStatus(postID, userID, models.PostPublished, time.Time{})
+		require.NoError(t, err)
+
+		revisions, err := s.GetRevisions(postID)
+		require.NoError(t, err)
+		require.Len(t, revisions, 2)
+		require.Equal(t, 1, revisions[0].Number)
+		require.Equal(t, "first", revisions[0].Title)
+		require.Equal(t, userID, revisions[0].CreatedBy)
+		require.Equal(t, "second", revisions[1].Title)
+		require.Equal(t, "one", revisions[1].Content)
+		require.Equal(t, editorID, revisions[1].CreatedBy)
+
+		number, err := s.RestoreRevision(postID, 1, editorID)
+		require.NoError(t, err)
+		require.Equal(t, 3, number)
+		post, err := s.GetPost(postID)
+		require.NoError(t, err)
+		require.Equal(t, "first", post.Title)
+		require.Equal(t, editorID, post.UpdatedBy)
+		rev, err := s.GetRevision(postID, 3)
+		require.NoError(t, err)
+		require.Equal(t, "first", rev.Title)
+		require.True(t, rev.CreatedAt.Equal(post.UpdatedAt))
+
+		rev, err = s.GetRevision(postID, 4)
+		require.NoError(t, err)
+		require.Nil(t, rev)
+		_, err = s.RestoreRevision(postID, 4, editorID)
+		require.True(t, xerrors.Is(err, ErrNotFound), "got %v", err)
+		_, err = s.RestoreRevision(missingID, 1, editorID)
+		require.True(t, xerrors.Is(err, ErrNotFound), "got %v", err)
+
+		// the history outlives its editors, but not the post
+		_, err = s.DeleteUser(editorID)
+		require.NoError(t, err)
+		_, _, err = s.PurgeDeleted(time.Now().Add(time.Second))
+		require.NoError(t, err)
+		revisions, err = s.GetRevisions(postID)
+		require.NoError(t, err)
+		require.Len(t, revisions, 3)
+		require.Empty(t, revisions[1].CreatedBy)
+		_, err = s.DeletePost(postID)
+		require.NoError(t, err)
+		revisions, err = s.GetRevisions(postID)
+		require.NoError(t, err)
+		require.Empty(t, revisions)
+	})
+
+	t.Run("Formats", func(t *testing.T) {
+		s := newStore(t)
+		userID, err := s.CreateUser("tiny cat", "tiny@cat.com")
+		require.NoError(t, err)
+
+		postID, err := s.CreatePost(userID, "title", "*hi*", models.FormatMarkdown, models.PostDraft, time.Time{})
+		require.NoError(t, err)
+		post, err := s.GetPost(postID)
+		require.NoError(t, err)
+		require.Equal(t, models.FormatMarkdown, post.Format)
+		require.Equal(t, "*hi*", post.Content)
+		require.Equal(t, "<p><em>hi</em></p>\n", post.HTML)
+		_, err = s.CreatePost(userID, "title", "hi", "rtf", models.PostDraft, time.Time{})
+		require.True(t, xerrors.Is(err, ErrValidation), "got %v", err)
+
+		// changing either the content or the format renders the post again
+		_, err = s.UpdatePost(postID, userID, "", "", models.FormatPlain)
+		require.NoError(t, err)
+		post, err = s.GetPost(postID)
+		require.NoError(t, err)
+		require.Equal(t, "<p>*hi*</p>\n", post.HTML)
+		_, err = s.UpdatePost(postID, userID, "", "<b>bye</b><script></script>", models.FormatHTML)
+		require.NoError(t, err)
+		post, err = s.GetPost(postID)
+		require.NoError(t, err)
+		require.Equal(t, "<b>bye</b>", post.HTML)
+		_, err = s.UpdatePost(postID, userID, "", "", "rtf")
+		require.True(t, xerrors.Is(err, ErrValidation), "got %v", err)
+
+		// revisions keep their format
+		rev, err := s.GetRevision(postID, 1)
+		require.NoError(t, err)
+		require.Equal(t, models.FormatMarkdown, rev.Format)
+		_, err = s.RestoreRevision(postID, 1, userID)
+		require.NoError(t, err)
+		post, err = s.GetPost(postID)
+		require.NoError(t, err)
+		require.Equal(t, models.FormatMarkdown, post.Format)
+		require.Equal(t, "<p><em>hi</em></p>\n", post.HTML)
+
+		n, err := s.RenderPosts()
+		require.NoError(t, err)
+		require.Zero(t, n)
+	})
+
+	t.Run("Slugs", func(t *testing.T) {
+		s := newStore(t)
+		userID, err := s.CreateUser("tiny cat", "tiny@cat.com")
+		require.NoError(t, err)
+
+		firstID, err := s.CreatePost(userID, "Hello, World!", "content", models.FormatPlain, models.PostPublished, time.Time{})
+		require.NoError(t, err)
+		first, err := s.GetPostBySlug("hello-world")
+		require.NoError(t, err)
+		require.Equal(t, firstID, first.ID)
+		require.Equal(t, "hello-world", first.Slug)
+
+		// clashing titles get the start of their ID, or all of it
+		secondID, err := s.CreatePost(userID, "hello world", "content", models.FormatPlain, models.PostDraft, time.Time{})
+		require.NoError(t, err)
+		second, err := s.GetPost(secondID)
+		require.NoError(t, err)
+		require.Equal(t, "hello-world-"+secondID[:8], second.Slug)
+		thirdID, err := s.CreatePost(userID, "Hello world", "content", models.FormatPlain, models.PostDraft, time.Time{})
+		require.NoError(t, err)
+		third, err := s.GetPost(thirdID)
+		require.NoError(t, err)
+		require.NotContains(t, []string{first.Slug, second.Slug}, third.Slug)
+
+		// the slug stays put when the title changes
+		_, err = s.UpdatePost(firstID, userID, "Goodbye", "", "")
+		require.NoError(t, err)
+		first, err = s.GetPostBySlug("hello-world")
+		require.NoError(t, err)
+		require.Equal(t, firstID, first.ID)
+
+		// trashed posts keep their slugs, but can't be found by them
+		_, err = s.DeletePost(firstID)
+		require.NoError(t, err)
+		first, err = s.GetPostBySlug("hello-world")
+		require.NoError(t, err)
+		require.Nil(t, first)
+		fourthID, err := s.CreatePost(userID, "Hello World", "content", models.FormatPlain, models.PostDraft, time.Time{})
+		require.NoError(t, err)
+		fourth, err := s.GetPost(fourthID)
+		require.NoError(t, err)
+		require.NotEqual(t, "hello-world", fourth.Slug)
+
+		missing, err := s.GetPostBySlug("missing")
+		require.NoError(t, err)
+		require.Nil(t, missing)
+
+		// posts created at once with the same title all get their own slug
+		var wg sync.WaitGroup
+		ids := make([]string, 5)
+		errs := make([]error, 5)
+		for i := range ids {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				ids[i], errs[i] = s.CreatePost(userID, "Race", "content", models.FormatPlain, models.PostDraft, time.Time{})
+			}(i)
+		}
+		wg.Wait()
+		slugs := make(map[string]bool)
+		for i, id := range ids {
+			require.NoError(t, errs[i])
+			post, err := s.GetPost(id)
+			require.NoError(t, err)
+			slugs[post.Slug] = true
+		}
+		require.Len(t, slugs, 5)
+	})
+
+	t.Run("GetAllPostsPages", func(t *testing.T) {
+		s := newStore(t)
+		userID, err := s.CreateUser("tiny cat", "tiny@cat.com")
+		require.NoError(t, err)
+
+		// created oldest first, with titles out of order
+		var oldest []string
+		for _, title := range []string{"c", "a", "e", "b", "d"} {
+			postID, err := s.CreatePost(userID, title, "content", models.FormatPlain, models.PostPublished, time.Time{})
+			require.NoError(t, err)
+			oldest = append(oldest, postID)
+			time.Sleep(2 * time.Millisecond)
+		}
+		newest := []string{oldest[4], oldest[3], oldest[2], oldest[1], oldest[0]}
+		byTitle := []string{oldest[1], oldest[3], oldest[0], oldest[4], oldest[2]}
+
+		for sort, want := range map[PostSort][]string{
+			"":         newest,
+			SortNewest: newest,
+			SortOldest: oldest,
+			SortTitle:  byTitle,
+		} {
+			// page forwards two at a time
+			var got []string
+			opts := PostListOptions{Sort: sort, Limit: 2}
+			for {
+				page, err := s.GetAllPosts(userID, opts)
+				require.NoError(t, err)
+				got = append(got, postIDs(page.Posts)...)
+				if !page.More {
+					break
+				}
+				key := KeyOf(page.Posts[len(page.Posts)-1])
+				opts.After = &key
+			}
+			require.Equal(t, want, got, "sort %q", sort)
+
+			// and back again from the end
+			got = nil
+			last, err := s.GetPost(want[len(want)-1])
+			require.NoError(t, err)
+			key := KeyOf(last)
+			opts = PostListOptions{Sort: sort, Limit: 2, Before: &key}
+			for {
+				page, err := s.GetAllPosts(userID, opts)
+				require.NoError(t, err)
+				got = append(postIDs(page.Posts), got...)
+				if !page.More {
+					break
+				}
+				key := KeyOf(page.Posts[0])
+				opts.Before = &key
+			}
+			require.Equal(t, want[:len(want)-1], got, "sort %q", sort)
+		}
+
+		_, err = s.GetAllPosts(userID, PostListOptions{Sort: "popular"})
+		require.True(t, xerrors.Is(err, ErrValidation), "got %v", err)
+	})
+
+	t.Run("Feed", func(t *testing.T) {
+		s := newStore(t)
+		userID, err := s.CreateUser("tiny cat", "tiny@cat.com")
+		require.NoError(t, err)
+		otherID, err := s.CreateUser("other cat", "other@cat.com")
+		require.NoError(t, err)
+
+		// written before the rest, but published after them
+		draftID, err := s.CreatePost(otherID, "draft", "content", models.FormatPlain, models.PostDraft, time.Time{})
+		require.NoError(t, err)
+		time.Sleep(2 * time.Millisecond)
+
+		start := time.Now().Add(-time.Second)
+		var newest []string
+		for _, author := range []string{userID, otherID, userID} {
+			postID, err := s.CreatePost(author, "title", "content", models.FormatPlain, models.PostPublished, time.Time{})
+			require.NoError(t, err)
+			newest = append([]string{postID}, newest...)
+			time.Sleep(2 * time.Millisecond)
+		}
+
+		page, err := s.GetFeed(FeedFilter{}, PostListOptions{Limit: 2})
+		require.NoError(t, err)
+		require.Equal(t, newest[:2], postIDs(page.Posts))
+		require.True(t, page.More)
+		require.Equal(t, &models.PostAuthor{ID: userID, Name: "tiny cat"}, page.Posts[0].Author)
+		require.Equal(t, &models.PostAuthor{ID: otherID, Name: "other cat"}, page.Posts[1].Author)
+
+		page, err = s.GetFeed(FeedFilter{AuthorID: userID}, PostListOptions{})
+		require.NoError(t, err)
+		require.Equal(t, []string{newest[0], newest[2]}, postIDs(page.Posts))
+
+		page, err = s.GetFeed(FeedFilter{PublishedFrom: start, PublishedTo: time.Now().Add(time.Second)}, PostListOptions{})
+		require.NoError(t, err)
+		require.Len(t, page.Posts, 3)
+		page, err = s.GetFeed(FeedFilter{PublishedTo: start}, PostListOptions{})
+		require.NoError(t, err)
+		require.Empty(t, page.Posts)
+		page, err = s.GetFeed(FeedFilter{PublishedFrom: time.Now().Add(time.Second)}, PostListOptions{})
+		require.NoError(t, err)
+		require.Empty(t, page.Posts)
+
+		// the feed is in order of publication
+		_, err = s.SetPostStatus(draftID, otherID, models.PostPublished, time.Time{})
+		require.NoError(t, err)
+		page, err = s.GetFeed(FeedFilter{}, PostListOptions{Limit: 2})
+		require.NoError(t, err)
+		require.Equal(t, []string{draftID, newest[0]}, postIDs(page.Posts))
+		after := KeyOf(page.Posts[1])
+		page, err = s.GetFeed(FeedFilter{}, PostListOptions{After: &after})
+		require.NoError(t, err)
+		require.Equal(t, newest[1:], postIDs(page.Posts))
+		page, err = s.GetFeed(FeedFilter{}, PostListOptions{Sort: SortOldest})
+		require.NoError(t, err)
+		require.Equal(t, []string{newest[2], newest[1], newest[0], draftID}, postIDs(page.Posts))
+	})
+
+	t.Run("Taxonomy", func(t *testing.T) {
+		s := newStore(t)
+		userID, err := s.CreateUser("tiny cat", "tiny@cat.com")
+		require.NoError(t, err)
+		var newest []string
+		for i := 0; i < 3; i++ {
+			postID, err := s.CreatePost(userID, "title", "content", models.FormatPlain, models.PostPublished, time.Time{})
+			require.NoError(t, err)
+			newest = append([]string{postID}, newest...)
+			time.Sleep(2 * time.Millisecond)
+		}
+		draftID, err := s.CreatePost(userID, "draft", "content", models.FormatPlain, models.PostDraft, time.Time{})
+		require.NoError(t, err)
+
+		for _, postID := range newest {
+			require.NoError(t, s.TagPost(postID, "cats"))
+		}
+		require.NoError(t, s.TagPost(newest[0], "dogs"))
+		require.NoError(t, s.TagPost(newest[0], "dogs"))
+		require.NoError(t, s.TagPost(draftID, "dogs"))
+		require.NoError(t, s.TagPost(draftID, "drafts"))
+		err = s.TagPost(missingID, "cats")
+		require.True(t, xerrors.Is(err, ErrNotFound), "got %v", err)
+
+		post, err := s.GetPost(newest[0])
+		require.NoError(t, err)
+		require.Equal(t, []string{"cats", "dogs"}, post.Tags)
+
+		// only published posts count
+		counts, err := s.GetTagCounts(0)
+		require.NoError(t, err)
+		require.Equal(t, []*models.TagCount{{Tag: "cats", Count: 3}, {Tag: "dogs", Count: 1}}, counts)
+		counts, err = s.GetTagCounts(1)
+		require.NoError(t, err)
+		require.Len(t, counts, 1)
+
+		page, err := s.GetFeed(FeedFilter{Tag: "cats"}, PostListOptions{Limit: 2})
+		require.NoError(t, err)
+		require.Equal(t, newest[:2], postIDs(page.Posts))
+		require.True(t, page.More)
+		require.Equal(t, []string{"cats", "dogs"}, page.Posts[0].Tags)
+		page, err = s.GetFeed(FeedFilter{Tag: "dogs"}, PostListOptions{})
+		require.NoError(t, err)
+		require.Equal(t, newest[:1], postIDs(page.Posts))
+
+		require.NoError(t, s.UntagPost(newest[0], "dogs"))
+		require.NoError(t, s.UntagPost(newest[0], "dogs"))
+		page, err = s.GetFeed(FeedFilter{Tag: "dogs"}, PostListOptions{})
+		require.NoError(t, err)
+		require.Empty(t, page.Posts)
+
+		// categories nest, and a category's posts include its subcategories'
+		animalsID, err := s.CreateCategory("animals", "")
+		require.NoError(t, err)
+		petsID, err := s.CreateCategory("pets", animalsID)
+		require.NoError(t, err)
+		_, err = s.CreateCategory("pets", animalsID)
+		require.True(t, xerrors.Is(err, ErrConflict), "got %v", err)
+		_, err = s.CreateCategory("pets", "")
+		require.NoError(t, err)
+		_, err = s.CreateCategory("orphans", missingID)
+		require.True(t, xerrors.Is(err, ErrForeignKey), "got %v", err)
+
+		categories, err := s.GetCategories()
+		require.NoError(t, err)
+		require.Len(t, categories, 3)
+		require.Equal(t, "animals", categories[0].Name)
+		category, err := s.GetCategory(petsID)
+		require.NoError(t, err)
+		require.Equal(t, &models.Category{ID: petsID, Name: "pets", ParentID: animalsID}, category)
+		category, err = s.GetCategory(missingID)
+		require.NoError(t, err)
+		require.Nil(t, category)
+
+		require.NoError(t, s.CategorizePost(newest[0], petsID))
+		require.NoError(t, s.CategorizePost(newest[1], animalsID))
+		require.NoError(t, s.CategorizePost(newest[1], petsID))
+		err = s.CategorizePost(newest[2], missingID)
+		require.True(t, xerrors.Is(err, ErrForeignKey), "got %v", err)
+		categories, err = s.GetPostCategories(newest[1])
+		require.NoError(t, err)
+		require.Len(t, categories, 2)
+
+		page, err = s.GetFeed(FeedFilter{CategoryID: animalsID}, PostListOptions{})
+		require.NoError(t, err)
+		require.Equal(t, newest[:2], postIDs(page.Posts))
+		page, err = s.GetFeed(FeedFilter{CategoryID: petsID, Tag: "cats"}, PostListOptions{})
+		require.NoError(t, err)
+		require.Equal(t, newest[:2], postIDs(page.Posts))
+
+		require.NoError(t, s.UncategorizePost(newest[1], petsID))
+		page, err = s.GetFeed(FeedFilter{CategoryID: petsID}, PostListOptions{})
+		require.NoError(t, err)
+		require.Equal(t, newest[:1], postIDs(page.Posts))
+
+		_, err = s.DeleteCategory(animalsID)
+		require.True(t, xerrors.Is(err, ErrConflict), "got %v", err)
+		_, err = s.DeleteCategory(petsID)
+		require.NoError(t, err)
+		categories, err = s.GetPostCategories(newest[0])
+		require.NoError(t, err)
+		require.Empty(t, categories)
+		_, err = s.DeleteCategory(petsID)
+		require.True(t, xerrors.Is(err, ErrNotFound), "got %v", err)
+	})
+
+	t.Run("Search", func(t *testing.T) {
+		s := newStore(t)
+		require.NoError(t, s.SetSearchLanguage("english"))
+		userID, err := s.CreateUser("tiny cat", "tiny@cat.com")
+		require.NoError(t, err)
+		bestID, err := s.CreatePost(userID, "kitten", "a kitten on the piano", models.FormatPlain, models.PostPublished, time.Time{})
+		require.NoError(t, err)
+		otherID, err := s.CreatePost(userID, "garden", "a kitten in the garden, eating a banana", models.FormatPlain, models.PostPublished, time.Time{})
+		require.NoError(t, err)
+		_, err = s.CreatePost(userID, "kitten draft", "kitten", models.FormatPlain, models.PostDraft, time.Time{})
+		require.NoError(t, err)
+		deletedID, err := s.CreatePost(userID, "kitten", "kitten", models.FormatPlain, models.PostPublished, time.Time{})
+		require.NoError(t, err)
+		_, err = s.DeletePost(deletedID)
+		require.NoError(t, err)
+
+		// titles weigh more than content
+		page, err := s.SearchPosts(SearchQuery{Text: "Kitten"})
+		require.NoError(t, err)
+		require.Len(t, page.Results, 2)
+		require.Equal(t, bestID, page.Results[0].Post.ID)
+		require.Equal(t, otherID, page.Results[1].Post.ID)
+		require.Greater(t, page.Results[0].Rank, page.Results[1].Rank)
+		require.Contains(t, page.Results[1].Snippet, "<mark>kitten</mark> in the garden")
+
+		page, err = s.SearchPosts(SearchQuery{Text: "kitten", Limit: 1})
+		require.NoError(t, err)
+		require.True(t, page.More)
+		last := page.Results[0]
+		page, err = s.SearchPosts(SearchQuery{Text: "kitten", Limit: 1, After: &SearchKey{Rank: last.Rank, ID: last.Post.ID}})
+		require.NoError(t, err)
+		require.False(t, page.More)
+		require.Equal(t, otherID, page.Results[0].Post.ID)
+
+		for text, want := range map[string][]string{
+			`"kitten on the piano"`: {bestID},
+			`"piano kitten"`:        nil,
+			"ban*":                  {otherID},
+			"kitten -garden":        {bestID},
+			"kitten banana":         {otherID},
+		} {
+			page, err = s.SearchPosts(SearchQuery{Text: text})
+			require.NoError(t, err)
+			var ids []string
+			for _, result := range page.Results {
+				ids = append(ids, result.Post.ID)
+			}
+			require.Equal(t, want, ids, text)
+		}
+
+		_, err = s.SearchPosts(SearchQuery{Text: "-kitten"})
+		require.True(t, xerrors.Is(err, ErrValidation), "got %v", err)
+
+		// content can't forge the marks around matches
+		_, err = s.CreatePost(userID, "marks", "\ue001a puppy\ue000 and a \ue000tiger\ue001", models.FormatPlain, models.PostPublished, time.Time{})
+		require.NoError(t, err)
+		page, err = s.SearchPosts(SearchQuery{Text: "puppy"})
+		require.NoError(t, err)
+		require.Len(t, page.Results, 1)
+		require.Contains(t, page.Results[0].Snippet, "a <mark>puppy</mark> and a tiger")
+	})
+
+	t.Run("Comments", func(t *testing.T) {
+		s := newStore(t)
+		authorID, err := s.CreateUser("tiny cat", "tiny@cat.com", "author")
+		require.NoError(t, err)
+		readerID, err := s.CreateUser("big cat", "big@cat.com", "reader")
+		require.NoError(t, err)
+		postID, err := s.CreatePost(authorID, "title", "content", models.FormatPlain, models.PostPublished, time.Time{})
+		require.NoError(t, err)
+		otherPostID, err := s.CreatePost(authorID, "other", "content", models.FormatPlain, models.PostPublished, time.Time{})
+		require.NoError(t, err)
+		draftID, err := s.CreatePost(authorID, "draft", "content", models.FormatPlain, models.PostDraft, time.Time{})
+		require.NoError(t, err)
+
+		var topIDs []string
+		for i := 0; i < 3; i++ {
+			id, err := s.CreateComment(postID, readerID, "", fmt.Sprintf("comment %d", i), models.CommentApproved)
+			require.NoError(t, err)
+			topIDs = append(topIDs, id)
+		}
+		replyID, err := s.CreateComment(postID, authorID, topIDs[0], "reply", models.CommentApproved)
+		require.NoError(t, err)
+		nestedID, err := s.CreateComment(postID, readerID, replyID, "nested", models.CommentApproved)
+		require.NoError(t, err)
+
+		_, err = s.CreateComment(draftID, readerID, "", "too soon", models.CommentApproved)
+		require.True(t, xerrors.Is(err, ErrConflict), "got %v", err)
+		_, err = s.CreateComment(otherPostID, readerID, topIDs[0], "wrong post", models.CommentApproved)
+		require.True(t, xerrors.Is(err, ErrValidation), "got %v", err)
+		_, err = s.CreateComment(postID, readerID, postID, "no such parent", models.CommentApproved)
+		require.True(t, xerrors.Is(err, ErrForeignKey), "got %v", err)
+		_, err = s.CreateComment(postID, readerID, "", "", models.CommentApproved)
+		require.True(t, xerrors.Is(err, ErrValidation), "got %v", err)
+
+		comment, err := s.GetComment(topIDs[0])
+		require.NoError(t, err)
+		require.Equal(t, "comment 0", comment.Content)
+		require.Equal(t, postID, comment.PostID)
+		require.Empty(t, comment.ParentID)
+		require.Equal(t, 1, comment.Replies)
+		require.Equal(t, "big cat", comment.Author.Name)
+		comment, err = s.GetComment(replyID)
+		require.NoError(t, err)
+		require.Equal(t, topIDs[0], comment.ParentID)
+
+		// threads page like posts, oldest first by default
+		commentIDs := func(page *CommentPage) []string {
+			var ids []string
+			for _, comment := range page.Comments {
+				ids = append(ids, comment.ID)
+			}
+			return ids
+		}
+		page, err := s.GetComments(postID, CommentListOptions{Limit: 2})
+		require.NoError(t, err)
+		require.Equal(t, topIDs[:2], commentIDs(page))
+		require.True(t, page.More)
+		after := CommentKeyOf(page.Comments[1])
+		page, err = s.GetComments(postID, CommentListOptions{Limit: 2, After: &after})
+		require.NoError(t, err)
+		require.Equal(t, topIDs[2:], commentIDs(page))
+		require.False(t, page.More)
+		page, err = s.GetComments(postID, CommentListOptions{Sort: CommentSortNewest, Limit: 1})
+		require.NoError(t, err)
+		require.Equal(t, topIDs[2:], commentIDs(page))
+		page, err = s.GetComments(postID, CommentListOptions{ParentID: topIDs[0]})
+		require.NoError(t, err)
+		require.Equal(t, []string{replyID}, commentIDs(page))
+
+		_, err = s.UpdateComment(replyID, "edited", "")
+		require.NoError(t, err)
+		comment, err = s.GetComment(replyID)
+		require.NoError(t, err)
+		require.Equal(t, "edited", comment.Content)
+
+		// deleting a comment takes its replies with it
+		_, err = s.DeleteComment(replyID)
+		require.NoError(t, err)
+		comment, err = s.GetComment(nestedID)
+		require.NoError(t, err)
+		require.Nil(t, comment)
+		comment, err = s.GetComment(topIDs[0])
+		require.NoError(t, err)
+		require.Zero(t, comment.Replies)
+		_, err = s.DeleteComment(replyID)
+		require.True(t, xerrors.Is(err, ErrNotFound), "got %v", err)
+		_, err = s.UpdateComment(replyID, "again", "")
+		require.True(t, xerrors.Is(err, ErrNotFound), "got %v", err)
+
+		// comments are hidden with their post, and come back with it
+		_, err = s.DeletePost(postID)
+		require.NoError(t, err)
+		comment, err = s.GetComment(topIDs[1])
+		require.NoError(t, err)
+		require.Nil(t, comment)
+		_, err = s.RestorePost(postID)
+		require.NoError(t, err)
+		page, err = s.GetComments(postID, CommentListOptions{})
+		require.NoError(t, err)
+		require.Equal(t, topIDs, commentIDs(page))
+
+		// and with their author, replies included
+		otherReplyID, err := s.CreateComment(postID, authorID, topIDs[1], "reply", models.CommentApproved)
+		require.NoError(t, err)
+		_, err = s.DeleteUser(readerID)
+		require.NoError(t, err)
+		page, err = s.GetComments(postID, CommentListOptions{})
+		require.NoError(t, err)
+		require.Empty(t, page.Comments)
+		comment, err = s.GetComment(otherReplyID)
+		require.NoError(t, err)
+		require.Nil(t, comment)
+		_, err = s.RestoreUser(readerID)
+		require.NoError(t, err)
+		comment, err = s.GetComment(otherReplyID)
+		require.NoError(t, err)
+		require.NotNil(t, comment)
+		comment, err = s.GetComment(nestedID)
+		require.NoError(t, err)
+		require.Nil(t, comment)
+
+		// deleted comments are purged with the trash
+		_, _, err = s.PurgeDeleted(time.Now().Add(time.Second))
+		require.NoError(t, err)
+		_, err = s.CreateComment(postID, readerID, replyID, "gone", models.CommentApproved)
+		require.True(t, xerrors.Is(err, ErrForeignKey), "got %v", err)
+	})
+
+	t.Run("Moderation", func(t *testing.T) {
+		s := newStore(t)
+		authorID, err := s.CreateUser("tiny cat", "tiny@cat.com", "author")
+		require.NoError(t, err)
+		otherID, err := s.CreateUser("other cat", "other@cat.com", "author")
+		require.NoError(t, err)
+		readerID, err := s.CreateUser("big cat", "big@cat.com", "reader")
+		require.NoError(t, err)
+		postID, err := s.CreatePost(authorID, "title", "content", models.FormatPlain, models.PostPublished, time.Time{})
+		require.NoError(t, err)
+		otherPostID, err := s.CreatePost(otherID, "other", "content", models.FormatPlain, models.PostPublished, time.Time{})
+		require.NoError(t, err)
+
+		approvedID, err := s.CreateComment(postID, readerID, "", "approved", models.CommentApproved)
+		require.NoError(t, err)
+		pendingID, err := s.CreateComment(postID, readerID, "", "pending", models.CommentPending)
+		require.NoError(t, err)
+		spamID, err := s.CreateComment(postID, readerID, approvedID, "spam", models.CommentSpam)
+		require.NoError(t, err)
+		otherPendingID, err := s.CreateComment(otherPostID, readerID, "", "pending", models.CommentPending)
+		require.NoError(t, err)
+		_, err = s.CreateComment(postID, readerID, "", "huh", "hidden")
+		require.True(t, xerrors.Is(err, ErrValidation), "got %v", err)
+
+		// only approved comments can be replied to
+		_, err = s.CreateComment(postID, authorID, pendingID, "reply", models.CommentApproved)
+		require.True(t, xerrors.Is(err, ErrConflict), "got %v", err)
+
+		commentIDs := func(page *CommentPage) []string {
+			var ids []string
+			for _, comment := range page.Comments {
+				ids = append(ids, comment.ID)
+			}
+			return ids
+		}
+		approved := []models.CommentStatus{models.CommentApproved}
+		page, err := s.GetComments(postID, CommentListOptions{Statuses: approved})
+		require.NoError(t, err)
+		require.Equal(t, []string{approvedID}, commentIDs(page))
+		require.Zero(t, page.Comments[0].Replies)
+		comment, err := s.GetComment(pendingID)
+		require.NoError(t, err)
+		require.Equal(t, models.CommentPending, comment.Status)
+		_, err = s.GetComments(postID, CommentListOptions{Statuses: []models.CommentStatus{"hidden"}})
+		require.True(t, xerrors.Is(err, ErrValidation), "got %v", err)
+
+		// the queue covers replies too, on one author's posts or everyone's
+		queued := []models.CommentStatus{models.CommentPending, models.CommentSpam}
+		page, err = s.GetCommentQueue(authorID, CommentListOptions{Statuses: queued})
+		require.NoError(t, err)
+		require.Equal(t, []string{pendingID, spamID}, commentIDs(page))
+		page, err = s.GetCommentQueue("", CommentListOptions{Statuses: queued, Limit: 2})
+		require.NoError(t, err)
+		require.Equal(t, []string{pendingID, spamID}, commentIDs(page))
+		require.True(t, page.More)
+		page, err = s.GetCommentQueue("", CommentListOptions{Statuses: []models.CommentStatus{models.CommentSpam}})
+		require.NoError(t, err)
+		require.Equal(t, []string{spamID}, commentIDs(page))
+
+		hasApproved, err := s.HasApprovedComment(readerID)
+		require.NoError(t, err)
+		require.True(t, hasApproved)
+		hasApproved, err = s.HasApprovedComment(authorID)
+		require.NoError(t, err)
+		require.False(t, hasApproved)
+
+		// moderation is all or nothing
+		err = s.ModerateComments([]string{pendingID}, []string{pendingID})
+		require.True(t, xerrors.Is(err, ErrValidation), "got %v", err)
+		err = s.ModerateComments([]string{pendingID}, []string{missingID})
+		require.True(t, xerrors.Is(err, ErrNotFound), "got %v", err)
+		comment, err = s.GetComment(pendingID)
+		require.NoError(t, err)
+		require.Equal(t, models.CommentPending, comment.Status)
+
+		require.NoError(t, s.ModerateComments([]string{pendingID, pendingID, spamID}, []string{otherPendingID}))
+		page, err = s.GetCommentQueue("", CommentListOptions{Statuses: queued})
+		require.NoError(t, err)
+		require.Empty(t, page.Comments)
+		page, err = s.GetComments(postID, CommentListOptions{ParentID: approvedID, Statuses: approved})
+		require.NoError(t, err)
+		require.Equal(t, []string{spamID}, commentIDs(page))
+		comment, err = s.GetComment(otherPendingID)
+		require.NoError(t, err)
+		require.Nil(t, comment)
+
+		// an edit can move a comment, or leave it where it is
+		_, err = s.UpdateComment(approvedID, "spam now", models.CommentSpam)
+		require.NoError(t, err)
+		comment, err = s.GetComment(approvedID)
+		require.NoError(t, err)
+		require.Equal(t, models.CommentSpam, comment.Status)
+		_, err = s.UpdateComment(approvedID, "still spam", "")
+		require.NoError(t, err)
+		comment, err = s.GetComment(approvedID)
+		require.NoError(t, err)
+		require.Equal(t, models.CommentSpam, comment.Status)
+	})
+
+	t.Run("DeletePost", func(t *testing.T) {
+		s := newStore(t)
+		userID, err := s.CreateUser("tiny cat", "tiny@cat.com")
+		require.NoError(t, err)
+		postID, err := s.CreatePost(userID, "title", "content", models.FormatPlain, models.PostPublished, time.Time{})
+		require.NoError(t, err)
+
+		_, err = s.DeletePost(postID)
+		require.NoError(t, err)
+		post, err := s.GetPost(postID)
+		require.NoError(t, err)
+		require.Nil(t, post)
+
+		_, err = s.DeletePost(postID)
+		require.True(t, xerrors.Is(err, ErrNotFound), "got %v", err)
+	})
+
+	t.Run("Trash", func(t *testing.T) {
+		s := newStore(t)
+		userID, err := s.CreateUser("tiny cat", "tiny@cat.com", "author")
+		require.NoError(t, err)
+		otherID, err := s.CreateUser("big cat", "big@cat.com")
+		require.NoError(t, err)
+		keptID, err := s.CreatePost(userID, "kept", "content", models.FormatPlain, models.PostPublished, time.Time{})
+		require.NoError(t, err)
+		binnedID, err := s.CreatePost(userID, "binned", "content", models.FormatPlain, models.PostPublished, time.Time{})
+		require.NoError(t, err)
+		otherPostID, err := s.CreatePost(otherID, "other", "content", models.FormatPlain, models.PostPublished, time.Time{})
+		require.NoError(t, err)
+
+		// a post deleted on its own stays deleted when its author comes back
+		_, err = s.DeletePost(binnedID)
+		require.NoError(t, err)
+		time.Sleep(10 * time.Millisecond)
+		_, err = s.DeleteUser(userID)
+		require.NoError(t, err)
+		_, err = s.DeletePost(otherPostID)
+		require.NoError(t, err)
+
+		users, err := s.GetDeletedUsers()
+		require.NoError(t, err)
+		require.Len(t, users, 1)
+		require.Equal(t, userID, users[0].ID)
+		require.NotNil(t, users[0].DeletedAt)
+		posts, err := s.GetDeletedPosts(userID)
+		require.NoError(t, err)
+		require.Len(t, posts, 2)
+		require.Equal(t, keptID, posts[0].ID)
+		require.Equal(t, binnedID, posts[1].ID)
+		posts, err = s.GetDeletedPosts("")
+		require.NoError(t, err)
+		require.Len(t, posts, 3)
+		require.Equal(t, otherPostID, posts[0].ID)
+
+		// deleted users have no roles, and can't post
+		roles, err := s.GetUserRoles(userID)
+		require.NoError(t, err)
+		require.Empty(t, roles)
+		_, err = s.CreatePost(userID, "title", "content", models.FormatPlain, models.PostPublished, time.Time{})
+		require.True(t, xerrors.Is(err, ErrForeignKey), "got %v", err)
+
+		// nor can their posts come back without them
+		post, err := s.GetDeletedPost(keptID)
+		require.NoError(t, err)
+		require.Equal(t, "kept", post.Title)
+		_, err = s.RestorePost(keptID)
+		require.True(t, xerrors.Is(err, ErrConflict), "got %v", err)
+
+		// their email is free while they're deleted
+		takenID, err := s.CreateUser("copy cat", "tiny@cat.com")
+		require.NoError(t, err)
+		_, err = s.RestoreUser(userID)
+		require.True(t, xerrors.Is(err, ErrConflict), "got %v", err)
+		_, err = s.UpdateUser(takenID, "", "copy@cat.com")
+		require.NoError(t, err)
+
+		_, err = s.RestoreUser(userID)
+		require.NoError(t, err)
+		user, err := s.GetUser(userID)
+		require.NoError(t, err)
+		require.Nil(t, user.DeletedAt)
+		roles, err = s.GetUserRoles(userID)
+		require.NoError(t, err)
+		require.Equal(t, []string{"author"}, roles)
+		post, err = s.GetPost(keptID)
+		require.NoError(t, err)
+		require.Nil(t, post.DeletedAt)
+		post, err = s.GetPost(binnedID)
+		require.NoError(t, err)
+		require.Nil(t, post)
+
+		_, err = s.RestorePost(binnedID)
+		require.NoError(t, err)
+		_, err = s.RestorePost(binnedID)
+		require.True(t, xerrors.Is(err, ErrNotFound), "got %v", err)
+		_, err = s.RestoreUser(userID)
+		require.True(t, xerrors.Is(err, ErrNotFound), "got %v", err)
+
+		// purging only takes what was deleted before the cutoff
+		_, err = s.DeleteUser(otherID)
+		require.NoError(t, err)
+		nUsers, nPosts, err := s.PurgeDeleted(time.Now().Add(-time.Hour))
+		require.NoError(t, err)
+		require.Zero(t, nUsers)
+		require.Zero(t, nPosts)
+		nUsers, nPosts, err = s.PurgeDeleted(time.Now().Add(time.Second))
+		require.NoError(t, err)
+		require.Equal(t, 1, nUsers)
+		require.Equal(t, 1, nPosts)
+		users, err = s.GetDeletedUsers()
+		require.NoError(t, err)
+		require.Empty(t, users)
+		_, err = s.RestoreUser(otherID)
+		require.True(t, xerrors.Is(err, ErrNotFound), "got %v", err)
+		post, err = s.GetDeletedPost(otherPostID)
+		require.NoError(t, err)
+		require.Nil(t, post)
+	})
+
+	t.Run("ConcurrentWrites", func(t *testing.T) {
+		s := newStore(t)
+		userID, err := s.CreateUser("tiny cat", "tiny@cat.com")
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 20)
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := s.CreatePost(userID, "title", "content", models.FormatPlain, models.PostPublished, time.Time{})
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			require.NoError(t, err)
+		}
+
+		page, err := s.GetAllPosts(userID, PostListOptions{})
+		require.NoError(t, err)
+		require.Len(t, page.Posts, 20)
+	})
+}
+
+func TestSlugify(t *testing.T) {
+	require.Equal(t, "hello-world", slugify("Hello, World!"))
+	require.Equal(t, "caf", slugify("Café"))
+	require.Equal(t, "post", slugify(" ?! "))
+	require.Equal(t, "post", slugify(""))
+	require.Len(t, slugify(strings.Repeat("cat ", 50)), 79)
+}
@@ -0,0 +1,315 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/gavinc95/go-blog/db/models"
+	"github.com/lib/pq"
+	"golang.org/x/xerrors"
+)
+
+// liveComments are the comments that aren't hidden, with their author's name,
+// their post's author and how many approved replies they have. It is a
+// subquery so that keyset can refer to its columns without a table name.
+const liveComments = `(SELECT c.id, c.post_id, c.user_id, c.parent_id, c.content, c.status, c.created_at, c.updated_at,
+		u.name AS author_name, p.user_id AS post_user_id,
+		(SELECT count(*) FROM comments r
+			WHERE r.parent_id = c.id AND r.deleted_at IS NULL AND r.status = 'approved') AS replies
+	FROM comments c
+	JOIN posts p ON p.id = c.post_id
+	JOIN users u ON u.id = c.user_id
+	WHERE c.deleted_at IS NULL AND p.deleted_at IS NULL) AS live_comments`
+
+const commentColumns = "id, post_id, user_id, parent_id, content, status, created_at, updated_at, author_name, replies"
+
+func scanComment(row scanner) (*models.Comment, error) {
+	var comment models.Comment
+	var parentID, authorName sql.NullString
+	err := row.Scan(&comment.ID, &comment.PostID, &comment.UserID, &parentID, &comment.Content, &comment.Status,
+		&comment.CreatedAt, &comment.UpdatedAt, &authorName, &comment.Replies)
+	if err != nil {
+		return nil, err
+	}
+	comment.ParentID = parentID.String
+	comment.CreatedAt = comment.CreatedAt.UTC()
+	comment.UpdatedAt = comment.UpdatedAt.UTC()
+	comment.Author = &models.PostAuthor{ID: comment.UserID, Name: authorName.String}
+	return &comment, nil
+}
+
+// commentTree selects the IDs of the comments that aren't deleted yet among
+// roots, a query for comment IDs, and their replies all the way down
+const commentTree = `WITH RECURSIVE tree AS (
+		%s
+		UNION
+		SELECT c.id FROM comments c JOIN tree ON c.parent_id = tree.id WHERE c.deleted_at IS NULL
+	)
+	SELECT id FROM tree`
+
+// deleteComments moves the comments selected by roots, a query on args, to
+// the trash along with their replies. They all share deletedAt, so they can
+// be restored together.
+func deleteComments(db execer, deletedAt time.Time, roots string, args ...interface{}) error {
+	query := "UPDATE comments SET deleted_at = $1 WHERE id IN (" + fmt.Sprintf(commentTree, roots) + ")"
+	_, err := db.Exec(query, append([]interface{}{deletedAt}, args...)...)
+	return err
+}
+
+func (m *store) GetComments(postID string, opts CommentListOptions) (*CommentPage, error) {
+	where := "post_id = $1 AND parent_id IS NULL"
+	args := []interface{}{postID}
+	if opts.ParentID != "" {
+		args = append(args, opts.ParentID)
+		where = "post_id = $1 AND parent_id = $2"
+	}
+	return m.listComments(where, args, opts)
+}
+
+func (m *store) GetCommentQueue(postAuthorID string, opts CommentListOptions) (*CommentPage, error) {
+	where := "true"
+	var args []interface{}
+	if postAuthorID != "" {
+		args = append(args, postAuthorID)
+		where = "post_user_id = $1"
+	}
+	return m.listComments(where, args, opts)
+}
+
+// listComments pages through the live comments matching where, a condition
+// on args, that are in one of opts.Statuses
+func (m *store) listComments(where string, args []interface{}, opts CommentListOptions) (*CommentPage, error) {
+	const msg = "failed to fetch comments"
+	if err := validateCommentListOptions(&opts); err != nil {
+		return nil, xerrors.Errorf("%s: %w", msg, err)
+	}
+
+	if len(opts.Statuses) > 0 {
+		names := make([]string, len(opts.Statuses))
+		for i, status := range opts.Statuses {
+			names[i] = string(status)
+		}
+		args = append(args, pq.Array(names))
+		where += fmt.Sprintf(" AND status = ANY($%d)", len(args))
+	}
+
+	ks := keyset{column: "created_at", desc: opts.Sort == CommentSortNewest, limit: opts.Limit}
+	key := opts.After
+	if opts.Before != nil {
+		key, ks.backwards = opts.Before, true
+	}
+	if key != nil {
+		ks.key, ks.id = key.CreatedAt, key.ID
+	}
+
+	where, suffix, args := ks.apply(where, args)
+	rows, err := m.db.Query("SELECT "+commentColumns+" FROM "+liveComments+" WHERE "+where+suffix, args...)
+	if err != nil {
+		return nil, translateError(err, msg)
+	}
+	defer rows.Close()
+
+	var comments []*models.Comment
+	for rows.Next() {
+		comment, err := scanComment(rows)
+		if err != nil {
+			return nil, xerrors.Errorf("error parsing DB response: %w", err)
+		}
+		comments = append(comments, comment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, xerrors.Errorf("%s: %w", msg, err)
+	}
+
+	return newCommentPage(comments, opts), nil
+}
+
+func (m *store) GetComment(id string) (*models.Comment, error) {
+	row := m.db.QueryRow("SELECT "+commentColumns+" FROM "+liveComments+" WHERE id = $1", id)
+
+	comment, err := scanComment(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, translateError(err, "error finding comment in db")
+	}
+	return comment, nil
+}
+
+func (m *store) HasApprovedComment(userID string) (bool, error) {
+	var found bool
+	err := m.db.QueryRow("SELECT EXISTS (SELECT 1 FROM "+liveComments+" WHERE user_id = $1 AND status = 'approved')",
+		userID).Scan(&found)
+	if err != nil {
+		return false, translateError(err, "error finding comments in db")
+	}
+	return found, nil
+}
+
+func (m *store) CreateComment(postID, userID, parentID, content string, status models.CommentStatus) (string, error) {
+	const msg = "error creating new comment"
+	id := m.idManager.UUID()
+	if err := validateCommentStatus(status); err != nil {
+		return id, xerrors.Errorf("%s: %w", msg, err)
+	}
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return id, xerrors.Errorf("%s: %w", msg, err)
+	}
+	defer tx.Rollback()
+
+	// the post can't be unpublished or deleted while the comment goes in
+	var postStatus models.PostStatus
+	err = tx.QueryRow("SELECT status FROM posts WHERE id = $1 AND deleted_at IS NULL FOR SHARE", postID).Scan(&postStatus)
+	if err == sql.ErrNoRows {
+		return id, newError(ErrForeignKey, nil, "%s: referenced resource does not exist", msg)
+	}
+	if err != nil {
+		return id, translateError(err, msg)
+	}
+	if postStatus != models.PostPublished {
+		return id, newError(ErrConflict, nil, "%s: only published posts can be commented on", msg)
+	}
+
+	if parentID != "" {
+		var parentPostID string
+		var parentStatus models.CommentStatus
+		err := tx.QueryRow("SELECT post_id, status FROM comments WHERE id = $1 AND deleted_at IS NULL FOR SHARE",
+			parentID).Scan(&parentPostID, &parentStatus)
+		if err == sql.ErrNoRows {
+			return id, newError(ErrForeignKey, nil, "%s: referenced resource does not exist", msg)
+		}
+		if err != nil {
+			return id, translateError(err, msg)
+		}
+		if parentPostID != postID {
+			return id, newError(ErrValidation, nil, "%s: the parent comment is on another post", msg)
+		}
+		if parentStatus != models.CommentApproved {
+			return id, newError(ErrConflict, nil, "%s: only approved comments can be replied to", msg)
+		}
+	}
+
+	// like posts, comments can't be written by deleted users
+	result, err := tx.Exec(`INSERT INTO comments(id, post_id, user_id, parent_id, content, status, created_at, updated_at)
+		SELECT $1, $2, id, $4, $5, $6, now(), now() FROM users WHERE id = $3 AND deleted_at IS NULL`,
+		id, postID, userID, sql.NullString{String: parentID, Valid: parentID != ""}, content, status)
+	if err != nil {
+		return id, translateError(err, msg)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return id, xerrors.Errorf("%s: %w", msg, err)
+	} else if n == 0 {
+		return id, newError(ErrForeignKey, nil, "%s: referenced resource does not exist", msg)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return id, xerrors.Errorf("%s: %w", msg, err)
+	}
+	return id, nil
+}
+
+func (m *store) UpdateComment(id, content string, status models.CommentStatus) (string, error) {
+	if status != "" {
+		if err := validateCommentStatus(status); err != nil {
+			return id, xerrors.Errorf("error while updating comment: %w", err)
+		}
+	}
+
+	result, err := m.db.Exec(`UPDATE comments SET content = $1, status = COALESCE(NULLIF($3, ''), status), updated_at = now()
+		WHERE id = $2 AND id IN (SELECT id FROM `+liveComments+`)`, content, id, status)
+	if err != nil {
+		return id, translateError(err, "error while updating comment")
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return id, xerrors.Errorf("error while updating comment: %w", err)
+	} else if n == 0 {
+		return id, notFound("comment doesn't exist for ID: %s", id)
+	}
+	return id, nil
+}
+
+// DeleteComment moves the comment to the trash along with its replies
+func (m *store) DeleteComment(id string) (string, error) {
+	const msg = "error deleting comment"
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return id, xerrors.Errorf("%s: %w", msg, err)
+	}
+	defer tx.Rollback()
+
+	// now() is the same for the whole transaction, so the replies share it
+	var deletedAt time.Time
+	err = tx.QueryRow("SELECT now() FROM "+liveComments+" WHERE id = $1", id).Scan(&deletedAt)
+	if err == sql.ErrNoRows {
+		return id, notFound("cannot delete comment that doesn't exist")
+	}
+	if err != nil {
+		return id, translateError(err, msg)
+	}
+	if err := deleteComments(tx, deletedAt, "SELECT id FROM comments WHERE id = $2", id); err != nil {
+		return id, translateError(err, msg)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return id, xerrors.Errorf("%s: %w", msg, err)
+	}
+	return id, nil
+}
+
+func (m *store) ModerateComments(approve, reject []string) error {
+	const msg = "error moderating comments"
+	rejected := make(map[string]bool)
+	for _, id := range reject {
+		rejected[id] = true
+	}
+	ids := make(map[string]bool)
+	for _, id := range approve {
+		if rejected[id] {
+			return newError(ErrValidation, nil, "%s: comment %s is both approved and rejected", msg, id)
+		}
+		ids[id] = true
+	}
+	for id := range rejected {
+		ids[id] = true
+	}
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return xerrors.Errorf("%s: %w", msg, err)
+	}
+	defer tx.Rollback()
+
+	// every comment has to be there, locked until the end so that none
+	// disappears half way
+	var found int
+	err = tx.QueryRow(`SELECT count(*) FROM (
+			SELECT id FROM comments WHERE id IN (SELECT id FROM `+liveComments+` WHERE id = ANY($1)) FOR UPDATE
+		) AS locked`, pq.Array(append(append([]string{}, approve...), reject...))).Scan(&found)
+	if err != nil {
+		return translateError(err, msg)
+	}
+	if found < len(ids) {
+		return notFound("cannot moderate comments that don't exist")
+	}
+
+	if _, err := tx.Exec("UPDATE comments SET status = 'approved' WHERE id = ANY($1)", pq.Array(approve)); err != nil {
+		return translateError(err, msg)
+	}
+	var deletedAt time.Time
+	if err := tx.QueryRow("SELECT now()").Scan(&deletedAt); err != nil {
+		return translateError(err, msg)
+	}
+	if err := deleteComments(tx, deletedAt, "SELECT id FROM comments WHERE id = ANY($2)", pq.Array(reject)); err != nil {
+		return translateError(err, msg)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return xerrors.Errorf("%s: %w", msg, err)
+	}
+	return nil
+}
@@ -3,9 +3,14 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/gavinc95/go-blog/db/models"
+	"github.com/gavinc95/go-blog/render"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"golang.org/x/xerrors"
 )
 
@@ -13,6 +18,13 @@ import (
 type BlogStore interface {
 	UserStore
 	PostStore
+	SessionStore
+	RefreshTokenStore
+	RoleStore
+	TrashStore
+	TaxonomyStore
+	SearchStore
+	CommentStore
 	GetDB() *sql.DB // used for table creation/deletion
 }
 
@@ -44,44 +56,340 @@ func (m *store) GetDB() *sql.DB {
 
 // a sub-interface that handles only user-related operations
 type UserStore interface {
-	//GetAllUsers() ([]*models.User, error)
+	GetAllUsers(opts UserListOptions) (*UserPage, error)
 	GetUser(id string) (*models.User, error)
-	CreateUser(name, email string) (string, error)
+	// CreateUser and CreateUserWithPassword grant the new user roles in the
+	// same step, failing with ErrForeignKey if one doesn't exist
+	CreateUser(name, email string, roles ...string) (string, error)
+	CreateUserWithPassword(name, email, passwordHash string, roles ...string) (string, error)
+	GetUserByEmail(email string) (*models.User, error)
 	UpdateUser(id, name, email string) (string, error)
+	// DeleteUser moves the user, their posts and their comments to the
+	// trash, and ends their sessions and refresh tokens
 	DeleteUser(id string) (string, error)
 }
 
+// a sub-interface that handles server-side login sessions
+type SessionStore interface {
+	CreateSession(session *models.Session) error
+	GetSession(id string) (*models.Session, error)
+	// RotateSession replaces the session oldID with session in one step
+	RotateSession(oldID string, session *models.Session) error
+	DeleteSession(id string) error
+}
+
+// a sub-interface that handles refresh tokens for bearer authentication.
+// Revoked tokens are kept until they expire, so that reuse can be detected.
+type RefreshTokenStore interface {
+	CreateRefreshToken(token *models.RefreshToken) error
+	GetRefreshToken(id string) (*models.RefreshToken, error)
+	// RotateRefreshToken revokes oldID and creates next in one step. It
+	// fails with ErrNotFound if oldID doesn't exist or is already revoked.
+	RotateRefreshToken(oldID string, next *models.RefreshToken, at time.Time) error
+	// RevokeRefreshToken fails with ErrNotFound if id doesn't exist or is
+	// already revoked
+	RevokeRefreshToken(id string, at time.Time) error
+	RevokeUserRefreshTokens(userID string, at time.Time) error
+}
+
+// a sub-interface that handles roles and the users they are granted to.
+// Deleted users have no roles until they are restored.
+type RoleStore interface {
+	GetRoles() ([]*models.Role, error)
+	GetUserRoles(userID string) ([]string, error)
+	// GetUserPermissions returns every permission granted by the user's roles
+	GetUserPermissions(userID string) ([]string, error)
+	// GrantRole fails with ErrForeignKey if the user or role doesn't exist.
+	// Granting a role twice, or revoking one the user doesn't have, is not
+	// an error.
+	GrantRole(userID, role string) error
+	RevokeRole(userID, role string) error
+}
+
+// a sub-interface that handles deleted users and posts. Deleting only marks
+// them as deleted; the rest of BlogStore acts as if they were gone until they
+// are restored, or purged for good.
+type TrashStore interface {
+	// GetDeletedUsers returns the users in the trash, most recently deleted
+	// first
+	GetDeletedUsers() ([]*models.User, error)
+	// GetDeletedPosts returns userID's posts in the trash, or everyone's if
+	// userID is empty, most recently deleted first
+	GetDeletedPosts(userID string) ([]*models.Post, error)
+	// GetDeletedPost returns nil if the post isn't in the trash
+	GetDeletedPost(postID string) (*models.Post, error)
+	// RestoreUser takes the user out of the trash along with the posts that
+	// were deleted with them. It fails with ErrConflict if their email has
+	// been taken in the meantime.
+	RestoreUser(id string) (string, error)
+	// RestorePost fails with ErrConflict while the post's author is deleted
+	RestorePost(postID string) (string, error)
+	// PurgeDeleted permanently deletes the users, posts and comments that
+	// were deleted before cutoff, returning how many users and posts
+	PurgeDeleted(cutoff time.Time) (users, posts int, err error)
+}
+
+// a sub-interface that handles full-text search over published posts
+type SearchStore interface {
+	SearchPosts(query SearchQuery) (*SearchPage, error)
+	// SetSearchLanguage picks the text search configuration, e.g. "english",
+	// that posts are indexed and searched with. Changing it re-indexes every
+	// post.
+	SetSearchLanguage(language string) error
+}
+
+// a sub-interface that handles the tags and categories posts are filed under.
+// Tags are created as they are first used, and are written as given, so
+// callers should normalize them first.
+type TaxonomyStore interface {
+	// GetTagCounts returns the tags of published posts with how many each
+	// has, most used first, at most limit of them unless limit is 0
+	GetTagCounts(limit int) ([]*models.TagCount, error)
+	// TagPost does nothing if the post already has the tag, and UntagPost
+	// nothing if it doesn't
+	TagPost(postID, tag string) error
+	UntagPost(postID, tag string) error
+
+	// GetCategories returns every category, by name, for the caller to
+	// build the tree from
+	GetCategories() ([]*models.Category, error)
+	// GetCategory returns nil if the category doesn't exist
+	GetCategory(id string) (*models.Category, error)
+	// CreateCategory creates a top-level category if parentID is empty.
+	// Names are unique among siblings.
+	CreateCategory(name, parentID string) (string, error)
+	// DeleteCategory takes the category off its posts. It fails with
+	// ErrConflict while the category has subcategories.
+	DeleteCategory(id string) (string, error)
+	GetPostCategories(postID string) ([]*models.Category, error)
+	CategorizePost(postID, categoryID string) error
+	UncategorizePost(postID, categoryID string) error
+}
+
+// a sub-interface that handles comments on posts. A comment is hidden while
+// its post is deleted, and deleting a comment deletes its replies with it.
+// Comments in any status can be fetched; callers decide who sees which.
+type CommentStore interface {
+	// GetComments returns one page of the post's top-level comments, or of
+	// the replies to opts.ParentID, with their Author filled in
+	GetComments(postID string, opts CommentListOptions) (*CommentPage, error)
+	// GetCommentQueue returns one page of the comments, replies included, on
+	// postAuthorID's posts, or on everyone's if postAuthorID is empty.
+	// opts.ParentID is ignored.
+	GetCommentQueue(postAuthorID string, opts CommentListOptions) (*CommentPage, error)
+	// GetComment returns nil if the comment doesn't exist, or is hidden
+	GetComment(id string) (*models.Comment, error)
+	// HasApprovedComment reports whether any of the user's comments has
+	// been approved
+	HasApprovedComment(userID string) (bool, error)
+	// CreateComment replies to parentID, or comments on the post itself if
+	// parentID is empty. It fails with ErrForeignKey if the post, user or
+	// parent doesn't exist, with ErrValidation if the parent is on another
+	// post, and with ErrConflict unless the post is published and the parent
+	// approved.
+	CreateComment(postID, userID, parentID, content string, status models.CommentStatus) (string, error)
+	// UpdateComment also moves the comment to status, unless it's empty
+	UpdateComment(id, content string, status models.CommentStatus) (string, error)
+	DeleteComment(id string) (string, error)
+	// ModerateComments approves some comments and deletes others, with their
+	// replies, in one step. It fails with ErrNotFound, changing nothing, if
+	// any of them doesn't exist.
+	ModerateComments(approve, reject []string) error
+}
+
 // a sub-interface that handles only post-related operations
 type PostStore interface {
-	GetAllPosts(userID string) ([]*models.Post, error)
+	// GetAllPosts returns one page of the user's posts that are in one of
+	// statuses, or in any status if none are given
+	GetAllPosts(userID string, opts PostListOptions, statuses ...models.PostStatus) (*PostPage, error)
+	// GetFeed returns one page of published posts by every author, with
+	// their Author filled in. The newest and oldest go by when the posts were
+	// published rather than created.
+	GetFeed(filter FeedFilter, opts PostListOptions) (*PostPage, error)
 	GetPost(postID string) (*models.Post, error)
-	CreatePost(userID, title, content string) (string, error)
-	UpdatePost(postID, title, content string) (string, error)
+	// GetPostBySlug returns nil if no post has the slug, or it is deleted
+	GetPostBySlug(slug string) (*models.Post, error)
+	// CreatePost creates a draft, a published post, or one scheduled to be
+	// published at publishAt. The content is rendered as it is written, and
+	// the post gets a slug made from its title.
+	CreatePost(userID, title, content string, format models.ContentFormat, status models.PostStatus, publishAt time.Time) (string, error)
+	// UpdatePost records editorID as the user who made the change. Empty
+	// fields are left as they are.
+	UpdatePost(postID, editorID, title, content string, format models.ContentFormat) (string, error)
 	DeletePost(postID string) (string, error)
+	// SetPostStatus moves a post to status, if postTransitions allows it from
+	// the post's current one (ErrConflict if not). publishAt is only used
+	// when scheduling.
+	SetPostStatus(postID, editorID string, status models.PostStatus, publishAt time.Time) (string, error)
+	// PublishDuePosts publishes the scheduled posts whose time has come,
+	// returning their IDs
+	PublishDuePosts() ([]string, error)
+	// RenderPosts renders the posts, trashed ones included, that were written
+	// before posts had rendered HTML, returning how many there were
+	RenderPosts() (int, error)
+
+	// CreatePost, UpdatePost and RestoreRevision each add a revision to the
+	// post's history. Status changes don't.
+
+	// GetRevisions returns the post's revisions, oldest first
+	GetRevisions(postID string) ([]*models.PostRevision, error)
+	// GetRevision returns nil if the post has no such revision
+	GetRevision(postID string, number int) (*models.PostRevision, error)
+	// RestoreRevision makes an old revision's title and content current
+	// again, returning the number of the revision that records it
+	RestoreRevision(postID string, number int, editorID string) (int, error)
 }
 
-func (m *store) GetUser(id string) (*models.User, error) {
-	row := m.db.QueryRow("SELECT * FROM users WHERE id = $1", id)
+// scanner is satisfied by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
 
+const userColumns = "id, name, email, password_hash, deleted_at"
+
+func scanUser(row scanner) (*models.User, error) {
 	var user models.User
-	err := row.Scan(&user.ID, &user.Name, &user.Email)
+	var passwordHash sql.NullString
+	var deletedAt sql.NullTime
+	err := row.Scan(&user.ID, &user.Name, &user.Email, &passwordHash, &deletedAt)
+	if err != nil {
+		return nil, err
+	}
+	user.PasswordHash = passwordHash.String
+	if deletedAt.Valid {
+		t := deletedAt.Time.UTC()
+		user.DeletedAt = &t
+	}
+	return &user, nil
+}
+
+func (m *store) GetUser(id string) (*models.User, error) {
+	row := m.db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = $1 AND deleted_at IS NULL", id)
+
+	user, err := scanUser(row)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 
 	if err != nil {
-		return nil, xerrors.Errorf("error finding user in db: %w", err)
+		return nil, translateError(err, "error finding user in db")
 	}
 
-	return &user, nil
+	return user, nil
+}
+
+func (m *store) GetUserByEmail(email string) (*models.User, error) {
+	row := m.db.QueryRow("SELECT "+userColumns+" FROM users WHERE email = $1 AND deleted_at IS NULL", email)
+
+	user, err := scanUser(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, translateError(err, "error finding user in db")
+	}
+
+	return user, nil
 }
 
-func (m *store) CreateUser(name, email string) (string, error) {
+func (m *store) GetAllUsers(opts UserListOptions) (*UserPage, error) {
+	const msg = "failed to fetch users"
+	if err := validateUserListOptions(&opts); err != nil {
+		return nil, xerrors.Errorf("%s: %w", msg, err)
+	}
+
+	where := "deleted_at IS NULL"
+	var args []interface{}
+	if opts.Search != "" {
+		args = append(args, likePrefix(strings.ToLower(opts.Search)))
+		where += fmt.Sprintf(" AND (lower(name) LIKE $%d OR lower(email) LIKE $%d)", len(args), len(args))
+	}
+
+	ks := keyset{column: `COALESCE(name, '') COLLATE "C"`, limit: opts.Limit}
+	if opts.Sort == UserSortEmail {
+		ks.column = `COALESCE(email, '') COLLATE "C"`
+	}
+	key := opts.After
+	if opts.Before != nil {
+		key, ks.backwards = opts.Before, true
+	}
+	if key != nil {
+		ks.key, ks.id = key.Name, key.ID
+		if opts.Sort == UserSortEmail {
+			ks.key = key.Email
+		}
+	}
+
+	where, suffix, args := ks.apply(where, args)
+	rows, err := m.db.Query("SELECT "+userColumns+" FROM users WHERE "+where+suffix, args...)
+	if err != nil {
+		return nil, translateError(err, msg)
+	}
+	defer rows.Close()
+
+	var users []*models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, xerrors.Errorf("error parsing DB response: %w", err)
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, xerrors.Errorf("%s: %w", msg, err)
+	}
+
+	return newUserPage(users, opts), nil
+}
+
+// likePrefix returns a LIKE pattern matching strings that start with s
+func likePrefix(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s) + "%"
+}
+
+// newUserPage trims users to a page, like newPostPage
+func newUserPage(users []*models.User, opts UserListOptions) *UserPage {
+	page := &UserPage{Users: users}
+	if opts.Limit > 0 && len(users) > opts.Limit {
+		page.Users = users[:opts.Limit]
+		page.More = true
+	}
+	if opts.Before != nil {
+		for i, j := 0, len(page.Users)-1; i < j; i, j = i+1, j-1 {
+			page.Users[i], page.Users[j] = page.Users[j], page.Users[i]
+		}
+	}
+	return page
+}
+
+func (m *store) CreateUser(name, email string, roles ...string) (string, error) {
+	return m.CreateUserWithPassword(name, email, "", roles...)
+}
+
+// CreateUserWithPassword creates a user who can log in. passwordHash must
+// already be hashed, see auth.HashPassword.
+func (m *store) CreateUserWithPassword(name, email, passwordHash string, roles ...string) (string, error) {
 	id := m.idManager.UUID()
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return id, xerrors.Errorf("error while inserting user: %w", err)
+	}
+	defer tx.Rollback()
+
 	// create a new user row
-	_, err := m.db.Exec("INSERT INTO users(id, name, email) VALUES($1, $2, $3)",
-		id, name, email)
+	_, err = tx.Exec("INSERT INTO users(id, name, email, password_hash) VALUES($1, $2, $3, $4)",
+		id, name, email, sql.NullString{String: passwordHash, Valid: passwordHash != ""})
 	if err != nil {
+		return id, translateError(err, "error while inserting user")
+	}
+	for _, role := range roles {
+		if err := grantRole(tx, id, role); err != nil {
+			return id, translateError(err, "error while inserting user")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
 		return id, xerrors.Errorf("error while inserting user: %w", err)
 	}
 	return id, nil
@@ -94,7 +402,7 @@ func (m *store) UpdateUser(id, name, email string) (string, error) {
 		return id, xerrors.Errorf("failed to check for existing user: %w", err)
 	}
 	if user == nil {
-		return id, fmt.Errorf("user doesn't exist - create one first")
+		return id, notFound("user doesn't exist - create one first")
 	}
 
 	// update the existing user
@@ -102,7 +410,7 @@ func (m *store) UpdateUser(id, name, email string) (string, error) {
 		_, err := m.db.Exec("UPDATE users SET name = $1 WHERE id = $2",
 			name, id)
 		if err != nil {
-			return id, xerrors.Errorf("error while updating user: %w", err)
+			return id, translateError(err, "error while updating user")
 		}
 	}
 
@@ -110,78 +418,612 @@ func (m *store) UpdateUser(id, name, email string) (string, error) {
 		_, err := m.db.Exec("UPDATE users SET email = $1 WHERE id = $2",
 			email, id)
 		if err != nil {
-			return id, xerrors.Errorf("error while updating user: %w", err)
+			return id, translateError(err, "error while updating user")
 		}
 	}
 
 	return id, nil
 }
 
+// DeleteUser moves the user to the trash, along with their posts and comments
+// (and the replies to them), and logs them out everywhere
 func (m *store) DeleteUser(id string) (string, error) {
-	// check if the user exists
-	user, err := m.GetUser(id)
+	tx, err := m.db.Begin()
 	if err != nil {
-		return id, err
+		return id, xerrors.Errorf("error deleting user: %w", err)
 	}
-	if user == nil {
-		return id, fmt.Errorf("user does not exist for ID: %s", id)
+	defer tx.Rollback()
+
+	var deletedAt time.Time
+	err = tx.QueryRow("UPDATE users SET deleted_at = now() WHERE id = $1 AND deleted_at IS NULL RETURNING deleted_at",
+		id).Scan(&deletedAt)
+	if err == sql.ErrNoRows {
+		return id, notFound("user does not exist for ID: %s", id)
+	}
+	if err != nil {
+		return id, translateError(err, "error finding user in db")
 	}
 
-	_, err = m.db.Exec("DELETE FROM users WHERE id = $1", id)
+	// the posts and comments share the user's deleted_at, so that
+	// RestoreUser can tell them from ones that were deleted on their own
+	_, err = tx.Exec("UPDATE posts SET deleted_at = $1 WHERE user_id = $2 AND deleted_at IS NULL", deletedAt, id)
 	if err != nil {
-		return id, xerrors.Errorf("error deleting user: %w", err)
+		return id, xerrors.Errorf("error deleting user's posts: %w", err)
+	}
+	err = deleteComments(tx, deletedAt, "SELECT id FROM comments WHERE user_id = $2 AND deleted_at IS NULL", id)
+	if err != nil {
+		return id, xerrors.Errorf("error deleting user's comments: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM sessions WHERE user_id = $1", id); err != nil {
+		return id, xerrors.Errorf("error deleting user's sessions: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM refresh_tokens WHERE user_id = $1", id); err != nil {
+		return id, xerrors.Errorf("error deleting user's refresh tokens: %w", err)
 	}
 
+	if err := tx.Commit(); err != nil {
+		return id, xerrors.Errorf("error deleting user: %w", err)
+	}
 	return id, nil
 }
 
-func (m *store) GetAllPosts(userID string) ([]*models.Post, error) {
-	rows, err := m.db.Query("SELECT * FROM posts WHERE user_id = $1", userID)
+// UserSort is the order of a user listing. Both sort by byte value.
+type UserSort string
+
+const (
+	UserSortName  UserSort = "name" // the default
+	UserSortEmail UserSort = "email"
+)
+
+// UserKey is a user's position in a listing
+type UserKey struct {
+	Name  string
+	Email string
+	ID    string
+}
+
+func UserKeyOf(user *models.User) UserKey {
+	return UserKey{Name: user.Name, Email: user.Email, ID: user.ID}
+}
+
+// UserListOptions selects a page of a user listing, like PostListOptions
+type UserListOptions struct {
+	Sort UserSort
+	// Search only lists users whose name or email starts with it, ignoring
+	// case
+	Search string
+	Limit  int // 0 for no limit
+	After  *UserKey
+	Before *UserKey
+}
+
+// UserPage is a page of users, like PostPage
+type UserPage struct {
+	Users []*models.User
+	More  bool
+}
+
+func validateUserListOptions(opts *UserListOptions) error {
+	if opts.Sort == "" {
+		opts.Sort = UserSortName
+	}
+	if opts.Sort != UserSortName && opts.Sort != UserSortEmail {
+		return newError(ErrValidation, nil, "unknown sort %q", opts.Sort)
+	}
+	if opts.After != nil && opts.Before != nil {
+		return newError(ErrValidation, nil, "only one of after and before may be set")
+	}
+	return nil
+}
+
+// PostSort is the order of a post listing
+type PostSort string
+
+const (
+	SortNewest PostSort = "newest" // the default
+	SortOldest PostSort = "oldest"
+	SortTitle  PostSort = "title" // by byte value, so "Zebra" comes before "apple"
+)
+
+// PostKey is a post's position in a listing. Only the fields used by the
+// listing's sort, and the ID that breaks ties, need to be set.
+type PostKey struct {
+	CreatedAt   time.Time
+	PublishedAt time.Time // in the feed
+	Title       string
+	ID          string
+}
+
+func KeyOf(post *models.Post) PostKey {
+	key := PostKey{CreatedAt: post.CreatedAt, Title: post.Title, ID: post.ID}
+	if post.PublishedAt != nil {
+		key.PublishedAt = *post.PublishedAt
+	}
+	return key
+}
+
+// PostListOptions selects a page of a post listing. After and Before are
+// exclusive, and at most one of them may be set.
+type PostListOptions struct {
+	Sort   PostSort
+	Limit  int // 0 for no limit
+	After  *PostKey
+	Before *PostKey
+}
+
+// FeedFilter narrows down the feed. Zero values don't filter.
+type FeedFilter struct {
+	AuthorID string
+	Tag      string
+	// CategoryID also matches posts in the category's subcategories
+	CategoryID string
+	// published in [PublishedFrom, PublishedTo)
+	PublishedFrom time.Time
+	PublishedTo   time.Time
+}
+
+// CommentSort is the order of a comment listing
+type CommentSort string
+
+const (
+	CommentSortOldest CommentSort = "oldest" // the default, so threads read top to bottom
+	CommentSortNewest CommentSort = "newest"
+)
+
+// CommentKey is a comment's position in a listing
+type CommentKey struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+func CommentKeyOf(comment *models.Comment) CommentKey {
+	return CommentKey{CreatedAt: comment.CreatedAt, ID: comment.ID}
+}
+
+// CommentListOptions selects a page of a comment listing, like
+// PostListOptions
+type CommentListOptions struct {
+	// ParentID lists the replies to a comment rather than the top-level
+	// comments
+	ParentID string
+	// Statuses only lists comments in one of them, or in any status if
+	// empty
+	Statuses []models.CommentStatus
+	Sort     CommentSort
+	Limit    int // 0 for no limit
+	After    *CommentKey
+	Before   *CommentKey
+}
+
+// CommentPage is a page of comments, like PostPage
+type CommentPage struct {
+	Comments []*models.Comment
+	More     bool
+}
+
+func validateCommentListOptions(opts *CommentListOptions) error {
+	if opts.Sort == "" {
+		opts.Sort = CommentSortOldest
+	}
+	if opts.Sort != CommentSortOldest && opts.Sort != CommentSortNewest {
+		return newError(ErrValidation, nil, "unknown sort %q", opts.Sort)
+	}
+	for _, status := range opts.Statuses {
+		if err := validateCommentStatus(status); err != nil {
+			return err
+		}
+	}
+	if opts.After != nil && opts.Before != nil {
+		return newError(ErrValidation, nil, "only one of after and before may be set")
+	}
+	return nil
+}
+
+// nonSlug matches what slugs leave out of titles
+var nonSlug = regexp.MustCompile(`[^a-zA-Z0-9]+`)
+
+const maxSlugLength = 80
+
+// slugify makes a title into the lowercase words joined by hyphens that
+// identify a post in URLs, e.g. "Hello, World!" into "hello-world". The
+// migration that adds slugs does the same in SQL.
+func slugify(title string) string {
+	slug := strings.ToLower(nonSlug.ReplaceAllString(title, "-"))
+	if len(slug) > maxSlugLength {
+		slug = slug[:maxSlugLength]
+	}
+	if slug = strings.Trim(slug, "-"); slug == "" {
+		return "post"
+	}
+	return slug
+}
+
+// slugCandidates are the slugs a new post tries in turn: its title's, then
+// the title's followed by the start of the post's ID, then followed by the
+// whole ID
+func slugCandidates(title, postID string) []string {
+	base := slugify(title)
+	if len(postID) > 8 {
+		return []string{base, base + "-" + postID[:8], base + "-" + postID}
+	}
+	return []string{base, base + "-" + postID}
+}
+
+func validateContentFormat(format models.ContentFormat) error {
+	switch format {
+	case models.FormatPlain, models.FormatMarkdown, models.FormatHTML:
+		return nil
+	}
+	return newError(ErrValidation, nil, "unknown content format %q", format)
+}
+
+func validateCommentStatus(status models.CommentStatus) error {
+	switch status {
+	case models.CommentPending, models.CommentApproved, models.CommentSpam:
+		return nil
+	}
+	return newError(ErrValidation, nil, "unknown comment status %q", status)
+}
+
+// newCommentPage trims comments to a page, like newPostPage
+func newCommentPage(comments []*models.Comment, opts CommentListOptions) *CommentPage {
+	page := &CommentPage{Comments: comments}
+	if opts.Limit > 0 && len(comments) > opts.Limit {
+		page.Comments = comments[:opts.Limit]
+		page.More = true
+	}
+	if opts.Before != nil {
+		for i, j := 0, len(page.Comments)-1; i < j; i, j = i+1, j-1 {
+			page.Comments[i], page.Comments[j] = page.Comments[j], page.Comments[i]
+		}
+	}
+	return page
+}
+
+// PostPage is a page of posts, always in the order of the listing's sort.
+type PostPage struct {
+	Posts []*models.Post
+	// More is set if there are posts beyond the page in the direction it
+	// was requested: after it, or before it when paging with Before
+	More bool
+}
+
+// newPostPage trims posts, which were fetched in the direction of paging
+// with one extra row, to a page
+func newPostPage(posts []*models.Post, opts PostListOptions) *PostPage {
+	page := &PostPage{Posts: posts}
+	if opts.Limit > 0 && len(posts) > opts.Limit {
+		page.Posts = posts[:opts.Limit]
+		page.More = true
+	}
+	if opts.Before != nil {
+		for i, j := 0, len(page.Posts)-1; i < j; i, j = i+1, j-1 {
+			page.Posts[i], page.Posts[j] = page.Posts[j], page.Posts[i]
+		}
+	}
+	return page
+}
+
+func validateListOptions(opts *PostListOptions) error {
+	if opts.Sort == "" {
+		opts.Sort = SortNewest
+	}
+	switch opts.Sort {
+	case SortNewest, SortOldest, SortTitle:
+	default:
+		return newError(ErrValidation, nil, "unknown sort %q", opts.Sort)
+	}
+	if opts.After != nil && opts.Before != nil {
+		return newError(ErrValidation, nil, "only one of after and before may be set")
+	}
+	return nil
+}
+
+// postTransitions lists the statuses a post can move to from each status.
+// The empty status is a post that is being created.
+var postTransitions = map[models.PostStatus][]models.PostStatus{
+	"":                   {models.PostDraft, models.PostScheduled, models.PostPublished},
+	models.PostDraft:     {models.PostScheduled, models.PostPublished},
+	models.PostScheduled: {models.PostDraft, models.PostScheduled, models.PostPublished},
+	models.PostPublished: {models.PostDraft, models.PostArchived},
+	models.PostArchived:  {models.PostDraft, models.PostPublished},
+}
+
+// checkTransition returns an error unless a post may move from one status to
+// another at now
+func checkTransition(from, to models.PostStatus, publishAt, now time.Time) error {
+	if _, ok := postTransitions[to]; !ok || to == "" {
+		return newError(ErrValidation, nil, "unknown status %q", to)
+	}
+	if to == models.PostScheduled && !publishAt.After(now) {
+		return newError(ErrValidation, nil, "a post can only be scheduled for the future")
+	}
+	for _, status := range postTransitions[from] {
+		if status == to {
+			return nil
+		}
+	}
+	return newError(ErrConflict, nil, "a %s post can't be made %s", from, to)
+}
+
+const postColumns = "id, user_id, slug, title, content, format, content_html, status, created_at, updated_at, published_at, updated_by, deleted_at"
+
+// scanPost scans postColumns, followed by any extra columns into extra
+func scanPost(row scanner, extra ...interface{}) (*models.Post, error) {
+	var post models.Post
+	var publishedAt, deletedAt sql.NullTime
+	var contentHTML, updatedBy sql.NullString
+	dest := []interface{}{&post.ID, &post.UserID, &post.Slug, &post.Title, &post.Content, &post.Format, &contentHTML, &post.Status,
+		&post.CreatedAt, &post.UpdatedAt, &publishedAt, &updatedBy, &deletedAt}
+	err := row.Scan(append(dest, extra...)...)
 	if err != nil {
-		return nil, xerrors.Errorf("failed to fetch posts for user: %w", err)
+		return nil, err
+	}
+	post.HTML = contentHTML.String
+	post.CreatedAt = post.CreatedAt.UTC()
+	post.UpdatedAt = post.UpdatedAt.UTC()
+	if publishedAt.Valid {
+		t := publishedAt.Time.UTC()
+		post.PublishedAt = &t
+	}
+	post.UpdatedBy = updatedBy.String
+	if deletedAt.Valid {
+		t := deletedAt.Time.UTC()
+		post.DeletedAt = &t
 	}
+	return &post, nil
+}
+
+func (m *store) GetAllPosts(userID string, opts PostListOptions, statuses ...models.PostStatus) (*PostPage, error) {
+	where := "user_id = $1"
+	args := []interface{}{userID}
+	if len(statuses) > 0 {
+		names := make([]string, len(statuses))
+		for i, status := range statuses {
+			names[i] = string(status)
+		}
+		args = append(args, pq.Array(names))
+		where += " AND status = ANY($2)"
+	}
+	return m.listPosts("failed to fetch posts for user", where, args, opts, false)
+}
+
+func (m *store) GetFeed(filter FeedFilter, opts PostListOptions) (*PostPage, error) {
+	const msg = "failed to fetch feed"
+
+	where := "status = 'published'"
+	var args []interface{}
+	if filter.AuthorID != "" {
+		args = append(args, filter.AuthorID)
+		where += fmt.Sprintf(" AND user_id = $%d", len(args))
+	}
+	if !filter.PublishedFrom.IsZero() {
+		args = append(args, filter.PublishedFrom)
+		where += fmt.Sprintf(" AND published_at >= $%d", len(args))
+	}
+	if !filter.PublishedTo.IsZero() {
+		args = append(args, filter.PublishedTo)
+		where += fmt.Sprintf(" AND published_at < $%d", len(args))
+	}
+	if filter.Tag != "" {
+		args = append(args, filter.Tag)
+		where += fmt.Sprintf(" AND id IN (SELECT post_id FROM post_tags WHERE tag = $%d)", len(args))
+	}
+	if filter.CategoryID != "" {
+		args = append(args, filter.CategoryID)
+		where += fmt.Sprintf(` AND id IN (SELECT post_id FROM post_categories WHERE category_id IN (
+			WITH RECURSIVE tree AS (
+				SELECT id FROM categories WHERE id = $%d
+				UNION ALL
+				SELECT c.id FROM categories c JOIN tree ON c.parent_id = tree.id
+			)
+			SELECT id FROM tree))`, len(args))
+	}
+
+	page, err := m.listPosts(msg, where, args, opts, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.fillAuthors(page.Posts); err != nil {
+		return nil, translateError(err, msg)
+	}
+	return page, nil
+}
+
+// fillAuthors sets the Author of each post
+func (m *store) fillAuthors(posts []*models.Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	var ids []string
+	for _, post := range posts {
+		ids = append(ids, post.UserID)
+	}
+
+	rows, err := m.db.Query("SELECT id, name FROM users WHERE id = ANY($1)", pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	authors := make(map[string]*models.PostAuthor)
+	for rows.Next() {
+		var author models.PostAuthor
+		var name sql.NullString
+		if err := rows.Scan(&author.ID, &name); err != nil {
+			return err
+		}
+		author.Name = name.String
+		authors[author.ID] = &author
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, post := range posts {
+		post.Author = authors[post.UserID]
+	}
+	return nil
+}
+
+// listPosts returns a page of the posts matching where, a condition on args,
+// leaving out deleted posts. byPublished orders the newest and oldest by
+// published_at rather than created_at.
+func (m *store) listPosts(msg, where string, args []interface{}, opts PostListOptions, byPublished bool) (*PostPage, error) {
+	if err := validateListOptions(&opts); err != nil {
+		return nil, xerrors.Errorf("%s: %w", msg, err)
+	}
+	where = "deleted_at IS NULL AND " + where
+
+	ks := keyset{column: "created_at", desc: true, limit: opts.Limit}
+	if byPublished {
+		ks.column = "published_at"
+	}
+	switch opts.Sort {
+	case SortOldest:
+		ks.desc = false
+	case SortTitle:
+		ks.column, ks.desc = `title COLLATE "C"`, false
+	}
+	key := opts.After
+	if opts.Before != nil {
+		key, ks.backwards = opts.Before, true
+	}
+	if key != nil {
+		ks.key, ks.id = key.CreatedAt, key.ID
+		if opts.Sort == SortTitle {
+			ks.key = key.Title
+		} else if byPublished {
+			ks.key = key.PublishedAt
+		}
+	}
+
+	where, suffix, args := ks.apply(where, args)
+	query := "SELECT " + postColumns + " FROM posts WHERE " + where + suffix
+
+	rows, err := m.db.Query(query, args...)
+	if err != nil {
+		return nil, translateError(err, msg)
+	}
+	defer rows.Close()
 
 	var posts []*models.Post
 	for rows.Next() {
-		var post models.Post
-		err := rows.Scan(&post.ID, &post.UserID, &post.Title, &post.Content)
+		post, err := scanPost(rows)
 		if err != nil {
 			return nil, xerrors.Errorf("error parsing DB response: %w", err)
 		}
-		posts = append(posts, &post)
+		posts = append(posts, post)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, xerrors.Errorf("%s: %w", msg, err)
 	}
 
-	return posts, nil
+	page := newPostPage(posts, opts)
+	if err := m.fillTags(page.Posts); err != nil {
+		return nil, translateError(err, msg)
+	}
+	return page, nil
 }
 
 func (m *store) GetPost(postID string) (*models.Post, error) {
-	row := m.db.QueryRow("SELECT * FROM posts WHERE id = $1", postID)
+	row := m.db.QueryRow("SELECT "+postColumns+" FROM posts WHERE id = $1 AND deleted_at IS NULL", postID)
 
-	var post models.Post
-	err := row.Scan(&post.ID, &post.UserID, &post.Title, &post.Content)
+	post, err := scanPost(row)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
-		return nil, xerrors.Errorf("error finding post in db: %w", err)
+		return nil, translateError(err, "error finding post in db")
+	}
+	if err := m.fillTags([]*models.Post{post}); err != nil {
+		return nil, translateError(err, "error finding post's tags in db")
 	}
 
-	return &post, nil
+	return post, nil
+}
+
+func (m *store) GetPostBySlug(slug string) (*models.Post, error) {
+	row := m.db.QueryRow("SELECT "+postColumns+" FROM posts WHERE slug = $1 AND deleted_at IS NULL", slug)
+
+	post, err := scanPost(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, translateError(err, "error finding post in db")
+	}
+	if err := m.fillTags([]*models.Post{post}); err != nil {
+		return nil, translateError(err, "error finding post's tags in db")
+	}
+
+	return post, nil
 }
 
-func (m *store) CreatePost(userID, title, content string) (string, error) {
+func (m *store) CreatePost(userID, title, content string, format models.ContentFormat, status models.PostStatus, publishAt time.Time) (string, error) {
 	postID := m.idManager.UUID()
+	if err := validateContentFormat(format); err != nil {
+		return postID, xerrors.Errorf("error creating new post: %w", err)
+	}
+	if err := checkTransition("", status, publishAt, time.Now()); err != nil {
+		return postID, xerrors.Errorf("error creating new post: %w", err)
+	}
+	contentHTML, err := render.HTML(format, content)
+	if err != nil {
+		return postID, xerrors.Errorf("error creating new post: %w", err)
+	}
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return postID, xerrors.Errorf("error creating new post: %w", err)
+	}
+	defer tx.Rollback()
 
-	// create the post
-	_, err := m.db.Exec("INSERT INTO posts(id, user_id, title, content) VALUES($1, $2, $3, $4)",
-		postID, userID, title, content)
+	// the slug index decides which post gets a contested slug, even between
+	// posts created at once, and the others go on to their next candidate
+	var result sql.Result
+	for _, slug := range slugCandidates(title, postID) {
+		if _, err := tx.Exec("SAVEPOINT post_slug"); err != nil {
+			return postID, xerrors.Errorf("error creating new post: %w", err)
+		}
+		// a deleted user can't be given posts, although their row still exists
+		result, err = tx.Exec(`INSERT INTO posts(id, user_id, slug, title, content, format, content_html, status, created_at, updated_at, published_at)
+			SELECT $1, id, $9, $3, $4, $7, $8, $5, now(), now(), CASE WHEN $5 = 'published' THEN now() ELSE $6::timestamptz END
+			FROM users WHERE id = $2 AND deleted_at IS NULL`,
+			postID, userID, title, content, status, sql.NullTime{Time: publishAt, Valid: status == models.PostScheduled},
+			format, contentHTML, slug)
+		if !isSlugConflict(err) {
+			break
+		}
+		if _, err := tx.Exec("ROLLBACK TO SAVEPOINT post_slug"); err != nil {
+			return postID, xerrors.Errorf("error creating new post: %w", err)
+		}
+	}
 	if err != nil {
+		return postID, translateError(err, "error creating new post")
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return postID, xerrors.Errorf("error creating new post: %w", err)
+	} else if n == 0 {
+		return postID, newError(ErrForeignKey, nil, "error creating new post: referenced resource does not exist")
+	}
+	if _, err := addRevision(tx, postID, userID); err != nil {
+		return postID, translateError(err, "error creating new post")
+	}
+
+	if err := tx.Commit(); err != nil {
 		return postID, xerrors.Errorf("error creating new post: %w", err)
 	}
 	return postID, nil
 }
 
-func (m *store) UpdatePost(postID, title, content string) (string, error) {
+func (m *store) UpdatePost(postID, editorID, title, content string, format models.ContentFormat) (string, error) {
+	if format != "" {
+		if err := validateContentFormat(format); err != nil {
+			return postID, xerrors.Errorf("error while updating post: %w", err)
+		}
+	}
+
 	// check to see if a post with the same postID already exists
 	// NOTE call the helper function instead of m.GetPost(...) to avoid checking for an existing user again
 	post, err := m.GetPost(postID)
@@ -189,29 +1031,60 @@ func (m *store) UpdatePost(postID, title, content string) (string, error) {
 		return postID, xerrors.Errorf("error getting post: %w", err)
 	}
 	if post == nil {
-		return postID, fmt.Errorf("post doesn't exist for ID: %s", postID)
+		return postID, notFound("post doesn't exist for ID: %s", postID)
 	}
 
-	// update the existing post
-	if title != "" {
-		_, err = m.db.Exec("UPDATE posts SET title = $1 WHERE id = $2",
-			title, postID)
-		if err != nil {
-			return postID, xerrors.Errorf("error while updating post: %w", err)
-		}
+	if title == "" && content == "" && format == "" {
+		return postID, nil
 	}
 
-	if content != "" {
-		_, err = m.db.Exec("UPDATE posts SET content = $1 WHERE id = $2",
-			content, postID)
-		if err != nil {
-			return postID, xerrors.Errorf("error while updating post: %w", err)
-		}
+	tx, err := m.db.Begin()
+	if err != nil {
+		return postID, xerrors.Errorf("error while updating post: %w", err)
+	}
+	defer tx.Rollback()
+
+	// the content is rendered as it will be, which nobody can change meanwhile
+	var current models.Post
+	err = tx.QueryRow("SELECT content, format FROM posts WHERE id = $1 FOR UPDATE", postID).Scan(&current.Content, &current.Format)
+	if err != nil {
+		return postID, translateError(err, "error while updating post")
+	}
+	if content == "" {
+		content = current.Content
+	}
+	if format == "" {
+		format = current.Format
+	}
+	contentHTML, err := render.HTML(format, content)
+	if err != nil {
+		return postID, xerrors.Errorf("error while updating post: %w", err)
+	}
+
+	// update the existing post, leaving an empty title untouched
+	_, err = tx.Exec(`UPDATE posts SET
+			title = COALESCE(NULLIF($1, ''), title),
+			content = $2,
+			format = $5,
+			content_html = $6,
+			updated_at = now(),
+			updated_by = $3
+		WHERE id = $4`,
+		title, content, sql.NullString{String: editorID, Valid: editorID != ""}, postID, format, contentHTML)
+	if err != nil {
+		return postID, translateError(err, "error while updating post")
+	}
+	if _, err := addRevision(tx, postID, editorID); err != nil {
+		return postID, translateError(err, "error while updating post")
 	}
 
+	if err := tx.Commit(); err != nil {
+		return postID, xerrors.Errorf("error while updating post: %w", err)
+	}
 	return postID, nil
 }
 
+// DeletePost moves the post to the trash
 func (m *store) DeletePost(postID string) (string, error) {
 	// check if the post exists
 	post, err := m.GetPost(postID)
@@ -219,13 +1092,104 @@ func (m *store) DeletePost(postID string) (string, error) {
 		return postID, xerrors.Errorf("error getting post: %w", err)
 	}
 	if post == nil {
-		return postID, fmt.Errorf("cannot delete post that doesn't exist")
+		return postID, notFound("cannot delete post that doesn't exist")
 	}
 
-	_, err = m.db.Exec("DELETE FROM posts WHERE id = $1", postID)
+	_, err = m.db.Exec("UPDATE posts SET deleted_at = now() WHERE id = $1 AND deleted_at IS NULL", postID)
 	if err != nil {
 		return postID, xerrors.Errorf("error deleting post: %w", err)
 	}
 
 	return postID, nil
 }
+
+func (m *store) SetPostStatus(postID, editorID string, status models.PostStatus, publishAt time.Time) (string, error) {
+	const msg = "error while changing post status"
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return postID, xerrors.Errorf("%s: %w", msg, err)
+	}
+	defer tx.Rollback()
+
+	var from models.PostStatus
+	err = tx.QueryRow("SELECT status FROM posts WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", postID).Scan(&from)
+	if err == sql.ErrNoRows {
+		return postID, notFound("post doesn't exist for ID: %s", postID)
+	}
+	if err != nil {
+		return postID, translateError(err, msg)
+	}
+	if err := checkTransition(from, status, publishAt, time.Now()); err != nil {
+		return postID, xerrors.Errorf("%s: %w", msg, err)
+	}
+
+	// restoring an archived post keeps its original publication time
+	_, err = tx.Exec(`UPDATE posts SET
+			status = $1,
+			published_at = CASE $1
+				WHEN 'draft' THEN NULL
+				WHEN 'scheduled' THEN $2::timestamptz
+				WHEN 'published' THEN CASE WHEN status = 'archived' THEN published_at ELSE now() END
+				ELSE published_at
+			END,
+			updated_at = now(),
+			updated_by = $3
+		WHERE id = $4`,
+		status, sql.NullTime{Time: publishAt, Valid: status == models.PostScheduled},
+		sql.NullString{String: editorID, Valid: editorID != ""}, postID)
+	if err != nil {
+		return postID, translateError(err, msg)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return postID, xerrors.Errorf("%s: %w", msg, err)
+	}
+	return postID, nil
+}
+
+func (m *store) PublishDuePosts() ([]string, error) {
+	// scheduled posts keep the time they were due as their publication time
+	return m.queryStrings("error publishing scheduled posts", `UPDATE posts SET status = 'published'
+		WHERE status = 'scheduled' AND published_at <= now() AND deleted_at IS NULL RETURNING id`)
+}
+
+func (m *store) RenderPosts() (int, error) {
+	const msg = "error rendering posts"
+	rows, err := m.db.Query("SELECT id, content, format FROM posts WHERE content_html IS NULL")
+	if err != nil {
+		return 0, translateError(err, msg)
+	}
+	defer rows.Close()
+
+	var posts []*models.Post
+	for rows.Next() {
+		var post models.Post
+		if err := rows.Scan(&post.ID, &post.Content, &post.Format); err != nil {
+			return 0, xerrors.Errorf("error parsing DB response: %w", err)
+		}
+		posts = append(posts, &post)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, xerrors.Errorf("%s: %w", msg, err)
+	}
+
+	rendered := 0
+	for _, post := range posts {
+		contentHTML, err := render.HTML(post.Format, post.Content)
+		if err != nil {
+			return rendered, xerrors.Errorf("%s: %w", msg, err)
+		}
+		// a post edited meanwhile has been rendered already
+		result, err := m.db.Exec(`UPDATE posts SET content_html = $1
+			WHERE id = $2 AND content = $3 AND format = $4 AND content_html IS NULL`,
+			contentHTML, post.ID, post.Content, post.Format)
+		if err != nil {
+			return rendered, translateError(err, msg)
+		}
+		if n, err := result.RowsAffected(); err == nil {
+			rendered += int(n)
+		}
+	}
+	return rendered, nil
+}
@@ -0,0 +1,96 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/lib/pq"
+	"golang.org/x/xerrors"
+)
+
+// Sentinel errors describing why a store operation failed. Check for them
+// with xerrors.Is (or errors.Is) - the errors returned by stores wrap them.
+var (
+	ErrNotFound   = xerrors.New("not found")
+	ErrConflict   = xerrors.New("conflict")
+	ErrValidation = xerrors.New("invalid input")
+	ErrForeignKey = xerrors.New("referenced resource does not exist")
+)
+
+// Error is a store error with a domain kind (one of the sentinels above), a
+// message that is safe to show to API clients, and the underlying cause, if
+// any, for logs.
+type Error struct {
+	Kind error
+	Msg  string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Msg, e.Err)
+	}
+	return e.Msg
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func newError(kind error, cause error, format string, args ...interface{}) *Error {
+	return &Error{Kind: kind, Msg: fmt.Sprintf(format, args...), Err: cause}
+}
+
+func notFound(format string, args ...interface{}) error {
+	return newError(ErrNotFound, nil, format, args...)
+}
+
+// Postgres error codes, see https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	pqUniqueViolation      = "23505"
+	pqForeignKeyViolation  = "23503"
+	pqNotNullViolation     = "23502"
+	pqCheckViolation       = "23514"
+	pqInvalidTextRepr      = "22P02"
+	pqStringDataTruncation = "22001"
+)
+
+// translateError wraps a database error as the matching domain error, falling
+// back to a plain wrapped error for anything unexpected
+func translateError(err error, msg string) error {
+	var pqErr *pq.Error
+	if !xerrors.As(err, &pqErr) {
+		return xerrors.Errorf("%s: %w", msg, err)
+	}
+
+	switch pqErr.Code {
+	case pqUniqueViolation:
+		return newError(ErrConflict, err, "%s: %s already exists", msg, conflictingField(pqErr))
+	case pqForeignKeyViolation:
+		return newError(ErrForeignKey, err, "%s: referenced resource does not exist", msg)
+	case pqNotNullViolation, pqCheckViolation, pqInvalidTextRepr, pqStringDataTruncation:
+		return newError(ErrValidation, err, "%s: invalid input", msg)
+	}
+	return xerrors.Errorf("%s: %w", msg, err)
+}
+
+// isSlugConflict reports whether err is the insert of a post whose slug
+// another post has
+func isSlugConflict(err error) bool {
+	var pqErr *pq.Error
+	return xerrors.As(err, &pqErr) && pqErr.Code == pqUniqueViolation && pqErr.Constraint == "idx_posts_slug"
+}
+
+// conflictingField names what was duplicated, without exposing the constraint
+func conflictingField(err *pq.Error) string {
+	if err.Constraint == "users_email_key" || err.Constraint == "idx_users_live_email" {
+		return "email"
+	}
+	if err.Constraint == "idx_categories_sibling_name" {
+		return "category name"
+	}
+	return "resource"
+}
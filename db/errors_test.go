@@ -0,0 +1,44 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestTranslateError(t *testing.T) {
+	cases := []struct {
+		err  error
+		kind error
+	}{
+		{&pq.Error{Code: pqUniqueViolation, Constraint: "users_email_key"}, ErrConflict},
+		{&pq.Error{Code: pqForeignKeyViolation}, ErrForeignKey},
+		{&pq.Error{Code: pqInvalidTextRepr}, ErrValidation},
+		{&pq.Error{Code: pqNotNullViolation}, ErrValidation},
+	}
+	for _, c := range cases {
+		err := translateError(c.err, "error doing thing")
+		require.True(t, xerrors.Is(err, c.kind), "%s should be %v", c.err, c.kind)
+
+		// the driver error is kept for logging
+		var pqErr *pq.Error
+		require.True(t, xerrors.As(err, &pqErr))
+	}
+
+	err := translateError(&pq.Error{Code: pqUniqueViolation, Constraint: "users_email_key"}, "error while inserting user")
+	var storeErr *Error
+	require.True(t, xerrors.As(err, &storeErr))
+	require.Equal(t, "error while inserting user: email already exists", storeErr.Msg)
+
+	require.True(t, isSlugConflict(&pq.Error{Code: pqUniqueViolation, Constraint: "idx_posts_slug"}))
+	require.False(t, isSlugConflict(&pq.Error{Code: pqUniqueViolation, Constraint: "users_email_key"}))
+	require.False(t, isSlugConflict(nil))
+
+	// anything unexpected stays an internal error
+	err = translateError(fmt.Errorf("connection reset"), "error doing thing")
+	require.False(t, xerrors.Is(err, ErrNotFound))
+	require.False(t, xerrors.As(err, &storeErr))
+}
@@ -0,0 +1,37 @@
+package db
+
+import "fmt"
+
+// keyset pages through a query's rows in the order of a column, with ties
+// broken by id, starting from the row just past a key rather than at an
+// offset
+type keyset struct {
+	column    string // may be an expression, e.g. with a COLLATE
+	desc      bool
+	key       interface{} // column value to start from, nil for the first page
+	id        string
+	backwards bool // page back from the key, in reverse order
+	limit     int  // 0 for no limit
+}
+
+// apply adds the key to where, a condition on args, and returns it with the
+// query's ORDER BY and LIMIT. One row more than the limit is fetched, so the
+// caller can tell whether there is another page.
+func (k keyset) apply(where string, args []interface{}) (string, string, []interface{}) {
+	desc := k.desc != k.backwards
+	op, dir := ">", "ASC"
+	if desc {
+		op, dir = "<", "DESC"
+	}
+
+	if k.key != nil {
+		args = append(args, k.key, k.id)
+		where += fmt.Sprintf(" AND (%s, id) %s ($%d, $%d)", k.column, op, len(args)-1, len(args))
+	}
+	suffix := fmt.Sprintf(" ORDER BY %s %s, id %s", k.column, dir, dir)
+	if k.limit > 0 {
+		args = append(args, k.limit+1)
+		suffix += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	return where, suffix, args
+}
@@ -0,0 +1,1951 @@
+package db
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gavinc95/go-blog/db/models"
+	"github.com/gavinc95/go-blog/render"
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+)
+
+// memoryStore is a BlogStore backed by in-memory maps. It mirrors the
+// behaviour of the Postgres store (unique emails, posts deleted along with
+// their user, nil results for missing rows) so it can stand in for it in
+// tests and local development. Nothing is persisted. Deleted users and posts
+// stay in the maps, with DeletedAt set, until they are purged.
+type memoryStore struct {
+	mu        sync.RWMutex
+	idManager IDManager
+
+	users    map[string]*models.User
+	posts    map[string]*models.Post
+	sessions map[string]*models.Session
+	tokens   map[string]*models.RefreshToken
+
+	revisions map[string][]*models.PostRevision // post ID -> revisions, oldest first
+	comments  map[string]*models.Comment
+
+	postTags       map[string]map[string]bool // post ID -> tags
+	categories     map[string]*models.Category
+	postCategories map[string]map[string]bool // post ID -> category IDs
+
+	roles     map[string]*models.Role
+	userRoles map[string]map[string]bool // user ID -> role names
+}
+
+func NewMemoryStore(idManager IDManager) *memoryStore {
+	return &memoryStore{
+		idManager: idManager,
+		users:     make(map[string]*models.User),
+		posts:     make(map[string]*models.Post),
+		sessions:  make(map[string]*models.Session),
+		tokens:    make(map[string]*models.RefreshToken),
+		revisions: make(map[string][]*models.PostRevision),
+		comments:  make(map[string]*models.Comment),
+
+		postTags:       make(map[string]map[string]bool),
+		categories:     make(map[string]*models.Category),
+		postCategories: make(map[string]map[string]bool),
+
+		roles:     defaultRoles(),
+		userRoles: make(map[string]map[string]bool),
+	}
+}
+
+// defaultRoles returns the roles that the migrations seed the database with
+func defaultRoles() map[string]*models.Role {
+	reader := []string{"comment.create.own", "comment.delete.own", "comment.update.own", "role.read.own", "user.delete.own", "user.update.own"}
+	author := append([]string{"comment.moderate.own", "post.create.own", "post.delete.own", "post.update.own"}, reader...)
+	editor := append([]string{"comment.delete.any", "comment.moderate.any", "post.delete.any", "post.update.any"}, author...)
+	admin := append([]string{"category.create.any", "category.delete.any", "comment.update.any", "post.create.any", "role.grant.any", "role.read.any", "role.revoke.any",
+		"user.create.any", "user.delete.any", "user.list.any", "user.update.any"}, editor...)
+
+	roles := map[string]*models.Role{
+		"admin":  {Name: "admin", Description: "manages users and roles, and can do anything", Permissions: admin},
+		"editor": {Name: "editor", Description: "edits and deletes any post", Permissions: editor},
+		"author": {Name: "author", Description: "writes their own posts", Permissions: author},
+		"reader": {Name: "reader", Description: "reads, and manages their own account", Permissions: reader},
+	}
+	for _, role := range roles {
+		sort.Strings(role.Permissions)
+	}
+	return roles
+}
+
+// GetDB returns nil - there is no database behind the memory store
+func (m *memoryStore) GetDB() *sql.DB {
+	return nil
+}
+
+// validateID rejects IDs that Postgres would fail to parse as a UUID
+func validateID(id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return newError(ErrValidation, err, "invalid ID %q", id)
+	}
+	return nil
+}
+
+func (m *memoryStore) GetUser(id string) (*models.User, error) {
+	if err := validateID(id); err != nil {
+		return nil, xerrors.Errorf("error finding user in db: %w", err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.liveUser(id)
+	if !ok {
+		return nil, nil
+	}
+	copied := *user
+	return &copied, nil
+}
+
+// liveUser returns the user unless they don't exist or are deleted. Callers
+// must hold the lock.
+func (m *memoryStore) liveUser(id string) (*models.User, bool) {
+	user, ok := m.users[id]
+	if !ok || user.DeletedAt != nil {
+		return nil, false
+	}
+	return user, true
+}
+
+// livePost returns the post unless it doesn't exist or is deleted. Callers
+// must hold the lock.
+func (m *memoryStore) livePost(id string) (*models.Post, bool) {
+	post, ok := m.posts[id]
+	if !ok || post.DeletedAt != nil {
+		return nil, false
+	}
+	return post, true
+}
+
+// emailTaken reports whether another user who isn't deleted already has the
+// given email, like the partial unique index. Callers must hold the lock.
+func (m *memoryStore) emailTaken(email, exceptID string) bool {
+	for id, user := range m.users {
+		if id != exceptID && user.DeletedAt == nil && user.Email == email {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *memoryStore) GetUserByEmail(email string) (*models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, user := range m.users {
+		if user.DeletedAt == nil && user.Email == email {
+			copied := *user
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memoryStore) GetAllUsers(opts UserListOptions) (*UserPage, error) {
+	if err := validateUserListOptions(&opts); err != nil {
+		return nil, xerrors.Errorf("failed to fetch users: %w", err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	less := func(a, b UserKey) bool {
+		if opts.Sort == UserSortEmail && a.Email != b.Email {
+			return a.Email < b.Email
+		} else if opts.Sort == UserSortName && a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.ID < b.ID
+	}
+	search := strings.ToLower(opts.Search)
+
+	// collect the matching users beyond the key, in the direction of paging
+	var users []*models.User
+	for _, user := range m.users {
+		if user.DeletedAt != nil {
+			continue
+		}
+		if search != "" && !strings.HasPrefix(strings.ToLower(user.Name), search) &&
+			!strings.HasPrefix(strings.ToLower(user.Email), search) {
+			continue
+		}
+		key := UserKeyOf(user)
+		if (opts.After != nil && !less(*opts.After, key)) || (opts.Before != nil && !less(key, *opts.Before)) {
+			continue
+		}
+		copied := *user
+		users = append(users, &copied)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if opts.Before != nil {
+			return less(UserKeyOf(users[j]), UserKeyOf(users[i]))
+		}
+		return less(UserKeyOf(users[i]), UserKeyOf(users[j]))
+	})
+	if opts.Limit > 0 && len(users) > opts.Limit+1 {
+		users = users[:opts.Limit+1]
+	}
+
+	return newUserPage(users, opts), nil
+}
+
+func (m *memoryStore) CreateUser(name, email string, roles ...string) (string, error) {
+	return m.CreateUserWithPassword(name, email, "", roles...)
+}
+
+func (m *memoryStore) CreateUserWithPassword(name, email, passwordHash string, roles ...string) (string, error) {
+	id := m.idManager.UUID()
+	if err := validateID(id); err != nil {
+		return id, xerrors.Errorf("error while inserting user: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[id]; ok {
+		return id, newError(ErrConflict, nil, "error while inserting user: resource already exists")
+	}
+	if m.emailTaken(email, "") {
+		return id, newError(ErrConflict, nil, "error while inserting user: email already exists")
+	}
+	for _, role := range roles {
+		if _, ok := m.roles[role]; !ok {
+			return id, newError(ErrForeignKey, nil, "error while inserting user: referenced resource does not exist")
+		}
+	}
+
+	m.users[id] = &models.User{ID: id, Name: name, Email: email, PasswordHash: passwordHash}
+	for _, role := range roles {
+		m.grantRole(id, role)
+	}
+	return id, nil
+}
+
+func (m *memoryStore) UpdateUser(id, name, email string) (string, error) {
+	if err := validateID(id); err != nil {
+		return id, xerrors.Errorf("failed to check for existing user: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.liveUser(id)
+	if !ok {
+		return id, notFound("user doesn't exist - create one first")
+	}
+
+	if email != "" && m.emailTaken(email, id) {
+		return id, newError(ErrConflict, nil, "error while updating user: email already exists")
+	}
+
+	if name != "" {
+		user.Name = name
+	}
+	if email != "" {
+		user.Email = email
+	}
+
+	return id, nil
+}
+
+func (m *memoryStore) DeleteUser(id string) (string, error) {
+	if err := validateID(id); err != nil {
+		return id, xerrors.Errorf("error finding user in db: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.liveUser(id)
+	if !ok {
+		return id, notFound("user does not exist for ID: %s", id)
+	}
+
+	at := now()
+	user.DeletedAt = &at
+	for _, post := range m.posts {
+		if post.UserID == id && post.DeletedAt == nil {
+			deletedAt := at
+			post.DeletedAt = &deletedAt
+		}
+	}
+	for commentID, comment := range m.comments {
+		if comment.UserID == id && comment.DeletedAt == nil {
+			m.deleteComment(commentID, at)
+		}
+	}
+	for sessionID, session := range m.sessions {
+		if session.UserID == id {
+			delete(m.sessions, sessionID)
+		}
+	}
+	for tokenID, token := range m.tokens {
+		if token.UserID == id {
+			delete(m.tokens, tokenID)
+		}
+	}
+
+	return id, nil
+}
+
+func (m *memoryStore) GetAllPosts(userID string, opts PostListOptions, statuses ...models.PostStatus) (*PostPage, error) {
+	if err := validateID(userID); err != nil {
+		return nil, xerrors.Errorf("failed to fetch posts for user: %w", err)
+	}
+
+	return m.listPosts("failed to fetch posts for user", func(post *models.Post) bool {
+		if post.UserID != userID || post.DeletedAt != nil {
+			return false
+		}
+		for _, status := range statuses {
+			if post.Status == status {
+				return true
+			}
+		}
+		return len(statuses) == 0
+	}, opts, false)
+}
+
+func (m *memoryStore) GetFeed(filter FeedFilter, opts PostListOptions) (*PostPage, error) {
+	if filter.AuthorID != "" {
+		if err := validateID(filter.AuthorID); err != nil {
+			return nil, xerrors.Errorf("failed to fetch feed: %w", err)
+		}
+	}
+
+	if filter.CategoryID != "" {
+		if err := validateID(filter.CategoryID); err != nil {
+			return nil, xerrors.Errorf("failed to fetch feed: %w", err)
+		}
+	}
+
+	var categories map[string]bool
+	if filter.CategoryID != "" {
+		m.mu.RLock()
+		categories = m.categoryTree(filter.CategoryID)
+		m.mu.RUnlock()
+	}
+	page, err := m.listPosts("failed to fetch feed", func(post *models.Post) bool {
+		published := post.PublishedAt
+		return post.Status == models.PostPublished && post.DeletedAt == nil &&
+			(filter.Tag == "" || m.postTags[post.ID][filter.Tag]) &&
+			(categories == nil || m.inCategories(post.ID, categories)) &&
+			(filter.AuthorID == "" || post.UserID == filter.AuthorID) &&
+			(filter.PublishedFrom.IsZero() || !published.Before(filter.PublishedFrom)) &&
+			(filter.PublishedTo.IsZero() || published.Before(filter.PublishedTo))
+	}, opts, true)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, post := range page.Posts {
+		if user, ok := m.users[post.UserID]; ok {
+			post.Author = &models.PostAuthor{ID: user.ID, Name: user.Name}
+		}
+	}
+	return page, nil
+}
+
+// listPosts returns a page of the posts that match. byPublished orders the
+// newest and oldest by PublishedAt rather than CreatedAt.
+func (m *memoryStore) listPosts(msg string, match func(*models.Post) bool, opts PostListOptions, byPublished bool) (*PostPage, error) {
+	if err := validateListOptions(&opts); err != nil {
+		return nil, xerrors.Errorf("%s: %w", msg, err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// collect the matching posts beyond the key, in the direction of paging
+	less := postLess(opts.Sort, byPublished)
+	var posts []*models.Post
+	for _, post := range m.posts {
+		if !match(post) {
+			continue
+		}
+		key := KeyOf(post)
+		if (opts.After != nil && !less(*opts.After, key)) || (opts.Before != nil && !less(key, *opts.Before)) {
+			continue
+		}
+		posts = append(posts, m.withTags(post))
+	}
+	sort.Slice(posts, func(i, j int) bool {
+		if opts.Before != nil {
+			return less(KeyOf(posts[j]), KeyOf(posts[i]))
+		}
+		return less(KeyOf(posts[i]), KeyOf(posts[j]))
+	})
+	if opts.Limit > 0 && len(posts) > opts.Limit+1 {
+		posts = posts[:opts.Limit+1]
+	}
+
+	return newPostPage(posts, opts), nil
+}
+
+func (m *memoryStore) GetPost(postID string) (*models.Post, error) {
+	if err := validateID(postID); err != nil {
+		return nil, xerrors.Errorf("error finding post in db: %w", err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	post, ok := m.livePost(postID)
+	if !ok {
+		return nil, nil
+	}
+	return m.withTags(post), nil
+}
+
+func (m *memoryStore) GetPostBySlug(slug string) (*models.Post, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, post := range m.posts {
+		if post.Slug == slug && post.DeletedAt == nil {
+			return m.withTags(post), nil
+		}
+	}
+	return nil, nil
+}
+
+// slugTaken reports whether any post, even a deleted one, has slug
+func (m *memoryStore) slugTaken(slug string) bool {
+	for _, post := range m.posts {
+		if post.Slug == slug {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *memoryStore) CreatePost(userID, title, content string, format models.ContentFormat, status models.PostStatus, publishAt time.Time) (string, error) {
+	postID := m.idManager.UUID()
+	if err := validateID(postID); err != nil {
+		return postID, xerrors.Errorf("error creating new post: %w", err)
+	}
+	if err := validateID(userID); err != nil {
+		return postID, xerrors.Errorf("error creating new post: %w", err)
+	}
+	if err := validateContentFormat(format); err != nil {
+		return postID, xerrors.Errorf("error creating new post: %w", err)
+	}
+	if err := checkTransition("", status, publishAt, time.Now()); err != nil {
+		return postID, xerrors.Errorf("error creating new post: %w", err)
+	}
+	contentHTML, err := render.HTML(format, content)
+	if err != nil {
+		return postID, xerrors.Errorf("error creating new post: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.liveUser(userID); !ok {
+		return postID, newError(ErrForeignKey, nil, "error creating new post: referenced resource does not exist")
+	}
+	if _, ok := m.posts[postID]; ok {
+		return postID, newError(ErrConflict, nil, "error creating new post: resource already exists")
+	}
+	var slug string
+	for _, slug = range slugCandidates(title, postID) {
+		if !m.slugTaken(slug) {
+			break
+		}
+	}
+
+	createdAt := now()
+	post := &models.Post{
+		ID:        postID,
+		UserID:    userID,
+		Slug:      slug,
+		Title:     title,
+		Content:   content,
+		Format:    format,
+		HTML:      contentHTML,
+		Status:    status,
+		CreatedAt: createdAt,
+		UpdatedAt: createdAt,
+	}
+	switch status {
+	case models.PostPublished:
+		post.PublishedAt = &createdAt
+	case models.PostScheduled:
+		publishAt = publishAt.UTC().Truncate(time.Microsecond)
+		post.PublishedAt = &publishAt
+	}
+	m.posts[postID] = post
+	m.recordRevision(post, userID)
+	return postID, nil
+}
+
+func (m *memoryStore) UpdatePost(postID, editorID, title, content string, format models.ContentFormat) (string, error) {
+	if err := validateID(postID); err != nil {
+		return postID, xerrors.Errorf("error getting post: %w", err)
+	}
+	if format != "" {
+		if err := validateContentFormat(format); err != nil {
+			return postID, xerrors.Errorf("error while updating post: %w", err)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	post, ok := m.livePost(postID)
+	if !ok {
+		return postID, notFound("post doesn't exist for ID: %s", postID)
+	}
+
+	if title == "" && content == "" && format == "" {
+		return postID, nil
+	}
+	if editorID != "" {
+		if _, ok := m.users[editorID]; !ok {
+			return postID, newError(ErrForeignKey, nil, "error while updating post: referenced resource does not exist")
+		}
+	}
+
+	if content == "" {
+		content = post.Content
+	}
+	if format == "" {
+		format = post.Format
+	}
+	contentHTML, err := render.HTML(format, content)
+	if err != nil {
+		return postID, xerrors.Errorf("error while updating post: %w", err)
+	}
+
+	if title != "" {
+		post.Title = title
+	}
+	post.Content, post.Format, post.HTML = content, format, contentHTML
+	post.UpdatedAt = now()
+	post.UpdatedBy = editorID
+	m.recordRevision(post, editorID)
+
+	return postID, nil
+}
+
+func (m *memoryStore) DeletePost(postID string) (string, error) {
+	if err := validateID(postID); err != nil {
+		return postID, xerrors.Errorf("error getting post: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	post, ok := m.livePost(postID)
+	if !ok {
+		return postID, notFound("cannot delete post that doesn't exist")
+	}
+	at := now()
+	post.DeletedAt = &at
+
+	return postID, nil
+}
+
+func (m *memoryStore) SetPostStatus(postID, editorID string, status models.PostStatus, publishAt time.Time) (string, error) {
+	if err := validateID(postID); err != nil {
+		return postID, xerrors.Errorf("error while changing post status: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	post, ok := m.livePost(postID)
+	if !ok {
+		return postID, notFound("post doesn't exist for ID: %s", postID)
+	}
+	if err := checkTransition(post.Status, status, publishAt, time.Now()); err != nil {
+		return postID, xerrors.Errorf("error while changing post status: %w", err)
+	}
+	if editorID != "" {
+		if _, ok := m.users[editorID]; !ok {
+			return postID, newError(ErrForeignKey, nil, "error while changing post status: referenced resource does not exist")
+		}
+	}
+
+	at := now()
+	switch status {
+	case models.PostDraft:
+		post.PublishedAt = nil
+	case models.PostScheduled:
+		publishAt = publishAt.UTC().Truncate(time.Microsecond)
+		post.PublishedAt = &publishAt
+	case models.PostPublished:
+		// restoring an archived post keeps its original publication time
+		if post.Status != models.PostArchived {
+			post.PublishedAt = &at
+		}
+	}
+	post.Status = status
+	post.UpdatedAt = at
+	post.UpdatedBy = editorID
+
+	return postID, nil
+}
+
+func (m *memoryStore) PublishDuePosts() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	at := time.Now()
+	var ids []string
+	for _, post := range m.posts {
+		if post.Status == models.PostScheduled && post.DeletedAt == nil && !post.PublishedAt.After(at) {
+			post.Status = models.PostPublished
+			ids = append(ids, post.ID)
+		}
+	}
+	return ids, nil
+}
+
+// RenderPosts has nothing to do, since the memory store's posts are all
+// rendered as they are written
+func (m *memoryStore) RenderPosts() (int, error) {
+	return 0, nil
+}
+
+// recordRevision adds the post as it now is to its history, returning the
+// revision's number. Callers must hold the lock.
+func (m *memoryStore) recordRevision(post *models.Post, editorID string) int {
+	rev := &models.PostRevision{
+		PostID:    post.ID,
+		Number:    len(m.revisions[post.ID]) + 1,
+		Title:     post.Title,
+		Content:   post.Content,
+		Format:    post.Format,
+		CreatedAt: post.UpdatedAt,
+		CreatedBy: editorID,
+	}
+	m.revisions[post.ID] = append(m.revisions[post.ID], rev)
+	return rev.Number
+}
+
+func (m *memoryStore) GetRevisions(postID string) ([]*models.PostRevision, error) {
+	if err := validateID(postID); err != nil {
+		return nil, xerrors.Errorf("failed to fetch post revisions: %w", err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.livePost(postID); !ok {
+		return nil, nil
+	}
+	var revisions []*models.PostRevision
+	for _, rev := range m.revisions[postID] {
+		copied := *rev
+		revisions = append(revisions, &copied)
+	}
+	return revisions, nil
+}
+
+func (m *memoryStore) GetRevision(postID string, number int) (*models.PostRevision, error) {
+	if err := validateID(postID); err != nil {
+		return nil, xerrors.Errorf("error finding post revision in db: %w", err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	revisions := m.revisions[postID]
+	if _, ok := m.livePost(postID); !ok || number < 1 || number > len(revisions) {
+		return nil, nil
+	}
+	copied := *revisions[number-1]
+	return &copied, nil
+}
+
+func (m *memoryStore) RestoreRevision(postID string, number int, editorID string) (int, error) {
+	if err := validateID(postID); err != nil {
+		return 0, xerrors.Errorf("error while restoring post revision: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	post, ok := m.livePost(postID)
+	revisions := m.revisions[postID]
+	if !ok || number < 1 || number > len(revisions) {
+		return 0, notFound("post %s has no revision %d", postID, number)
+	}
+	if editorID != "" {
+		if _, ok := m.users[editorID]; !ok {
+			return 0, newError(ErrForeignKey, nil, "error while restoring post revision: referenced resource does not exist")
+		}
+	}
+
+	rev := revisions[number-1]
+	contentHTML, err := render.HTML(rev.Format, rev.Content)
+	if err != nil {
+		return 0, xerrors.Errorf("error while restoring post revision: %w", err)
+	}
+	post.Title = rev.Title
+	post.Content, post.Format, post.HTML = rev.Content, rev.Format, contentHTML
+	post.UpdatedAt = now()
+	post.UpdatedBy = editorID
+	return m.recordRevision(post, editorID), nil
+}
+
+// now returns the current time as Postgres would store it
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+func copyPost(post *models.Post) *models.Post {
+	copied := *post
+	if post.PublishedAt != nil {
+		publishedAt := *post.PublishedAt
+		copied.PublishedAt = &publishedAt
+	}
+	if post.DeletedAt != nil {
+		deletedAt := *post.DeletedAt
+		copied.DeletedAt = &deletedAt
+	}
+	return &copied
+}
+
+// postLess reports whether a comes before b in a listing, ordered like the
+// Postgres store: by the sort's key and then by ID
+func postLess(order PostSort, byPublished bool) func(a, b PostKey) bool {
+	at := func(key PostKey) time.Time {
+		if byPublished {
+			return key.PublishedAt
+		}
+		return key.CreatedAt
+	}
+
+	switch order {
+	case SortOldest:
+		return func(a, b PostKey) bool {
+			if !at(a).Equal(at(b)) {
+				return at(a).Before(at(b))
+			}
+			return a.ID < b.ID
+		}
+	case SortTitle:
+		return func(a, b PostKey) bool {
+			if a.Title != b.Title {
+				return a.Title < b.Title
+			}
+			return a.ID < b.ID
+		}
+	}
+	return func(a, b PostKey) bool {
+		if !at(a).Equal(at(b)) {
+			return at(a).After(at(b))
+		}
+		return a.ID > b.ID
+	}
+}
+
+func (m *memoryStore) CreateSession(session *models.Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.createSession(session)
+}
+
+// createSession checks the same constraints as the sessions table. Callers
+// must hold the lock.
+func (m *memoryStore) createSession(session *models.Session) error {
+	if err := validateID(session.UserID); err != nil {
+		return xerrors.Errorf("error creating session: %w", err)
+	}
+	if _, ok := m.users[session.UserID]; !ok {
+		return newError(ErrForeignKey, nil, "error creating session: referenced resource does not exist")
+	}
+	if _, ok := m.sessions[session.ID]; ok {
+		return newError(ErrConflict, nil, "error creating session: resource already exists")
+	}
+
+	copied := *session
+	m.sessions[session.ID] = &copied
+	return nil
+}
+
+func (m *memoryStore) GetSession(id string) (*models.Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	session, ok := m.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	copied := *session
+	return &copied, nil
+}
+
+func (m *memoryStore) RotateSession(oldID string, session *models.Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old, ok := m.sessions[oldID]
+	if !ok {
+		return notFound("session doesn't exist")
+	}
+
+	delete(m.sessions, oldID)
+	if err := m.createSession(session); err != nil {
+		m.sessions[oldID] = old
+		return xerrors.Errorf("error rotating session: %w", err)
+	}
+	return nil
+}
+
+func (m *memoryStore) DeleteSession(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.sessions[id]; !ok {
+		return notFound("session doesn't exist")
+	}
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *memoryStore) CreateRefreshToken(token *models.RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.createRefreshToken(token)
+}
+
+// createRefreshToken checks the same constraints as the refresh_tokens
+// table. Callers must hold the lock.
+func (m *memoryStore) createRefreshToken(token *models.RefreshToken) error {
+	if err := validateID(token.UserID); err != nil {
+		return xerrors.Errorf("error creating refresh token: %w", err)
+	}
+	if _, ok := m.users[token.UserID]; !ok {
+		return newError(ErrForeignKey, nil, "error creating refresh token: referenced resource does not exist")
+	}
+	if _, ok := m.tokens[token.ID]; ok {
+		return newError(ErrConflict, nil, "error creating refresh token: resource already exists")
+	}
+
+	copied := *token
+	copied.RevokedAt = nil
+	m.tokens[token.ID] = &copied
+	return nil
+}
+
+func (m *memoryStore) GetRefreshToken(id string) (*models.RefreshToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	token, ok := m.tokens[id]
+	if !ok {
+		return nil, nil
+	}
+	copied := *token
+	if token.RevokedAt != nil {
+		revokedAt := *token.RevokedAt
+		copied.RevokedAt = &revokedAt
+	}
+	return &copied, nil
+}
+
+// revokeRefreshToken revokes a token that isn't revoked yet, and reports
+// whether there was one. Callers must hold the lock.
+func (m *memoryStore) revokeRefreshToken(id string, at time.Time) bool {
+	token, ok := m.tokens[id]
+	if !ok || token.RevokedAt != nil {
+		return false
+	}
+	token.RevokedAt = &at
+	return true
+}
+
+func (m *memoryStore) RotateRefreshToken(oldID string, next *models.RefreshToken, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if old, ok := m.tokens[oldID]; !ok || old.RevokedAt != nil {
+		return notFound("refresh token doesn't exist or has been revoked")
+	}
+	if err := m.createRefreshToken(next); err != nil {
+		return xerrors.Errorf("error rotating refresh token: %w", err)
+	}
+	m.revokeRefreshToken(oldID, at)
+	return nil
+}
+
+func (m *memoryStore) RevokeRefreshToken(id string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.revokeRefreshToken(id, at) {
+		return notFound("refresh token doesn't exist or has been revoked")
+	}
+	return nil
+}
+
+func (m *memoryStore) RevokeUserRefreshTokens(userID string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, token := range m.tokens {
+		if token.UserID == userID {
+			m.revokeRefreshToken(id, at)
+		}
+	}
+	return nil
+}
+
+func (m *memoryStore) GetRoles() ([]*models.Role, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	roles := make([]*models.Role, 0, len(m.roles))
+	for _, role := range m.roles {
+		copied := *role
+		copied.Permissions = append([]string(nil), role.Permissions...)
+		roles = append(roles, &copied)
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+	return roles, nil
+}
+
+func (m *memoryStore) GetUserRoles(userID string) ([]string, error) {
+	if err := validateID(userID); err != nil {
+		return nil, xerrors.Errorf("failed to fetch roles for user: %w", err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.liveUser(userID); !ok {
+		return nil, nil
+	}
+	var roles []string
+	for role := range m.userRoles[userID] {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles, nil
+}
+
+func (m *memoryStore) GetUserPermissions(userID string) ([]string, error) {
+	if err := validateID(userID); err != nil {
+		return nil, xerrors.Errorf("failed to fetch permissions for user: %w", err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.liveUser(userID); !ok {
+		return nil, nil
+	}
+	seen := make(map[string]bool)
+	var permissions []string
+	for role := range m.userRoles[userID] {
+		for _, permission := range m.roles[role].Permissions {
+			if !seen[permission] {
+				seen[permission] = true
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+	sort.Strings(permissions)
+	return permissions, nil
+}
+
+// grantRole assumes the user and role exist. Callers must hold the lock.
+func (m *memoryStore) grantRole(userID, role string) {
+	if m.userRoles[userID] == nil {
+		m.userRoles[userID] = make(map[string]bool)
+	}
+	m.userRoles[userID][role] = true
+}
+
+func (m *memoryStore) GrantRole(userID, role string) error {
+	if err := validateID(userID); err != nil {
+		return xerrors.Errorf("error granting role: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, userExists := m.users[userID]
+	_, roleExists := m.roles[role]
+	if !userExists || !roleExists {
+		return newError(ErrForeignKey, nil, "error granting role: referenced resource does not exist")
+	}
+	m.grantRole(userID, role)
+	return nil
+}
+
+func (m *memoryStore) RevokeRole(userID, role string) error {
+	if err := validateID(userID); err != nil {
+		return xerrors.Errorf("error revoking role: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.userRoles[userID], role)
+	return nil
+}
+
+func (m *memoryStore) GetDeletedUsers() ([]*models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var users []*models.User
+	for _, user := range m.users {
+		if user.DeletedAt != nil {
+			copied := *user
+			users = append(users, &copied)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if a, b := users[i].DeletedAt, users[j].DeletedAt; !a.Equal(*b) {
+			return a.After(*b)
+		}
+		return users[i].ID < users[j].ID
+	})
+	return users, nil
+}
+
+func (m *memoryStore) GetDeletedPosts(userID string) ([]*models.Post, error) {
+	if userID != "" {
+		if err := validateID(userID); err != nil {
+			return nil, xerrors.Errorf("failed to fetch deleted posts: %w", err)
+		}
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var posts []*models.Post
+	for _, post := range m.posts {
+		if post.DeletedAt != nil && (userID == "" || post.UserID == userID) {
+			posts = append(posts, copyPost(post))
+		}
+	}
+	sort.Slice(posts, func(i, j int) bool {
+		if a, b := posts[i].DeletedAt, posts[j].DeletedAt; !a.Equal(*b) {
+			return a.After(*b)
+		}
+		return posts[i].ID < posts[j].ID
+	})
+	return posts, nil
+}
+
+func (m *memoryStore) GetDeletedPost(postID string) (*models.Post, error) {
+	if err := validateID(postID); err != nil {
+		return nil, xerrors.Errorf("error finding deleted post in db: %w", err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	post, ok := m.posts[postID]
+	if !ok || post.DeletedAt == nil {
+		return nil, nil
+	}
+	return copyPost(post), nil
+}
+
+func (m *memoryStore) RestoreUser(id string) (string, error) {
+	if err := validateID(id); err != nil {
+		return id, xerrors.Errorf("error restoring user: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok || user.DeletedAt == nil {
+		return id, notFound("user %s isn't in the trash", id)
+	}
+	if m.emailTaken(user.Email, id) {
+		return id, newError(ErrConflict, nil, "error restoring user: email already exists")
+	}
+
+	for _, post := range m.posts {
+		if post.UserID == id && post.DeletedAt != nil && post.DeletedAt.Equal(*user.DeletedAt) {
+			post.DeletedAt = nil
+		}
+	}
+	for commentID, comment := range m.comments {
+		if comment.UserID == id && comment.DeletedAt != nil && comment.DeletedAt.Equal(*user.DeletedAt) {
+			m.restoreComment(commentID, *user.DeletedAt)
+		}
+	}
+	user.DeletedAt = nil
+	return id, nil
+}
+
+func (m *memoryStore) RestorePost(postID string) (string, error) {
+	if err := validateID(postID); err != nil {
+		return postID, xerrors.Errorf("error restoring post: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	post, ok := m.posts[postID]
+	if !ok || post.DeletedAt == nil {
+		return postID, notFound("post %s isn't in the trash", postID)
+	}
+	if _, ok := m.liveUser(post.UserID); !ok {
+		return postID, newError(ErrConflict, nil, "error restoring post: restore the post's author first")
+	}
+
+	post.DeletedAt = nil
+	return postID, nil
+}
+
+func (m *memoryStore) PurgeDeleted(cutoff time.Time) (int, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, comment := range m.comments {
+		if comment.DeletedAt != nil && comment.DeletedAt.Before(cutoff) {
+			m.purgeComment(id)
+		}
+	}
+	var users, posts int
+	for id, post := range m.posts {
+		if post.DeletedAt != nil && post.DeletedAt.Before(cutoff) {
+			m.purgePost(id)
+			posts++
+		}
+	}
+	for id, user := range m.users {
+		if user.DeletedAt != nil && user.DeletedAt.Before(cutoff) {
+			m.purgeUser(id)
+			users++
+		}
+	}
+	return users, posts, nil
+}
+
+// purgePost removes the post for good. Callers must hold the lock.
+func (m *memoryStore) purgePost(id string) {
+	delete(m.posts, id)
+	delete(m.revisions, id)
+	delete(m.postTags, id)
+	delete(m.postCategories, id)
+	for commentID, comment := range m.comments {
+		if comment.PostID == id {
+			m.purgeComment(commentID)
+		}
+	}
+}
+
+// purgeComment removes the comment for good, along with its replies like ON
+// DELETE CASCADE. Callers must hold the lock.
+func (m *memoryStore) purgeComment(id string) {
+	delete(m.comments, id)
+	for replyID, reply := range m.comments {
+		if reply.ParentID == id {
+			m.purgeComment(replyID)
+		}
+	}
+}
+
+// purgeUser removes the user for good, mirroring ON DELETE CASCADE, and SET
+// NULL for updated_by and created_by. Callers must hold the lock.
+func (m *memoryStore) purgeUser(id string) {
+	delete(m.users, id)
+	for commentID, comment := range m.comments {
+		if comment.UserID == id {
+			m.purgeComment(commentID)
+		}
+	}
+	for postID, post := range m.posts {
+		if post.UserID == id {
+			m.purgePost(postID)
+		} else if post.UpdatedBy == id {
+			post.UpdatedBy = ""
+		}
+	}
+	for _, revisions := range m.revisions {
+		for _, rev := range revisions {
+			if rev.CreatedBy == id {
+				rev.CreatedBy = ""
+			}
+		}
+	}
+	for sessionID, session := range m.sessions {
+		if session.UserID == id {
+			delete(m.sessions, sessionID)
+		}
+	}
+	for tokenID, token := range m.tokens {
+		if token.UserID == id {
+			delete(m.tokens, tokenID)
+		}
+	}
+	delete(m.userRoles, id)
+}
+
+// withTags copies the post with its tags filled in. Callers must hold the
+// lock.
+func (m *memoryStore) withTags(post *models.Post) *models.Post {
+	copied := copyPost(post)
+	for tag := range m.postTags[post.ID] {
+		copied.Tags = append(copied.Tags, tag)
+	}
+	sort.Strings(copied.Tags)
+	return copied
+}
+
+func (m *memoryStore) GetTagCounts(limit int) ([]*models.TagCount, error) {
+	if limit < 0 {
+		return nil, newError(ErrValidation, nil, "failed to fetch tag counts: limit must not be negative")
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for postID, tags := range m.postTags {
+		if post, ok := m.livePost(postID); !ok || post.Status != models.PostPublished {
+			continue
+		}
+		for tag := range tags {
+			counts[tag]++
+		}
+	}
+
+	var tagCounts []*models.TagCount
+	for tag, count := range counts {
+		tagCounts = append(tagCounts, &models.TagCount{Tag: tag, Count: count})
+	}
+	sort.Slice(tagCounts, func(i, j int) bool {
+		if tagCounts[i].Count != tagCounts[j].Count {
+			return tagCounts[i].Count > tagCounts[j].Count
+		}
+		return tagCounts[i].Tag < tagCounts[j].Tag
+	})
+	if limit > 0 && len(tagCounts) > limit {
+		tagCounts = tagCounts[:limit]
+	}
+	return tagCounts, nil
+}
+
+func (m *memoryStore) TagPost(postID, tag string) error {
+	if err := validateID(postID); err != nil {
+		return xerrors.Errorf("error tagging post: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.livePost(postID); !ok {
+		return notFound("post doesn't exist for ID: %s", postID)
+	}
+	if tag == "" {
+		return newError(ErrValidation, nil, "error tagging post: invalid input")
+	}
+	if m.postTags[postID] == nil {
+		m.postTags[postID] = make(map[string]bool)
+	}
+	m.postTags[postID][tag] = true
+	return nil
+}
+
+func (m *memoryStore) UntagPost(postID, tag string) error {
+	if err := validateID(postID); err != nil {
+		return xerrors.Errorf("error untagging post: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.livePost(postID); !ok {
+		return notFound("post doesn't exist for ID: %s", postID)
+	}
+	delete(m.postTags[postID], tag)
+	return nil
+}
+
+// categoryTree returns the IDs of the category and all its descendants.
+// Callers must hold the lock.
+func (m *memoryStore) categoryTree(id string) map[string]bool {
+	tree := map[string]bool{id: true}
+	for grew := true; grew; {
+		grew = false
+		for _, category := range m.categories {
+			if tree[category.ParentID] && !tree[category.ID] {
+				tree[category.ID] = true
+				grew = true
+			}
+		}
+	}
+	return tree
+}
+
+// inCategories reports whether the post is in any of the categories. Callers
+// must hold the lock.
+func (m *memoryStore) inCategories(postID string, categories map[string]bool) bool {
+	for id := range m.postCategories[postID] {
+		if categories[id] {
+			return true
+		}
+	}
+	return false
+}
+
+// sortCategories orders categories like the Postgres store: by name, then ID
+func sortCategories(categories []*models.Category) {
+	sort.Slice(categories, func(i, j int) bool {
+		if categories[i].Name != categories[j].Name {
+			return categories[i].Name < categories[j].Name
+		}
+		return categories[i].ID < categories[j].ID
+	})
+}
+
+func (m *memoryStore) GetCategories() ([]*models.Category, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var categories []*models.Category
+	for _, category := range m.categories {
+		copied := *category
+		categories = append(categories, &copied)
+	}
+	sortCategories(categories)
+	return categories, nil
+}
+
+func (m *memoryStore) GetCategory(id string) (*models.Category, error) {
+	if err := validateID(id); err != nil {
+		return nil, xerrors.Errorf("error finding category in db: %w", err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	category, ok := m.categories[id]
+	if !ok {
+		return nil, nil
+	}
+	copied := *category
+	return &copied, nil
+}
+
+func (m *memoryStore) CreateCategory(name, parentID string) (string, error) {
+	id := m.idManager.UUID()
+	if err := validateID(id); err != nil {
+		return id, xerrors.Errorf("error creating category: %w", err)
+	}
+	if parentID != "" {
+		if err := validateID(parentID); err != nil {
+			return id, xerrors.Errorf("error creating category: %w", err)
+		}
+	}
+	if name == "" {
+		return id, newError(ErrValidation, nil, "error creating category: invalid input")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.categories[id]; ok {
+		return id, newError(ErrConflict, nil, "error creating category: resource already exists")
+	}
+	if _, ok := m.categories[parentID]; parentID != "" && !ok {
+		return id, newError(ErrForeignKey, nil, "error creating category: referenced resource does not exist")
+	}
+	for _, category := range m.categories {
+		if category.ParentID == parentID && category.Name == name {
+			return id, newError(ErrConflict, nil, "error creating category: category name already exists")
+		}
+	}
+
+	m.categories[id] = &models.Category{ID: id, Name: name, ParentID: parentID}
+	return id, nil
+}
+
+func (m *memoryStore) DeleteCategory(id string) (string, error) {
+	if err := validateID(id); err != nil {
+		return id, xerrors.Errorf("error deleting category: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.categories[id]; !ok {
+		return id, notFound("category doesn't exist for ID: %s", id)
+	}
+	for _, category := range m.categories {
+		if category.ParentID == id {
+			return id, newError(ErrConflict, nil, "error deleting category: delete its subcategories first")
+		}
+	}
+
+	delete(m.categories, id)
+	for _, categories := range m.postCategories {
+		delete(categories, id)
+	}
+	return id, nil
+}
+
+func (m *memoryStore) GetPostCategories(postID string) ([]*models.Category, error) {
+	if err := validateID(postID); err != nil {
+		return nil, xerrors.Errorf("failed to fetch categories for post: %w", err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var categories []*models.Category
+	for id := range m.postCategories[postID] {
+		copied := *m.categories[id]
+		categories = append(categories, &copied)
+	}
+	sortCategories(categories)
+	return categories, nil
+}
+
+func (m *memoryStore) CategorizePost(postID, categoryID string) error {
+	if err := validateID(postID); err != nil {
+		return xerrors.Errorf("error adding post to category: %w", err)
+	}
+	if err := validateID(categoryID); err != nil {
+		return xerrors.Errorf("error adding post to category: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.livePost(postID); !ok {
+		return notFound("post doesn't exist for ID: %s", postID)
+	}
+	if _, ok := m.categories[categoryID]; !ok {
+		return newError(ErrForeignKey, nil, "error adding post to category: referenced resource does not exist")
+	}
+	if m.postCategories[postID] == nil {
+		m.postCategories[postID] = make(map[string]bool)
+	}
+	m.postCategories[postID][categoryID] = true
+	return nil
+}
+
+func (m *memoryStore) UncategorizePost(postID, categoryID string) error {
+	if err := validateID(postID); err != nil {
+		return xerrors.Errorf("error removing post from category: %w", err)
+	}
+	if err := validateID(categoryID); err != nil {
+		return xerrors.Errorf("error removing post from category: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.livePost(postID); !ok {
+		return notFound("post doesn't exist for ID: %s", postID)
+	}
+	delete(m.postCategories[postID], categoryID)
+	return nil
+}
+
+// The memory store's search matches words as they are written, ignoring case:
+// unlike Postgres it knows no language, so it doesn't stem words or drop
+// stop words. Titles weigh more than content, as in the Postgres store.
+const (
+	titleWeight   = 1.0
+	contentWeight = 0.4
+
+	snippetWords = 35
+)
+
+// searchText is a field of a post split into words
+type searchText struct {
+	text  string
+	spans [][2]int
+	words []string // lowercase
+}
+
+func newSearchText(text string) searchText {
+	t := searchText{text: text, spans: wordSpans(text)}
+	for _, span := range t.spans {
+		t.words = append(t.words, strings.ToLower(text[span[0]:span[1]]))
+	}
+	return t
+}
+
+// matches returns the index of the first word of each match of the term
+func (t searchText) matches(term searchTerm) []int {
+	var starts []int
+	n := len(term.words)
+	for i := 0; i+n <= len(t.words); i++ {
+		matched := true
+		for j, word := range term.words {
+			if j == n-1 && term.prefix {
+				matched = strings.HasPrefix(t.words[i+j], word)
+			} else {
+				matched = t.words[i+j] == word
+			}
+			if !matched {
+				break
+			}
+		}
+		if matched {
+			starts = append(starts, i)
+		}
+	}
+	return starts
+}
+
+// snippet returns about snippetWords words around the first match, with the
+// matching words marked
+func (t searchText) snippet(terms []searchTerm) string {
+	marked := make([]bool, len(t.words))
+	first := -1
+	for _, term := range terms {
+		if term.exclude {
+			continue
+		}
+		for _, start := range t.matches(term) {
+			for i := start; i < start+len(term.words); i++ {
+				marked[i] = true
+			}
+			if first < 0 || start < first {
+				first = start
+			}
+		}
+	}
+	if len(t.words) == 0 {
+		return ""
+	}
+
+	from := first - snippetWords/3
+	if from < 0 {
+		from = 0
+	}
+	to := from + snippetWords
+	if to > len(t.words) {
+		to = len(t.words)
+	}
+
+	var b strings.Builder
+	pos := t.spans[from][0]
+	for i := from; i < to; i++ {
+		span := t.spans[i]
+		b.WriteString(unmarked.Replace(t.text[pos:span[0]]))
+		if marked[i] {
+			b.WriteString(startMark + unmarked.Replace(t.text[span[0]:span[1]]) + stopMark)
+		} else {
+			b.WriteString(unmarked.Replace(t.text[span[0]:span[1]]))
+		}
+		pos = span[1]
+	}
+	return b.String()
+}
+
+func (m *memoryStore) SearchPosts(query SearchQuery) (*SearchPage, error) {
+	terms, err := parseSearch(query.Text)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to search posts: %w", err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// results are ordered like the Postgres store's keyset: by rank, then ID,
+	// both descending
+	less := func(a, b SearchKey) bool {
+		if a.Rank != b.Rank {
+			return a.Rank > b.Rank
+		}
+		return a.ID > b.ID
+	}
+
+	var results []*models.SearchResult
+	for _, post := range m.posts {
+		if post.Status != models.PostPublished || post.DeletedAt != nil {
+			continue
+		}
+		title, content := newSearchText(post.Title), newSearchText(post.Content)
+
+		rank, matched := 0.0, true
+		for _, term := range terms {
+			titleHits, contentHits := len(title.matches(term)), len(content.matches(term))
+			found := titleHits+contentHits > 0
+			if found == term.exclude {
+				matched = false
+				break
+			}
+			rank += titleWeight*float64(titleHits) + contentWeight*float64(contentHits)
+		}
+		if !matched {
+			continue
+		}
+		if query.After != nil && !less(*query.After, SearchKey{Rank: rank, ID: post.ID}) {
+			continue
+		}
+
+		results = append(results, &models.SearchResult{
+			Post:    m.withTags(post),
+			Rank:    rank,
+			Snippet: highlight(content.snippet(terms)),
+		})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return less(SearchKey{results[i].Rank, results[i].Post.ID}, SearchKey{results[j].Rank, results[j].Post.ID})
+	})
+
+	page := &SearchPage{Results: results}
+	if query.Limit > 0 && len(results) > query.Limit {
+		page.Results = results[:query.Limit]
+		page.More = true
+	}
+	return page, nil
+}
+
+// SetSearchLanguage only checks the language, since the memory store's search
+// doesn't depend on one
+func (m *memoryStore) SetSearchLanguage(language string) error {
+	if language == "" {
+		return newError(ErrValidation, nil, "error setting search language: invalid input")
+	}
+	return nil
+}
+
+// liveComment returns the comment unless it doesn't exist, or it or its post
+// is deleted. Callers must hold the lock.
+func (m *memoryStore) liveComment(id string) (*models.Comment, bool) {
+	comment, ok := m.comments[id]
+	if !ok || comment.DeletedAt != nil {
+		return nil, false
+	}
+	if _, ok := m.livePost(comment.PostID); !ok {
+		return nil, false
+	}
+	return comment, true
+}
+
+// withReplies copies the comment with its approved reply count and author
+// filled in. Callers must hold the lock.
+func (m *memoryStore) withReplies(comment *models.Comment) *models.Comment {
+	copied := *comment
+	for _, reply := range m.comments {
+		if reply.ParentID == comment.ID && reply.DeletedAt == nil && reply.Status == models.CommentApproved {
+			copied.Replies++
+		}
+	}
+	copied.Author = &models.PostAuthor{ID: comment.UserID}
+	if user, ok := m.users[comment.UserID]; ok {
+		copied.Author.Name = user.Name
+	}
+	return &copied
+}
+
+// deleteComment moves the comment and its replies that aren't deleted yet to
+// the trash. Callers must hold the lock.
+func (m *memoryStore) deleteComment(id string, at time.Time) {
+	deletedAt := at
+	m.comments[id].DeletedAt = &deletedAt
+	for replyID, reply := range m.comments {
+		if reply.ParentID == id && reply.DeletedAt == nil {
+			m.deleteComment(replyID, at)
+		}
+	}
+}
+
+// restoreComment undoes deleteComment for the comments deleted at the same
+// time. Callers must hold the lock.
+func (m *memoryStore) restoreComment(id string, at time.Time) {
+	m.comments[id].DeletedAt = nil
+	for replyID, reply := range m.comments {
+		if reply.ParentID == id && reply.DeletedAt != nil && reply.DeletedAt.Equal(at) {
+			m.restoreComment(replyID, at)
+		}
+	}
+}
+
+// commentLess orders comments like the Postgres store: by creation time and
+// then by ID
+func commentLess(order CommentSort) func(a, b CommentKey) bool {
+	if order == CommentSortNewest {
+		return func(a, b CommentKey) bool {
+			if !a.CreatedAt.Equal(b.CreatedAt) {
+				return a.CreatedAt.After(b.CreatedAt)
+			}
+			return a.ID > b.ID
+		}
+	}
+	return func(a, b CommentKey) bool {
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	}
+}
+
+func (m *memoryStore) GetComments(postID string, opts CommentListOptions) (*CommentPage, error) {
+	if err := validateCommentListOptions(&opts); err != nil {
+		return nil, xerrors.Errorf("failed to fetch comments: %w", err)
+	}
+	if err := validateID(postID); err != nil {
+		return nil, xerrors.Errorf("failed to fetch comments: %w", err)
+	}
+	if opts.ParentID != "" {
+		if err := validateID(opts.ParentID); err != nil {
+			return nil, xerrors.Errorf("failed to fetch comments: %w", err)
+		}
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.listComments(opts, func(comment *models.Comment) bool {
+		return comment.PostID == postID && comment.ParentID == opts.ParentID
+	}), nil
+}
+
+func (m *memoryStore) GetCommentQueue(postAuthorID string, opts CommentListOptions) (*CommentPage, error) {
+	if err := validateCommentListOptions(&opts); err != nil {
+		return nil, xerrors.Errorf("failed to fetch comments: %w", err)
+	}
+	if postAuthorID != "" {
+		if err := validateID(postAuthorID); err != nil {
+			return nil, xerrors.Errorf("failed to fetch comments: %w", err)
+		}
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.listComments(opts, func(comment *models.Comment) bool {
+		return postAuthorID == "" || m.posts[comment.PostID].UserID == postAuthorID
+	}), nil
+}
+
+// listComments pages through the live comments that match and are in one of
+// opts.Statuses. Callers must hold the lock.
+func (m *memoryStore) listComments(opts CommentListOptions, match func(*models.Comment) bool) *CommentPage {
+	statuses := make(map[models.CommentStatus]bool)
+	for _, status := range opts.Statuses {
+		statuses[status] = true
+	}
+
+	// collect the matching comments beyond the key, in the direction of
+	// paging
+	less := commentLess(opts.Sort)
+	var comments []*models.Comment
+	for id, comment := range m.comments {
+		if _, ok := m.liveComment(id); !ok || !match(comment) {
+			continue
+		}
+		if len(statuses) > 0 && !statuses[comment.Status] {
+			continue
+		}
+		key := CommentKeyOf(comment)
+		if (opts.After != nil && !less(*opts.After, key)) || (opts.Before != nil && !less(key, *opts.Before)) {
+			continue
+		}
+		comments = append(comments, m.withReplies(comment))
+	}
+	sort.Slice(comments, func(i, j int) bool {
+		if opts.Before != nil {
+			return less(CommentKeyOf(comments[j]), CommentKeyOf(comments[i]))
+		}
+		return less(CommentKeyOf(comments[i]), CommentKeyOf(comments[j]))
+	})
+	if opts.Limit > 0 && len(comments) > opts.Limit+1 {
+		comments = comments[:opts.Limit+1]
+	}
+
+	return newCommentPage(comments, opts)
+}
+
+func (m *memoryStore) GetComment(id string) (*models.Comment, error) {
+	if err := validateID(id); err != nil {
+		return nil, xerrors.Errorf("error finding comment in db: %w", err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	comment, ok := m.liveComment(id)
+	if !ok {
+		return nil, nil
+	}
+	return m.withReplies(comment), nil
+}
+
+func (m *memoryStore) HasApprovedComment(userID string) (bool, error) {
+	if err := validateID(userID); err != nil {
+		return false, xerrors.Errorf("error finding comments in db: %w", err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for id, comment := range m.comments {
+		if _, ok := m.liveComment(id); ok && comment.UserID == userID && comment.Status == models.CommentApproved {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *memoryStore) CreateComment(postID, userID, parentID, content string, status models.CommentStatus) (string, error) {
+	const msg = "error creating new comment"
+	id := m.idManager.UUID()
+	for _, checkID := range []string{id, postID, userID} {
+		if err := validateID(checkID); err != nil {
+			return id, xerrors.Errorf("%s: %w", msg, err)
+		}
+	}
+	if parentID != "" {
+		if err := validateID(parentID); err != nil {
+			return id, xerrors.Errorf("%s: %w", msg, err)
+		}
+	}
+	if content == "" {
+		return id, newError(ErrValidation, nil, "%s: content is empty", msg)
+	}
+	if err := validateCommentStatus(status); err != nil {
+		return id, xerrors.Errorf("%s: %w", msg, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	post, ok := m.livePost(postID)
+	if !ok {
+		return id, newError(ErrForeignKey, nil, "%s: referenced resource does not exist", msg)
+	}
+	if post.Status != models.PostPublished {
+		return id, newError(ErrConflict, nil, "%s: only published posts can be commented on", msg)
+	}
+	if parentID != "" {
+		parent, ok := m.comments[parentID]
+		if !ok || parent.DeletedAt != nil {
+			return id, newError(ErrForeignKey, nil, "%s: referenced resource does not exist", msg)
+		}
+		if parent.PostID != postID {
+			return id, newError(ErrValidation, nil, "%s: the parent comment is on another post", msg)
+		}
+		if parent.Status != models.CommentApproved {
+			return id, newError(ErrConflict, nil, "%s: only approved comments can be replied to", msg)
+		}
+	}
+	if _, ok := m.liveUser(userID); !ok {
+		return id, newError(ErrForeignKey, nil, "%s: referenced resource does not exist", msg)
+	}
+	if _, ok := m.comments[id]; ok {
+		return id, newError(ErrConflict, nil, "%s: resource already exists", msg)
+	}
+
+	createdAt := now()
+	m.comments[id] = &models.Comment{
+		ID:        id,
+		PostID:    postID,
+		UserID:    userID,
+		ParentID:  parentID,
+		Content:   content,
+		Status:    status,
+		CreatedAt: createdAt,
+		UpdatedAt: createdAt,
+	}
+	return id, nil
+}
+
+func (m *memoryStore) UpdateComment(id, content string, status models.CommentStatus) (string, error) {
+	if err := validateID(id); err != nil {
+		return id, xerrors.Errorf("error while updating comment: %w", err)
+	}
+	if content == "" {
+		return id, newError(ErrValidation, nil, "error while updating comment: content is empty")
+	}
+	if status != "" {
+		if err := validateCommentStatus(status); err != nil {
+			return id, xerrors.Errorf("error while updating comment: %w", err)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	comment, ok := m.liveComment(id)
+	if !ok {
+		return id, notFound("comment doesn't exist for ID: %s", id)
+	}
+	comment.Content = content
+	if status != "" {
+		comment.Status = status
+	}
+	comment.UpdatedAt = now()
+	return id, nil
+}
+
+func (m *memoryStore) DeleteComment(id string) (string, error) {
+	if err := validateID(id); err != nil {
+		return id, xerrors.Errorf("error deleting comment: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.liveComment(id); !ok {
+		return id, notFound("cannot delete comment that doesn't exist")
+	}
+	m.deleteComment(id, now())
+	return id, nil
+}
+
+func (m *memoryStore) ModerateComments(approve, reject []string) error {
+	const msg = "error moderating comments"
+	rejected := make(map[string]bool)
+	for _, id := range reject {
+		if err := validateID(id); err != nil {
+			return xerrors.Errorf("%s: %w", msg, err)
+		}
+		rejected[id] = true
+	}
+	for _, id := range approve {
+		if err := validateID(id); err != nil {
+			return xerrors.Errorf("%s: %w", msg, err)
+		}
+		if rejected[id] {
+			return newError(ErrValidation, nil, "%s: comment %s is both approved and rejected", msg, id)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, ids := range [][]string{approve, reject} {
+		for _, id := range ids {
+			if _, ok := m.liveComment(id); !ok {
+				return notFound("comment doesn't exist for ID: %s", id)
+			}
+		}
+	}
+
+	for _, id := range approve {
+		m.comments[id].Status = models.CommentApproved
+	}
+	deletedAt := now()
+	for _, id := range reject {
+		// a rejected reply may have gone already with its parent
+		if m.comments[id].DeletedAt == nil {
+			m.deleteComment(id, deletedAt)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+package migrations
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+var (
+	ErrDatabaseAhead    = fmt.Errorf("database schema is newer than this binary")
+	ErrChecksumMismatch = fmt.Errorf("applied migration does not match its definition")
+)
+
+// arbitrary key used with pg_advisory_xact_lock so that only one process
+// migrates the schema at a time
+const lockKey = 8010
+
+const schemaMigrationsTableCreationQuery = `CREATE TABLE IF NOT EXISTS schema_migrations
+(
+	version integer NOT NULL,
+	name varchar NOT NULL,
+	checksum varchar NOT NULL,
+	applied_at timestamptz NOT NULL DEFAULT now(),
+
+	PRIMARY KEY (version)
+)
+`
+
+// Migration is a single, ordered change to the schema. Down must undo
+// everything that Up does.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Checksum identifies the contents of a migration, so that editing a
+// migration after it has been applied can be detected.
+func (m Migration) Checksum() string {
+	sum := sha256.Sum256([]byte(m.Up + "\x00" + m.Down))
+	return hex.EncodeToString(sum[:])
+}
+
+// Status describes a migration that is either known to the binary, recorded
+// in the database, or both.
+type Status struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	Known     bool // false if the migration only exists in the database
+	Modified  bool // true if the applied checksum differs from the definition
+}
+
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+func NewMigrator(db *sql.DB, migrations []Migration) (*Migrator, error) {
+	if err := Validate(migrations); err != nil {
+		return nil, err
+	}
+
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+
+	return &Migrator{
+		db:         db,
+		migrations: sorted,
+	}, nil
+}
+
+// Validate checks that every migration has a positive, unique version and a
+// non-empty up and down step.
+func Validate(migrations []Migration) error {
+	seen := make(map[int]bool, len(migrations))
+	for _, m := range migrations {
+		if m.Version <= 0 {
+			return fmt.Errorf("migration %q has invalid version %d", m.Name, m.Version)
+		}
+		if seen[m.Version] {
+			return fmt.Errorf("duplicate migration version %d", m.Version)
+		}
+		if m.Up == "" || m.Down == "" {
+			return fmt.Errorf("migration %d (%s) must define both up and down", m.Version, m.Name)
+		}
+		seen[m.Version] = true
+	}
+	return nil
+}
+
+type appliedMigration struct {
+	version   int
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+func (m *Migrator) ensureTable() error {
+	if _, err := m.db.Exec(schemaMigrationsTableCreationQuery); err != nil {
+		return xerrors.Errorf("error creating schema_migrations table: %w", err)
+	}
+	return nil
+}
+
+func (m *Migrator) applied() (map[int]appliedMigration, error) {
+	rows, err := m.db.Query("SELECT version, name, checksum, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, xerrors.Errorf("failed to fetch applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]appliedMigration)
+	for rows.Next() {
+		var a appliedMigration
+		if err := rows.Scan(&a.version, &a.name, &a.checksum, &a.appliedAt); err != nil {
+			return nil, xerrors.Errorf("error parsing DB response: %w", err)
+		}
+		applied[a.version] = a
+	}
+	if err := rows.Err(); err != nil {
+		return nil, xerrors.Errorf("error reading applied migrations: %w", err)
+	}
+
+	return applied, nil
+}
+
+// Status reports every known and applied migration, ordered by version.
+func (m *Migrator) Status() ([]Status, error) {
+	if err := m.ensureTable(); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []Status
+	for _, mig := range m.migrations {
+		s := Status{Version: mig.Version, Name: mig.Name, Known: true}
+		if a, ok := applied[mig.Version]; ok {
+			s.Applied = true
+			s.AppliedAt = a.appliedAt
+			s.Modified = a.checksum != mig.Checksum()
+			delete(applied, mig.Version)
+		}
+		statuses = append(statuses, s)
+	}
+
+	// anything left over was applied by a newer binary
+	for _, a := range applied {
+		statuses = append(statuses, Status{
+			Version:   a.version,
+			Name:      a.name,
+			Applied:   true,
+			AppliedAt: a.appliedAt,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+
+	return statuses, nil
+}
+
+// Check returns ErrDatabaseAhead if the database has migrations applied that
+// this binary doesn't know about, and ErrChecksumMismatch if an applied
+// migration has been edited since it ran.
+func (m *Migrator) Check() error {
+	statuses, err := m.Status()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range statuses {
+		if !s.Known {
+			return xerrors.Errorf("migration %d (%s): %w", s.Version, s.Name, ErrDatabaseAhead)
+		}
+		if s.Modified {
+			return xerrors.Errorf("migration %d (%s): %w", s.Version, s.Name, ErrChecksumMismatch)
+		}
+	}
+	return nil
+}
+
+// Up applies every pending migration in order and returns the ones applied.
+func (m *Migrator) Up() ([]Migration, error) {
+	if err := m.Check(); err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, mig := range m.migrations {
+		ran, err := m.apply(mig, true)
+		if err != nil {
+			return done, err
+		}
+		if ran {
+			done = append(done, mig)
+		}
+	}
+	return done, nil
+}
+
+// Down reverts the latest `steps` applied migrations, newest first, and
+// returns the ones reverted.
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	if err := m.Check(); err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		mig := m.migrations[i]
+		ran, err := m.apply(mig, false)
+		if err != nil {
+			return done, err
+		}
+		if ran {
+			done = append(done, mig)
+		}
+	}
+	return done, nil
+}
+
+// apply runs a single migration in its own transaction. It returns false if
+// there was nothing to do, e.g. another process already applied it.
+func (m *Migrator) apply(mig Migration, up bool) (bool, error) {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return false, xerrors.Errorf("error starting migration %d: %w", mig.Version, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", lockKey); err != nil {
+		return false, xerrors.Errorf("error locking schema_migrations: %w", err)
+	}
+
+	var applied bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)",
+		mig.Version).Scan(&applied)
+	if err != nil {
+		return false, xerrors.Errorf("error checking migration %d: %w", mig.Version, err)
+	}
+	if applied == up {
+		return false, nil
+	}
+
+	if up {
+		if _, err := tx.Exec(mig.Up); err != nil {
+			return false, xerrors.Errorf("error applying migration %d (%s): %w", mig.Version, mig.Name, err)
+		}
+		_, err = tx.Exec("INSERT INTO schema_migrations(version, name, checksum) VALUES($1, $2, $3)",
+			mig.Version, mig.Name, mig.Checksum())
+	} else {
+		if _, err := tx.Exec(mig.Down); err != nil {
+			return false, xerrors.Errorf("error reverting migration %d (%s): %w", mig.Version, mig.Name, err)
+		}
+		_, err = tx.Exec("DELETE FROM schema_migrations WHERE version = $1", mig.Version)
+	}
+	if err != nil {
+		return false, xerrors.Errorf("error recording migration %d: %w", mig.Version, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, xerrors.Errorf("error committing migration %d: %w", mig.Version, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,48 @@
+package migrations
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllMigrationsAreValid(t *testing.T) {
+	require.NoError(t, Validate(All))
+
+	// versions must be released in order so that `migrate down` reverts the newest first
+	for i := 1; i < len(All); i++ {
+		require.Greater(t, All[i].Version, All[i-1].Version)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	ok := Migration{Version: 1, Name: "one", Up: "SELECT 1", Down: "SELECT 1"}
+
+	require.NoError(t, Validate([]Migration{ok}))
+	require.Error(t, Validate([]Migration{ok, ok}))
+	require.Error(t, Validate([]Migration{{Version: 0, Name: "zero", Up: "SELECT 1", Down: "SELECT 1"}}))
+	require.Error(t, Validate([]Migration{{Version: 2, Name: "no down", Up: "SELECT 1"}}))
+}
+
+func TestChecksum(t *testing.T) {
+	m := Migration{Version: 1, Name: "one", Up: "SELECT 1", Down: "SELECT 2"}
+	require.Equal(t, m.Checksum(), m.Checksum())
+
+	edited := m
+	edited.Up = "SELECT 3"
+	require.NotEqual(t, m.Checksum(), edited.Checksum())
+
+	edited = m
+	edited.Down = "SELECT 3"
+	require.NotEqual(t, m.Checksum(), edited.Checksum())
+}
+
+func TestNewMigratorSortsByVersion(t *testing.T) {
+	m, err := NewMigrator(nil, []Migration{
+		{Version: 2, Name: "two", Up: "SELECT 2", Down: "SELECT 2"},
+		{Version: 1, Name: "one", Up: "SELECT 1", Down: "SELECT 1"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, m.migrations[0].Version)
+	require.Equal(t, 2, m.migrations[1].Version)
+}
@@ -0,0 +1,497 @@
+package migrations
+
+// All is the full, ordered history of the blog schema. Never edit a migration
+// once it has been released - add a new one instead.
+//
+// The first two migrations use IF NOT EXISTS so that databases created before
+// migrations existed are adopted without changes.
+var All = []Migration{
+	{
+		Version: 1,
+		Name:    "create_users",
+		Up: `CREATE TABLE IF NOT EXISTS users
+		(
+			id UUID NOT NULL,
+			name varchar,
+			email varchar,
+
+			PRIMARY KEY (id),
+			UNIQUE (email)
+		);
+		`,
+		Down: `DROP TABLE users;`,
+	},
+	{
+		Version: 2,
+		Name:    "create_posts",
+		Up: `CREATE TABLE IF NOT EXISTS posts
+		(
+			id UUID NOT NULL,
+			user_id UUID NOT NULL,
+			title varchar NOT NULL,
+			content TEXT,
+
+			PRIMARY KEY (id),
+			FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_user_id ON posts(user_id);
+		`,
+		Down: `DROP TABLE posts;`,
+	},
+	{
+		Version: 3,
+		Name:    "add_passwords_and_sessions",
+		Up: `ALTER TABLE users ADD COLUMN password_hash varchar;
+
+		CREATE TABLE sessions
+		(
+			id varchar(64) NOT NULL,
+			user_id UUID NOT NULL,
+			created_at timestamptz NOT NULL,
+			expires_at timestamptz NOT NULL,
+
+			PRIMARY KEY (id),
+			FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE
+		);
+
+		CREATE INDEX idx_sessions_user_id ON sessions(user_id);
+		`,
+		Down: `DROP TABLE sessions;
+
+		ALTER TABLE users DROP COLUMN password_hash;
+		`,
+	},
+	{
+		Version: 4,
+		Name:    "create_refresh_tokens",
+		Up: `CREATE TABLE refresh_tokens
+		(
+			id varchar(64) NOT NULL,
+			user_id UUID NOT NULL,
+			created_at timestamptz NOT NULL,
+			expires_at timestamptz NOT NULL,
+			revoked_at timestamptz,
+
+			PRIMARY KEY (id),
+			FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE
+		);
+
+		CREATE INDEX idx_refresh_tokens_user_id ON refresh_tokens(user_id);
+		`,
+		Down: `DROP TABLE refresh_tokens;`,
+	},
+	{
+		Version: 5,
+		Name:    "add_users_is_admin",
+		Up:      `ALTER TABLE users ADD COLUMN is_admin boolean NOT NULL DEFAULT false;`,
+		Down:    `ALTER TABLE users DROP COLUMN is_admin;`,
+	},
+	{
+		Version: 6,
+		Name:    "create_roles",
+		Up: `CREATE TABLE roles
+		(
+			name varchar NOT NULL,
+			description varchar NOT NULL,
+
+			PRIMARY KEY (name)
+		);
+
+		CREATE TABLE role_permissions
+		(
+			role varchar NOT NULL,
+			permission varchar NOT NULL,
+
+			PRIMARY KEY (role, permission),
+			FOREIGN KEY (role) REFERENCES roles (name) ON DELETE CASCADE ON UPDATE CASCADE
+		);
+
+		CREATE TABLE user_roles
+		(
+			user_id UUID NOT NULL,
+			role varchar NOT NULL,
+
+			PRIMARY KEY (user_id, role),
+			FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE,
+			FOREIGN KEY (role) REFERENCES roles (name) ON DELETE CASCADE ON UPDATE CASCADE
+		);
+
+		CREATE INDEX idx_user_roles_role ON user_roles(role);
+
+		INSERT INTO roles(name, description) VALUES
+			('admin', 'manages users and roles, and can do anything'),
+			('editor', 'edits and deletes any post'),
+			('author', 'writes their own posts'),
+			('reader', 'reads, and manages their own account');
+
+		INSERT INTO role_permissions(role, permission)
+		SELECT r.role, p.permission FROM (VALUES
+			('reader', 'user.update.own'),
+			('reader', 'user.delete.own'),
+			('reader', 'role.read.own'),
+			('author', 'post.create.own'),
+			('author', 'post.update.own'),
+			('author', 'post.delete.own'),
+			('editor', 'post.update.any'),
+			('editor', 'post.delete.any'),
+			('admin', 'post.create.any'),
+			('admin', 'user.update.any'),
+			('admin', 'user.delete.any'),
+			('admin', 'role.read.any'),
+			('admin', 'role.grant.any'),
+			('admin', 'role.revoke.any')
+		) AS p(role, permission)
+		-- each role also has the permissions of the ones below it
+		JOIN (VALUES
+			('reader', 'reader'), ('author', 'reader'), ('editor', 'reader'), ('admin', 'reader'),
+			('author', 'author'), ('editor', 'author'), ('admin', 'author'),
+			('editor', 'editor'), ('admin', 'editor'),
+			('admin', 'admin')
+		) AS r(role, includes) ON r.includes = p.role;
+
+		-- everyone keeps what they could do before roles existed
+		INSERT INTO user_roles(user_id, role) SELECT id, 'author' FROM users;
+		INSERT INTO user_roles(user_id, role) SELECT id, 'admin' FROM users WHERE is_admin;
+
+		ALTER TABLE users DROP COLUMN is_admin;
+		`,
+		Down: `ALTER TABLE users ADD COLUMN is_admin boolean NOT NULL DEFAULT false;
+		UPDATE users SET is_admin = true WHERE id IN (SELECT user_id FROM user_roles WHERE role = 'admin');
+
+		DROP TABLE user_roles;
+		DROP TABLE role_permissions;
+		DROP TABLE roles;
+		`,
+	},
+	{
+		Version: 7,
+		Name:    "add_post_timestamps",
+		// existing posts are stamped with the time of the migration, since
+		// there's no record of when they were written
+		Up: `ALTER TABLE posts
+			ADD COLUMN created_at timestamptz NOT NULL DEFAULT now(),
+			ADD COLUMN updated_at timestamptz NOT NULL DEFAULT now(),
+			ADD COLUMN published_at timestamptz,
+			ADD COLUMN updated_by UUID REFERENCES users (id) ON DELETE SET NULL ON UPDATE CASCADE;
+
+		UPDATE posts SET published_at = created_at;
+
+		CREATE INDEX idx_posts_user_id_created_at ON posts(user_id, created_at DESC, id DESC);
+		`,
+		Down: `DROP INDEX idx_posts_user_id_created_at;
+
+		ALTER TABLE posts
+			DROP COLUMN created_at,
+			DROP COLUMN updated_at,
+			DROP COLUMN published_at,
+			DROP COLUMN updated_by;
+		`,
+	},
+	{
+		Version: 8,
+		Name:    "index_posts_by_title",
+		// titles are sorted by byte value, which the default collation
+		// can't serve
+		Up:   `CREATE INDEX idx_posts_user_id_title ON posts(user_id, title COLLATE "C", id);`,
+		Down: `DROP INDEX idx_posts_user_id_title;`,
+	},
+	{
+		Version: 9,
+		Name:    "index_posts_for_feed",
+		Up: `CREATE INDEX idx_posts_created_at ON posts(created_at DESC, id DESC);
+		CREATE INDEX idx_posts_published_at ON posts(published_at);
+		`,
+		Down: `DROP INDEX idx_posts_created_at;
+		DROP INDEX idx_posts_published_at;
+		`,
+	},
+	{
+		Version: 10,
+		Name:    "add_user_listing",
+		Up: `CREATE INDEX idx_users_name ON users((COALESCE(name, '')) COLLATE "C", id);
+		CREATE INDEX idx_users_email ON users((COALESCE(email, '')) COLLATE "C", id);
+		CREATE INDEX idx_users_lower_name ON users(lower(name) text_pattern_ops);
+		CREATE INDEX idx_users_lower_email ON users(lower(email) text_pattern_ops);
+
+		INSERT INTO role_permissions(role, permission) VALUES ('admin', 'user.list.any');
+		`,
+		Down: `DELETE FROM role_permissions WHERE permission = 'user.list.any';
+
+		DROP INDEX idx_users_name;
+		DROP INDEX idx_users_email;
+		DROP INDEX idx_users_lower_name;
+		DROP INDEX idx_users_lower_email;
+		`,
+	},
+	{
+		Version: 11,
+		Name:    "add_post_status",
+		// existing posts were all published when they were created
+		Up: `ALTER TABLE posts ADD COLUMN status TEXT NOT NULL DEFAULT 'published'
+			CHECK (status IN ('draft', 'scheduled', 'published', 'archived'));
+		ALTER TABLE posts ALTER COLUMN status SET DEFAULT 'draft';
+
+		CREATE INDEX idx_posts_scheduled ON posts(published_at) WHERE status = 'scheduled';
+		`,
+		// drafts have no published_at and scheduled posts one in the future,
+		// so neither shows up in the feed after going down
+		Down: `DROP INDEX idx_posts_scheduled;
+		ALTER TABLE posts DROP COLUMN status;
+		`,
+	},
+	{
+		Version: 12,
+		Name:    "create_post_revisions",
+		// existing posts start their history at how they are now
+		Up: `CREATE TABLE post_revisions
+		(
+			post_id UUID NOT NULL,
+			number INT NOT NULL,
+			title varchar NOT NULL,
+			content TEXT NOT NULL,
+			created_at timestamptz NOT NULL,
+			created_by UUID,
+
+			PRIMARY KEY (post_id, number),
+			FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE ON UPDATE CASCADE,
+			FOREIGN KEY (created_by) REFERENCES users (id) ON DELETE SET NULL
+		);
+
+		INSERT INTO post_revisions(post_id, number, title, content, created_at, created_by)
+			SELECT id, 1, title, COALESCE(content, ''), updated_at, COALESCE(updated_by, user_id) FROM posts;
+		`,
+		Down: `DROP TABLE post_revisions;`,
+	},
+	{
+		Version: 13,
+		Name:    "add_soft_deletes",
+		// a deleted user's email can be taken by a new account, so it is
+		// only unique among users that aren't deleted
+		Up: `ALTER TABLE users ADD COLUMN deleted_at timestamptz;
+		ALTER TABLE posts ADD COLUMN deleted_at timestamptz;
+
+		ALTER TABLE users DROP CONSTRAINT users_email_key;
+		CREATE UNIQUE INDEX idx_users_live_email ON users(email) WHERE deleted_at IS NULL;
+
+		CREATE INDEX idx_users_deleted_at ON users(deleted_at) WHERE deleted_at IS NOT NULL;
+		CREATE INDEX idx_posts_deleted_at ON posts(deleted_at) WHERE deleted_at IS NOT NULL;
+		`,
+		// deleted rows are purged rather than brought back to life
+		Down: `DELETE FROM posts WHERE deleted_at IS NOT NULL;
+		DELETE FROM users WHERE deleted_at IS NOT NULL;
+
+		DROP INDEX idx_users_live_email;
+		ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
+
+		ALTER TABLE users DROP COLUMN deleted_at;
+		ALTER TABLE posts DROP COLUMN deleted_at;
+		`,
+	},
+	{
+		Version: 14,
+		Name:    "create_taxonomy",
+		// category names are unique among their siblings; the top level's
+		// parent is NULL, which a plain UNIQUE constraint wouldn't compare
+		Up: `CREATE TABLE tags
+		(
+			name TEXT PRIMARY KEY CHECK (name <> '')
+		);
+		CREATE TABLE post_tags
+		(
+			post_id UUID NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+			tag TEXT NOT NULL REFERENCES tags (name) ON DELETE CASCADE,
+			PRIMARY KEY (post_id, tag)
+		);
+		CREATE INDEX idx_post_tags_tag ON post_tags(tag, post_id);
+
+		CREATE TABLE categories
+		(
+			id UUID PRIMARY KEY,
+			name TEXT NOT NULL CHECK (name <> ''),
+			parent_id UUID REFERENCES categories (id)
+		);
+		CREATE UNIQUE INDEX idx_categories_sibling_name ON categories((COALESCE(parent_id::text, '')), name);
+		CREATE INDEX idx_categories_parent_id ON categories(parent_id);
+		CREATE TABLE post_categories
+		(
+			post_id UUID NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+			category_id UUID NOT NULL REFERENCES categories (id) ON DELETE CASCADE,
+			PRIMARY KEY (post_id, category_id)
+		);
+		CREATE INDEX idx_post_categories_category_id ON post_categories(category_id, post_id);
+
+		INSERT INTO role_permissions(role, permission) VALUES
+			('admin', 'category.create.any'),
+			('admin', 'category.delete.any');
+		`,
+		Down: `DELETE FROM role_permissions WHERE permission IN ('category.create.any', 'category.delete.any');
+
+		DROP TABLE post_categories;
+		DROP TABLE categories;
+		DROP TABLE post_tags;
+		DROP TABLE tags;
+		`,
+	},
+	{
+		Version: 15,
+		Name:    "add_post_search",
+		// posts are indexed with the language in search_settings, which
+		// SetSearchLanguage changes. Titles weigh more than content.
+		Up: `CREATE TABLE search_settings
+		(
+			id BOOLEAN PRIMARY KEY DEFAULT true CHECK (id),
+			language regconfig NOT NULL
+		);
+		INSERT INTO search_settings(language) VALUES ('english');
+
+		ALTER TABLE posts ADD COLUMN search_vector tsvector;
+
+		CREATE FUNCTION posts_search_vector() RETURNS trigger AS $$
+		BEGIN
+			SELECT setweight(to_tsvector(language, COALESCE(NEW.title, '')), 'A') ||
+				setweight(to_tsvector(language, COALESCE(NEW.content, '')), 'B')
+			INTO NEW.search_vector
+			FROM search_settings;
+			RETURN NEW;
+		END
+		$$ LANGUAGE plpgsql;
+		CREATE TRIGGER posts_search_vector BEFORE INSERT OR UPDATE OF title, content ON posts
+			FOR EACH ROW EXECUTE PROCEDURE posts_search_vector();
+
+		UPDATE posts SET title = title;
+		CREATE INDEX idx_posts_search_vector ON posts USING GIN (search_vector);
+		`,
+		Down: `DROP TRIGGER posts_search_vector ON posts;
+		DROP FUNCTION posts_search_vector();
+		ALTER TABLE posts DROP COLUMN search_vector;
+		DROP TABLE search_settings;
+		`,
+	},
+	{
+		Version: 16,
+		Name:    "create_comments",
+		// every role can comment; editors and admins delete anyone's comments
+		Up: `CREATE TABLE comments
+		(
+			id UUID PRIMARY KEY,
+			post_id UUID NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+			user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			parent_id UUID REFERENCES comments (id) ON DELETE CASCADE,
+			content TEXT NOT NULL CHECK (content <> ''),
+			created_at timestamptz NOT NULL DEFAULT now(),
+			updated_at timestamptz NOT NULL DEFAULT now(),
+			deleted_at timestamptz
+		);
+		CREATE INDEX idx_comments_post_id ON comments(post_id, created_at, id) WHERE parent_id IS NULL;
+		CREATE INDEX idx_comments_parent_id ON comments(parent_id, created_at, id);
+		CREATE INDEX idx_comments_user_id ON comments(user_id);
+		CREATE INDEX idx_comments_deleted_at ON comments(deleted_at) WHERE deleted_at IS NOT NULL;
+
+		INSERT INTO role_permissions(role, permission)
+		SELECT r.role, p.permission
+		FROM (VALUES ('reader'), ('author'), ('editor'), ('admin')) AS r(role),
+			(VALUES ('comment.create.own'), ('comment.update.own'), ('comment.delete.own')) AS p(permission);
+		INSERT INTO role_permissions(role, permission) VALUES
+			('editor', 'comment.delete.any'),
+			('admin', 'comment.delete.any'),
+			('admin', 'comment.update.any');
+		`,
+		Down: `DELETE FROM role_permissions WHERE permission LIKE 'comment.%';
+
+		DROP TABLE comments;
+		`,
+	},
+	{
+		Version: 17,
+		Name:    "add_comment_status",
+		// comments written so far stay visible. Authors moderate the comments
+		// on their own posts, editors and admins everyone's.
+		Up: `ALTER TABLE comments ADD COLUMN status TEXT NOT NULL DEFAULT 'approved'
+			CHECK (status IN ('pending', 'approved', 'spam'));
+		ALTER TABLE comments ALTER COLUMN status DROP DEFAULT;
+		CREATE INDEX idx_comments_queue ON comments(status, created_at, id) WHERE status <> 'approved';
+
+		INSERT INTO role_permissions(role, permission) VALUES
+			('author', 'comment.moderate.own'),
+			('editor', 'comment.moderate.own'),
+			('editor', 'comment.moderate.any'),
+			('admin', 'comment.moderate.own'),
+			('admin', 'comment.moderate.any');
+		`,
+		Down: `DELETE FROM role_permissions WHERE permission LIKE 'comment.moderate.%';
+
+		DROP INDEX idx_comments_queue;
+		ALTER TABLE comments DROP COLUMN status;
+		`,
+	},
+	{
+		Version: 18,
+		Name:    "add_post_format",
+		// posts written so far are plain text. Their HTML is rendered by the
+		// app, which can't happen here, so it stays NULL until RenderPosts.
+		Up: `ALTER TABLE posts ADD COLUMN format TEXT NOT NULL DEFAULT 'plain'
+			CHECK (format IN ('plain', 'markdown', 'html'));
+		ALTER TABLE posts ALTER COLUMN format DROP DEFAULT;
+		ALTER TABLE posts ADD COLUMN content_html TEXT;
+
+		ALTER TABLE post_revisions ADD COLUMN format TEXT NOT NULL DEFAULT 'plain'
+			CHECK (format IN ('plain', 'markdown', 'html'));
+		ALTER TABLE post_revisions ALTER COLUMN format DROP DEFAULT;
+		`,
+		Down: `ALTER TABLE post_revisions DROP COLUMN format;
+		ALTER TABLE posts DROP COLUMN content_html;
+		ALTER TABLE posts DROP COLUMN format;
+		`,
+	},
+	{
+		Version: 19,
+		Name:    "add_post_slug",
+		// slugs are made from titles as db.slugify does. Where titles clash,
+		// the oldest post keeps the plain slug and the rest get the start of
+		// their IDs appended.
+		Up: `ALTER TABLE posts ADD COLUMN slug TEXT;
+		UPDATE posts SET slug = COALESCE(NULLIF(trim(both '-' from
+			left(lower(regexp_replace(title, '[^a-zA-Z0-9]+', '-', 'g')), 80)), ''), 'post');
+		UPDATE posts p SET slug = p.slug || '-' || left(p.id::text, 8)
+		WHERE EXISTS (SELECT 1 FROM posts o
+			WHERE o.slug = p.slug AND (o.created_at, o.id) < (p.created_at, p.id));
+		ALTER TABLE posts ALTER COLUMN slug SET NOT NULL;
+		CREATE UNIQUE INDEX idx_posts_slug ON posts(slug);
+		`,
+		Down: `ALTER TABLE posts DROP COLUMN slug;
+		`,
+	},
+	{
+		Version: 20,
+		Name:    "add_user_create_permission",
+		// users sign themselves up through /auth/register, so only admins may
+		// create accounts for others
+		Up: `INSERT INTO role_permissions(role, permission) VALUES ('admin', 'user.create.any');
+		`,
+		Down: `DELETE FROM role_permissions WHERE permission = 'user.create.any';
+		`,
+	},
+	{
+		Version: 21,
+		Name:    "require_post_content",
+		// content has been nullable since the original schema, though the app
+		// has never written NULL
+		Up: `UPDATE posts SET content = '' WHERE content IS NULL;
+		ALTER TABLE posts ALTER COLUMN content SET NOT NULL;
+		`,
+		Down: `ALTER TABLE posts ALTER COLUMN content DROP NOT NULL;
+		`,
+	},
+	{
+		Version: 22,
+		Name:    "index_feed_by_published_at",
+		// the feed is in order of publication, and only ever has published
+		// posts in it
+		Up: `CREATE INDEX idx_posts_feed ON posts(published_at DESC, id DESC)
+			WHERE status = 'published' AND deleted_at IS NULL;
+		`,
+		Down: `DROP INDEX idx_posts_feed;
+		`,
+	},
+}
@@ -1,14 +1,168 @@
 package models
 
+import "time"
+
 type User struct {
 	ID    string `json:"id"`
 	Name  string `json:"name"`
 	Email string `json:"email"`
+
+	// PasswordHash is empty for users created without a password, who can't log in
+	PasswordHash string `json:"-"`
+
+	// DeletedAt is only set for users in the trash
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// Role is a named set of permissions that can be granted to users, see
+// package authz for how permissions are named
+type Role struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
 }
 
+// PostStatus is where a post is in its lifecycle. Only published posts are
+// shown to everyone; see db.PostStore for the moves between statuses.
+type PostStatus string
+
+const (
+	PostDraft     PostStatus = "draft"
+	PostScheduled PostStatus = "scheduled" // published once PublishedAt comes
+	PostPublished PostStatus = "published"
+	PostArchived  PostStatus = "archived" // no longer in the feed
+)
+
+// ContentFormat is how a post's content is written
+type ContentFormat string
+
+const (
+	FormatPlain    ContentFormat = "plain"    // text, whose paragraphs and line breaks are kept
+	FormatMarkdown ContentFormat = "markdown" // CommonMark, with GitHub's extensions
+	FormatHTML     ContentFormat = "html"
+)
+
 type Post struct {
-	ID      string `json:"id"`
-	UserID  string `json:"user_id"`
-	Title   string `json:"title"`
-	Content string `json:"content"`
+	ID     string `json:"id"`
+	UserID string `json:"user_id"`
+	// Slug names the post in its URL. It is made from the title when the
+	// post is created, and doesn't change with it, so links keep working.
+	Slug  string `json:"slug"`
+	Title string `json:"title"`
+	// Content is the source, written in Format. HTML is it rendered and
+	// sanitized, ready to be shown to readers.
+	Content string        `json:"content"`
+	Format  ContentFormat `json:"format"`
+	HTML    string        `json:"html"`
+	Status  PostStatus    `json:"status"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// PublishedAt is when the post was published, or is due to be if it's
+	// scheduled. It is nil for drafts.
+	PublishedAt *time.Time `json:"published_at"`
+	// UpdatedBy is the user who last edited the post, which isn't always
+	// its author. It is empty until the post is first edited.
+	UpdatedBy string `json:"updated_by,omitempty"`
+	// DeletedAt is only set for posts in the trash
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+
+	// Tags are kept in alphabetical order
+	Tags []string `json:"tags,omitempty"`
+
+	// Author is only filled in by listings that span authors
+	Author *PostAuthor `json:"author,omitempty"`
+}
+
+// TagCount is how many published posts carry a tag, e.g. for a tag cloud
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// SearchResult is a post that matched a search
+type SearchResult struct {
+	Post *Post   `json:"post"`
+	Rank float64 `json:"rank"` // higher is a better match
+	// Snippet is an excerpt of the post's content as HTML: escaped, with the
+	// matching words wrapped in <mark>
+	Snippet string `json:"snippet"`
+}
+
+// Category is a node in the tree of categories. Top-level categories have no
+// parent.
+type Category struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	ParentID string `json:"parent_id,omitempty"`
+}
+
+// PostRevision is a post's title and content as one edit left them.
+// Revisions are numbered from 1, the post as it was created, and never
+// change once written.
+type PostRevision struct {
+	PostID    string        `json:"post_id"`
+	Number    int           `json:"number"`
+	Title     string        `json:"title"`
+	Content   string        `json:"content"`
+	Format    ContentFormat `json:"format"`
+	CreatedAt time.Time     `json:"created_at"`
+	// CreatedBy is the user who made the edit, empty if they have since
+	// been deleted
+	CreatedBy string `json:"created_by,omitempty"`
+}
+
+// CommentStatus is whether a comment has been through moderation. Only
+// approved comments are shown to everyone.
+type CommentStatus string
+
+const (
+	CommentPending  CommentStatus = "pending" // waiting for a moderator
+	CommentApproved CommentStatus = "approved"
+	CommentSpam     CommentStatus = "spam" // held, having been flagged by the spam filter
+)
+
+// Comment is a reader's response to a post, or a reply to another comment on
+// the same post
+type Comment struct {
+	ID       string        `json:"id"`
+	PostID   string        `json:"post_id"`
+	UserID   string        `json:"user_id"`
+	ParentID string        `json:"parent_id,omitempty"` // empty for top-level comments
+	Content  string        `json:"content"`
+	Status   CommentStatus `json:"status"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// DeletedAt is only set for comments in the trash
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+
+	// Replies is how many approved direct replies the comment has
+	Replies int         `json:"replies"`
+	Author  *PostAuthor `json:"author,omitempty"`
+}
+
+// PostAuthor is the user who wrote a post, or a comment
+type PostAuthor struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Session is a logged-in user. ID is derived from the token in the client's
+// cookie, see auth.TokenID.
+type Session struct {
+	ID        string    `json:"-"`
+	UserID    string    `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// RefreshToken can be exchanged once for a new access token and refresh
+// token. Like a session, its ID is derived from the token the client holds.
+type RefreshToken struct {
+	ID        string     `json:"-"`
+	UserID    string     `json:"user_id"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
 }
@@ -0,0 +1,127 @@
+package db
+
+import (
+	"database/sql"
+
+	"github.com/gavinc95/go-blog/db/models"
+	"github.com/gavinc95/go-blog/render"
+	"golang.org/x/xerrors"
+)
+
+// addRevision records the post as it now is as its next revision, returning
+// the revision's number. It must run in the transaction that changed the
+// post, whose row lock keeps concurrent edits from taking the same number.
+func addRevision(tx *sql.Tx, postID, editorID string) (int, error) {
+	var number int
+	err := tx.QueryRow(`INSERT INTO post_revisions(post_id, number, title, content, format, created_at, created_by)
+		SELECT id, COALESCE((SELECT max(number) FROM post_revisions WHERE post_id = $1), 0) + 1,
+			title, content, format, updated_at, $2
+		FROM posts WHERE id = $1
+		RETURNING number`,
+		postID, sql.NullString{String: editorID, Valid: editorID != ""}).Scan(&number)
+	return number, err
+}
+
+const revisionColumns = "post_id, number, title, content, format, created_at, created_by"
+
+func scanRevision(row scanner) (*models.PostRevision, error) {
+	var rev models.PostRevision
+	var createdBy sql.NullString
+	err := row.Scan(&rev.PostID, &rev.Number, &rev.Title, &rev.Content, &rev.Format, &rev.CreatedAt, &createdBy)
+	if err != nil {
+		return nil, err
+	}
+	rev.CreatedAt = rev.CreatedAt.UTC()
+	rev.CreatedBy = createdBy.String
+	return &rev, nil
+}
+
+func (m *store) GetRevisions(postID string) ([]*models.PostRevision, error) {
+	const msg = "failed to fetch post revisions"
+
+	rows, err := m.db.Query(`SELECT `+revisionColumns+` FROM post_revisions
+		WHERE post_id = $1 AND post_id IN (SELECT id FROM posts WHERE deleted_at IS NULL)
+		ORDER BY number`, postID)
+	if err != nil {
+		return nil, translateError(err, msg)
+	}
+	defer rows.Close()
+
+	var revisions []*models.PostRevision
+	for rows.Next() {
+		rev, err := scanRevision(rows)
+		if err != nil {
+			return nil, xerrors.Errorf("error parsing DB response: %w", err)
+		}
+		revisions = append(revisions, rev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, xerrors.Errorf("%s: %w", msg, err)
+	}
+
+	return revisions, nil
+}
+
+func (m *store) GetRevision(postID string, number int) (*models.PostRevision, error) {
+	row := m.db.QueryRow(`SELECT `+revisionColumns+` FROM post_revisions
+		WHERE post_id = $1 AND number = $2 AND post_id IN (SELECT id FROM posts WHERE deleted_at IS NULL)`,
+		postID, number)
+
+	rev, err := scanRevision(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, translateError(err, "error finding post revision in db")
+	}
+	return rev, nil
+}
+
+func (m *store) RestoreRevision(postID string, number int, editorID string) (int, error) {
+	const msg = "error while restoring post revision"
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return 0, xerrors.Errorf("%s: %w", msg, err)
+	}
+	defer tx.Rollback()
+
+	var rev models.PostRevision
+	err = tx.QueryRow(`SELECT r.content, r.format FROM post_revisions r JOIN posts p ON p.id = r.post_id
+		WHERE r.post_id = $1 AND r.number = $2 AND p.deleted_at IS NULL
+		FOR UPDATE OF p`, postID, number).Scan(&rev.Content, &rev.Format)
+	if err == sql.ErrNoRows {
+		return 0, notFound("post %s has no revision %d", postID, number)
+	}
+	if err != nil {
+		return 0, translateError(err, msg)
+	}
+	contentHTML, err := render.HTML(rev.Format, rev.Content)
+	if err != nil {
+		return 0, xerrors.Errorf("%s: %w", msg, err)
+	}
+
+	_, err = tx.Exec(`UPDATE posts SET
+			title = r.title,
+			content = r.content,
+			format = r.format,
+			content_html = $4,
+			updated_at = now(),
+			updated_by = $3
+		FROM post_revisions r
+		WHERE posts.id = $1 AND r.post_id = $1 AND r.number = $2`,
+		postID, number, sql.NullString{String: editorID, Valid: editorID != ""}, contentHTML)
+	if err != nil {
+		return 0, translateError(err, msg)
+	}
+
+	restored, err := addRevision(tx, postID, editorID)
+	if err != nil {
+		return 0, translateError(err, msg)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, xerrors.Errorf("%s: %w", msg, err)
+	}
+	return restored, nil
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"github.com/gavinc95/go-blog/db/models"
+	"golang.org/x/xerrors"
+)
+
+func grantRole(db execer, userID, role string) error {
+	_, err := db.Exec("INSERT INTO user_roles(user_id, role) VALUES($1, $2) ON CONFLICT DO NOTHING", userID, role)
+	return err
+}
+
+func (m *store) GetRoles() ([]*models.Role, error) {
+	rows, err := m.db.Query(`SELECT r.name, r.description, rp.permission FROM roles r
+		LEFT JOIN role_permissions rp ON rp.role = r.name
+		ORDER BY r.name, rp.permission`)
+	if err != nil {
+		return nil, translateError(err, "failed to fetch roles")
+	}
+	defer rows.Close()
+
+	var roles []*models.Role
+	for rows.Next() {
+		var role models.Role
+		var permission *string
+		if err := rows.Scan(&role.Name, &role.Description, &permission); err != nil {
+			return nil, xerrors.Errorf("error parsing DB response: %w", err)
+		}
+		if n := len(roles); n == 0 || roles[n-1].Name != role.Name {
+			roles = append(roles, &role)
+		}
+		if permission != nil {
+			last := roles[len(roles)-1]
+			last.Permissions = append(last.Permissions, *permission)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, xerrors.Errorf("failed to fetch roles: %w", err)
+	}
+
+	return roles, nil
+}
+
+func (m *store) GetUserRoles(userID string) ([]string, error) {
+	return m.queryStrings("failed to fetch roles for user",
+		`SELECT ur.role FROM user_roles ur
+		JOIN users u ON u.id = ur.user_id AND u.deleted_at IS NULL
+		WHERE ur.user_id = $1
+		ORDER BY ur.role`, userID)
+}
+
+func (m *store) GetUserPermissions(userID string) ([]string, error) {
+	return m.queryStrings("failed to fetch permissions for user",
+		`SELECT DISTINCT rp.permission FROM user_roles ur
+		JOIN users u ON u.id = ur.user_id AND u.deleted_at IS NULL
+		JOIN role_permissions rp ON rp.role = ur.role
+		WHERE ur.user_id = $1
+		ORDER BY rp.permission`, userID)
+}
+
+// queryStrings runs a query that returns a single text column
+func (m *store) queryStrings(msg, query string, args ...interface{}) ([]string, error) {
+	rows, err := m.db.Query(query, args...)
+	if err != nil {
+		return nil, translateError(err, msg)
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, xerrors.Errorf("error parsing DB response: %w", err)
+		}
+		values = append(values, value)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, xerrors.Errorf("%s: %w", msg, err)
+	}
+
+	return values, nil
+}
+
+func (m *store) GrantRole(userID, role string) error {
+	if err := grantRole(m.db, userID, role); err != nil {
+		return translateError(err, "error granting role")
+	}
+	return nil
+}
+
+func (m *store) RevokeRole(userID, role string) error {
+	_, err := m.db.Exec("DELETE FROM user_roles WHERE user_id = $1 AND role = $2", userID, role)
+	if err != nil {
+		return translateError(err, "error revoking role")
+	}
+	return nil
+}
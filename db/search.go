@@ -0,0 +1,242 @@
+package db
+
+import (
+	"html"
+	"strings"
+	"unicode"
+
+	"github.com/gavinc95/go-blog/db/models"
+	"golang.org/x/xerrors"
+)
+
+// SearchQuery is a search of published posts
+type SearchQuery struct {
+	// Text is what to search for: words, which must all match, "quoted
+	// phrases", prefixes ending in * (e.g. bak*), and words or phrases
+	// starting with - that must not match
+	Text  string
+	Limit int // 0 for no limit
+	// After is the last result of the previous page
+	After *SearchKey
+}
+
+// SearchKey is the position of a result in the ranking
+type SearchKey struct {
+	Rank float64
+	ID   string
+}
+
+// SearchPage is a page of results, best match first
+type SearchPage struct {
+	Results []*models.SearchResult
+	More    bool // there are results after the page
+}
+
+const maxSearchTerms = 32
+
+// searchTerm is a word or phrase in a search
+type searchTerm struct {
+	words   []string // lowercase, more than one for a phrase
+	prefix  bool     // the last word only has to start the matching word
+	exclude bool
+}
+
+// parseSearch splits a search into terms. Words are runs of letters and
+// digits, so other characters only separate them.
+func parseSearch(text string) ([]searchTerm, error) {
+	var terms []searchTerm
+	rest := strings.TrimSpace(text)
+	for rest != "" {
+		var term searchTerm
+		if strings.HasPrefix(rest, "-") {
+			term.exclude = true
+			rest = rest[1:]
+		}
+
+		var token string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				token, rest = rest[1:], ""
+			} else {
+				token, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			end := strings.IndexFunc(rest, func(r rune) bool { return unicode.IsSpace(r) || r == '"' })
+			if end < 0 {
+				end = len(rest)
+			}
+			token, rest = rest[:end], rest[end:]
+		}
+		rest = strings.TrimSpace(rest)
+
+		term.prefix = strings.HasSuffix(strings.TrimSpace(token), "*")
+		for _, span := range wordSpans(token) {
+			term.words = append(term.words, strings.ToLower(token[span[0]:span[1]]))
+		}
+		if len(term.words) > 0 {
+			terms = append(terms, term)
+		}
+	}
+
+	if len(terms) > maxSearchTerms {
+		return nil, newError(ErrValidation, nil, "a search can have at most %d terms", maxSearchTerms)
+	}
+	for _, term := range terms {
+		if !term.exclude {
+			return terms, nil
+		}
+	}
+	return nil, newError(ErrValidation, nil, "a search needs a word to look for")
+}
+
+// wordSpans returns the start and end of each word in s
+func wordSpans(s string) [][2]int {
+	var spans [][2]int
+	start := -1
+	for i, r := range s {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWord && start < 0 {
+			start = i
+		} else if !isWord && start >= 0 {
+			spans = append(spans, [2]int{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, [2]int{start, len(s)})
+	}
+	return spans
+}
+
+// tsquery writes the terms for to_tsquery, which stems each word with the
+// search language
+func tsquery(terms []searchTerm) string {
+	parts := make([]string, len(terms))
+	for i, term := range terms {
+		// words are only letters and digits, so they need no escaping
+		words := make([]string, len(term.words))
+		for j, word := range term.words {
+			words[j] = "'" + word + "'"
+		}
+		if term.prefix {
+			words[len(words)-1] += ":*"
+		}
+		part := strings.Join(words, " <-> ")
+		if len(words) > 1 {
+			part = "(" + part + ")"
+		}
+		if term.exclude {
+			part = "!" + part
+		}
+		parts[i] = part
+	}
+	return strings.Join(parts, " & ")
+}
+
+// Matches in snippets are marked with characters from Unicode's private use
+// area, which survive HTML escaping, and then replaced with <mark> tags
+const (
+	startMark = "\ue000"
+	stopMark  = "\ue001"
+)
+
+var markReplacer = strings.NewReplacer(startMark, "<mark>", stopMark, "</mark>")
+
+// unmarked removes the marks from text about to be marked, so that posts
+// containing those characters can't unbalance the tags
+var unmarked = strings.NewReplacer(startMark, "", stopMark, "")
+
+// highlight turns a snippet with marked matches into HTML
+func highlight(snippet string) string {
+	return markReplacer.Replace(html.EscapeString(snippet))
+}
+
+// headlineOptions are ts_headline's options for snippets
+const headlineOptions = `StartSel="` + startMark + `", StopSel="` + stopMark + `", MinWords=15, MaxWords=35`
+
+func (m *store) SearchPosts(query SearchQuery) (*SearchPage, error) {
+	const msg = "failed to search posts"
+
+	terms, err := parseSearch(query.Text)
+	if err != nil {
+		return nil, xerrors.Errorf("%s: %w", msg, err)
+	}
+
+	ks := keyset{column: "rank", desc: true, limit: query.Limit}
+	if query.After != nil {
+		ks.key, ks.id = query.After.Rank, query.After.ID
+	}
+	where, suffix, args := ks.apply("true", []interface{}{tsquery(terms), headlineOptions})
+
+	rows, err := m.db.Query(`SELECT `+postColumns+`, rank, ts_headline(language, translate(content, '`+startMark+stopMark+`', ''), query, $2)
+		FROM (
+			SELECT p.*, s.language, q.query, ts_rank(p.search_vector, q.query)::float8 AS rank
+			FROM posts p, search_settings s, to_tsquery(s.language, $1) q(query)
+			WHERE p.search_vector @@ q.query AND p.status = 'published' AND p.deleted_at IS NULL
+		) r
+		WHERE `+where+suffix, args...)
+	if err != nil {
+		return nil, translateError(err, msg)
+	}
+	defer rows.Close()
+
+	page := &SearchPage{}
+	var posts []*models.Post
+	for rows.Next() {
+		var result models.SearchResult
+		post, err := scanPost(rows, &result.Rank, &result.Snippet)
+		if err != nil {
+			return nil, xerrors.Errorf("error parsing DB response: %w", err)
+		}
+
+		result.Post = post
+		result.Snippet = highlight(result.Snippet)
+		page.Results = append(page.Results, &result)
+		posts = append(posts, post)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, xerrors.Errorf("%s: %w", msg, err)
+	}
+
+	if query.Limit > 0 && len(page.Results) > query.Limit {
+		page.Results, posts = page.Results[:query.Limit], posts[:query.Limit]
+		page.More = true
+	}
+	if err := m.fillTags(posts); err != nil {
+		return nil, translateError(err, msg)
+	}
+	return page, nil
+}
+
+func (m *store) SetSearchLanguage(language string) error {
+	const msg = "error setting search language"
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return xerrors.Errorf("%s: %w", msg, err)
+	}
+	defer tx.Rollback()
+
+	var changed bool
+	err = tx.QueryRow(`SELECT language <> $1::regconfig FROM search_settings FOR UPDATE`, language).Scan(&changed)
+	if err != nil {
+		return translateError(err, msg)
+	}
+	if !changed {
+		return nil
+	}
+
+	if _, err := tx.Exec("UPDATE search_settings SET language = $1::regconfig", language); err != nil {
+		return translateError(err, msg)
+	}
+	// the trigger re-indexes each post that is written to
+	if _, err := tx.Exec("UPDATE posts SET title = title"); err != nil {
+		return translateError(err, msg)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return xerrors.Errorf("%s: %w", msg, err)
+	}
+	return nil
+}
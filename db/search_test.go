@@ -0,0 +1,36 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestParseSearch(t *testing.T) {
+	for _, tt := range []struct {
+		text    string
+		tsquery string
+	}{
+		{"cats", "'cats'"},
+		{"  Big   CATS ", "'big' & 'cats'"},
+		{`"big cats" -dogs`, "('big' <-> 'cats') & !'dogs'"},
+		{`bak* -"hot dogs"`, "'bak':* & !('hot' <-> 'dogs')"},
+		{`"big cat*`, "('big' <-> 'cat':*)"},
+		{"e-mail it's", "('e' <-> 'mail') & ('it' <-> 's')"},
+		{"cats & ! 'dogs'", "'cats' & 'dogs'"},
+	} {
+		terms, err := parseSearch(tt.text)
+		require.NoError(t, err, tt.text)
+		require.Equal(t, tt.tsquery, tsquery(terms), tt.text)
+	}
+
+	for _, text := range []string{"", "  ", "!!!", "-dogs", `-"hot dogs"`} {
+		_, err := parseSearch(text)
+		require.True(t, xerrors.Is(err, ErrValidation), "%q: got %v", text, err)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	require.Equal(t, "&lt;b&gt;big&lt;/b&gt; <mark>cats</mark>", highlight("<b>big</b> "+startMark+"cats"+stopMark))
+}
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"database/sql"
+
+	"github.com/gavinc95/go-blog/db/models"
+	"golang.org/x/xerrors"
+)
+
+func (m *store) CreateSession(session *models.Session) error {
+	_, err := m.db.Exec("INSERT INTO sessions(id, user_id, created_at, expires_at) VALUES($1, $2, $3, $4)",
+		session.ID, session.UserID, session.CreatedAt, session.ExpiresAt)
+	if err != nil {
+		return translateError(err, "error creating session")
+	}
+	return nil
+}
+
+func (m *store) GetSession(id string) (*models.Session, error) {
+	row := m.db.QueryRow("SELECT id, user_id, created_at, expires_at FROM sessions WHERE id = $1", id)
+
+	var session models.Session
+	err := row.Scan(&session.ID, &session.UserID, &session.CreatedAt, &session.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, translateError(err, "error finding session")
+	}
+
+	return &session, nil
+}
+
+func (m *store) RotateSession(oldID string, session *models.Session) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return xerrors.Errorf("error rotating session: %w", err)
+	}
+	defer tx.Rollback()
+
+	// whoever deletes the old session first wins, so a session can't be
+	// rotated into two
+	res, err := tx.Exec("DELETE FROM sessions WHERE id = $1", oldID)
+	if err != nil {
+		return translateError(err, "error rotating session")
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return xerrors.Errorf("error rotating session: %w", err)
+	} else if n == 0 {
+		return notFound("session doesn't exist")
+	}
+
+	_, err = tx.Exec("INSERT INTO sessions(id, user_id, created_at, expires_at) VALUES($1, $2, $3, $4)",
+		session.ID, session.UserID, session.CreatedAt, session.ExpiresAt)
+	if err != nil {
+		return translateError(err, "error rotating session")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return xerrors.Errorf("error rotating session: %w", err)
+	}
+	return nil
+}
+
+func (m *store) DeleteSession(id string) error {
+	res, err := m.db.Exec("DELETE FROM sessions WHERE id = $1", id)
+	if err != nil {
+		return xerrors.Errorf("error deleting session: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return xerrors.Errorf("error deleting session: %w", err)
+	} else if n == 0 {
+		return notFound("session doesn't exist")
+	}
+	return nil
+}
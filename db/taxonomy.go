@@ -0,0 +1,259 @@
+package db
+
+import (
+	"database/sql"
+
+	"github.com/gavinc95/go-blog/db/models"
+	"github.com/lib/pq"
+	"golang.org/x/xerrors"
+)
+
+// fillTags sets the tags of each post
+func (m *store) fillTags(posts []*models.Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	byID := make(map[string]*models.Post, len(posts))
+	var ids []string
+	for _, post := range posts {
+		byID[post.ID] = post
+		ids = append(ids, post.ID)
+	}
+
+	rows, err := m.db.Query(`SELECT post_id, tag FROM post_tags WHERE post_id = ANY($1) ORDER BY tag COLLATE "C"`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var postID, tag string
+		if err := rows.Scan(&postID, &tag); err != nil {
+			return err
+		}
+		post := byID[postID]
+		post.Tags = append(post.Tags, tag)
+	}
+	return rows.Err()
+}
+
+func (m *store) GetTagCounts(limit int) ([]*models.TagCount, error) {
+	const msg = "failed to fetch tag counts"
+	if limit < 0 {
+		return nil, newError(ErrValidation, nil, "%s: limit must not be negative", msg)
+	}
+
+	query := `SELECT pt.tag, count(*) FROM post_tags pt
+		JOIN posts p ON p.id = pt.post_id AND p.status = 'published' AND p.deleted_at IS NULL
+		GROUP BY pt.tag
+		ORDER BY count(*) DESC, pt.tag COLLATE "C"`
+	var args []interface{}
+	if limit > 0 {
+		query += " LIMIT $1"
+		args = append(args, limit)
+	}
+	rows, err := m.db.Query(query, args...)
+	if err != nil {
+		return nil, translateError(err, msg)
+	}
+	defer rows.Close()
+
+	var counts []*models.TagCount
+	for rows.Next() {
+		var count models.TagCount
+		if err := rows.Scan(&count.Tag, &count.Count); err != nil {
+			return nil, xerrors.Errorf("error parsing DB response: %w", err)
+		}
+		counts = append(counts, &count)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, xerrors.Errorf("%s: %w", msg, err)
+	}
+	return counts, nil
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// checkPost fails with ErrNotFound unless the post exists and isn't deleted
+func checkPost(db queryRower, postID, msg string) error {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM posts WHERE id = $1 AND deleted_at IS NULL)", postID).Scan(&exists)
+	if err != nil {
+		return translateError(err, msg)
+	}
+	if !exists {
+		return notFound("post doesn't exist for ID: %s", postID)
+	}
+	return nil
+}
+
+func (m *store) TagPost(postID, tag string) error {
+	const msg = "error tagging post"
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return xerrors.Errorf("%s: %w", msg, err)
+	}
+	defer tx.Rollback()
+
+	if err := checkPost(tx, postID, msg); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO tags(name) VALUES($1) ON CONFLICT DO NOTHING", tag); err != nil {
+		return translateError(err, msg)
+	}
+	if _, err := tx.Exec("INSERT INTO post_tags(post_id, tag) VALUES($1, $2) ON CONFLICT DO NOTHING", postID, tag); err != nil {
+		return translateError(err, msg)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return xerrors.Errorf("%s: %w", msg, err)
+	}
+	return nil
+}
+
+func (m *store) UntagPost(postID, tag string) error {
+	const msg = "error untagging post"
+
+	if err := checkPost(m.db, postID, msg); err != nil {
+		return err
+	}
+	if _, err := m.db.Exec("DELETE FROM post_tags WHERE post_id = $1 AND tag = $2", postID, tag); err != nil {
+		return translateError(err, msg)
+	}
+	return nil
+}
+
+const categoryColumns = "id, name, parent_id"
+
+func scanCategory(row scanner) (*models.Category, error) {
+	var category models.Category
+	var parentID sql.NullString
+	if err := row.Scan(&category.ID, &category.Name, &parentID); err != nil {
+		return nil, err
+	}
+	category.ParentID = parentID.String
+	return &category, nil
+}
+
+// queryCategories runs a query for categoryColumns
+func (m *store) queryCategories(msg, query string, args ...interface{}) ([]*models.Category, error) {
+	rows, err := m.db.Query(query, args...)
+	if err != nil {
+		return nil, translateError(err, msg)
+	}
+	defer rows.Close()
+
+	var categories []*models.Category
+	for rows.Next() {
+		category, err := scanCategory(rows)
+		if err != nil {
+			return nil, xerrors.Errorf("error parsing DB response: %w", err)
+		}
+		categories = append(categories, category)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, xerrors.Errorf("%s: %w", msg, err)
+	}
+	return categories, nil
+}
+
+func (m *store) GetCategories() ([]*models.Category, error) {
+	return m.queryCategories("failed to fetch categories",
+		`SELECT `+categoryColumns+` FROM categories ORDER BY name COLLATE "C", id`)
+}
+
+func (m *store) GetCategory(id string) (*models.Category, error) {
+	row := m.db.QueryRow("SELECT "+categoryColumns+" FROM categories WHERE id = $1", id)
+
+	category, err := scanCategory(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, translateError(err, "error finding category in db")
+	}
+	return category, nil
+}
+
+func (m *store) CreateCategory(name, parentID string) (string, error) {
+	id := m.idManager.UUID()
+
+	_, err := m.db.Exec("INSERT INTO categories(id, name, parent_id) VALUES($1, $2, $3)",
+		id, name, sql.NullString{String: parentID, Valid: parentID != ""})
+	if err != nil {
+		return id, translateError(err, "error creating category")
+	}
+	return id, nil
+}
+
+func (m *store) DeleteCategory(id string) (string, error) {
+	const msg = "error deleting category"
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return id, xerrors.Errorf("%s: %w", msg, err)
+	}
+	defer tx.Rollback()
+
+	var children int
+	err = tx.QueryRow(`SELECT (SELECT count(*) FROM categories WHERE parent_id = c.id)
+		FROM categories c WHERE c.id = $1 FOR UPDATE`, id).Scan(&children)
+	if err == sql.ErrNoRows {
+		return id, notFound("category doesn't exist for ID: %s", id)
+	}
+	if err != nil {
+		return id, translateError(err, msg)
+	}
+	if children > 0 {
+		return id, newError(ErrConflict, nil, "%s: delete its subcategories first", msg)
+	}
+
+	// the category's posts keep their other categories, ON DELETE CASCADE
+	if _, err := tx.Exec("DELETE FROM categories WHERE id = $1", id); err != nil {
+		return id, translateError(err, msg)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return id, xerrors.Errorf("%s: %w", msg, err)
+	}
+	return id, nil
+}
+
+func (m *store) GetPostCategories(postID string) ([]*models.Category, error) {
+	return m.queryCategories("failed to fetch categories for post",
+		`SELECT c.id, c.name, c.parent_id FROM categories c
+		JOIN post_categories pc ON pc.category_id = c.id
+		WHERE pc.post_id = $1
+		ORDER BY c.name COLLATE "C", c.id`, postID)
+}
+
+func (m *store) CategorizePost(postID, categoryID string) error {
+	const msg = "error adding post to category"
+
+	if err := checkPost(m.db, postID, msg); err != nil {
+		return err
+	}
+	_, err := m.db.Exec("INSERT INTO post_categories(post_id, category_id) VALUES($1, $2) ON CONFLICT DO NOTHING",
+		postID, categoryID)
+	if err != nil {
+		return translateError(err, msg)
+	}
+	return nil
+}
+
+func (m *store) UncategorizePost(postID, categoryID string) error {
+	const msg = "error removing post from category"
+
+	if err := checkPost(m.db, postID, msg); err != nil {
+		return err
+	}
+	_, err := m.db.Exec("DELETE FROM post_categories WHERE post_id = $1 AND category_id = $2", postID, categoryID)
+	if err != nil {
+		return translateError(err, msg)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/gavinc95/go-blog/db/models"
+	"golang.org/x/xerrors"
+)
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func insertRefreshToken(db execer, token *models.RefreshToken) error {
+	_, err := db.Exec("INSERT INTO refresh_tokens(id, user_id, created_at, expires_at) VALUES($1, $2, $3, $4)",
+		token.ID, token.UserID, token.CreatedAt, token.ExpiresAt)
+	return err
+}
+
+// revokeRefreshToken revokes a token that isn't revoked yet, and reports
+// whether there was one
+func revokeRefreshToken(db execer, id string, at time.Time) (bool, error) {
+	res, err := db.Exec("UPDATE refresh_tokens SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL", at, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (m *store) CreateRefreshToken(token *models.RefreshToken) error {
+	if err := insertRefreshToken(m.db, token); err != nil {
+		return translateError(err, "error creating refresh token")
+	}
+	return nil
+}
+
+func (m *store) GetRefreshToken(id string) (*models.RefreshToken, error) {
+	row := m.db.QueryRow("SELECT id, user_id, created_at, expires_at, revoked_at FROM refresh_tokens WHERE id = $1", id)
+
+	var token models.RefreshToken
+	var revokedAt sql.NullTime
+	err := row.Scan(&token.ID, &token.UserID, &token.CreatedAt, &token.ExpiresAt, &revokedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, translateError(err, "error finding refresh token")
+	}
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+
+	return &token, nil
+}
+
+func (m *store) RotateRefreshToken(oldID string, next *models.RefreshToken, at time.Time) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return xerrors.Errorf("error rotating refresh token: %w", err)
+	}
+	defer tx.Rollback()
+
+	ok, err := revokeRefreshToken(tx, oldID, at)
+	if err != nil {
+		return translateError(err, "error rotating refresh token")
+	}
+	if !ok {
+		return notFound("refresh token doesn't exist or has been revoked")
+	}
+
+	if err := insertRefreshToken(tx, next); err != nil {
+		return translateError(err, "error rotating refresh token")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return xerrors.Errorf("error rotating refresh token: %w", err)
+	}
+	return nil
+}
+
+func (m *store) RevokeRefreshToken(id string, at time.Time) error {
+	ok, err := revokeRefreshToken(m.db, id, at)
+	if err != nil {
+		return translateError(err, "error revoking refresh token")
+	}
+	if !ok {
+		return notFound("refresh token doesn't exist or has been revoked")
+	}
+	return nil
+}
+
+func (m *store) RevokeUserRefreshTokens(userID string, at time.Time) error {
+	_, err := m.db.Exec("UPDATE refresh_tokens SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL", at, userID)
+	if err != nil {
+		return translateError(err, "error revoking refresh tokens")
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/gavinc95/go-blog/db/models"
+	"golang.org/x/xerrors"
+)
+
+func (m *store) GetDeletedUsers() ([]*models.User, error) {
+	const msg = "failed to fetch deleted users"
+
+	rows, err := m.db.Query("SELECT " + userColumns + " FROM users WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC, id")
+	if err != nil {
+		return nil, translateError(err, msg)
+	}
+	defer rows.Close()
+
+	var users []*models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, xerrors.Errorf("error parsing DB response: %w", err)
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, xerrors.Errorf("%s: %w", msg, err)
+	}
+
+	return users, nil
+}
+
+func (m *store) GetDeletedPosts(userID string) ([]*models.Post, error) {
+	const msg = "failed to fetch deleted posts"
+
+	query := "SELECT " + postColumns + " FROM posts WHERE deleted_at IS NOT NULL"
+	var args []interface{}
+	if userID != "" {
+		query += " AND user_id = $1"
+		args = append(args, userID)
+	}
+	rows, err := m.db.Query(query+" ORDER BY deleted_at DESC, id", args...)
+	if err != nil {
+		return nil, translateError(err, msg)
+	}
+	defer rows.Close()
+
+	var posts []*models.Post
+	for rows.Next() {
+		post, err := scanPost(rows)
+		if err != nil {
+			return nil, xerrors.Errorf("error parsing DB response: %w", err)
+		}
+		posts = append(posts, post)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, xerrors.Errorf("%s: %w", msg, err)
+	}
+
+	return posts, nil
+}
+
+func (m *store) GetDeletedPost(postID string) (*models.Post, error) {
+	row := m.db.QueryRow("SELECT "+postColumns+" FROM posts WHERE id = $1 AND deleted_at IS NOT NULL", postID)
+
+	post, err := scanPost(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, translateError(err, "error finding deleted post in db")
+	}
+	return post, nil
+}
+
+func (m *store) RestoreUser(id string) (string, error) {
+	const msg = "error restoring user"
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return id, xerrors.Errorf("%s: %w", msg, err)
+	}
+	defer tx.Rollback()
+
+	var deletedAt time.Time
+	err = tx.QueryRow("SELECT deleted_at FROM users WHERE id = $1 AND deleted_at IS NOT NULL FOR UPDATE", id).Scan(&deletedAt)
+	if err == sql.ErrNoRows {
+		return id, notFound("user %s isn't in the trash", id)
+	}
+	if err != nil {
+		return id, translateError(err, msg)
+	}
+
+	if _, err := tx.Exec("UPDATE users SET deleted_at = NULL WHERE id = $1", id); err != nil {
+		return id, translateError(err, msg)
+	}
+	_, err = tx.Exec("UPDATE posts SET deleted_at = NULL WHERE user_id = $1 AND deleted_at = $2", id, deletedAt)
+	if err != nil {
+		return id, translateError(err, msg)
+	}
+	// replies by others were deleted along with the user's comments
+	_, err = tx.Exec(`WITH RECURSIVE tree AS (
+			SELECT id FROM comments WHERE user_id = $1 AND deleted_at = $2
+			UNION
+			SELECT c.id FROM comments c JOIN tree ON c.parent_id = tree.id WHERE c.deleted_at = $2
+		)
+		UPDATE comments SET deleted_at = NULL WHERE id IN (SELECT id FROM tree)`, id, deletedAt)
+	if err != nil {
+		return id, translateError(err, msg)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return id, xerrors.Errorf("%s: %w", msg, err)
+	}
+	return id, nil
+}
+
+func (m *store) RestorePost(postID string) (string, error) {
+	const msg = "error restoring post"
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return postID, xerrors.Errorf("%s: %w", msg, err)
+	}
+	defer tx.Rollback()
+
+	var authorDeleted bool
+	err = tx.QueryRow(`SELECT u.deleted_at IS NOT NULL FROM posts p
+		JOIN users u ON u.id = p.user_id
+		WHERE p.id = $1 AND p.deleted_at IS NOT NULL
+		FOR UPDATE OF p`, postID).Scan(&authorDeleted)
+	if err == sql.ErrNoRows {
+		return postID, notFound("post %s isn't in the trash", postID)
+	}
+	if err != nil {
+		return postID, translateError(err, msg)
+	}
+	if authorDeleted {
+		return postID, newError(ErrConflict, nil, "%s: restore the post's author first", msg)
+	}
+
+	if _, err := tx.Exec("UPDATE posts SET deleted_at = NULL WHERE id = $1", postID); err != nil {
+		return postID, translateError(err, msg)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return postID, xerrors.Errorf("%s: %w", msg, err)
+	}
+	return postID, nil
+}
+
+// PurgeDeleted deletes posts before users, so that the posts deleted along
+// with a user are counted, rather than going with them by ON DELETE CASCADE
+func (m *store) PurgeDeleted(cutoff time.Time) (int, int, error) {
+	const msg = "error purging deleted users and posts"
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return 0, 0, xerrors.Errorf("%s: %w", msg, err)
+	}
+	defer tx.Rollback()
+
+	// comments aren't counted, and go with their posts and users anyway
+	if _, err := tx.Exec("DELETE FROM comments WHERE deleted_at < $1", cutoff); err != nil {
+		return 0, 0, translateError(err, msg)
+	}
+
+	result, err := tx.Exec("DELETE FROM posts WHERE deleted_at < $1", cutoff)
+	if err != nil {
+		return 0, 0, translateError(err, msg)
+	}
+	posts, err := result.RowsAffected()
+	if err != nil {
+		return 0, 0, xerrors.Errorf("%s: %w", msg, err)
+	}
+
+	result, err = tx.Exec("DELETE FROM users WHERE deleted_at < $1", cutoff)
+	if err != nil {
+		return 0, 0, translateError(err, msg)
+	}
+	users, err := result.RowsAffected()
+	if err != nil {
+		return 0, 0, xerrors.Errorf("%s: %w", msg, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, xerrors.Errorf("%s: %w", msg, err)
+	}
+	return int(users), int(posts), nil
+}
@@ -0,0 +1,111 @@
+// Package diff compares texts line by line, e.g. two revisions of a post.
+package diff
+
+import "strings"
+
+type Op string
+
+const (
+	Equal  Op = "equal"
+	Insert Op = "insert" // only in the new text
+	Delete Op = "delete" // only in the old text
+)
+
+// Line is one line of a diff
+type Line struct {
+	Op   Op     `json:"op"`
+	Text string `json:"text"`
+}
+
+// maxCells bounds the table used to compare the lines that differ, which
+// takes 8MB at most. Beyond it, the old lines are all deleted and the new
+// ones all inserted, which is still a correct diff, just not the smallest.
+const maxCells = 1 << 20
+
+// Lines returns the changes that turn a into b, keeping the longest common
+// subsequence of their lines. Where lines were replaced, the deletions come
+// first.
+func Lines(a, b string) []Line {
+	as, bs := split(a), split(b)
+
+	// lines shared at either end don't need comparing
+	var prefix, suffix int
+	for prefix < len(as) && prefix < len(bs) && as[prefix] == bs[prefix] {
+		prefix++
+	}
+	for suffix < len(as)-prefix && suffix < len(bs)-prefix &&
+		as[len(as)-1-suffix] == bs[len(bs)-1-suffix] {
+		suffix++
+	}
+
+	var lines []Line
+	for _, s := range as[:prefix] {
+		lines = append(lines, Line{Equal, s})
+	}
+	lines = append(lines, lcs(as[prefix:len(as)-suffix], bs[prefix:len(bs)-suffix])...)
+	for _, s := range as[len(as)-suffix:] {
+		lines = append(lines, Line{Equal, s})
+	}
+	return lines
+}
+
+// split breaks s into lines. An empty text has none.
+func split(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+func lcs(a, b []string) []Line {
+	n, m := len(a), len(b)
+	if n*m > maxCells {
+		lines := make([]Line, 0, n+m)
+		for _, s := range a {
+			lines = append(lines, Line{Delete, s})
+		}
+		for _, s := range b {
+			lines = append(lines, Line{Insert, s})
+		}
+		return lines
+	}
+
+	// length[i*(m+1)+j] is the length of the LCS of a[i:] and b[j:]
+	length := make([]int, (n+1)*(m+1))
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				length[i*(m+1)+j] = length[(i+1)*(m+1)+j+1] + 1
+			case length[(i+1)*(m+1)+j] >= length[i*(m+1)+j+1]:
+				length[i*(m+1)+j] = length[(i+1)*(m+1)+j]
+			default:
+				length[i*(m+1)+j] = length[i*(m+1)+j+1]
+			}
+		}
+	}
+
+	var lines []Line
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, Line{Equal, a[i]})
+			i++
+			j++
+		case length[(i+1)*(m+1)+j] >= length[i*(m+1)+j+1]:
+			lines = append(lines, Line{Delete, a[i]})
+			i++
+		default:
+			lines = append(lines, Line{Insert, b[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		lines = append(lines, Line{Delete, a[i]})
+	}
+	for ; j < m; j++ {
+		lines = append(lines, Line{Insert, b[j]})
+	}
+	return lines
+}
@@ -0,0 +1,69 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []Line
+	}{
+		{"both empty", "", "", nil},
+		{"unchanged", "a\nb", "a\nb", []Line{{Equal, "a"}, {Equal, "b"}}},
+		{"from nothing", "", "a\nb", []Line{{Insert, "a"}, {Insert, "b"}}},
+		{"to nothing", "a", "", []Line{{Delete, "a"}}},
+		{"line added", "a\nc", "a\nb\nc", []Line{{Equal, "a"}, {Insert, "b"}, {Equal, "c"}}},
+		{"line removed", "a\nb\nc", "a\nc", []Line{{Equal, "a"}, {Delete, "b"}, {Equal, "c"}}},
+		{"line replaced", "a\nb\nc", "a\nx\nc", []Line{{Equal, "a"}, {Delete, "b"}, {Insert, "x"}, {Equal, "c"}}},
+		{"lines moved", "a\nb\nc\nd", "b\nc\na\nd", []Line{
+			{Delete, "a"}, {Equal, "b"}, {Equal, "c"}, {Insert, "a"}, {Equal, "d"},
+		}},
+		{"trailing newline", "a", "a\n", []Line{{Equal, "a"}, {Insert, ""}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, Lines(tt.a, tt.b))
+		})
+	}
+}
+
+func TestLines_TooLarge(t *testing.T) {
+	// every line differs, so the table would need 5000*5000 cells
+	a := strings.Repeat("a\n", 4999) + "a"
+	b := strings.Repeat("b\n", 4999) + "b"
+
+	lines := Lines(a, b)
+	require.Len(t, lines, 10000)
+	require.Equal(t, Line{Delete, "a"}, lines[0])
+	require.Equal(t, Line{Insert, "b"}, lines[9999])
+}
+
+func TestLines_TooLargeFallsBack(t *testing.T) {
+	// every other line is shared, but the 1099 lines between the first and
+	// the shared last one would need more cells than maxCells
+	var as, bs []string
+	for i := 0; i < 1100; i++ {
+		if i%2 == 1 {
+			as, bs = append(as, "same"), append(bs, "same")
+		} else {
+			as, bs = append(as, fmt.Sprintf("a%d", i)), append(bs, fmt.Sprintf("b%d", i))
+		}
+	}
+	require.Greater(t, 1099*1099, maxCells)
+
+	lines := Lines(strings.Join(as, "\n"), strings.Join(bs, "\n"))
+	require.Len(t, lines, 2*1099+1)
+	for i, line := range lines[:1099] {
+		require.Equal(t, Line{Delete, as[i]}, line)
+	}
+	for i, line := range lines[1099 : 2*1099] {
+		require.Equal(t, Line{Insert, bs[i]}, line)
+	}
+	require.Equal(t, Line{Equal, "same"}, lines[2*1099])
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/mail"
+
+	"github.com/gavinc95/go-blog/authz"
+	"github.com/gavinc95/go-blog/db"
+	"golang.org/x/xerrors"
+)
+
+// Error codes returned in ErrorResponse
+const (
+	CodeBadRequest     = "bad_request"
+	CodeInvalidRequest = "invalid_request"
+	CodeUnauthorized   = "unauthorized"
+	CodeForbidden      = "forbidden"
+	CodeNotFound       = "not_found"
+	CodeMethod         = "method_not_allowed"
+	CodeConflict       = "conflict"
+	CodeValidation     = "validation_failed"
+	CodeForeignKey     = "invalid_reference"
+	CodeInternal       = "internal"
+)
+
+// ErrorResponse is the body of every error the API returns
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+type ErrorBody struct {
+	Code      string       `json:"code"`
+	Message   string       `json:"message"`
+	Details   []FieldError `json:"details,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+}
+
+// FieldError describes a problem with a single request field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// writeJSON sends v as a JSON response with the given status
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		// the status has already been sent, so all we can do is log it
+		log.Printf("request %s: failed to encode response: %+v", requestID(r), err)
+	}
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	writeErrorDetails(w, r, status, code, message, nil)
+}
+
+func writeErrorDetails(w http.ResponseWriter, r *http.Request, status int, code, message string, details []FieldError) {
+	writeJSON(w, r, status, ErrorResponse{
+		Error: ErrorBody{
+			Code:      code,
+			Message:   message,
+			Details:   details,
+			RequestID: requestID(r),
+		},
+	})
+}
+
+// writeDecodeError reports a request body that isn't valid JSON. The decoder's
+// message mentions Go types, so it is only logged.
+func writeDecodeError(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("request %s: %s %s: invalid request body: %v", requestID(r), r.Method, r.URL.Path, err)
+	writeError(w, r, http.StatusBadRequest, CodeBadRequest, "request body must be a valid JSON object")
+}
+
+func writeValidationError(w http.ResponseWriter, r *http.Request, details []FieldError) {
+	writeErrorDetails(w, r, http.StatusBadRequest, CodeInvalidRequest, "request has invalid fields", details)
+}
+
+// storeErrorStatus maps the domain errors from package db to an HTTP status
+// and error code
+func storeErrorStatus(err error) (int, string) {
+	switch {
+	case xerrors.Is(err, db.ErrNotFound):
+		return http.StatusNotFound, CodeNotFound
+	case xerrors.Is(err, db.ErrConflict):
+		return http.StatusConflict, CodeConflict
+	case xerrors.Is(err, db.ErrValidation):
+		return http.StatusUnprocessableEntity, CodeValidation
+	case xerrors.Is(err, db.ErrForeignKey):
+		return http.StatusUnprocessableEntity, CodeForeignKey
+	}
+	return http.StatusInternalServerError, CodeInternal
+}
+
+// writeStoreError sends the response for an error returned by the BlogStore.
+// Only the client-safe message of a domain error is sent - anything else is
+// logged with the request ID and replaced by a generic message.
+func writeStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	status, code := storeErrorStatus(err)
+
+	var storeErr *db.Error
+	if status == http.StatusInternalServerError || !xerrors.As(err, &storeErr) {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeError(w, r, status, code, storeErr.Msg)
+}
+
+// writeAuthzError sends the response for a request denied by package authz
+func writeAuthzError(w http.ResponseWriter, r *http.Request, err error) {
+	var denied *authz.Error
+	if !xerrors.As(err, &denied) {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	if xerrors.Is(err, authz.ErrUnauthenticated) {
+		writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, denied.Msg)
+		return
+	}
+	writeError(w, r, http.StatusForbidden, CodeForbidden, denied.Msg)
+}
+
+func writeInternalError(w http.ResponseWriter, r *http.Request, err error) {
+	logError(r, err)
+	writeError(w, r, http.StatusInternalServerError, CodeInternal, "internal server error")
+}
+
+// logError records an error that the client isn't shown
+func logError(r *http.Request, err error) {
+	log.Printf("request %s: %s %s: %+v", requestID(r), r.Method, r.URL.Path, err)
+}
+
+// validator collects field-level problems with a request
+type validator struct {
+	details []FieldError
+}
+
+func (v *validator) add(field, message string) {
+	v.details = append(v.details, FieldError{Field: field, Message: message})
+}
+
+func (v *validator) failed() bool {
+	return len(v.details) > 0
+}
+
+func (v *validator) required(field, val string) {
+	if val == "" {
+		v.add(field, "is required")
+	}
+}
+
+// email checks that val is a bare email address, e.g. tiny@cat.com
+func (v *validator) email(field, val string, required bool) {
+	if val == "" {
+		if required {
+			v.add(field, "is required")
+		}
+		return
+	}
+
+	addr, err := mail.ParseAddress(val)
+	if err != nil || addr.Address != val {
+		v.add(field, "must be a valid email address")
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gavinc95/go-blog/db"
+	"github.com/gavinc95/go-blog/db/models"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+type GetFeedResponse struct {
+	Posts []*models.Post `json:"posts"`
+	Page  PageInfo       `json:"page"`
+}
+
+// feedDateLayouts are accepted for the feed's from and to parameters. A bare
+// date means midnight UTC.
+var feedDateLayouts = []string{time.RFC3339, "2006-01-02"}
+
+// date parses an optional date query parameter
+func (v *validator) date(field, val string) time.Time {
+	if val == "" {
+		return time.Time{}
+	}
+	for _, layout := range feedDateLayouts {
+		if t, err := time.Parse(layout, val); err == nil {
+			return t
+		}
+	}
+	v.add(field, "must be a date (2006-01-02) or an RFC 3339 time")
+	return time.Time{}
+}
+
+// HandleGetFeed lists published posts by every author, with the author's
+// name, for the blog's front page. It takes the same paging parameters as a
+// user's posts, and can be narrowed down to one author (author), a tag (tag),
+// a category and its subcategories (category), and to posts published from a
+// date (inclusive) or up to one (exclusive).
+func (a *App) HandleGetFeed(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	// validate the request
+	var v validator
+	filter, opts, from := a.feedParams(r, &v)
+	if tag := query.Get("tag"); tag != "" {
+		filter.Tag = normalizeTag(tag)
+		v.tag("tag", filter.Tag)
+	}
+	filter.CategoryID = query.Get("category")
+	v.id("category", filter.CategoryID)
+	if v.failed() {
+		writeValidationError(w, r, v.details)
+		return
+	}
+
+	a.writeFeed(w, r, filter, opts, from)
+}
+
+// HandleGetTagPosts is the feed of posts with a tag
+func (a *App) HandleGetTagPosts(w http.ResponseWriter, r *http.Request) {
+	// validate the request
+	var v validator
+	filter, opts, from := a.feedParams(r, &v)
+	filter.Tag = normalizeTag(mux.Vars(r)["tag"])
+	v.tag("tag", filter.Tag)
+	if v.failed() {
+		writeValidationError(w, r, v.details)
+		return
+	}
+
+	a.writeFeed(w, r, filter, opts, from)
+}
+
+// HandleGetCategoryPosts is the feed of posts in a category, or in any of its
+// subcategories
+func (a *App) HandleGetCategoryPosts(w http.ResponseWriter, r *http.Request) {
+	// validate the request
+	var v validator
+	filter, opts, from := a.feedParams(r, &v)
+	if v.failed() {
+		writeValidationError(w, r, v.details)
+		return
+	}
+
+	category, err := a.BlogStore.GetCategory(mux.Vars(r)["id"])
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if category == nil {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "category not found")
+		return
+	}
+	filter.CategoryID = category.ID
+
+	a.writeFeed(w, r, filter, opts, from)
+}
+
+// feedParams reads the query parameters shared by feeds: author, the
+// publication dates, and paging
+func (a *App) feedParams(r *http.Request, v *validator) (db.FeedFilter, db.PostListOptions, *cursor) {
+	query := r.URL.Query()
+
+	var filter db.FeedFilter
+	if author := query.Get("author"); author != "" {
+		if _, err := uuid.Parse(author); err != nil {
+			v.add("author", "must be a user ID")
+		}
+		filter.AuthorID = author
+	}
+	filter.PublishedFrom = v.date("from", query.Get("from"))
+	filter.PublishedTo = v.date("to", query.Get("to"))
+	if !filter.PublishedFrom.IsZero() && !filter.PublishedTo.IsZero() && !filter.PublishedFrom.Before(filter.PublishedTo) {
+		v.add("to", "must be after from")
+	}
+	opts, from := a.postListOptions(r, v)
+	return filter, opts, from
+}
+
+func (a *App) writeFeed(w http.ResponseWriter, r *http.Request, filter db.FeedFilter, opts db.PostListOptions, from *cursor) {
+	page, err := a.BlogStore.GetFeed(filter, opts)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	res := GetFeedResponse{Posts: page.Posts, Page: feedPageInfo(page, opts, from)}
+	writeJSON(w, r, http.StatusOK, res)
+}
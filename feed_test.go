@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gavinc95/go-blog/db/models"
+	"github.com/stretchr/testify/require"
+)
+
+func getFeed(t *testing.T, query url.Values) (*GetFeedResponse, int) {
+	req, err := http.NewRequest("GET", "/feed?"+query.Encode(), nil)
+	require.NoError(t, err)
+	resp := executeRequest(req)
+	if resp.Code != http.StatusOK {
+		requireErrorCode(t, resp, CodeInvalidRequest)
+		return nil, resp.Code
+	}
+
+	var res GetFeedResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &res))
+	return &res, resp.Code
+}
+
+func TestGetFeed(t *testing.T) {
+	clearTable()
+
+	userID, err := app.BlogStore.CreateUser("tiny cat", "tiny@cat.com", "author")
+	require.NoError(t, err)
+	otherID, err := app.BlogStore.CreateUser("other cat", "other@cat.com", "author")
+	require.NoError(t, err)
+	first, err := app.BlogStore.CreatePost(userID, "first", "content", models.FormatPlain, models.PostPublished, time.Time{})
+	require.NoError(t, err)
+	time.Sleep(2 * time.Millisecond)
+	second, err := app.BlogStore.CreatePost(otherID, "second", "content", models.FormatPlain, models.PostPublished, time.Time{})
+	require.NoError(t, err)
+
+	res, _ := getFeed(t, nil)
+	require.Len(t, res.Posts, 2)
+	require.Equal(t, second, res.Posts[0].ID)
+	require.Equal(t, "other cat", res.Posts[0].Author.Name)
+	require.Equal(t, first, res.Posts[1].ID)
+	require.Equal(t, "tiny cat", res.Posts[1].Author.Name)
+
+	res, _ = getFeed(t, url.Values{"author": {userID}})
+	require.Len(t, res.Posts, 1)
+	require.Equal(t, first, res.Posts[0].ID)
+
+	// the feed pages like any other listing
+	res, _ = getFeed(t, url.Values{"limit": {"1"}})
+	require.Equal(t, second, res.Posts[0].ID)
+	res, _ = getFeed(t, url.Values{"limit": {"1"}, "cursor": {res.Page.NextCursor}})
+	require.Equal(t, first, res.Posts[0].ID)
+	require.Empty(t, res.Page.NextCursor)
+
+	tomorrow := time.Now().UTC().AddDate(0, 0, 1).Format("2006-01-02")
+	res, _ = getFeed(t, url.Values{"from": {tomorrow}})
+	require.Empty(t, res.Posts)
+	res, _ = getFeed(t, url.Values{"to": {tomorrow}})
+	require.Len(t, res.Posts, 2)
+
+	for _, query := range []url.Values{
+		{"author": {"tiny cat"}},
+		{"from": {"last tuesday"}},
+		{"from": {tomorrow}, "to": {"2020-01-01"}},
+	} {
+		_, code := getFeed(t, query)
+		require.Equal(t, http.StatusBadRequest, code, "query %v", query)
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gavinc95/go-blog/authz"
+	"github.com/gavinc95/go-blog/db"
+	"github.com/gavinc95/go-blog/db/models"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"golang.org/x/xerrors"
+)
+
+// builtinTemplates are the pages the app serves unless
+// Config.Frontend.TemplatesDir replaces them. Each page defines the "content"
+// of layout.html, and may use the snippets in partials.html.
+//
+//go:embed templates
+var builtinTemplates embed.FS
+
+var pageNames = []string{"home", "post", "author", "tag", "error"}
+
+var templateFuncs = template.FuncMap{
+	"date": func(t time.Time) string {
+		return t.Format("January 2, 2006")
+	},
+	// content marks a post's HTML as safe to include, which it is because
+	// package render sanitized it
+	"content": func(html string) template.HTML {
+		return template.HTML(html)
+	},
+}
+
+// page is what every page template is given
+type page struct {
+	BlogTitle string
+	Title     string // of the page, empty on the front page
+
+	Posts []*models.Post
+	// NextPage and PrevPage link to the pages of Posts either side of this
+	// one, if there are any
+	NextPage string
+	PrevPage string
+
+	Post     *models.Post
+	Comments []*models.Comment
+	Author   *models.User
+	Tag      string
+
+	Message string // on error pages
+}
+
+// loadPages parses each page along with the layout, from dir if it is set and
+// the built-in templates otherwise
+func loadPages(dir string) (map[string]*template.Template, error) {
+	var fsys fs.FS
+	if dir != "" {
+		fsys = os.DirFS(dir)
+	} else {
+		var err error
+		if fsys, err = fs.Sub(builtinTemplates, "templates"); err != nil {
+			return nil, xerrors.Errorf("failed to open the built-in templates: %w", err)
+		}
+	}
+
+	pages := make(map[string]*template.Template, len(pageNames))
+	for _, name := range pageNames {
+		t, err := template.New(name).Funcs(templateFuncs).ParseFS(fsys, "layout.html", "partials.html", name+".html")
+		if err != nil {
+			return nil, xerrors.Errorf("failed to parse the %s page: %w", name, err)
+		}
+		pages[name] = t
+	}
+	return pages, nil
+}
+
+func (a *App) registerFrontendRoutes() {
+	a.Router.HandleFunc("/", a.HandleHomePage).Methods("GET")
+	a.Router.HandleFunc("/p/{slug}", a.HandlePostPage).Methods("GET")
+	a.Router.HandleFunc("/a/{id}", a.HandleAuthorPage).Methods("GET")
+	a.Router.HandleFunc("/t/{tag}", a.HandleTagPage).Methods("GET")
+}
+
+// HandleHomePage is the front page: the feed of every author's posts, newest
+// first, paged like the API's feed
+func (a *App) HandleHomePage(w http.ResponseWriter, r *http.Request) {
+	// validate the request
+	var v validator
+	opts, from := a.postListOptions(r, &v)
+	if v.failed() {
+		a.renderInvalid(w, r, v.details)
+		return
+	}
+
+	a.renderFeed(w, r, "home", page{}, db.FeedFilter{}, opts, from)
+}
+
+// HandlePostPage shows a post and its approved top-level comments. As in the
+// API, a post that isn't published is only shown to those who can edit it.
+func (a *App) HandlePostPage(w http.ResponseWriter, r *http.Request) {
+	post, err := a.BlogStore.GetPostBySlug(mux.Vars(r)["slug"])
+	if err != nil {
+		a.renderStoreError(w, r, err)
+		return
+	}
+	if post == nil {
+		a.renderNotFound(w, r)
+		return
+	}
+	if !isPublic(post.Status) {
+		editor, err := a.can(r, authz.Update, authz.Post(post))
+		if err != nil {
+			a.renderStoreError(w, r, err)
+			return
+		}
+		if !editor {
+			a.renderNotFound(w, r)
+			return
+		}
+	}
+
+	author, err := a.BlogStore.GetUser(post.UserID)
+	if err != nil {
+		a.renderStoreError(w, r, err)
+		return
+	}
+	if author != nil {
+		post.Author = &models.PostAuthor{ID: author.ID, Name: author.Name}
+	}
+
+	comments, err := a.BlogStore.GetComments(post.ID, db.CommentListOptions{
+		Statuses: []models.CommentStatus{models.CommentApproved},
+		Limit:    a.Config.Server.MaxPageSize,
+	})
+	if err != nil {
+		a.renderStoreError(w, r, err)
+		return
+	}
+
+	a.render(w, r, http.StatusOK, "post", page{Title: post.Title, Post: post, Comments: comments.Comments})
+}
+
+// HandleAuthorPage is the feed of one author's posts
+func (a *App) HandleAuthorPage(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, err := uuid.Parse(id); err != nil {
+		a.renderNotFound(w, r)
+		return
+	}
+
+	// validate the request
+	var v validator
+	opts, from := a.postListOptions(r, &v)
+	if v.failed() {
+		a.renderInvalid(w, r, v.details)
+		return
+	}
+
+	author, err := a.BlogStore.GetUser(id)
+	if err != nil {
+		a.renderStoreError(w, r, err)
+		return
+	}
+	if author == nil {
+		a.renderNotFound(w, r)
+		return
+	}
+
+	p := page{Title: author.Name, Author: author}
+	a.renderFeed(w, r, "author", p, db.FeedFilter{AuthorID: author.ID}, opts, from)
+}
+
+// HandleTagPage is the feed of posts with a tag
+func (a *App) HandleTagPage(w http.ResponseWriter, r *http.Request) {
+	tag := normalizeTag(mux.Vars(r)["tag"])
+	if len(tag) > maxTagLength || !tagPattern.MatchString(tag) {
+		a.renderNotFound(w, r)
+		return
+	}
+
+	// validate the request
+	var v validator
+	opts, from := a.postListOptions(r, &v)
+	if v.failed() {
+		a.renderInvalid(w, r, v.details)
+		return
+	}
+
+	p := page{Title: "#" + tag, Tag: tag}
+	a.renderFeed(w, r, "tag", p, db.FeedFilter{Tag: tag}, opts, from)
+}
+
+func (a *App) renderFeed(w http.ResponseWriter, r *http.Request, name string, p page, filter db.FeedFilter, opts db.PostListOptions, from *cursor) {
+	feed, err := a.BlogStore.GetFeed(filter, opts)
+	if err != nil {
+		a.renderStoreError(w, r, err)
+		return
+	}
+
+	info := feedPageInfo(feed, opts, from)
+	p.Posts = feed.Posts
+	p.NextPage, p.PrevPage = pageURL(r, info.NextCursor), pageURL(r, info.PrevCursor)
+	a.render(w, r, http.StatusOK, name, p)
+}
+
+// pageURL links to the page of a listing from cursor, keeping the request's
+// other query parameters. It is empty without a cursor.
+func pageURL(r *http.Request, cursor string) string {
+	if cursor == "" {
+		return ""
+	}
+	query := r.URL.Query()
+	query.Set("cursor", cursor)
+	return r.URL.Path + "?" + query.Encode()
+}
+
+// render sends a page. It is rendered before anything is sent, so that a
+// template that fails can still be reported as a 500.
+func (a *App) render(w http.ResponseWriter, r *http.Request, status int, name string, p page) {
+	p.BlogTitle = a.Config.Frontend.Title
+
+	var buf bytes.Buffer
+	if err := a.pages[name].ExecuteTemplate(&buf, "layout", p); err != nil {
+		logError(r, xerrors.Errorf("failed to render the %s page: %w", name, err))
+		// the error page may be broken too
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if _, err := buf.WriteTo(w); err != nil {
+		logError(r, xerrors.Errorf("failed to send the %s page: %w", name, err))
+	}
+}
+
+func (a *App) renderError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	a.render(w, r, status, "error", page{Title: http.StatusText(status), Message: message})
+}
+
+func (a *App) renderNotFound(w http.ResponseWriter, r *http.Request) {
+	a.renderError(w, r, http.StatusNotFound, "There's nothing here. It may have been moved or deleted.")
+}
+
+// renderInvalid reports bad query parameters, e.g. a mangled cursor
+func (a *App) renderInvalid(w http.ResponseWriter, r *http.Request, details []FieldError) {
+	problems := make([]string, len(details))
+	for i, d := range details {
+		problems[i] = d.Field + " " + d.Message
+	}
+	a.renderError(w, r, http.StatusBadRequest, "The link is broken: "+strings.Join(problems, ", ")+".")
+}
+
+// renderStoreError shows the error page for an error returned by the
+// BlogStore. Pages only read, so anything but an internal error means there is
+// nothing to show.
+func (a *App) renderStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	if status, _ := storeErrorStatus(err); status != http.StatusInternalServerError {
+		a.renderNotFound(w, r)
+		return
+	}
+
+	logError(r, err)
+	a.renderError(w, r, http.StatusInternalServerError, "Something went wrong. Please try again later.")
+}
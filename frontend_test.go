@@ -0,0 +1,110 @@
+package main
+
+import (
+	"html"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gavinc95/go-blog/db/models"
+	"github.com/stretchr/testify/require"
+)
+
+// getPage fetches a frontend page, checking its status
+func getPage(t *testing.T, path, userID string, status int) string {
+	resp := authedRequest(t, "GET", path, userID, nil)
+	checkResponseCode(t, status, resp.Code)
+	require.Equal(t, "text/html; charset=utf-8", resp.Header().Get("Content-Type"))
+	return resp.Body.String()
+}
+
+var nextPageLink = regexp.MustCompile(`href="([^"]+)" rel="next"`)
+
+func TestFrontend(t *testing.T) {
+	clearTable()
+
+	authorID, err := app.BlogStore.CreateUser("tiny cat", "tiny@cat.com", "author")
+	require.NoError(t, err)
+	readerID, err := app.BlogStore.CreateUser("big cat", "big@cat.com", "reader")
+	require.NoError(t, err)
+	firstID, err := app.BlogStore.CreatePost(authorID, "Hello, World!", "*hi* <script>alert(1)</script>", models.FormatMarkdown, models.PostPublished, time.Time{})
+	require.NoError(t, err)
+	require.NoError(t, app.BlogStore.TagPost(firstID, "cats"))
+	_, err = app.BlogStore.CreatePost(authorID, "Second <post>", "more", models.FormatPlain, models.PostPublished, time.Time{})
+	require.NoError(t, err)
+	_, err = app.BlogStore.CreatePost(authorID, "Secret", "draft", models.FormatPlain, models.PostDraft, time.Time{})
+	require.NoError(t, err)
+	_, err = app.BlogStore.CreateComment(firstID, readerID, "", "nice <b>post</b>", models.CommentApproved)
+	require.NoError(t, err)
+	_, err = app.BlogStore.CreateComment(firstID, readerID, "", "buy now", models.CommentSpam)
+	require.NoError(t, err)
+
+	// the front page lists published posts, escaping what isn't rendered HTML
+	home := getPage(t, "/", "", http.StatusOK)
+	require.Contains(t, home, `<a href="/p/hello-world">Hello, World!</a>`)
+	require.Contains(t, home, "Second &lt;post&gt;")
+	require.Contains(t, home, "<p><em>hi</em> </p>")
+	require.Contains(t, home, `<a href="/a/`+authorID+`">tiny cat</a>`)
+	require.Contains(t, home, `<a href="/t/cats">#cats</a>`)
+	require.NotContains(t, home, "Secret")
+	require.NotContains(t, home, "<script>")
+
+	// and is paged with links
+	page := getPage(t, "/?limit=1", "", http.StatusOK)
+	require.Contains(t, page, "Second &lt;post&gt;")
+	next := nextPageLink.FindStringSubmatch(page)
+	require.Len(t, next, 2)
+	page = getPage(t, html.UnescapeString(next[1]), "", http.StatusOK)
+	require.Contains(t, page, "Hello, World!")
+	require.NotContains(t, page, "Second &lt;post&gt;")
+	require.Contains(t, page, `rel="prev"`)
+	require.Contains(t, getPage(t, "/?cursor=nonsense", "", http.StatusBadRequest), "cursor is invalid")
+
+	// posts show their approved comments
+	post := getPage(t, "/p/hello-world", "", http.StatusOK)
+	require.Contains(t, post, "<h1>Hello, World!</h1>")
+	require.Contains(t, post, "nice &lt;b&gt;post&lt;/b&gt;")
+	require.NotContains(t, post, "buy now")
+	getPage(t, "/p/missing", "", http.StatusNotFound)
+
+	// drafts are only shown to those who can edit them
+	getPage(t, "/p/secret", "", http.StatusNotFound)
+	getPage(t, "/p/secret", readerID, http.StatusNotFound)
+	require.Contains(t, getPage(t, "/p/secret", authorID, http.StatusOK), "Not published")
+
+	author := getPage(t, "/a/"+authorID, "", http.StatusOK)
+	require.Contains(t, author, "Posts by tiny cat")
+	require.Contains(t, author, "Hello, World!")
+	require.Contains(t, getPage(t, "/a/"+readerID, "", http.StatusOK), "There are no posts here yet.")
+	getPage(t, "/a/"+samplePostID, "", http.StatusNotFound)
+	getPage(t, "/a/nonsense", "", http.StatusNotFound)
+
+	tag := getPage(t, "/t/Cats", "", http.StatusOK)
+	require.Contains(t, tag, "Posts tagged #cats")
+	require.Contains(t, tag, "Hello, World!")
+	require.NotContains(t, tag, "Second")
+	getPage(t, "/t/not_a_tag", "", http.StatusNotFound)
+}
+
+func TestLoadPages(t *testing.T) {
+	pages, err := loadPages("")
+	require.NoError(t, err)
+	require.Len(t, pages, len(pageNames))
+
+	// a templates directory must have every page
+	dir := t.TempDir()
+	_, err = loadPages(dir)
+	require.Error(t, err)
+
+	for _, name := range append([]string{"layout", "partials"}, pageNames...) {
+		src, err := builtinTemplates.ReadFile("templates/" + name + ".html")
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name+".html"), src, 0o644))
+	}
+	pages, err = loadPages(dir)
+	require.NoError(t, err)
+	require.Len(t, pages, len(pageNames))
+}
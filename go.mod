@@ -1,11 +1,18 @@
 module github.com/gavinc95/go-blog
 
-go 1.14
+go 1.16
 
 require (
+	github.com/BurntSushi/toml v1.2.1
+	github.com/golang-jwt/jwt/v4 v4.5.2
 	github.com/google/uuid v1.1.1
 	github.com/gorilla/mux v1.7.4
 	github.com/lib/pq v1.5.2
+	github.com/microcosm-cc/bluemonday v1.0.18
 	github.com/stretchr/testify v1.5.1
+	github.com/yuin/goldmark v1.5.2
+	golang.org/x/crypto v0.10.0
+	golang.org/x/net v0.11.0 // indirect
 	golang.org/x/xerrors v0.0.0-20191204190536-9bdfabe68543
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// runEvery calls job straight away and then every interval until ctx is
+// done. It returns at once if interval isn't positive.
+func runEvery(ctx context.Context, interval time.Duration, job func()) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		job()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunScheduler publishes scheduled posts as they fall due, checking every
+// Config.Server.SchedulerInterval until ctx is done. Run starts it; an app
+// mounted in a larger server has to start it itself.
+func (a *App) RunScheduler(ctx context.Context) {
+	runEvery(ctx, a.Config.Server.SchedulerInterval, a.publishDuePosts)
+}
+
+func (a *App) publishDuePosts() {
+	ids, err := a.BlogStore.PublishDuePosts()
+	if err != nil {
+		// the posts are still scheduled, so the next tick tries again
+		log.Printf("failed to publish scheduled posts: %+v", err)
+		return
+	}
+	for _, id := range ids {
+		log.Printf("published scheduled post %s", id)
+	}
+}
+
+// RunPurger permanently deletes users and posts that have been in the trash
+// for longer than Config.Trash.Retention, every Config.Trash.PurgeInterval
+// until ctx is done. Like RunScheduler, Run starts it.
+func (a *App) RunPurger(ctx context.Context) {
+	runEvery(ctx, a.Config.Trash.PurgeInterval, a.purgeTrash)
+}
+
+func (a *App) purgeTrash() {
+	users, posts, err := a.BlogStore.PurgeDeleted(time.Now().Add(-a.Config.Trash.Retention))
+	if err != nil {
+		log.Printf("failed to purge the trash: %+v", err)
+		return
+	}
+	if users > 0 || posts > 0 {
+		log.Printf("purged %d users and %d posts from the trash", users, posts)
+	}
+}
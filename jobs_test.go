@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gavinc95/go-blog/db/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunScheduler(t *testing.T) {
+	clearTable()
+
+	userID, err := app.BlogStore.CreateUser("tiny cat", "tiny@cat.com", "author")
+	require.NoError(t, err)
+	postID, err := app.BlogStore.CreatePost(userID, "title", "content", models.FormatPlain, models.PostScheduled, time.Now().Add(20*time.Millisecond))
+	require.NoError(t, err)
+
+	cfg := app.Config
+	cfg.Server.SchedulerInterval = 5 * time.Millisecond
+	scheduled, err := NewApp(cfg, app.BlogStore)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scheduled.RunScheduler(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	require.Eventually(t, func() bool {
+		post, err := app.BlogStore.GetPost(postID)
+		return err == nil && post.Status == models.PostPublished
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestRunScheduler_Off(t *testing.T) {
+	cfg := app.Config
+	cfg.Server.SchedulerInterval = 0
+	off, err := NewApp(cfg, app.BlogStore)
+	require.NoError(t, err)
+
+	// returns straight away rather than waiting for ctx
+	off.RunScheduler(context.Background())
+}
+
+func TestRunPurger(t *testing.T) {
+	clearTable()
+
+	userID, err := app.BlogStore.CreateUser("tiny cat", "tiny@cat.com", "author")
+	require.NoError(t, err)
+	postID, err := app.BlogStore.CreatePost(userID, "title", "content", models.FormatPlain, models.PostPublished, time.Time{})
+	require.NoError(t, err)
+	_, err = app.BlogStore.DeletePost(postID)
+	require.NoError(t, err)
+
+	cfg := app.Config
+	cfg.Trash.Retention = 20 * time.Millisecond
+	cfg.Trash.PurgeInterval = 5 * time.Millisecond
+	purging, err := NewApp(cfg, app.BlogStore)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		purging.RunPurger(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	require.Eventually(t, func() bool {
+		post, err := app.BlogStore.GetDeletedPost(postID)
+		return err == nil && post == nil
+	}, time.Second, 5*time.Millisecond)
+	user, err := app.BlogStore.GetUser(userID)
+	require.NoError(t, err)
+	require.NotNil(t, user)
+}
@@ -1,8 +1,35 @@
 package main
 
-import "github.com/gavinc95/go-blog/db"
+import (
+	"log"
+	"os"
+
+	"github.com/gavinc95/go-blog/config"
+)
 
 func main() {
-	app := NewApp(":8010", &db.GenID{})
-	app.Run()
+	cfg, args, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "migrate":
+			runMigrate(cfg, args[1:])
+			return
+		case "admin":
+			runAdmin(cfg, args[1:])
+			return
+		}
+	}
+
+	app, err := NewApp(cfg, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := app.Run(); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -6,11 +6,20 @@ import (
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/gavinc95/go-blog/auth"
+	"github.com/gavinc95/go-blog/config"
+	"github.com/gavinc95/go-blog/db"
+	"github.com/gavinc95/go-blog/db/migrations"
 	"github.com/gavinc95/go-blog/db/models"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/xerrors"
 )
 
 var (
@@ -25,7 +34,8 @@ var (
 	sampleUserID = "553e5015-ce17-4c10-abf3-e7329f063dc9"
 )
 
-// this is used to prevent random UUIDs from being created for testing
+// this is used to prevent random UUIDs from being created for testing, when
+// a test needs to know the ID in advance
 type stubUUIDGenerator struct {
 	shouldGenPostID bool
 	shouldGenUserID bool
@@ -38,36 +48,171 @@ func (g *stubUUIDGenerator) UUID() string {
 		return samplePostID
 	}
 
-	return ""
+	return uuid.New().String()
 }
 
+// TestMain runs the suite against the memory store by default. Set
+// BLOG_TEST_STORE=postgres to run it against a live database instead, which
+// is configured the same way as the app.
 func TestMain(m *testing.M) {
-	app = NewApp(":8010", uuidGenerator)
-	app.ensureTablesExists()
+	cfg, _, err := config.Load(nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg.Database.Store = config.StoreMemory
+	if store := os.Getenv("BLOG_TEST_STORE"); store != "" {
+		cfg.Database.Store = store
+	}
+	cfg.Auth.BcryptCost = bcrypt.MinCost
+
+	app, err = NewApp(cfg, newTestStore(cfg))
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.TokenKeys = testKeys()
+	if err := app.migrate(); err != nil {
+		log.Fatal(err)
+	}
 
 	code := m.Run()
-	clearTable()
+	if app.BlogStore.GetDB() != nil {
+		dropTables()
+	}
 	os.Exit(code)
 }
 
+func newTestStore(cfg config.Config) db.BlogStore {
+	if cfg.Database.Store == config.StorePostgres {
+		return db.NewBlogStore(MustDB(cfg.Database), uuidGenerator)
+	}
+	return db.NewMemoryStore(uuidGenerator)
+}
+
+// dropTables reverts every migration, removing all tables and data. It only
+// exists for tests - the app itself never drops anything.
+func dropTables() {
+	migrator, err := migrations.NewMigrator(app.BlogStore.GetDB(), migrations.All)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := migrator.Down(len(migrations.All)); err != nil {
+		log.Fatal(err)
+	}
+}
+
 func clearTable() {
-	if _, err := app.BlogStore.GetDB().Exec("DELETE FROM users"); err != nil {
+	*uuidGenerator = stubUUIDGenerator{}
+	testAdminID = ""
+
+	if app.BlogStore.GetDB() == nil {
+		app.BlogStore = newTestStore(app.Config)
+		return
+	}
+
+	if _, err := app.BlogStore.GetDB().Exec("DELETE FROM users; DELETE FROM categories; DELETE FROM tags"); err != nil {
 		log.Fatal(err)
 	}
 }
 
 func executeRequest(req *http.Request) *httptest.ResponseRecorder {
 	rr := httptest.NewRecorder()
-	app.Router.ServeHTTP(rr, req)
+	app.ServeHTTP(rr, req)
 	return rr
 }
 
+// testKeys signs the access tokens that the tests act as users with
+func testKeys() *auth.KeySet {
+	key, err := auth.NewHMACKey("test", []byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	keys, err := auth.NewKeySet("test", key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return keys
+}
+
+// asUser makes req on behalf of userID
+func asUser(t *testing.T, req *http.Request, userID string) *http.Request {
+	token, _, err := app.TokenKeys.IssueAccessToken(userID, app.Config.Auth.JWTIssuer, time.Minute)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+// authedRequest sends a request as userID, or as nobody if it is empty. body
+// is encoded as JSON unless it is nil.
+func authedRequest(t *testing.T, method, path, userID string, body interface{}) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		require.NoError(t, json.NewEncoder(&buf).Encode(body))
+	}
+	req, err := http.NewRequest(method, path, &buf)
+	require.NoError(t, err)
+	if userID != "" {
+		req = asUser(t, req, userID)
+	}
+	return executeRequest(req)
+}
+
+// testAdminID is the admin made by createTestAdmin since the last clearTable
+var testAdminID string
+
+// createTestAdmin returns the ID of a user who may do anything, creating them
+// the first time after clearTable. Their ID never comes from the stubbed
+// sample IDs.
+func createTestAdmin(t *testing.T) string {
+	if testAdminID == "" {
+		gen := *uuidGenerator
+		*uuidGenerator = stubUUIDGenerator{}
+		id, err := app.BlogStore.CreateUser("admin cat", "admin@cat.com", "admin")
+		*uuidGenerator = gen
+		require.NoError(t, err)
+		testAdminID = id
+	}
+	return testAdminID
+}
+
 func checkResponseCode(t *testing.T, expected, actual int) {
 	if expected != actual {
 		t.Errorf("expected response code: %d, but got: %d\n", expected, actual)
 	}
 }
 
+func requireErrorCode(t *testing.T, resp *httptest.ResponseRecorder, code string) {
+	require.Equal(t, "application/json", resp.Header().Get("Content-Type"))
+	var res ErrorResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &res))
+	require.Equal(t, code, res.Error.Code)
+	require.NotEmpty(t, res.Error.Message)
+	require.NotEmpty(t, res.Error.RequestID)
+	require.Equal(t, resp.Header().Get(RequestIDHeader), res.Error.RequestID)
+}
+
+func TestNewApp_UnknownStore(t *testing.T) {
+	cfg := config.Default()
+	cfg.Database.Store = "carrier-pigeon"
+	_, err := NewApp(cfg, nil)
+	require.Error(t, err)
+}
+
+func TestNewApp_MountedInLargerServer(t *testing.T) {
+	clearTable()
+
+	parent := http.NewServeMux()
+	parent.Handle("/blog/", http.StripPrefix("/blog", app))
+
+	uuidGenerator.shouldGenUserID = true
+	reqBytes, err := json.Marshal(&CreateUserRequest{Name: "tiny cat", Email: "tiny@cat.com"})
+	require.NoError(t, err)
+	req, err := http.NewRequest("POST", "/blog/users", bytes.NewBuffer(reqBytes))
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	parent.ServeHTTP(rr, asUser(t, req, createTestAdmin(t)))
+	checkResponseCode(t, http.StatusCreated, rr.Code)
+}
+
 func TestGetUser_Empty(t *testing.T) {
 	clearTable()
 
@@ -77,26 +222,21 @@ func TestGetUser_Empty(t *testing.T) {
 	require.NoError(t, err)
 	resp := executeRequest(req)
 
-	var user models.User
-	err = json.Unmarshal(resp.Body.Bytes(), &user)
-	require.NoError(t, err)
-	checkResponseCode(t, http.StatusOK, resp.Code)
-	require.Equal(t, "", user.ID)
-	require.Equal(t, "", user.Name)
-	require.Equal(t, "", user.Email)
+	checkResponseCode(t, http.StatusNotFound, resp.Code)
+	requireErrorCode(t, resp, CodeNotFound)
 }
 
 func TestGetNonExistentUser(t *testing.T) {
 	clearTable()
 
 	resp := getTestUser(t, sampleUserID)
-	var user models.User
-	err := json.Unmarshal(resp.Body.Bytes(), &user)
-	require.NoError(t, err)
-	checkResponseCode(t, http.StatusOK, resp.Code)
-	require.Equal(t, "", user.ID)
-	require.Equal(t, "", user.Name)
-	require.Equal(t, "", user.Email)
+	checkResponseCode(t, http.StatusNotFound, resp.Code)
+	requireErrorCode(t, resp, CodeNotFound)
+
+	// IDs that aren't UUIDs can never exist
+	resp = getTestUser(t, "not-a-uuid")
+	checkResponseCode(t, http.StatusUnprocessableEntity, resp.Code)
+	requireErrorCode(t, resp, CodeValidation)
 }
 
 func TestCreateUser(t *testing.T) {
@@ -104,7 +244,7 @@ func TestCreateUser(t *testing.T) {
 
 	uuidGenerator.shouldGenUserID = true
 	resp := createTestUser(t, "tiny cat", "tiny@cat.com")
-	checkResponseCode(t, http.StatusOK, resp.Code)
+	checkResponseCode(t, http.StatusCreated, resp.Code)
 	var res CreateUserResponse
 	err := json.Unmarshal(resp.Body.Bytes(), &res)
 	require.NoError(t, err)
@@ -119,6 +259,14 @@ func TestCreateUser(t *testing.T) {
 	require.Equal(t, sampleUserID, getResp.User.ID)
 	require.Equal(t, "tiny cat", getResp.User.Name)
 	require.Equal(t, "tiny@cat.com", getResp.User.Email)
+
+	// only admins can create users, as everyone else signs up
+	body := &CreateUserRequest{Name: "big cat", Email: "big@cat.com"}
+	resp = authedRequest(t, "POST", "/users", "", body)
+	checkResponseCode(t, http.StatusUnauthorized, resp.Code)
+	resp = authedRequest(t, "POST", "/users", sampleUserID, body)
+	checkResponseCode(t, http.StatusForbidden, resp.Code)
+	requireErrorCode(t, resp, CodeForbidden)
 }
 
 func TestCreateExistingUser(t *testing.T) {
@@ -126,7 +274,7 @@ func TestCreateExistingUser(t *testing.T) {
 
 	uuidGenerator.shouldGenUserID = true
 	resp := createTestUser(t, "tiny cat", "tiny@cat.com")
-	checkResponseCode(t, http.StatusOK, resp.Code)
+	checkResponseCode(t, http.StatusCreated, resp.Code)
 	var res CreateUserResponse
 	err := json.Unmarshal(resp.Body.Bytes(), &res)
 	require.NoError(t, err)
@@ -134,7 +282,8 @@ func TestCreateExistingUser(t *testing.T) {
 
 	// create the same user again, and check for an error
 	resp = createTestUser(t, "tiny cat", "tiny@cat.com")
-	require.Equal(t, http.StatusInternalServerError, resp.Result().StatusCode)
+	checkResponseCode(t, http.StatusConflict, resp.Code)
+	requireErrorCode(t, resp, CodeConflict)
 }
 
 func TestCreateAndUpdateUser(t *testing.T) {
@@ -143,7 +292,7 @@ func TestCreateAndUpdateUser(t *testing.T) {
 	// create a new user
 	uuidGenerator.shouldGenUserID = true
 	resp := createTestUser(t, "tiny cat", "tiny@cat.com")
-	checkResponseCode(t, http.StatusOK, resp.Code)
+	checkResponseCode(t, http.StatusCreated, resp.Code)
 	var res CreateUserResponse
 	err := json.Unmarshal(resp.Body.Bytes(), &res)
 	require.NoError(t, err)
@@ -175,7 +324,7 @@ func TestDeleteUser(t *testing.T) {
 	// create a user
 	uuidGenerator.shouldGenUserID = true
 	resp := createTestUser(t, "tiny cat", "tiny@cat.com")
-	checkResponseCode(t, http.StatusOK, resp.Code)
+	checkResponseCode(t, http.StatusCreated, resp.Code)
 	var res CreateUserResponse
 	err := json.Unmarshal(resp.Body.Bytes(), &res)
 	require.NoError(t, err)
@@ -183,16 +332,16 @@ func TestDeleteUser(t *testing.T) {
 
 	// delete the user
 	resp = deleteTestUser(t, sampleUserID)
-	checkResponseCode(t, http.StatusOK, resp.Code)
-	var deleteRes DeleteUserResponse
-	err = json.Unmarshal(resp.Body.Bytes(), &deleteRes)
-	require.NoError(t, err)
-	require.Equal(t, sampleUserID, deleteRes.ID)
+	checkResponseCode(t, http.StatusNoContent, resp.Code)
+	require.Empty(t, resp.Body.Bytes())
 
 	// try to delete a non-existant user and verify there is an error
-	resp = deleteTestUser(t, sampleUserID)
-	require.Equal(t, http.StatusInternalServerError, resp.Result().StatusCode)
-	checkResponseCode(t, http.StatusInternalServerError, resp.Result().StatusCode)
+	uuidGenerator.shouldGenUserID = false
+	req, err := http.NewRequest("DELETE", "/users/"+sampleUserID, nil)
+	require.NoError(t, err)
+	resp = executeRequest(asUser(t, req, createTestAdmin(t)))
+	checkResponseCode(t, http.StatusNotFound, resp.Code)
+	requireErrorCode(t, resp, CodeNotFound)
 }
 
 func TestGetPost_EmptyTable(t *testing.T) {
@@ -200,28 +349,32 @@ func TestGetPost_EmptyTable(t *testing.T) {
 
 	// try to get a post for a non-existant user
 	resp := getTestPost(t, samplePostID)
-	checkResponseCode(t, http.StatusOK, resp.Code)
-	var getPostResponse GetPostResponse
-	err := json.Unmarshal(resp.Body.Bytes(), &getPostResponse)
-	require.NoError(t, err)
-	require.Nil(t, getPostResponse.Post)
+	checkResponseCode(t, http.StatusNotFound, resp.Code)
+	requireErrorCode(t, resp, CodeNotFound)
 
 	// create the user that has no posts saved yet
 	uuidGenerator.shouldGenUserID = true
 	resp = createTestUser(t, "tiny cat", "tiny@cat.com")
-	checkResponseCode(t, http.StatusOK, resp.Code)
+	checkResponseCode(t, http.StatusCreated, resp.Code)
 	var res CreateUserResponse
-	err = json.Unmarshal(resp.Body.Bytes(), &res)
+	err := json.Unmarshal(resp.Body.Bytes(), &res)
 	require.NoError(t, err)
 	require.Equal(t, sampleUserID, res.ID)
 
 	// try to get a non-existant post for the user
 	resp = getTestPost(t, samplePostID)
-	checkResponseCode(t, http.StatusOK, resp.Result().StatusCode)
-	var getRes GetPostResponse
-	err = json.Unmarshal(resp.Body.Bytes(), &getRes)
+	checkResponseCode(t, http.StatusNotFound, resp.Code)
+	requireErrorCode(t, resp, CodeNotFound)
+
+	// posts can't be created for users that don't exist
+	uuidGenerator.shouldGenUserID = false
+	reqBytes, err := json.Marshal(&CreatePostRequest{UserID: samplePostID2, Title: "title"})
 	require.NoError(t, err)
-	require.Nil(t, getRes.Post)
+	req, err := http.NewRequest("POST", "/posts", bytes.NewBuffer(reqBytes))
+	require.NoError(t, err)
+	resp = executeRequest(asUser(t, req, createTestAdmin(t)))
+	checkResponseCode(t, http.StatusUnprocessableEntity, resp.Code)
+	requireErrorCode(t, resp, CodeForeignKey)
 }
 
 func TestCreateOrUpdatePost(t *testing.T) {
@@ -230,7 +383,7 @@ func TestCreateOrUpdatePost(t *testing.T) {
 	// create a new user
 	uuidGenerator.shouldGenUserID = true
 	resp := createTestUser(t, "tiny cat", "tiny@cat.com")
-	checkResponseCode(t, http.StatusOK, resp.Code)
+	checkResponseCode(t, http.StatusCreated, resp.Code)
 	var userRes CreateUserResponse
 	err := json.Unmarshal(resp.Body.Bytes(), &userRes)
 	require.NoError(t, err)
@@ -240,7 +393,7 @@ func TestCreateOrUpdatePost(t *testing.T) {
 	uuidGenerator.shouldGenUserID = false
 	uuidGenerator.shouldGenPostID = true
 	resp = createTestPost(t, sampleUserID, "title", "content")
-	checkResponseCode(t, http.StatusOK, resp.Code)
+	checkResponseCode(t, http.StatusCreated, resp.Code)
 	var res CreatePostResponse
 	err = json.Unmarshal(resp.Body.Bytes(), &res)
 	require.NoError(t, err)
@@ -269,6 +422,47 @@ func TestCreateOrUpdatePost(t *testing.T) {
 	require.Equal(t, sampleUserID, getRes.Post.UserID)
 	require.Equal(t, "updated title", getRes.Post.Title)
 	require.Equal(t, "updated content", getRes.Post.Content)
+	require.Equal(t, sampleUserID, getRes.Post.UpdatedBy)
+
+	// timestamps are RFC 3339
+	var raw struct {
+		Post map[string]interface{} `json:"post"`
+	}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &raw))
+	for _, field := range []string{"created_at", "updated_at", "published_at"} {
+		value, ok := raw.Post[field].(string)
+		require.True(t, ok, "%s is %v", field, raw.Post[field])
+		_, err := time.Parse(time.RFC3339, value)
+		require.NoError(t, err, field)
+	}
+}
+
+func TestPostFormats(t *testing.T) {
+	clearTable()
+
+	userID, err := app.BlogStore.CreateUser("tiny cat", "tiny@cat.com", "author")
+	require.NoError(t, err)
+
+	resp := authedRequest(t, "POST", "/posts", userID, CreatePostRequest{UserID: userID, Title: "title", Content: "hi", Format: "rtf"})
+	checkResponseCode(t, http.StatusBadRequest, resp.Code)
+	requireErrorCode(t, resp, CodeInvalidRequest)
+
+	// posts are plain text unless they say otherwise
+	resp = authedRequest(t, "POST", "/posts", userID, CreatePostRequest{UserID: userID, Title: "title", Content: "a < b"})
+	checkResponseCode(t, http.StatusCreated, resp.Code)
+	var created CreatePostResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &created))
+	var got GetPostResponse
+	require.NoError(t, json.Unmarshal(getTestPost(t, created.ID).Body.Bytes(), &got))
+	require.Equal(t, models.FormatPlain, got.Post.Format)
+	require.Equal(t, "<p>a &lt; b</p>\n", got.Post.HTML)
+
+	resp = authedRequest(t, "PUT", "/posts/"+created.ID, userID,
+		UpdatePostRequest{Content: "[x](javascript:alert(1)) **bold**", Format: models.FormatMarkdown})
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	require.NoError(t, json.Unmarshal(getTestPost(t, created.ID).Body.Bytes(), &got))
+	require.Equal(t, "[x](javascript:alert(1)) **bold**", got.Post.Content)
+	require.Equal(t, "<p>x <strong>bold</strong></p>\n", got.Post.HTML)
 }
 
 func TestDeletePost(t *testing.T) {
@@ -277,7 +471,7 @@ func TestDeletePost(t *testing.T) {
 	// create a new user
 	uuidGenerator.shouldGenUserID = true
 	resp := createTestUser(t, "tiny cat", "tiny@cat.com")
-	checkResponseCode(t, http.StatusOK, resp.Code)
+	checkResponseCode(t, http.StatusCreated, resp.Code)
 	var userRes CreateUserResponse
 	err := json.Unmarshal(resp.Body.Bytes(), &userRes)
 	require.NoError(t, err)
@@ -287,7 +481,7 @@ func TestDeletePost(t *testing.T) {
 	uuidGenerator.shouldGenUserID = false
 	uuidGenerator.shouldGenPostID = true
 	resp = createTestPost(t, sampleUserID, "title", "content")
-	checkResponseCode(t, http.StatusOK, resp.Code)
+	checkResponseCode(t, http.StatusCreated, resp.Code)
 	var res CreatePostResponse
 	err = json.Unmarshal(resp.Body.Bytes(), &res)
 	require.NoError(t, err)
@@ -303,50 +497,450 @@ func TestDeletePost(t *testing.T) {
 
 	// delete the post
 	resp = deleteTestPost(t, samplePostID)
-	checkResponseCode(t, http.StatusOK, resp.Code)
-	var deleteRes DeletePostResponse
-	err = json.Unmarshal(resp.Body.Bytes(), &deleteRes)
-	require.NoError(t, err)
-	require.Equal(t, samplePostID, deleteRes.ID)
+	checkResponseCode(t, http.StatusNoContent, resp.Code)
+	require.Empty(t, resp.Body.Bytes())
 
 	// try and get the deleted post
 	resp = getTestPost(t, samplePostID)
-	checkResponseCode(t, http.StatusOK, resp.Result().StatusCode)
-	err = json.Unmarshal(resp.Body.Bytes(), &getRes)
-	require.NoError(t, err)
-	require.Nil(t, getRes.Post)
+	checkResponseCode(t, http.StatusNotFound, resp.Code)
 
 	// try to delete a post that doesn't exist
 	resp = deleteTestPost(t, samplePostID2)
-	checkResponseCode(t, http.StatusInternalServerError, resp.Result().StatusCode)
+	checkResponseCode(t, http.StatusNotFound, resp.Code)
 
 	// try to delete a post for a user that doesn't exist
 	resp = deleteTestPost(t, samplePostID)
-	checkResponseCode(t, http.StatusInternalServerError, resp.Result().StatusCode)
+	checkResponseCode(t, http.StatusNotFound, resp.Code)
+	requireErrorCode(t, resp, CodeNotFound)
 }
 
-func deleteTestUser(t *testing.T, id string) *httptest.ResponseRecorder {
-	reqBytes, err := json.Marshal(&DeleteUserRequest{
-		ID: id,
-	})
+func TestPostOwnership(t *testing.T) {
+	clearTable()
+
+	uuidGenerator.shouldGenUserID = true
+	createTestUser(t, "tiny cat", "tiny@cat.com")
+	uuidGenerator.shouldGenUserID = false
+	otherUserID, err := app.BlogStore.CreateUser("other cat", "other@cat.com", "author")
 	require.NoError(t, err)
-	req, err := http.NewRequest("DELETE", "/users", bytes.NewBuffer(reqBytes))
+	editorID, err := app.BlogStore.CreateUser("editor cat", "editor@cat.com", "editor")
 	require.NoError(t, err)
-	req.Header.Set("Content-Type", "application/json")
-	return executeRequest(req)
+	adminID := createTestAdmin(t)
+
+	postID, err := app.BlogStore.CreatePost(sampleUserID, "title", "content", models.FormatPlain, models.PostPublished, time.Time{})
+	require.NoError(t, err)
+	body := func() *bytes.Buffer {
+		return bytes.NewBufferString(`{"title": "hijacked"}`)
+	}
+
+	// anonymous callers must log in
+	req, err := http.NewRequest("PUT", "/posts/"+postID, body())
+	require.NoError(t, err)
+	resp := executeRequest(req)
+	checkResponseCode(t, http.StatusUnauthorized, resp.Code)
+	requireErrorCode(t, resp, CodeUnauthorized)
+
+	// other users can't touch the post
+	for _, method := range []string{"PUT", "DELETE"} {
+		req, err = http.NewRequest(method, "/posts/"+postID, body())
+		require.NoError(t, err)
+		resp = executeRequest(asUser(t, req, otherUserID))
+		checkResponseCode(t, http.StatusForbidden, resp.Code)
+		requireErrorCode(t, resp, CodeForbidden)
+	}
+
+	// or post in someone else's name
+	reqBytes, err := json.Marshal(&CreatePostRequest{UserID: sampleUserID, Title: "impostor"})
+	require.NoError(t, err)
+	req, err = http.NewRequest("POST", "/posts", bytes.NewBuffer(reqBytes))
+	require.NoError(t, err)
+	resp = executeRequest(asUser(t, req, otherUserID))
+	checkResponseCode(t, http.StatusForbidden, resp.Code)
+
+	post, err := app.BlogStore.GetPost(postID)
+	require.NoError(t, err)
+	require.Equal(t, "title", post.Title)
+
+	// editors can edit anyone's post, but not post as them
+	req, err = http.NewRequest("PUT", "/posts/"+postID, bytes.NewBufferString(`{"title": "edited"}`))
+	require.NoError(t, err)
+	resp = executeRequest(asUser(t, req, editorID))
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	req, err = http.NewRequest("POST", "/posts", bytes.NewBuffer(reqBytes))
+	require.NoError(t, err)
+	resp = executeRequest(asUser(t, req, editorID))
+	checkResponseCode(t, http.StatusForbidden, resp.Code)
+
+	// admins can do anything
+	req, err = http.NewRequest("DELETE", "/posts/"+postID, nil)
+	require.NoError(t, err)
+	resp = executeRequest(asUser(t, req, adminID))
+	checkResponseCode(t, http.StatusNoContent, resp.Code)
+}
+
+func TestPostLifecycle(t *testing.T) {
+	clearTable()
+
+	authorID, err := app.BlogStore.CreateUser("tiny cat", "tiny@cat.com", "author")
+	require.NoError(t, err)
+	otherID, err := app.BlogStore.CreateUser("other cat", "other@cat.com", "author")
+	require.NoError(t, err)
+	editorID, err := app.BlogStore.CreateUser("editor cat", "editor@cat.com", "editor")
+	require.NoError(t, err)
+
+	postAs := func(userID string, req *CreatePostRequest) *httptest.ResponseRecorder {
+		reqBytes, err := json.Marshal(req)
+		require.NoError(t, err)
+		r, err := http.NewRequest("POST", "/posts", bytes.NewBuffer(reqBytes))
+		require.NoError(t, err)
+		return executeRequest(asUser(t, r, userID))
+	}
+	setStatus := func(postID, userID string, req *SetPostStatusRequest) *httptest.ResponseRecorder {
+		reqBytes, err := json.Marshal(req)
+		require.NoError(t, err)
+		r, err := http.NewRequest("PUT", "/posts/"+postID+"/status", bytes.NewBuffer(reqBytes))
+		require.NoError(t, err)
+		return executeRequest(asUser(t, r, userID))
+	}
+	getPostAs := func(postID, userID string) int {
+		r, err := http.NewRequest("GET", "/posts/"+postID, nil)
+		require.NoError(t, err)
+		if userID != "" {
+			r = asUser(t, r, userID)
+		}
+		return executeRequest(r).Code
+	}
+	listAs := func(userID string, query url.Values) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("GET", "/users/"+authorID+"/posts?"+query.Encode(), nil)
+		require.NoError(t, err)
+		if userID != "" {
+			r = asUser(t, r, userID)
+		}
+		return executeRequest(r)
+	}
+	listedIDs := func(resp *httptest.ResponseRecorder) []string {
+		var res GetAllPostsResponse
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &res))
+		return responseIDs(&res)
+	}
+
+	// posts are published unless asked otherwise
+	resp := postAs(authorID, &CreatePostRequest{UserID: authorID, Title: "published"})
+	checkResponseCode(t, http.StatusCreated, resp.Code)
+	var created CreatePostResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &created))
+	publishedID := created.ID
+
+	resp = postAs(authorID, &CreatePostRequest{UserID: authorID, Title: "draft", Status: models.PostDraft})
+	checkResponseCode(t, http.StatusCreated, resp.Code)
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &created))
+	draftID := created.ID
+
+	// drafts are hidden from everyone but those who can edit them
+	require.Equal(t, http.StatusNotFound, getPostAs(draftID, ""))
+	require.Equal(t, http.StatusNotFound, getPostAs(draftID, otherID))
+	require.Equal(t, http.StatusOK, getPostAs(draftID, authorID))
+	require.Equal(t, http.StatusOK, getPostAs(draftID, editorID))
+	require.Equal(t, []string{publishedID}, listedIDs(listAs(otherID, nil)))
+	require.Equal(t, []string{draftID, publishedID}, listedIDs(listAs(authorID, nil)))
+	require.Equal(t, []string{draftID}, listedIDs(listAs(editorID, url.Values{"status": {"draft"}})))
+	resp = listAs(otherID, url.Values{"status": {"draft"}})
+	checkResponseCode(t, http.StatusForbidden, resp.Code)
+	resp = listAs("", url.Values{"status": {"lost"}})
+	checkResponseCode(t, http.StatusBadRequest, resp.Code)
+
+	// scheduling needs a time, and only scheduling takes one
+	publishAt := time.Now().Add(time.Hour)
+	for _, req := range []*SetPostStatusRequest{
+		{Status: models.PostScheduled},
+		{Status: models.PostPublished, PublishAt: &publishAt},
+		{Status: "lost"},
+	} {
+		resp = setStatus(draftID, authorID, req)
+		checkResponseCode(t, http.StatusBadRequest, resp.Code)
+	}
+	past := time.Now().Add(-time.Hour)
+	resp = setStatus(draftID, authorID, &SetPostStatusRequest{Status: models.PostScheduled, PublishAt: &past})
+	checkResponseCode(t, http.StatusUnprocessableEntity, resp.Code)
+	resp = setStatus(draftID, authorID, &SetPostStatusRequest{Status: models.PostArchived})
+	checkResponseCode(t, http.StatusConflict, resp.Code)
+	requireErrorCode(t, resp, CodeConflict)
+	resp = setStatus(draftID, otherID, &SetPostStatusRequest{Status: models.PostPublished})
+	checkResponseCode(t, http.StatusForbidden, resp.Code)
+
+	resp = setStatus(draftID, authorID, &SetPostStatusRequest{Status: models.PostScheduled, PublishAt: &publishAt})
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	require.Equal(t, http.StatusNotFound, getPostAs(draftID, otherID))
+
+	// editors can publish anyone's post
+	resp = setStatus(draftID, editorID, &SetPostStatusRequest{Status: models.PostPublished})
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	require.Equal(t, http.StatusOK, getPostAs(draftID, ""))
+	post, err := app.BlogStore.GetPost(draftID)
+	require.NoError(t, err)
+	require.Equal(t, models.PostPublished, post.Status)
+	require.Equal(t, editorID, post.UpdatedBy)
+
+	// archived posts can still be read, but leave the feed
+	resp = setStatus(draftID, authorID, &SetPostStatusRequest{Status: models.PostArchived})
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	require.Equal(t, http.StatusOK, getPostAs(draftID, ""))
+	feed, _ := getFeed(t, nil)
+	require.Len(t, feed.Posts, 1)
+	require.Equal(t, publishedID, feed.Posts[0].ID)
+}
+
+func TestUserOwnership(t *testing.T) {
+	clearTable()
+
+	uuidGenerator.shouldGenUserID = true
+	createTestUser(t, "tiny cat", "tiny@cat.com")
+	uuidGenerator.shouldGenUserID = false
+	otherID, err := app.BlogStore.CreateUser("other cat", "other@cat.com", "author")
+	require.NoError(t, err)
+
+	// updateTestUser and deleteTestUser act as the user themselves
+	resp := updateTestUser(t, sampleUserID, "tiny kitten", "")
+	checkResponseCode(t, http.StatusOK, resp.Code)
+
+	req, err := http.NewRequest("PUT", "/users/"+sampleUserID, bytes.NewBufferString(`{"name": "renamed"}`))
+	require.NoError(t, err)
+	resp = executeRequest(asUser(t, req, otherID))
+	checkResponseCode(t, http.StatusForbidden, resp.Code)
+
+	req, err = http.NewRequest("DELETE", "/users/"+sampleUserID, nil)
+	require.NoError(t, err)
+	resp = executeRequest(req)
+	checkResponseCode(t, http.StatusUnauthorized, resp.Code)
+
+	require.NoError(t, app.BlogStore.GrantRole(otherID, "admin"))
+	req, err = http.NewRequest("DELETE", "/users/"+sampleUserID, nil)
+	require.NoError(t, err)
+	resp = executeRequest(asUser(t, req, otherID))
+	checkResponseCode(t, http.StatusNoContent, resp.Code)
+}
+
+func TestGetAllUsers(t *testing.T) {
+	clearTable()
+
+	adminID := createTestAdmin(t)
+	authorID, err := app.BlogStore.CreateUser("tiny cat", "tiny@cat.com", "author")
+	require.NoError(t, err)
+	bigID, err := app.BlogStore.CreateUser("big cat", "big@cat.com", "author")
+	require.NoError(t, err)
+
+	listUsers := func(query url.Values, userID string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/users?"+query.Encode(), nil)
+		require.NoError(t, err)
+		if userID != "" {
+			req = asUser(t, req, userID)
+		}
+		return executeRequest(req)
+	}
+	userIDs := func(resp *httptest.ResponseRecorder) []string {
+		var res GetAllUsersResponse
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &res))
+		var ids []string
+		for _, user := range res.Users {
+			ids = append(ids, user.ID)
+		}
+		return ids
+	}
+
+	// only admins can list users
+	resp := listUsers(nil, "")
+	checkResponseCode(t, http.StatusUnauthorized, resp.Code)
+	resp = listUsers(nil, authorID)
+	checkResponseCode(t, http.StatusForbidden, resp.Code)
+	requireErrorCode(t, resp, CodeForbidden)
+
+	resp = listUsers(url.Values{"limit": {"2"}}, adminID)
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	require.Equal(t, []string{adminID, bigID}, userIDs(resp))
+	var res GetAllUsersResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &res))
+	require.Equal(t, "name", res.Page.Sort)
+	require.NotEmpty(t, res.Page.NextCursor)
+	require.Empty(t, res.Users[0].PasswordHash)
+
+	resp = listUsers(url.Values{"limit": {"2"}, "cursor": {res.Page.NextCursor}}, adminID)
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	require.Equal(t, []string{authorID}, userIDs(resp))
+
+	resp = listUsers(url.Values{"sort": {"email"}, "search": {"B"}}, adminID)
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	require.Equal(t, []string{bigID}, userIDs(resp))
+
+	resp = listUsers(url.Values{"sort": {"age"}}, adminID)
+	checkResponseCode(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestCreateSetsLocation(t *testing.T) {
+	clearTable()
+
+	uuidGenerator.shouldGenUserID = true
+	resp := createTestUser(t, "tiny cat", "tiny@cat.com")
+	checkResponseCode(t, http.StatusCreated, resp.Code)
+	require.Equal(t, "/users/"+sampleUserID, resp.Header().Get("Location"))
+
+	uuidGenerator.shouldGenUserID = false
+	uuidGenerator.shouldGenPostID = true
+	resp = createTestPost(t, sampleUserID, "title", "content")
+	checkResponseCode(t, http.StatusCreated, resp.Code)
+	require.Equal(t, "/posts/"+samplePostID, resp.Header().Get("Location"))
+
+	resp = getTestUserPosts(t, sampleUserID)
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	var res GetAllPostsResponse
+	err := json.Unmarshal(resp.Body.Bytes(), &res)
+	require.NoError(t, err)
+	require.Len(t, res.Posts, 1)
+	require.Equal(t, samplePostID, res.Posts[0].ID)
+}
+
+func TestLegacyRoutes(t *testing.T) {
+	clearTable()
+
+	uuidGenerator.shouldGenUserID = true
+	resp := createTestUser(t, "tiny cat", "tiny@cat.com")
+	checkResponseCode(t, http.StatusCreated, resp.Code)
+	uuidGenerator.shouldGenUserID = false
+	uuidGenerator.shouldGenPostID = true
+	resp = createTestPost(t, sampleUserID, "title", "content")
+	checkResponseCode(t, http.StatusCreated, resp.Code)
+
+	resp = legacyRequest(t, "GET", "/users", &GetUserRequest{ID: sampleUserID})
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	var getRes GetUserResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &getRes))
+	require.Equal(t, sampleUserID, getRes.User.ID)
+
+	resp = legacyRequest(t, "GET", "/posts/all", &GetAllPostsRequest{UserID: sampleUserID})
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	var allRes GetAllPostsResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &allRes))
+	require.Len(t, allRes.Posts, 1)
+
+	// legacy deletes keep returning the deleted ID
+	resp = legacyRequest(t, "DELETE", "/posts", &DeletePostRequest{ID: samplePostID})
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	var deleteRes DeletePostResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &deleteRes))
+	require.Equal(t, samplePostID, deleteRes.ID)
+
+	// missing IDs are rejected
+	resp = legacyRequest(t, "DELETE", "/users", &DeleteUserRequest{})
+	checkResponseCode(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestLegacyRoutes_Disabled(t *testing.T) {
+	cfg := app.Config
+	cfg.Features.LegacyRoutes = false
+	restOnly, err := NewApp(cfg, app.BlogStore)
+	require.NoError(t, err)
+
+	reqBytes, err := json.Marshal(&GetPostRequest{ID: samplePostID})
+	require.NoError(t, err)
+	req, err := http.NewRequest("GET", "/posts", bytes.NewBuffer(reqBytes))
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	restOnly.ServeHTTP(rr, req)
+	checkResponseCode(t, http.StatusMethodNotAllowed, rr.Code)
+	requireErrorCode(t, rr, CodeMethod)
+}
+
+// brokenStore fails every call with an error that must not reach clients
+type brokenStore struct {
+	db.BlogStore
+}
+
+func (brokenStore) GetUser(id string) (*models.User, error) {
+	return nil, xerrors.New("pq: password authentication failed for user \"postgres\"")
+}
+
+func TestErrorEnvelope_ValidationDetails(t *testing.T) {
+	clearTable()
+
+	resp := authedRequest(t, "POST", "/users", createTestAdmin(t), &CreateUserRequest{Email: "not an email"})
+	checkResponseCode(t, http.StatusBadRequest, resp.Code)
+	requireErrorCode(t, resp, CodeInvalidRequest)
+
+	var res ErrorResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &res))
+	require.Equal(t, []FieldError{{Field: "email", Message: "must be a valid email address"}}, res.Error.Details)
+
+	// the nil error that used to be dereferenced here
+	resp = legacyRequest(t, "DELETE", "/users", &DeleteUserRequest{})
+	requireErrorCode(t, resp, CodeInvalidRequest)
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &res))
+	require.Equal(t, []FieldError{{Field: "id", Message: "is required"}}, res.Error.Details)
+}
+
+func TestErrorEnvelope_MalformedBody(t *testing.T) {
+	clearTable()
+
+	req, err := http.NewRequest("POST", "/users", bytes.NewBufferString(`{"email": 42}`))
+	require.NoError(t, err)
+	resp := executeRequest(asUser(t, req, createTestAdmin(t)))
+	checkResponseCode(t, http.StatusBadRequest, resp.Code)
+	requireErrorCode(t, resp, CodeBadRequest)
+	require.NotContains(t, resp.Body.String(), "Go struct")
+}
+
+func TestErrorEnvelope_HidesInternalErrors(t *testing.T) {
+	broken, err := NewApp(app.Config, brokenStore{})
+	require.NoError(t, err)
+
+	req, err := http.NewRequest("GET", "/users/"+sampleUserID, nil)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	broken.ServeHTTP(rr, req)
+
+	checkResponseCode(t, http.StatusInternalServerError, rr.Code)
+	requireErrorCode(t, rr, CodeInternal)
+	require.NotContains(t, rr.Body.String(), "pq:")
+	require.NotContains(t, rr.Body.String(), "password")
+}
+
+func TestErrorEnvelope_UnknownRoute(t *testing.T) {
+	req, err := http.NewRequest("GET", "/kittens", nil)
+	require.NoError(t, err)
+	resp := executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, resp.Code)
+	requireErrorCode(t, resp, CodeNotFound)
+}
+
+func TestRequestID(t *testing.T) {
+	// a client-supplied ID is echoed back and used in the error body
+	req, err := http.NewRequest("GET", "/users/not-a-uuid", nil)
+	require.NoError(t, err)
+	req.Header.Set(RequestIDHeader, "trace-1234")
+	resp := executeRequest(req)
+	require.Equal(t, "trace-1234", resp.Header().Get(RequestIDHeader))
+	requireErrorCode(t, resp, CodeValidation)
+
+	// anything unprintable is replaced with a generated one
+	req.Header.Set(RequestIDHeader, "bad id\n")
+	resp = executeRequest(req)
+	require.NotEqual(t, "bad id\n", resp.Header().Get(RequestIDHeader))
+	require.NotEmpty(t, resp.Header().Get(RequestIDHeader))
+}
+
+func deleteTestUser(t *testing.T, id string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("DELETE", "/users/"+id, nil)
+	require.NoError(t, err)
+	return executeRequest(asUser(t, req, id))
 }
 
 func updateTestUser(t *testing.T, id, name, email string) *httptest.ResponseRecorder {
 	reqBytes, err := json.Marshal(&UpdateUserRequest{
-		ID:    id,
 		Name:  name,
 		Email: email,
 	})
 	require.NoError(t, err)
-	req, err := http.NewRequest("PUT", "/users", bytes.NewBuffer(reqBytes))
+	req, err := http.NewRequest("PUT", "/users/"+id, bytes.NewBuffer(reqBytes))
 	require.NoError(t, err)
 	req.Header.Set("Content-Type", "application/json")
-	return executeRequest(req)
+	return executeRequest(asUser(t, req, id))
 }
 
 func createTestUser(t *testing.T, name, email string) *httptest.ResponseRecorder {
@@ -358,13 +952,17 @@ func createTestUser(t *testing.T, name, email string) *httptest.ResponseRecorder
 	req, err := http.NewRequest("POST", "/users", bytes.NewBuffer(reqBytes))
 	require.NoError(t, err)
 	req.Header.Set("Content-Type", "application/json")
-	return executeRequest(req)
+	return executeRequest(asUser(t, req, createTestAdmin(t)))
 }
 
 func getTestUser(t *testing.T, id string) *httptest.ResponseRecorder {
-	reqBytes, err := json.Marshal(&GetUserRequest{ID: id})
+	req, err := http.NewRequest("GET", "/users/"+id, nil)
 	require.NoError(t, err)
-	req, err := http.NewRequest("GET", "/users", bytes.NewBuffer(reqBytes))
+	return executeRequest(req)
+}
+
+func getTestUserPosts(t *testing.T, id string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", "/users/"+id+"/posts", nil)
 	require.NoError(t, err)
 	return executeRequest(req)
 }
@@ -378,37 +976,38 @@ func createTestPost(t *testing.T, userID, title, content string) *httptest.Respo
 	require.NoError(t, err)
 	req, err := http.NewRequest("POST", "/posts", bytes.NewBuffer(reqBytes))
 	require.NoError(t, err)
-	return executeRequest(req)
+	return executeRequest(asUser(t, req, userID))
 }
 
 func updateTestPost(t *testing.T, id, title, content string) *httptest.ResponseRecorder {
 	reqBytes, err := json.Marshal(&UpdatePostRequest{
-		ID:      id,
 		Title:   title,
 		Content: content,
 	})
 	require.NoError(t, err)
-	req, err := http.NewRequest("PUT", "/posts", bytes.NewBuffer(reqBytes))
+	req, err := http.NewRequest("PUT", "/posts/"+id, bytes.NewBuffer(reqBytes))
 	require.NoError(t, err)
-	return executeRequest(req)
+	return executeRequest(asUser(t, req, sampleUserID))
 }
 
 func getTestPost(t *testing.T, postID string) *httptest.ResponseRecorder {
-	reqBytes, err := json.Marshal(&GetPostRequest{
-		ID: postID,
-	})
-	require.NoError(t, err)
-	req, err := http.NewRequest("GET", "/posts", bytes.NewBuffer(reqBytes))
+	req, err := http.NewRequest("GET", "/posts/"+postID, nil)
 	require.NoError(t, err)
 	return executeRequest(req)
 }
 
 func deleteTestPost(t *testing.T, id string) *httptest.ResponseRecorder {
-	reqBytes, err := json.Marshal(&DeletePostRequest{
-		ID: id,
-	})
+	req, err := http.NewRequest("DELETE", "/posts/"+id, nil)
 	require.NoError(t, err)
-	req, err := http.NewRequest("DELETE", "/posts", bytes.NewBuffer(reqBytes))
+	return executeRequest(asUser(t, req, sampleUserID))
+}
+
+// legacyRequest sends a request to one of the original routes that take
+// their parameters from the JSON body
+func legacyRequest(t *testing.T, method, path string, body interface{}) *httptest.ResponseRecorder {
+	reqBytes, err := json.Marshal(body)
 	require.NoError(t, err)
-	return executeRequest(req)
+	req, err := http.NewRequest(method, path, bytes.NewBuffer(reqBytes))
+	require.NoError(t, err)
+	return executeRequest(asUser(t, req, sampleUserID))
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/gavinc95/go-blog/config"
+	"github.com/gavinc95/go-blog/db/migrations"
+)
+
+const migrateUsage = `usage: go-blog migrate <command> [flags]
+
+commands:
+  up      apply all pending migrations
+  down    revert the latest migrations (see -steps)
+  status  list migrations and whether they have been applied
+`
+
+// runMigrate implements the `migrate` subcommand
+func runMigrate(cfg config.Config, args []string) {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet("migrate "+args[0], flag.ExitOnError)
+	steps := fs.Int("steps", 1, "number of migrations to revert with `down`")
+	fs.Parse(args[1:])
+
+	pg := MustDB(cfg.Database)
+	defer pg.Close()
+
+	migrator, err := migrations.NewMigrator(pg, migrations.All)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up()
+		for _, m := range applied {
+			fmt.Printf("applied %d (%s)\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatalf("migrate up failed: %+v", err)
+		}
+	case "down":
+		reverted, err := migrator.Down(*steps)
+		for _, m := range reverted {
+			fmt.Printf("reverted %d (%s)\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatalf("migrate down failed: %+v", err)
+		}
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			log.Fatalf("migrate status failed: %+v", err)
+		}
+		printStatus(os.Stdout, statuses)
+	default:
+		fmt.Fprint(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+}
+
+func printStatus(out io.Writer, statuses []migrations.Status) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	for _, s := range statuses {
+		state := "pending"
+		switch {
+		case !s.Known:
+			state = "unknown (database ahead)"
+		case s.Modified:
+			state = "modified"
+		case s.Applied:
+			state = "applied"
+		}
+
+		appliedAt := ""
+		if s.Applied {
+			appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05 MST")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", s.Version, s.Name, state, appliedAt)
+	}
+	w.Flush()
+}
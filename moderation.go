@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gavinc95/go-blog/authz"
+	"github.com/gavinc95/go-blog/db/models"
+)
+
+type ModerateCommentsRequest struct {
+	Approve []string `json:"approve"` // IDs of comments to publish
+	Reject  []string `json:"reject"`  // IDs of comments to delete, with their replies
+}
+
+const maxModeratedComments = 100
+
+// HandleGetModerationQueue lists the comments waiting for the caller to
+// moderate them, oldest first by default: on their own posts, or on everyone's
+// for editors and admins. status picks pending or spam comments; both are
+// listed without it.
+func (a *App) HandleGetModerationQueue(w http.ResponseWriter, r *http.Request) {
+	// validate the request
+	var v validator
+	opts, from := a.commentListOptions(r, &v)
+	switch status := models.CommentStatus(r.URL.Query().Get("status")); status {
+	case "":
+		opts.Statuses = []models.CommentStatus{models.CommentPending, models.CommentSpam}
+	case models.CommentPending, models.CommentSpam:
+		opts.Statuses = []models.CommentStatus{status}
+	default:
+		v.add("status", "must be pending or spam")
+	}
+	if v.failed() {
+		writeValidationError(w, r, v.details)
+		return
+	}
+
+	userID := currentUserID(r)
+	everyone, err := a.can(r, authz.Moderate, authz.AllComments())
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	postAuthorID := ""
+	if !everyone {
+		if !a.authorize(w, r, authz.Moderate, authz.UserPostComments(userID)) {
+			return
+		}
+		postAuthorID = userID
+	}
+
+	page, err := a.BlogStore.GetCommentQueue(postAuthorID, opts)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	res := GetCommentsResponse{Comments: page.Comments, Page: commentPageInfo(page, opts, from)}
+	if res.Comments == nil {
+		res.Comments = []*models.Comment{}
+	}
+	writeJSON(w, r, http.StatusOK, res)
+}
+
+// HandleModerateComments approves and rejects comments in bulk. Either all of
+// them are moderated or, if the caller may not moderate one of them, none.
+func (a *App) HandleModerateComments(w http.ResponseWriter, r *http.Request) {
+	var req ModerateCommentsRequest
+	err := decodeRequest(r, &req)
+	if err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	// validate the request
+	var v validator
+	seen := make(map[string]bool)
+	for _, id := range req.Approve {
+		v.required("approve", id)
+		v.id("approve", id)
+		seen[id] = true
+	}
+	for _, id := range req.Reject {
+		v.required("reject", id)
+		v.id("reject", id)
+		if contains(req.Approve, id) {
+			v.add("reject", "must not list a comment that is also approved")
+		}
+		seen[id] = true
+	}
+	switch {
+	case len(seen) == 0:
+		v.add("approve", "or reject is required")
+	case len(seen) > maxModeratedComments:
+		v.add("approve", "and reject must list at most 100 comments between them")
+	}
+	if v.failed() {
+		writeValidationError(w, r, v.details)
+		return
+	}
+
+	if !a.authorize(w, r, authz.Moderate, authz.UserPostComments(currentUserID(r))) {
+		return
+	}
+	posts := make(map[string]*models.Post)
+	for id := range seen {
+		comment, err := a.BlogStore.GetComment(id)
+		if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		if comment == nil {
+			writeError(w, r, http.StatusNotFound, CodeNotFound, "comment not found: "+id)
+			return
+		}
+
+		post, ok := posts[comment.PostID]
+		if !ok {
+			if post, err = a.BlogStore.GetPost(comment.PostID); err != nil {
+				writeStoreError(w, r, err)
+				return
+			}
+			posts[comment.PostID] = post
+		}
+		// the post may have been deleted since the comment was read
+		if post == nil {
+			writeError(w, r, http.StatusNotFound, CodeNotFound, "comment not found: "+id)
+			return
+		}
+		if !a.authorize(w, r, authz.Moderate, authz.PostComments(post)) {
+			return
+		}
+	}
+
+	if err := a.BlogStore.ModerateComments(req.Approve, req.Reject); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,84 @@
+// Package moderation decides whether a new comment goes straight up or waits
+// for a moderator, and holds the spam filters that help decide.
+package moderation
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gavinc95/go-blog/config"
+	"github.com/gavinc95/go-blog/db/models"
+)
+
+// Verdict is a classifier's opinion of a comment
+type Verdict struct {
+	Spam   bool
+	Reason string // why it is spam, for moderators
+}
+
+// Classifier flags spam. Implementations may call out to other services, so
+// Classify takes the request's context.
+type Classifier interface {
+	Classify(ctx context.Context, comment *models.Comment) (Verdict, error)
+}
+
+// Heuristic is the built-in Classifier. A comment is spam if it contains one
+// of its keywords as a whole word, ignoring case, or more than its maximum
+// number of links. A zero maximum doesn't count links.
+type Heuristic struct {
+	keywords *regexp.Regexp // nil without keywords
+	maxLinks int
+}
+
+// links are URLs with a scheme, or bare ones starting with www.
+var linkPattern = regexp.MustCompile(`(?i)\bhttps?://\S+|\bwww\.\S+`)
+
+func NewHeuristic(keywords []string, maxLinks int) *Heuristic {
+	h := &Heuristic{maxLinks: maxLinks}
+
+	var quoted []string
+	for _, keyword := range keywords {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			quoted = append(quoted, regexp.QuoteMeta(keyword))
+		}
+	}
+	if len(quoted) > 0 {
+		h.keywords = regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+	}
+	return h
+}
+
+func (h *Heuristic) Classify(ctx context.Context, comment *models.Comment) (Verdict, error) {
+	if h.keywords != nil {
+		if keyword := h.keywords.FindString(comment.Content); keyword != "" {
+			return Verdict{Spam: true, Reason: fmt.Sprintf("contains %q", strings.ToLower(keyword))}, nil
+		}
+	}
+	if h.maxLinks > 0 {
+		if n := len(linkPattern.FindAllStringIndex(comment.Content, -1)); n > h.maxLinks {
+			return Verdict{Spam: true, Reason: fmt.Sprintf("has %d links", n)}, nil
+		}
+	}
+	return Verdict{}, nil
+}
+
+// Status decides which status a new comment by someone who can't moderate it
+// starts in. Spam is held as spam; the rest goes by policy, a config
+// moderation policy, where returning says whether the writer has had a
+// comment approved before.
+func Status(policy string, verdict Verdict, returning bool) models.CommentStatus {
+	if verdict.Spam {
+		return models.CommentSpam
+	}
+	switch policy {
+	case config.ModerateNone:
+		return models.CommentApproved
+	case config.ModerateFirst:
+		if returning {
+			return models.CommentApproved
+		}
+	}
+	return models.CommentPending
+}
@@ -0,0 +1,50 @@
+package moderation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gavinc95/go-blog/config"
+	"github.com/gavinc95/go-blog/db/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeuristic(t *testing.T) {
+	h := NewHeuristic([]string{"cheap pills", "casino", " "}, 2)
+
+	tests := []struct {
+		name    string
+		content string
+		want    Verdict
+	}{
+		{"clean", "nice post, thanks", Verdict{}},
+		{"keyword", "Visit our CASINO today", Verdict{Spam: true, Reason: `contains "casino"`}},
+		{"phrase", "buy cheap pills here", Verdict{Spam: true, Reason: `contains "cheap pills"`}},
+		{"part of a word", "casinos and pills", Verdict{}},
+		{"few links", "see https://a.com and www.b.com", Verdict{}},
+		{"a link is counted once", "https://www.a.com https://www.b.com", Verdict{}},
+		{"too many links", "http://a.com http://b.com www.c.com", Verdict{Spam: true, Reason: "has 3 links"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := h.Classify(context.Background(), &models.Comment{Content: tt.content})
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+
+	// without limits nothing is spam
+	got, err := NewHeuristic(nil, 0).Classify(context.Background(), &models.Comment{Content: "http://a.com http://b.com www.c.com"})
+	require.NoError(t, err)
+	require.False(t, got.Spam)
+}
+
+func TestStatus(t *testing.T) {
+	spam := Verdict{Spam: true}
+
+	require.Equal(t, models.CommentPending, Status(config.ModerateAll, Verdict{}, true))
+	require.Equal(t, models.CommentPending, Status(config.ModerateFirst, Verdict{}, false))
+	require.Equal(t, models.CommentApproved, Status(config.ModerateFirst, Verdict{}, true))
+	require.Equal(t, models.CommentApproved, Status(config.ModerateNone, Verdict{}, false))
+	require.Equal(t, models.CommentSpam, Status(config.ModerateNone, spam, true))
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gavinc95/go-blog/config"
+	"github.com/gavinc95/go-blog/db/models"
+	"github.com/gavinc95/go-blog/moderation"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+// stubClassifier calls comments containing "spam" spam, and fails on "error"
+type stubClassifier struct{}
+
+func (stubClassifier) Classify(ctx context.Context, comment *models.Comment) (moderation.Verdict, error) {
+	if strings.Contains(comment.Content, "error") {
+		return moderation.Verdict{}, xerrors.New("classifier is down")
+	}
+	return moderation.Verdict{Spam: strings.Contains(comment.Content, "spam"), Reason: "stub"}, nil
+}
+
+func postTestComment(t *testing.T, postID, userID, content string) CreateCommentResponse {
+	resp := authedRequest(t, "POST", "/posts/"+postID+"/comments", userID, CreateCommentRequest{Content: content})
+	checkResponseCode(t, http.StatusCreated, resp.Code)
+	var res CreateCommentResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &res))
+	return res
+}
+
+// editTestComment edits a comment as userID, returning its new status
+func editTestComment(t *testing.T, postID, commentID, userID, content string) models.CommentStatus {
+	resp := authedRequest(t, "PUT", "/posts/"+postID+"/comments/"+commentID, userID, UpdateCommentRequest{Content: content})
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	var res UpdateCommentResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &res))
+	return res.Status
+}
+
+func getQueue(t *testing.T, path, userID string) []string {
+	resp := authedRequest(t, "GET", path, userID, nil)
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	var res GetCommentsResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &res))
+	var ids []string
+	for _, comment := range res.Comments {
+		ids = append(ids, comment.ID)
+	}
+	return ids
+}
+
+func TestModeration(t *testing.T) {
+	clearTable()
+
+	classifier, policy := app.Classifier, app.Config.Moderation.Policy
+	app.Classifier = stubClassifier{}
+	defer func() { app.Classifier, app.Config.Moderation.Policy = classifier, policy }()
+
+	authorID, err := app.BlogStore.CreateUser("tiny cat", "tiny@cat.com", "author")
+	require.NoError(t, err)
+	otherID, err := app.BlogStore.CreateUser("other cat", "other@cat.com", "author")
+	require.NoError(t, err)
+	readerID, err := app.BlogStore.CreateUser("big cat", "big@cat.com", "reader")
+	require.NoError(t, err)
+	editorID, err := app.BlogStore.CreateUser("fat cat", "fat@cat.com", "editor")
+	require.NoError(t, err)
+	postID, err := app.BlogStore.CreatePost(authorID, "title", "content", models.FormatPlain, models.PostPublished, time.Time{})
+	require.NoError(t, err)
+	otherPostID, err := app.BlogStore.CreatePost(otherID, "other", "content", models.FormatPlain, models.PostPublished, time.Time{})
+	require.NoError(t, err)
+
+	// every comment waits, unless the post's moderators write it
+	app.Config.Moderation.Policy = config.ModerateAll
+	pending := postTestComment(t, postID, readerID, "first!")
+	require.Equal(t, models.CommentPending, pending.Status)
+	spam := postTestComment(t, postID, readerID, "buy spam")
+	require.Equal(t, models.CommentSpam, spam.Status)
+	broken := postTestComment(t, postID, readerID, "error")
+	require.Equal(t, models.CommentPending, broken.Status)
+	other := postTestComment(t, otherPostID, readerID, "hello")
+	require.Equal(t, models.CommentPending, other.Status)
+	own := postTestComment(t, postID, authorID, "spam, but mine")
+	require.Equal(t, models.CommentApproved, own.Status)
+
+	// until then only their writer and moderators see them
+	require.Len(t, getComments(t, "/posts/"+postID+"/comments").Comments, 1)
+	resp := authedRequest(t, "GET", "/posts/"+postID+"/comments/"+pending.ID, "", nil)
+	checkResponseCode(t, http.StatusNotFound, resp.Code)
+	resp = authedRequest(t, "GET", "/posts/"+postID+"/comments/"+pending.ID, readerID, nil)
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	resp = authedRequest(t, "GET", "/posts/"+postID+"/comments/"+pending.ID, authorID, nil)
+	checkResponseCode(t, http.StatusOK, resp.Code)
+
+	// authors moderate their own posts, editors everyone's
+	resp = authedRequest(t, "GET", "/moderation/comments", "", nil)
+	checkResponseCode(t, http.StatusUnauthorized, resp.Code)
+	resp = authedRequest(t, "GET", "/moderation/comments", readerID, nil)
+	checkResponseCode(t, http.StatusForbidden, resp.Code)
+	resp = authedRequest(t, "GET", "/moderation/comments?status=approved", authorID, nil)
+	checkResponseCode(t, http.StatusBadRequest, resp.Code)
+	require.Equal(t, []string{pending.ID, spam.ID, broken.ID}, getQueue(t, "/moderation/comments", authorID))
+	require.Equal(t, []string{spam.ID}, getQueue(t, "/moderation/comments?status=spam", authorID))
+	require.Equal(t, []string{pending.ID, spam.ID, broken.ID, other.ID}, getQueue(t, "/moderation/comments", editorID))
+
+	resp = authedRequest(t, "POST", "/moderation/comments", authorID, ModerateCommentsRequest{})
+	checkResponseCode(t, http.StatusBadRequest, resp.Code)
+	resp = authedRequest(t, "POST", "/moderation/comments", authorID,
+		ModerateCommentsRequest{Approve: []string{pending.ID}, Reject: []string{pending.ID}})
+	checkResponseCode(t, http.StatusBadRequest, resp.Code)
+	resp = authedRequest(t, "POST", "/moderation/comments", authorID,
+		ModerateCommentsRequest{Approve: []string{pending.ID, other.ID}})
+	checkResponseCode(t, http.StatusForbidden, resp.Code)
+	resp = authedRequest(t, "POST", "/moderation/comments", authorID,
+		ModerateCommentsRequest{Approve: []string{pending.ID}, Reject: []string{spam.ID, samplePostID}})
+	checkResponseCode(t, http.StatusNotFound, resp.Code)
+	require.Len(t, getQueue(t, "/moderation/comments", authorID), 3)
+
+	resp = authedRequest(t, "POST", "/moderation/comments", authorID,
+		ModerateCommentsRequest{Approve: []string{pending.ID, broken.ID}, Reject: []string{spam.ID}})
+	checkResponseCode(t, http.StatusNoContent, resp.Code)
+	require.Empty(t, getQueue(t, "/moderation/comments", authorID))
+	require.Len(t, getComments(t, "/posts/"+postID+"/comments").Comments, 3)
+	resp = authedRequest(t, "GET", "/posts/"+postID+"/comments/"+spam.ID, authorID, nil)
+	checkResponseCode(t, http.StatusNotFound, resp.Code)
+
+	// edits are checked for spam again
+	require.Equal(t, models.CommentSpam, editTestComment(t, postID, pending.ID, readerID, "now spam"))
+
+	// once a comment is approved, its writer is trusted under "first"
+	app.Config.Moderation.Policy = config.ModerateFirst
+	again := postTestComment(t, postID, readerID, "again")
+	require.Equal(t, models.CommentApproved, again.Status)
+	require.Equal(t, models.CommentPending, postTestComment(t, postID, otherID, "new here").Status)
+	require.Equal(t, models.CommentApproved, editTestComment(t, postID, again.ID, readerID, "again, edited"))
+
+	// but their edits wait when the filter fails, and under "all"
+	require.Equal(t, models.CommentPending, editTestComment(t, postID, again.ID, readerID, "error"))
+	app.Config.Moderation.Policy = config.ModerateAll
+	require.Equal(t, models.CommentPending, editTestComment(t, postID, broken.ID, readerID, "edited"))
+	require.Equal(t, models.CommentApproved, editTestComment(t, postID, own.ID, authorID, "edited by its moderator"))
+
+	// and fixing a comment doesn't approve it
+	app.Config.Moderation.Policy = config.ModerateNone
+	require.Equal(t, models.CommentPending, editTestComment(t, postID, broken.ID, readerID, "fixed"))
+	require.Equal(t, models.CommentSpam, editTestComment(t, postID, pending.ID, readerID, "not spam"))
+
+	require.Equal(t, models.CommentApproved, postTestComment(t, otherPostID, authorID, "anything").Status)
+	require.Equal(t, models.CommentSpam, postTestComment(t, otherPostID, authorID, "spam").Status)
+}
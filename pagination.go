@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gavinc95/go-blog/db"
+	"github.com/gavinc95/go-blog/db/models"
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+)
+
+// PageInfo describes a page of a listing. The cursors are opaque: pass one
+// back as the cursor query parameter to get the next or previous page.
+type PageInfo struct {
+	Sort       string `json:"sort"`
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+}
+
+// cursor is what PageInfo's cursors decode to: the position of an item in a
+// listing, as the value it is sorted on and its ID. It only holds what the
+// client could see anyway, so it isn't signed - a forged cursor is just a
+// position.
+type cursor struct {
+	Sort   string     `json:"s"`
+	Before bool       `json:"b,omitempty"`
+	Time   *time.Time `json:"c,omitempty"`
+	Value  string     `json:"v,omitempty"`
+	ID     string     `json:"i"`
+}
+
+var errInvalidCursor = xerrors.New("invalid cursor")
+
+func (c *cursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, errInvalidCursor
+	}
+	if _, err := uuid.Parse(c.ID); err != nil {
+		return nil, errInvalidCursor
+	}
+	return &c, nil
+}
+
+// pageParams reads the limit, sort and cursor query parameters shared by
+// listings. sorts are the listing's sorts, the first being the default.
+// Limits above the configured maximum are capped.
+func (a *App) pageParams(r *http.Request, v *validator, sorts ...string) (string, int, *cursor) {
+	query := r.URL.Query()
+	sort := query.Get("sort")
+	limit := a.Config.Server.DefaultPageSize
+
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		switch {
+		case err != nil || n <= 0:
+			v.add("limit", "must be a positive integer")
+		case n > a.Config.Server.MaxPageSize:
+			limit = a.Config.Server.MaxPageSize
+		default:
+			limit = n
+		}
+	}
+
+	if sort != "" && !contains(sorts, sort) {
+		v.add("sort", "must be one of "+strings.Join(sorts, ", "))
+	}
+
+	var c *cursor
+	if s := query.Get("cursor"); s != "" {
+		var err error
+		c, err = decodeCursor(s)
+		switch {
+		case err != nil || !contains(sorts, c.Sort):
+			v.add("cursor", "is invalid")
+			c = nil
+		case sort != "" && sort != c.Sort:
+			v.add("cursor", "is for a different sort")
+			c = nil
+		default:
+			sort = c.Sort
+		}
+	}
+
+	if sort == "" {
+		sort = sorts[0]
+	}
+	return sort, limit, c
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// pageInfo returns the cursors around a page, given cursors for its first and
+// last items (nil if it's empty) and the cursor it was fetched from, if any
+func pageInfo(sort string, limit int, more bool, from, first, last *cursor) PageInfo {
+	info := PageInfo{Sort: sort, Limit: limit}
+	if first == nil {
+		return info
+	}
+
+	// paging from a cursor means there are items on the side it came from
+	backwards := from != nil && from.Before
+	if more || backwards {
+		last.Before = false
+		info.NextCursor = last.encode()
+	}
+	if (more && backwards) || (from != nil && !from.Before) {
+		first.Before = true
+		info.PrevCursor = first.encode()
+	}
+	return info
+}
+
+var postSorts = []string{string(db.SortNewest), string(db.SortOldest), string(db.SortTitle)}
+
+func postCursor(sort db.PostSort, post *models.Post) *cursor {
+	c := &cursor{Sort: string(sort), ID: post.ID}
+	if sort == db.SortTitle {
+		c.Value = post.Title
+	} else {
+		c.Time = &post.CreatedAt
+	}
+	return c
+}
+
+// feedCursor is postCursor for the feed, whose posts are in order of
+// publication
+func feedCursor(sort db.PostSort, post *models.Post) *cursor {
+	c := postCursor(sort, post)
+	if c.Time != nil && post.PublishedAt != nil {
+		c.Time = post.PublishedAt
+	}
+	return c
+}
+
+// postListOptions reads the paging query parameters of a post listing
+func (a *App) postListOptions(r *http.Request, v *validator) (db.PostListOptions, *cursor) {
+	sort, limit, c := a.pageParams(r, v, postSorts...)
+	opts := db.PostListOptions{Sort: db.PostSort(sort), Limit: limit}
+	if c == nil {
+		return opts, nil
+	}
+
+	key := db.PostKey{Title: c.Value, ID: c.ID}
+	if opts.Sort != db.SortTitle {
+		if c.Time == nil {
+			v.add("cursor", "is invalid")
+			return opts, nil
+		}
+		// only the feed goes by PublishedAt
+		key.CreatedAt, key.PublishedAt = *c.Time, *c.Time
+	}
+	if c.Before {
+		opts.Before = &key
+	} else {
+		opts.After = &key
+	}
+	return opts, c
+}
+
+func postPageInfo(page *db.PostPage, opts db.PostListOptions, from *cursor) PageInfo {
+	return postsPageInfo(page, opts, from, postCursor)
+}
+
+func feedPageInfo(page *db.PostPage, opts db.PostListOptions, from *cursor) PageInfo {
+	return postsPageInfo(page, opts, from, feedCursor)
+}
+
+func postsPageInfo(page *db.PostPage, opts db.PostListOptions, from *cursor, cursorOf func(db.PostSort, *models.Post) *cursor) PageInfo {
+	var first, last *cursor
+	if n := len(page.Posts); n > 0 {
+		first, last = cursorOf(opts.Sort, page.Posts[0]), cursorOf(opts.Sort, page.Posts[n-1])
+	}
+	return pageInfo(string(opts.Sort), opts.Limit, page.More, from, first, last)
+}
+
+var userSorts = []string{string(db.UserSortName), string(db.UserSortEmail)}
+
+func userCursor(sort db.UserSort, user *models.User) *cursor {
+	c := &cursor{Sort: string(sort), Value: user.Name, ID: user.ID}
+	if sort == db.UserSortEmail {
+		c.Value = user.Email
+	}
+	return c
+}
+
+// userListOptions reads the search and paging query parameters of the user
+// listing
+func (a *App) userListOptions(r *http.Request, v *validator) (db.UserListOptions, *cursor) {
+	sort, limit, c := a.pageParams(r, v, userSorts...)
+	opts := db.UserListOptions{Sort: db.UserSort(sort), Search: r.URL.Query().Get("search"), Limit: limit}
+	if c == nil {
+		return opts, nil
+	}
+
+	key := db.UserKey{Name: c.Value, ID: c.ID}
+	if opts.Sort == db.UserSortEmail {
+		key = db.UserKey{Email: c.Value, ID: c.ID}
+	}
+	if c.Before {
+		opts.Before = &key
+	} else {
+		opts.After = &key
+	}
+	return opts, c
+}
+
+func userPageInfo(page *db.UserPage, opts db.UserListOptions, from *cursor) PageInfo {
+	var first, last *cursor
+	if n := len(page.Users); n > 0 {
+		first, last = userCursor(opts.Sort, page.Users[0]), userCursor(opts.Sort, page.Users[n-1])
+	}
+	return pageInfo(string(opts.Sort), opts.Limit, page.More, from, first, last)
+}
+
+var commentSorts = []string{string(db.CommentSortOldest), string(db.CommentSortNewest)}
+
+func commentCursor(sort db.CommentSort, comment *models.Comment) *cursor {
+	return &cursor{Sort: string(sort), Time: &comment.CreatedAt, ID: comment.ID}
+}
+
+// commentListOptions reads the paging query parameters of a thread
+func (a *App) commentListOptions(r *http.Request, v *validator) (db.CommentListOptions, *cursor) {
+	sort, limit, c := a.pageParams(r, v, commentSorts...)
+	opts := db.CommentListOptions{Sort: db.CommentSort(sort), Limit: limit}
+	if c == nil {
+		return opts, nil
+	}
+	if c.Time == nil {
+		v.add("cursor", "is invalid")
+		return opts, nil
+	}
+
+	key := db.CommentKey{CreatedAt: *c.Time, ID: c.ID}
+	if c.Before {
+		opts.Before = &key
+	} else {
+		opts.After = &key
+	}
+	return opts, c
+}
+
+func commentPageInfo(page *db.CommentPage, opts db.CommentListOptions, from *cursor) PageInfo {
+	var first, last *cursor
+	if n := len(page.Comments); n > 0 {
+		first, last = commentCursor(opts.Sort, page.Comments[0]), commentCursor(opts.Sort, page.Comments[n-1])
+	}
+	return pageInfo(string(opts.Sort), opts.Limit, page.More, from, first, last)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gavinc95/go-blog/db/models"
+	"github.com/stretchr/testify/require"
+)
+
+func listPosts(t *testing.T, userID string, query url.Values) (*GetAllPostsResponse, int) {
+	req, err := http.NewRequest("GET", "/users/"+userID+"/posts?"+query.Encode(), nil)
+	require.NoError(t, err)
+	resp := executeRequest(req)
+	if resp.Code != http.StatusOK {
+		return nil, resp.Code
+	}
+
+	var res GetAllPostsResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &res))
+	return &res, resp.Code
+}
+
+func TestGetAllPosts_Pagination(t *testing.T) {
+	clearTable()
+
+	userID, err := app.BlogStore.CreateUser("tiny cat", "tiny@cat.com", "author")
+	require.NoError(t, err)
+	var oldest []string
+	for i := 0; i < 5; i++ {
+		postID, err := app.BlogStore.CreatePost(userID, fmt.Sprintf("post %d", i), "content", models.FormatPlain, models.PostPublished, time.Time{})
+		require.NoError(t, err)
+		oldest = append(oldest, postID)
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	// the first page, newest first by default
+	res, code := listPosts(t, userID, url.Values{"limit": {"2"}})
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "newest", res.Page.Sort)
+	require.Equal(t, 2, res.Page.Limit)
+	require.Equal(t, []string{oldest[4], oldest[3]}, responseIDs(res))
+	require.NotEmpty(t, res.Page.NextCursor)
+	require.Empty(t, res.Page.PrevCursor)
+
+	// the cursor carries the sort, so it isn't repeated
+	res, _ = listPosts(t, userID, url.Values{"limit": {"2"}, "cursor": {res.Page.NextCursor}})
+	require.Equal(t, []string{oldest[2], oldest[1]}, responseIDs(res))
+	require.NotEmpty(t, res.Page.PrevCursor)
+	res, _ = listPosts(t, userID, url.Values{"limit": {"2"}, "cursor": {res.Page.NextCursor}})
+	require.Equal(t, []string{oldest[0]}, responseIDs(res))
+	require.Empty(t, res.Page.NextCursor)
+
+	// and back
+	res, _ = listPosts(t, userID, url.Values{"limit": {"2"}, "cursor": {res.Page.PrevCursor}})
+	require.Equal(t, []string{oldest[2], oldest[1]}, responseIDs(res))
+	res, _ = listPosts(t, userID, url.Values{"limit": {"2"}, "cursor": {res.Page.PrevCursor}})
+	require.Equal(t, []string{oldest[4], oldest[3]}, responseIDs(res))
+	require.Empty(t, res.Page.PrevCursor)
+	require.NotEmpty(t, res.Page.NextCursor)
+
+	res, _ = listPosts(t, userID, url.Values{"sort": {"oldest"}, "limit": {"3"}})
+	require.Equal(t, oldest[:3], responseIDs(res))
+	oldestCursor := res.Page.NextCursor
+
+	// limits are capped
+	res, _ = listPosts(t, userID, url.Values{"limit": {"100000"}})
+	require.Equal(t, app.Config.Server.MaxPageSize, res.Page.Limit)
+	require.Len(t, res.Posts, 5)
+
+	for _, query := range []url.Values{
+		{"limit": {"0"}},
+		{"limit": {"lots"}},
+		{"sort": {"popular"}},
+		{"cursor": {"not a cursor"}},
+		{"cursor": {oldestCursor}, "sort": {"title"}},
+		{"cursor": {(&cursor{Sort: "newest", ID: "not-a-uuid"}).encode()}},
+	} {
+		_, code := listPosts(t, userID, query)
+		require.Equal(t, http.StatusBadRequest, code, "query %v", query)
+	}
+}
+
+func responseIDs(res *GetAllPostsResponse) []string {
+	var ids []string
+	for _, post := range res.Posts {
+		ids = append(ids, post.ID)
+	}
+	return ids
+}
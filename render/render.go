@@ -0,0 +1,71 @@
+// Package render turns post content into the HTML shown to readers. Whatever
+// the format, the output passes through an allow-list sanitizer, so no post
+// can run script in a reader's browser.
+package render
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/gavinc95/go-blog/db/models"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	goldmarkhtml "github.com/yuin/goldmark/renderer/html"
+	"golang.org/x/xerrors"
+)
+
+// markdown follows CommonMark with the GitHub extensions (tables,
+// strikethrough, autolinks). Raw HTML is kept, for the sanitizer to vet.
+var markdown = goldmark.New(
+	goldmark.WithExtensions(extension.GFM),
+	goldmark.WithRendererOptions(goldmarkhtml.WithUnsafe()),
+)
+
+// policy allows the markup of user-generated content: formatting, links,
+// images, lists and tables, but no scripts, styles, forms or event handlers.
+// Links get rel="nofollow". Code blocks keep the language class that syntax
+// highlighters look for.
+var policy = func() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+-]+$`)).OnElements("code")
+	return p
+}()
+
+// paragraphBreak separates paragraphs of plain text
+var paragraphBreak = regexp.MustCompile(`\n[ \t]*\n\s*`)
+
+// HTML renders source, written in format, as sanitized HTML
+func HTML(format models.ContentFormat, source string) (string, error) {
+	switch format {
+	case models.FormatPlain:
+		return plain(source), nil
+	case models.FormatMarkdown:
+		var buf bytes.Buffer
+		if err := markdown.Convert([]byte(source), &buf); err != nil {
+			return "", xerrors.Errorf("failed to render markdown: %w", err)
+		}
+		return policy.Sanitize(buf.String()), nil
+	case models.FormatHTML:
+		return policy.Sanitize(source), nil
+	}
+	return "", xerrors.Errorf("unknown content format %q", format)
+}
+
+// plain escapes text, keeping its paragraphs and line breaks
+func plain(source string) string {
+	source = strings.TrimSpace(strings.ReplaceAll(source, "\r\n", "\n"))
+	if source == "" {
+		return ""
+	}
+
+	var b strings.Builder
+	for _, paragraph := range paragraphBreak.Split(source, -1) {
+		b.WriteString("<p>")
+		b.WriteString(strings.ReplaceAll(html.EscapeString(paragraph), "\n", "<br>\n"))
+		b.WriteString("</p>\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,36 @@
+package render
+
+import (
+	"testing"
+
+	"github.com/gavinc95/go-blog/db/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTML(t *testing.T) {
+	tests := []struct {
+		name   string
+		format models.ContentFormat
+		source string
+		want   string
+	}{
+		{"plain", models.FormatPlain, "a <b>\nline\n\n  next", "<p>a &lt;b&gt;<br>\nline</p>\n<p>next</p>\n"},
+		{"plain empty", models.FormatPlain, " \n ", ""},
+		{"markdown", models.FormatMarkdown, "# Title\n\n*hi* ~~there~~", "<h1>Title</h1>\n<p><em>hi</em> <del>there</del></p>\n"},
+		{"markdown code", models.FormatMarkdown, "```go\nx := 1\n```", "<pre><code class=\"language-go\">x := 1\n</code></pre>\n"},
+		{"markdown links", models.FormatMarkdown, "[cat](https://cat.com)", "<p><a href=\"https://cat.com\" rel=\"nofollow\">cat</a></p>\n"},
+		{"markdown script", models.FormatMarkdown, "hi <script>alert(1)</script>", "<p>hi </p>\n"},
+		{"markdown javascript link", models.FormatMarkdown, "[x](javascript:alert(1))", "<p>x</p>\n"},
+		{"html", models.FormatHTML, `<p onclick="steal()">hi</p><img src="x.png" onerror="steal()"><style>p{}</style>`, `<p>hi</p><img src="x.png">`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := HTML(tt.format, tt.source)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := HTML("rtf", "hi")
+	require.Error(t, err)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gavinc95/go-blog/authz"
+	"github.com/gavinc95/go-blog/db/models"
+	"github.com/gavinc95/go-blog/diff"
+	"github.com/gorilla/mux"
+)
+
+type GetRevisionsResponse struct {
+	Revisions []*models.PostRevision `json:"revisions"`
+}
+
+type GetRevisionResponse struct {
+	Revision *models.PostRevision `json:"revision"`
+}
+
+// GetRevisionDiffResponse lists the line-level changes between two revisions
+type GetRevisionDiffResponse struct {
+	From    int         `json:"from"`
+	To      int         `json:"to"`
+	Title   []diff.Line `json:"title"`
+	Content []diff.Line `json:"content"`
+}
+
+type RestoreRevisionResponse struct {
+	ID       string `json:"id"`
+	Revision int    `json:"revision"` // the new revision recording the restore
+}
+
+// A post's history can hold text its author has since taken out, so only
+// those who can edit the post get to see it.
+
+func (a *App) HandleGetRevisions(w http.ResponseWriter, r *http.Request) {
+	postID := mux.Vars(r)["id"]
+	if !a.authorizePost(w, r, authz.Update, postID) {
+		return
+	}
+
+	revisions, err := a.BlogStore.GetRevisions(postID)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	res := GetRevisionsResponse{Revisions: revisions}
+	if res.Revisions == nil {
+		res.Revisions = []*models.PostRevision{}
+	}
+	writeJSON(w, r, http.StatusOK, res)
+}
+
+func (a *App) HandleGetRevision(w http.ResponseWriter, r *http.Request) {
+	postID := mux.Vars(r)["id"]
+	if !a.authorizePost(w, r, authz.Update, postID) {
+		return
+	}
+
+	rev, ok := a.getRevision(w, r, postID, mux.Vars(r)["number"])
+	if !ok {
+		return
+	}
+
+	res := GetRevisionResponse{Revision: rev}
+	writeJSON(w, r, http.StatusOK, res)
+}
+
+// HandleGetRevisionDiff compares revision from with revision to, or with the
+// latest revision if to isn't given
+func (a *App) HandleGetRevisionDiff(w http.ResponseWriter, r *http.Request) {
+	postID := mux.Vars(r)["id"]
+	query := r.URL.Query()
+
+	// validate the request
+	var v validator
+	v.required("from", query.Get("from"))
+	v.revision("from", query.Get("from"))
+	v.revision("to", query.Get("to"))
+	if v.failed() {
+		writeValidationError(w, r, v.details)
+		return
+	}
+
+	if !a.authorizePost(w, r, authz.Update, postID) {
+		return
+	}
+
+	from, ok := a.getRevision(w, r, postID, query.Get("from"))
+	if !ok {
+		return
+	}
+	var to *models.PostRevision
+	if query.Get("to") != "" {
+		if to, ok = a.getRevision(w, r, postID, query.Get("to")); !ok {
+			return
+		}
+	} else {
+		revisions, err := a.BlogStore.GetRevisions(postID)
+		if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		// the post may have been deleted since from was read
+		if len(revisions) == 0 {
+			writeError(w, r, http.StatusNotFound, CodeNotFound, "revision not found")
+			return
+		}
+		to = revisions[len(revisions)-1]
+	}
+
+	res := GetRevisionDiffResponse{
+		From:    from.Number,
+		To:      to.Number,
+		Title:   diff.Lines(from.Title, to.Title),
+		Content: diff.Lines(from.Content, to.Content),
+	}
+	writeJSON(w, r, http.StatusOK, res)
+}
+
+// HandleRestoreRevision makes an old revision's title and content current
+// again. The history isn't rewritten: the restore is a new revision.
+func (a *App) HandleRestoreRevision(w http.ResponseWriter, r *http.Request) {
+	postID := mux.Vars(r)["id"]
+	if !a.authorizePost(w, r, authz.Update, postID) {
+		return
+	}
+
+	number, err := strconv.Atoi(mux.Vars(r)["number"])
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "revision not found")
+		return
+	}
+	restored, err := a.BlogStore.RestoreRevision(postID, number, currentUserID(r))
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	res := RestoreRevisionResponse{ID: postID, Revision: restored}
+	writeJSON(w, r, http.StatusOK, res)
+}
+
+// getRevision fetches a revision by its number, writing a 404 if the post
+// has no such revision
+func (a *App) getRevision(w http.ResponseWriter, r *http.Request, postID, number string) (*models.PostRevision, bool) {
+	n, err := strconv.Atoi(number)
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "revision not found")
+		return nil, false
+	}
+
+	rev, err := a.BlogStore.GetRevision(postID, n)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return nil, false
+	}
+	if rev == nil {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "revision not found")
+		return nil, false
+	}
+	return rev, true
+}
+
+// revision checks an optional revision number
+func (v *validator) revision(field, val string) {
+	if val == "" {
+		return
+	}
+	if n, err := strconv.Atoi(val); err != nil || n < 1 {
+		v.add(field, "must be a revision number")
+	}
+}
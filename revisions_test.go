@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gavinc95/go-blog/db/models"
+	"github.com/gavinc95/go-blog/diff"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRevisions(t *testing.T) {
+	clearTable()
+
+	uuidGenerator.shouldGenUserID = true
+	createTestUser(t, "tiny cat", "tiny@cat.com")
+	uuidGenerator.shouldGenUserID = false
+	authorID := sampleUserID
+	otherID, err := app.BlogStore.CreateUser("other cat", "other@cat.com", "author")
+	require.NoError(t, err)
+	postID, err := app.BlogStore.CreatePost(authorID, "title", "one\ntwo\nthree", models.FormatPlain, models.PostPublished, time.Time{})
+	require.NoError(t, err)
+
+	resp := updateTestPost(t, postID, "", "one\n2\nthree")
+	checkResponseCode(t, http.StatusOK, resp.Code)
+
+	resp = authedRequest(t, "GET", "/posts/"+postID+"/revisions", authorID, nil)
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	var list GetRevisionsResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &list))
+	require.Len(t, list.Revisions, 2)
+	require.Equal(t, "one\ntwo\nthree", list.Revisions[0].Content)
+
+	// the history is only for those who can edit the post
+	resp = authedRequest(t, "GET", "/posts/"+postID+"/revisions", otherID, nil)
+	checkResponseCode(t, http.StatusForbidden, resp.Code)
+	resp = authedRequest(t, "GET", "/posts/"+postID+"/revisions/1", "", nil)
+	checkResponseCode(t, http.StatusUnauthorized, resp.Code)
+
+	resp = authedRequest(t, "GET", "/posts/"+postID+"/revisions/1", authorID, nil)
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	var one GetRevisionResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &one))
+	require.Equal(t, 1, one.Revision.Number)
+	require.Equal(t, authorID, one.Revision.CreatedBy)
+	resp = authedRequest(t, "GET", "/posts/"+postID+"/revisions/9", authorID, nil)
+	checkResponseCode(t, http.StatusNotFound, resp.Code)
+
+	// to defaults to the latest revision
+	resp = authedRequest(t, "GET", "/posts/"+postID+"/diff?"+url.Values{"from": {"1"}}.Encode(), authorID, nil)
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	var changes GetRevisionDiffResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &changes))
+	require.Equal(t, 2, changes.To)
+	require.Equal(t, []diff.Line{{Op: diff.Equal, Text: "title"}}, changes.Title)
+	require.Equal(t, []diff.Line{
+		{Op: diff.Equal, Text: "one"},
+		{Op: diff.Delete, Text: "two"},
+		{Op: diff.Insert, Text: "2"},
+		{Op: diff.Equal, Text: "three"},
+	}, changes.Content)
+
+	for _, query := range []url.Values{{}, {"from": {"first"}}, {"from": {"1"}, "to": {"0"}}} {
+		resp = authedRequest(t, "GET", "/posts/"+postID+"/diff?"+query.Encode(), authorID, nil)
+		checkResponseCode(t, http.StatusBadRequest, resp.Code)
+	}
+	resp = authedRequest(t, "GET", "/posts/"+postID+"/diff?"+url.Values{"from": {"1"}, "to": {"3"}}.Encode(), authorID, nil)
+	checkResponseCode(t, http.StatusNotFound, resp.Code)
+
+	// restoring adds a revision rather than rewriting the history
+	resp = authedRequest(t, "POST", "/posts/"+postID+"/revisions/1/restore", otherID, nil)
+	checkResponseCode(t, http.StatusForbidden, resp.Code)
+	resp = authedRequest(t, "POST", "/posts/"+postID+"/revisions/1/restore", authorID, nil)
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	var restored RestoreRevisionResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &restored))
+	require.Equal(t, RestoreRevisionResponse{ID: postID, Revision: 3}, restored)
+	post, err := app.BlogStore.GetPost(postID)
+	require.NoError(t, err)
+	require.Equal(t, "one\ntwo\nthree", post.Content)
+
+	resp = authedRequest(t, "POST", "/posts/"+postID+"/revisions/7/restore", authorID, nil)
+	checkResponseCode(t, http.StatusNotFound, resp.Code)
+	requireErrorCode(t, resp, CodeNotFound)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gavinc95/go-blog/authz"
+	"github.com/gavinc95/go-blog/db/models"
+	"github.com/gorilla/mux"
+)
+
+type GetRolesResponse struct {
+	Roles []*models.Role `json:"roles"`
+}
+
+type GetUserRolesResponse struct {
+	Roles []string `json:"roles"`
+}
+
+// HandleGetRoles lists every role and the permissions it grants
+func (a *App) HandleGetRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := a.BlogStore.GetRoles()
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	res := GetRolesResponse{Roles: roles}
+	writeJSON(w, r, http.StatusOK, res)
+}
+
+// authorizeUserRoles checks that the caller may act on the roles of the user
+// in the path, and that the user exists
+func (a *App) authorizeUserRoles(w http.ResponseWriter, r *http.Request, action authz.Action) (string, bool) {
+	userID := mux.Vars(r)["id"]
+	if !a.authorize(w, r, action, authz.UserRoles(userID)) {
+		return "", false
+	}
+
+	user, err := a.BlogStore.GetUser(userID)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return "", false
+	}
+	if user == nil {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "user not found")
+		return "", false
+	}
+	return userID, true
+}
+
+func (a *App) HandleGetUserRoles(w http.ResponseWriter, r *http.Request) {
+	userID, ok := a.authorizeUserRoles(w, r, authz.Read)
+	if !ok {
+		return
+	}
+
+	roles, err := a.BlogStore.GetUserRoles(userID)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	res := GetUserRolesResponse{Roles: roles}
+	if res.Roles == nil {
+		res.Roles = []string{}
+	}
+	writeJSON(w, r, http.StatusOK, res)
+}
+
+// HandleGrantRole grants the role in the path to the user. Granting a role
+// the user already has succeeds.
+func (a *App) HandleGrantRole(w http.ResponseWriter, r *http.Request) {
+	userID, ok := a.authorizeUserRoles(w, r, authz.Grant)
+	if !ok {
+		return
+	}
+
+	if err := a.BlogStore.GrantRole(userID, mux.Vars(r)["role"]); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleRevokeRole takes the role in the path away from the user. Revoking a
+// role the user doesn't have succeeds.
+func (a *App) HandleRevokeRole(w http.ResponseWriter, r *http.Request) {
+	userID, ok := a.authorizeUserRoles(w, r, authz.Revoke)
+	if !ok {
+		return
+	}
+
+	if err := a.BlogStore.RevokeRole(userID, mux.Vars(r)["role"]); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
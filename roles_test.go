@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func getUserRoles(t *testing.T, userID, asUserID string) []string {
+	resp := authedRequest(t, "GET", "/users/"+userID+"/roles", asUserID, nil)
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	var res GetUserRolesResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &res))
+	return res.Roles
+}
+
+func TestGetRoles(t *testing.T) {
+	clearTable()
+
+	resp := authedRequest(t, "GET", "/roles", "", nil)
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	var res GetRolesResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &res))
+	require.Len(t, res.Roles, 4)
+	require.Equal(t, "admin", res.Roles[0].Name)
+	require.Contains(t, res.Roles[0].Permissions, "role.grant.any")
+}
+
+func TestUserRoles(t *testing.T) {
+	clearTable()
+
+	// new users get the default role
+	uuidGenerator.shouldGenUserID = true
+	createTestUser(t, "tiny cat", "tiny@cat.com")
+	uuidGenerator.shouldGenUserID = false
+	require.Equal(t, []string{"author"}, getUserRoles(t, sampleUserID, sampleUserID))
+
+	adminID := createTestAdmin(t)
+
+	// only admins can see other users' roles, or change anyone's
+	resp := authedRequest(t, "GET", "/users/"+adminID+"/roles", sampleUserID, nil)
+	checkResponseCode(t, http.StatusForbidden, resp.Code)
+	requireErrorCode(t, resp, CodeForbidden)
+	resp = authedRequest(t, "PUT", "/users/"+sampleUserID+"/roles/admin", sampleUserID, nil)
+	checkResponseCode(t, http.StatusForbidden, resp.Code)
+	resp = authedRequest(t, "PUT", "/users/"+sampleUserID+"/roles/admin", "", nil)
+	checkResponseCode(t, http.StatusUnauthorized, resp.Code)
+
+	// an author demoted to reader can no longer post
+	resp = authedRequest(t, "PUT", "/users/"+sampleUserID+"/roles/reader", adminID, nil)
+	checkResponseCode(t, http.StatusNoContent, resp.Code)
+	resp = authedRequest(t, "DELETE", "/users/"+sampleUserID+"/roles/author", adminID, nil)
+	checkResponseCode(t, http.StatusNoContent, resp.Code)
+	require.Equal(t, []string{"reader"}, getUserRoles(t, sampleUserID, adminID))
+
+	resp = createTestPost(t, sampleUserID, "title", "content")
+	checkResponseCode(t, http.StatusForbidden, resp.Code)
+	requireErrorCode(t, resp, CodeForbidden)
+
+	// but can still manage their account
+	resp = updateTestUser(t, sampleUserID, "tiny reader", "")
+	checkResponseCode(t, http.StatusOK, resp.Code)
+
+	// unknown users and roles
+	resp = authedRequest(t, "PUT", "/users/"+samplePostID+"/roles/editor", adminID, nil)
+	checkResponseCode(t, http.StatusNotFound, resp.Code)
+	requireErrorCode(t, resp, CodeNotFound)
+	resp = authedRequest(t, "PUT", "/users/"+sampleUserID+"/roles/overlord", adminID, nil)
+	checkResponseCode(t, http.StatusUnprocessableEntity, resp.Code)
+	requireErrorCode(t, resp, CodeForeignKey)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gavinc95/go-blog/db"
+	"github.com/gavinc95/go-blog/db/models"
+)
+
+type SearchResponse struct {
+	Results []*models.SearchResult `json:"results"`
+	Page    PageInfo               `json:"page"`
+}
+
+// search results can only be paged forwards, so they have a single sort
+const searchSort = "rank"
+
+// HandleSearch searches published posts for q, best match first. Each result
+// has a snippet of the post's content with the matches highlighted.
+func (a *App) HandleSearch(w http.ResponseWriter, r *http.Request) {
+	// validate the request
+	var v validator
+	text := r.URL.Query().Get("q")
+	v.required("q", text)
+	_, limit, c := a.pageParams(r, &v, searchSort)
+	query := db.SearchQuery{Text: text, Limit: limit}
+	if c != nil {
+		rank, err := strconv.ParseFloat(c.Value, 64)
+		if err != nil || c.Before {
+			v.add("cursor", "is invalid")
+		}
+		query.After = &db.SearchKey{Rank: rank, ID: c.ID}
+	}
+	if v.failed() {
+		writeValidationError(w, r, v.details)
+		return
+	}
+
+	page, err := a.BlogStore.SearchPosts(query)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	res := SearchResponse{Results: page.Results, Page: PageInfo{Sort: searchSort, Limit: limit}}
+	if res.Results == nil {
+		res.Results = []*models.SearchResult{}
+	}
+	if n := len(page.Results); page.More && n > 0 {
+		last := page.Results[n-1]
+		next := cursor{Sort: searchSort, Value: strconv.FormatFloat(last.Rank, 'g', -1, 64), ID: last.Post.ID}
+		res.Page.NextCursor = next.encode()
+	}
+	writeJSON(w, r, http.StatusOK, res)
+}
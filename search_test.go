@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gavinc95/go-blog/db/models"
+	"github.com/stretchr/testify/require"
+)
+
+func searchRequest(t *testing.T, query url.Values) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", "/search?"+query.Encode(), nil)
+	require.NoError(t, err)
+	return executeRequest(req)
+}
+
+func TestSearch(t *testing.T) {
+	clearTable()
+
+	authorID, err := app.BlogStore.CreateUser("tiny cat", "tiny@cat.com", "author")
+	require.NoError(t, err)
+	titleID, err := app.BlogStore.CreatePost(authorID, "kittens", "all about cats", models.FormatPlain, models.PostPublished, time.Time{})
+	require.NoError(t, err)
+	contentID, err := app.BlogStore.CreatePost(authorID, "pets", "my kittens <b>sleep</b> a lot", models.FormatPlain, models.PostPublished, time.Time{})
+	require.NoError(t, err)
+	_, err = app.BlogStore.CreatePost(authorID, "drafts", "secret kittens", models.FormatPlain, models.PostDraft, time.Time{})
+	require.NoError(t, err)
+
+	resp := searchRequest(t, url.Values{})
+	checkResponseCode(t, http.StatusBadRequest, resp.Code)
+	requireErrorCode(t, resp, CodeInvalidRequest)
+	resp = searchRequest(t, url.Values{"q": {"-kittens"}})
+	checkResponseCode(t, http.StatusUnprocessableEntity, resp.Code)
+
+	// a match in the title ranks above one in the content, and drafts are left out
+	resp = searchRequest(t, url.Values{"q": {"kittens"}, "limit": {"1"}})
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	var res SearchResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &res))
+	require.Len(t, res.Results, 1)
+	require.Equal(t, titleID, res.Results[0].Post.ID)
+	require.NotEmpty(t, res.Page.NextCursor)
+
+	resp = searchRequest(t, url.Values{"q": {"kittens"}, "cursor": {res.Page.NextCursor}})
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	res = SearchResponse{}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &res))
+	require.Len(t, res.Results, 1)
+	require.Equal(t, contentID, res.Results[0].Post.ID)
+	require.Contains(t, res.Results[0].Snippet, "<mark>kittens</mark>")
+	require.Contains(t, res.Results[0].Snippet, "&lt;b&gt;")
+	require.Empty(t, res.Page.NextCursor)
+
+	resp = searchRequest(t, url.Values{"q": {"kittens"}, "cursor": {"nope"}})
+	checkResponseCode(t, http.StatusBadRequest, resp.Code)
+}
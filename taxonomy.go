@@ -0,0 +1,241 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gavinc95/go-blog/authz"
+	"github.com/gavinc95/go-blog/db/models"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+type GetTagsResponse struct {
+	Tags []*models.TagCount `json:"tags"`
+}
+
+type GetCategoriesResponse struct {
+	Categories []*models.Category `json:"categories"`
+}
+
+type GetCategoryResponse struct {
+	Category *models.Category `json:"category"`
+}
+
+type CreateCategoryRequest struct {
+	Name     string `json:"name"`      // required
+	ParentID string `json:"parent_id"` // empty for a top-level category
+}
+
+type CreateCategoryResponse struct {
+	ID string `json:"id"`
+}
+
+// tags are lowercase words joined by hyphens, e.g. "go" or "web-dev"
+var tagPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+const maxTagLength = 50
+
+// normalizeTag lowercases a tag, so that "Go" and "go" are the same tag
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+func (v *validator) tag(field, val string) {
+	if len(val) > maxTagLength || !tagPattern.MatchString(val) {
+		v.add(field, "must be letters and digits, optionally joined by hyphens, at most 50 characters")
+	}
+}
+
+// id checks an optional ID
+func (v *validator) id(field, val string) {
+	if val == "" {
+		return
+	}
+	if _, err := uuid.Parse(val); err != nil {
+		v.add(field, "must be an ID")
+	}
+}
+
+// HandleGetTags lists the tags of published posts, most used first, for tag
+// clouds. limit keeps only the top ones.
+func (a *App) HandleGetTags(w http.ResponseWriter, r *http.Request) {
+	// validate the request
+	var v validator
+	limit := 0
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			v.add("limit", "must be a positive integer")
+		}
+		limit = n
+	}
+	if v.failed() {
+		writeValidationError(w, r, v.details)
+		return
+	}
+
+	tags, err := a.BlogStore.GetTagCounts(limit)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	res := GetTagsResponse{Tags: tags}
+	if res.Tags == nil {
+		res.Tags = []*models.TagCount{}
+	}
+	writeJSON(w, r, http.StatusOK, res)
+}
+
+func (a *App) HandleTagPost(w http.ResponseWriter, r *http.Request) {
+	a.changeTag(w, r, a.BlogStore.TagPost)
+}
+
+func (a *App) HandleUntagPost(w http.ResponseWriter, r *http.Request) {
+	a.changeTag(w, r, a.BlogStore.UntagPost)
+}
+
+// changeTag adds a tag to a post or takes one off, as whoever can edit it
+func (a *App) changeTag(w http.ResponseWriter, r *http.Request, change func(postID, tag string) error) {
+	postID := mux.Vars(r)["id"]
+	tag := normalizeTag(mux.Vars(r)["tag"])
+
+	// validate the request
+	var v validator
+	v.tag("tag", tag)
+	if v.failed() {
+		writeValidationError(w, r, v.details)
+		return
+	}
+
+	if !a.authorizePost(w, r, authz.Update, postID) {
+		return
+	}
+
+	if err := change(postID, tag); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *App) HandleGetCategories(w http.ResponseWriter, r *http.Request) {
+	categories, err := a.BlogStore.GetCategories()
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	res := GetCategoriesResponse{Categories: categories}
+	if res.Categories == nil {
+		res.Categories = []*models.Category{}
+	}
+	writeJSON(w, r, http.StatusOK, res)
+}
+
+func (a *App) HandleGetCategory(w http.ResponseWriter, r *http.Request) {
+	category, err := a.BlogStore.GetCategory(mux.Vars(r)["id"])
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if category == nil {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "category not found")
+		return
+	}
+
+	res := GetCategoryResponse{Category: category}
+	writeJSON(w, r, http.StatusOK, res)
+}
+
+func (a *App) HandleCreateCategory(w http.ResponseWriter, r *http.Request) {
+	var req CreateCategoryRequest
+	err := decodeRequest(r, &req)
+	if err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	// validate the request
+	var v validator
+	req.Name = strings.TrimSpace(req.Name)
+	v.required("name", req.Name)
+	v.id("parent_id", req.ParentID)
+	if v.failed() {
+		writeValidationError(w, r, v.details)
+		return
+	}
+
+	if !a.authorize(w, r, authz.Create, authz.Categories()) {
+		return
+	}
+
+	id, err := a.BlogStore.CreateCategory(req.Name, req.ParentID)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	res := CreateCategoryResponse{ID: id}
+	a.setLocation(w, "category", id)
+	writeJSON(w, r, http.StatusCreated, res)
+}
+
+// HandleDeleteCategory takes the category off its posts, which stay in their
+// other categories. Subcategories have to be deleted first.
+func (a *App) HandleDeleteCategory(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r, authz.Delete, authz.Categories()) {
+		return
+	}
+
+	if _, err := a.BlogStore.DeleteCategory(mux.Vars(r)["id"]); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *App) HandleGetPostCategories(w http.ResponseWriter, r *http.Request) {
+	postID := mux.Vars(r)["id"]
+	if _, ok := a.readablePost(w, r, postID); !ok {
+		return
+	}
+
+	categories, err := a.BlogStore.GetPostCategories(postID)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	res := GetCategoriesResponse{Categories: categories}
+	if res.Categories == nil {
+		res.Categories = []*models.Category{}
+	}
+	writeJSON(w, r, http.StatusOK, res)
+}
+
+func (a *App) HandleCategorizePost(w http.ResponseWriter, r *http.Request) {
+	a.changeCategory(w, r, a.BlogStore.CategorizePost)
+}
+
+func (a *App) HandleUncategorizePost(w http.ResponseWriter, r *http.Request) {
+	a.changeCategory(w, r, a.BlogStore.UncategorizePost)
+}
+
+// changeCategory adds a post to a category or takes it out, as whoever can
+// edit the post
+func (a *App) changeCategory(w http.ResponseWriter, r *http.Request, change func(postID, categoryID string) error) {
+	postID := mux.Vars(r)["id"]
+	if !a.authorizePost(w, r, authz.Update, postID) {
+		return
+	}
+
+	if err := change(postID, mux.Vars(r)["category"]); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gavinc95/go-blog/db/models"
+	"github.com/stretchr/testify/require"
+)
+
+func feedIDs(t *testing.T, resp *httptest.ResponseRecorder) []string {
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	var res GetFeedResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &res))
+	var ids []string
+	for _, post := range res.Posts {
+		ids = append(ids, post.ID)
+	}
+	return ids
+}
+
+func TestTags(t *testing.T) {
+	clearTable()
+
+	authorID, err := app.BlogStore.CreateUser("tiny cat", "tiny@cat.com", "author")
+	require.NoError(t, err)
+	otherID, err := app.BlogStore.CreateUser("other cat", "other@cat.com", "author")
+	require.NoError(t, err)
+	postID, err := app.BlogStore.CreatePost(authorID, "title", "content", models.FormatPlain, models.PostPublished, time.Time{})
+	require.NoError(t, err)
+
+	// tags are normalized, and only the post's editors can change them
+	resp := authedRequest(t, "PUT", "/posts/"+postID+"/tags/Cats", authorID, nil)
+	checkResponseCode(t, http.StatusNoContent, resp.Code)
+	resp = authedRequest(t, "PUT", "/posts/"+postID+"/tags/web-dev", authorID, nil)
+	checkResponseCode(t, http.StatusNoContent, resp.Code)
+	resp = authedRequest(t, "PUT", "/posts/"+postID+"/tags/dogs", otherID, nil)
+	checkResponseCode(t, http.StatusForbidden, resp.Code)
+	resp = authedRequest(t, "PUT", "/posts/"+postID+"/tags/no_underscores", authorID, nil)
+	checkResponseCode(t, http.StatusBadRequest, resp.Code)
+	requireErrorCode(t, resp, CodeInvalidRequest)
+
+	resp = getTestPost(t, postID)
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	var got GetPostResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &got))
+	require.Equal(t, []string{"cats", "web-dev"}, got.Post.Tags)
+
+	resp = authedRequest(t, "GET", "/tags", "", nil)
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	var tags GetTagsResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &tags))
+	require.Equal(t, []*models.TagCount{{Tag: "cats", Count: 1}, {Tag: "web-dev", Count: 1}}, tags.Tags)
+	resp = authedRequest(t, "GET", "/tags?limit=0", "", nil)
+	checkResponseCode(t, http.StatusBadRequest, resp.Code)
+
+	require.Equal(t, []string{postID}, feedIDs(t, authedRequest(t, "GET", "/tags/cats/posts", "", nil)))
+	require.Equal(t, []string{postID}, feedIDs(t, authedRequest(t, "GET", "/feed?tag=CATS", "", nil)))
+
+	resp = authedRequest(t, "DELETE", "/posts/"+postID+"/tags/cats", authorID, nil)
+	checkResponseCode(t, http.StatusNoContent, resp.Code)
+	require.Empty(t, feedIDs(t, authedRequest(t, "GET", "/tags/cats/posts", "", nil)))
+}
+
+func TestCategories(t *testing.T) {
+	clearTable()
+
+	authorID, err := app.BlogStore.CreateUser("tiny cat", "tiny@cat.com", "author")
+	require.NoError(t, err)
+	adminID := createTestAdmin(t)
+	postID, err := app.BlogStore.CreatePost(authorID, "title", "content", models.FormatPlain, models.PostPublished, time.Time{})
+	require.NoError(t, err)
+
+	// only admins manage the tree
+	resp := authedRequest(t, "POST", "/categories", authorID, CreateCategoryRequest{Name: "animals"})
+	checkResponseCode(t, http.StatusForbidden, resp.Code)
+	resp = authedRequest(t, "POST", "/categories", adminID, CreateCategoryRequest{Name: "animals"})
+	checkResponseCode(t, http.StatusCreated, resp.Code)
+	var created CreateCategoryResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &created))
+	animalsID := created.ID
+	require.Equal(t, "/categories/"+animalsID, resp.Header().Get("Location"))
+
+	resp = authedRequest(t, "POST", "/categories", adminID, CreateCategoryRequest{Name: "pets", ParentID: animalsID})
+	checkResponseCode(t, http.StatusCreated, resp.Code)
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &created))
+	petsID := created.ID
+	resp = authedRequest(t, "POST", "/categories", adminID, CreateCategoryRequest{Name: "pets", ParentID: animalsID})
+	checkResponseCode(t, http.StatusConflict, resp.Code)
+	resp = authedRequest(t, "POST", "/categories", adminID, CreateCategoryRequest{Name: "pets", ParentID: "nope"})
+	checkResponseCode(t, http.StatusBadRequest, resp.Code)
+
+	resp = authedRequest(t, "GET", "/categories", "", nil)
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	var list GetCategoriesResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &list))
+	require.Len(t, list.Categories, 2)
+
+	// a post filed under a subcategory shows up in its parent too
+	resp = authedRequest(t, "PUT", "/posts/"+postID+"/categories/"+petsID, authorID, nil)
+	checkResponseCode(t, http.StatusNoContent, resp.Code)
+	resp = authedRequest(t, "PUT", "/posts/"+postID+"/categories/"+samplePostID, authorID, nil)
+	checkResponseCode(t, http.StatusUnprocessableEntity, resp.Code)
+	resp = authedRequest(t, "GET", "/posts/"+postID+"/categories", "", nil)
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &list))
+	require.Equal(t, []*models.Category{{ID: petsID, Name: "pets", ParentID: animalsID}}, list.Categories)
+
+	require.Equal(t, []string{postID}, feedIDs(t, authedRequest(t, "GET", "/categories/"+animalsID+"/posts", "", nil)))
+	require.Equal(t, []string{postID}, feedIDs(t, authedRequest(t, "GET", "/feed?category="+petsID, "", nil)))
+	resp = authedRequest(t, "GET", "/categories/"+samplePostID+"/posts", "", nil)
+	checkResponseCode(t, http.StatusNotFound, resp.Code)
+
+	resp = authedRequest(t, "DELETE", "/categories/"+animalsID, adminID, nil)
+	checkResponseCode(t, http.StatusConflict, resp.Code)
+	resp = authedRequest(t, "DELETE", "/categories/"+petsID, adminID, nil)
+	checkResponseCode(t, http.StatusNoContent, resp.Code)
+	require.Empty(t, feedIDs(t, authedRequest(t, "GET", "/categories/"+animalsID+"/posts", "", nil)))
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gavinc95/go-blog/auth"
+	"github.com/gavinc95/go-blog/db"
+	"github.com/gavinc95/go-blog/db/models"
+	"golang.org/x/xerrors"
+)
+
+type TokenRequest struct {
+	Email    string `json:"email"`    // required
+	Password string `json:"password"` // required
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"` // required
+}
+
+// TokenResponse follows the shape of an OAuth 2.0 token response (RFC 6749)
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"` // seconds
+	RefreshToken string `json:"refresh_token"`
+}
+
+// withBearerToken authenticates requests that carry an access token in an
+// "Authorization: Bearer" header, for clients that can't use the session
+// cookie. A bad token is rejected rather than treated as anonymous, so that
+// clients know to refresh it.
+func (a *App) withBearerToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if a.TokenKeys == nil {
+			writeBearerError(w, r, "bearer tokens are not enabled")
+			return
+		}
+
+		userID, err := a.TokenKeys.VerifyAccessToken(token, a.Config.Auth.JWTIssuer)
+		if err != nil {
+			writeBearerError(w, r, auth.ErrInvalidToken.Error())
+			return
+		}
+
+		next.ServeHTTP(w, withUserID(r, userID))
+	})
+}
+
+// bearerToken returns the token from the request's Authorization header
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	const prefix = "bearer "
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(header[len(prefix):]), true
+}
+
+func writeBearerError(w http.ResponseWriter, r *http.Request, message string) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, message)
+}
+
+// issueTokens returns a new access token and refresh token for the user. If
+// oldID is set, that refresh token is exchanged for the new one.
+func (a *App) issueTokens(userID, oldID string) (*TokenResponse, error) {
+	accessToken, expiresAt, err := a.TokenKeys.IssueAccessToken(userID, a.Config.Auth.JWTIssuer, a.Config.Auth.AccessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := auth.NewToken()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	stored := &models.RefreshToken{
+		ID:        auth.TokenID(refreshToken),
+		UserID:    userID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(a.Config.Auth.RefreshTokenTTL),
+	}
+	if oldID == "" {
+		err = a.BlogStore.CreateRefreshToken(stored)
+	} else {
+		err = a.BlogStore.RotateRefreshToken(oldID, stored, now)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(time.Until(expiresAt).Round(time.Second).Seconds()),
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// tokensEnabled writes a 404 unless bearer tokens have been configured
+func (a *App) tokensEnabled(w http.ResponseWriter, r *http.Request) bool {
+	if a.TokenKeys == nil {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "bearer tokens are not enabled")
+		return false
+	}
+	return true
+}
+
+// HandleToken exchanges an email and password for an access token and a
+// refresh token
+func (a *App) HandleToken(w http.ResponseWriter, r *http.Request) {
+	if !a.tokensEnabled(w, r) {
+		return
+	}
+
+	var req TokenRequest
+	err := decodeRequest(r, &req)
+	if err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	// validate the request
+	var v validator
+	v.required("email", req.Email)
+	v.required("password", req.Password)
+	if v.failed() {
+		writeValidationError(w, r, v.details)
+		return
+	}
+
+	user, ok := a.checkCredentials(w, r, req.Email, req.Password)
+	if !ok {
+		return
+	}
+
+	res, err := a.issueTokens(user.ID, "")
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, res)
+}
+
+// HandleRefreshToken exchanges a refresh token for a new access token and a
+// new refresh token. Each refresh token works once: presenting one that has
+// already been used means it has leaked, so every token the user holds is
+// revoked.
+func (a *App) HandleRefreshToken(w http.ResponseWriter, r *http.Request) {
+	if !a.tokensEnabled(w, r) {
+		return
+	}
+
+	var req RefreshTokenRequest
+	err := decodeRequest(r, &req)
+	if err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	// validate the request
+	var v validator
+	v.required("refresh_token", req.RefreshToken)
+	if v.failed() {
+		writeValidationError(w, r, v.details)
+		return
+	}
+
+	id := auth.TokenID(req.RefreshToken)
+	stored, err := a.BlogStore.GetRefreshToken(id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if stored == nil || !time.Now().Before(stored.ExpiresAt) {
+		writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, "invalid or expired refresh token")
+		return
+	}
+	if stored.RevokedAt != nil {
+		a.revokeAllTokens(w, r, stored.UserID)
+		return
+	}
+
+	res, err := a.issueTokens(stored.UserID, id)
+	if xerrors.Is(err, db.ErrNotFound) {
+		// revoked since we looked it up, by a concurrent refresh
+		a.revokeAllTokens(w, r, stored.UserID)
+		return
+	}
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, res)
+}
+
+// revokeAllTokens handles the reuse of a revoked refresh token
+func (a *App) revokeAllTokens(w http.ResponseWriter, r *http.Request, userID string) {
+	logError(r, xerrors.Errorf("revoked refresh token reused, revoking all tokens for user %s", userID))
+	if err := a.BlogStore.RevokeUserRefreshTokens(userID, time.Now().UTC()); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, "invalid or expired refresh token")
+}
+
+// HandleRevokeToken revokes a refresh token, e.g. when the user logs out of
+// the app. Like RFC 7009, it succeeds for tokens that are already invalid.
+func (a *App) HandleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	if !a.tokensEnabled(w, r) {
+		return
+	}
+
+	var req RefreshTokenRequest
+	err := decodeRequest(r, &req)
+	if err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	// validate the request
+	var v validator
+	v.required("refresh_token", req.RefreshToken)
+	if v.failed() {
+		writeValidationError(w, r, v.details)
+		return
+	}
+
+	err = a.BlogStore.RevokeRefreshToken(auth.TokenID(req.RefreshToken), time.Now().UTC())
+	if err != nil && !xerrors.Is(err, db.ErrNotFound) {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
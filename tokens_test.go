@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func requestTokens(t *testing.T, path string, body interface{}) TokenResponse {
+	resp := authRequest(t, "POST", path, body, nil)
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	var res TokenResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &res))
+	require.Equal(t, "Bearer", res.TokenType)
+	require.NotEmpty(t, res.AccessToken)
+	require.NotEmpty(t, res.RefreshToken)
+	return res
+}
+
+func bearerRequest(t *testing.T, path, token string) int {
+	req, err := http.NewRequest("GET", path, nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return executeRequest(req).Code
+}
+
+func TestBearerTokens(t *testing.T) {
+	clearTable()
+	registerTestUser(t)
+
+	tokens := requestTokens(t, "/auth/token", &TokenRequest{Email: "tiny@cat.com", Password: "correct horse"})
+	require.Equal(t, int(app.Config.Auth.AccessTokenTTL.Seconds()), tokens.ExpiresIn)
+	checkResponseCode(t, http.StatusOK, bearerRequest(t, "/auth/me", tokens.AccessToken))
+
+	resp := authRequest(t, "POST", "/auth/token", &TokenRequest{Email: "tiny@cat.com", Password: "battery staple"}, nil)
+	checkResponseCode(t, http.StatusUnauthorized, resp.Code)
+
+	// a bad token is an error, not an anonymous request
+	req, err := http.NewRequest("GET", "/auth/me", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken+"x")
+	resp = executeRequest(req)
+	checkResponseCode(t, http.StatusUnauthorized, resp.Code)
+	requireErrorCode(t, resp, CodeUnauthorized)
+	require.Contains(t, resp.Header().Get("WWW-Authenticate"), "invalid_token")
+}
+
+func TestRefreshTokens(t *testing.T) {
+	clearTable()
+	registerTestUser(t)
+
+	first := requestTokens(t, "/auth/token", &TokenRequest{Email: "tiny@cat.com", Password: "correct horse"})
+	second := requestTokens(t, "/auth/token/refresh", &RefreshTokenRequest{RefreshToken: first.RefreshToken})
+	require.NotEqual(t, first.RefreshToken, second.RefreshToken)
+	checkResponseCode(t, http.StatusOK, bearerRequest(t, "/auth/me", second.AccessToken))
+
+	// reusing a refresh token revokes every token the user has
+	resp := authRequest(t, "POST", "/auth/token/refresh", &RefreshTokenRequest{RefreshToken: first.RefreshToken}, nil)
+	checkResponseCode(t, http.StatusUnauthorized, resp.Code)
+	resp = authRequest(t, "POST", "/auth/token/refresh", &RefreshTokenRequest{RefreshToken: second.RefreshToken}, nil)
+	checkResponseCode(t, http.StatusUnauthorized, resp.Code)
+
+	third := requestTokens(t, "/auth/token", &TokenRequest{Email: "tiny@cat.com", Password: "correct horse"})
+	resp = authRequest(t, "POST", "/auth/token/revoke", &RefreshTokenRequest{RefreshToken: third.RefreshToken}, nil)
+	checkResponseCode(t, http.StatusNoContent, resp.Code)
+	resp = authRequest(t, "POST", "/auth/token/revoke", &RefreshTokenRequest{RefreshToken: third.RefreshToken}, nil)
+	checkResponseCode(t, http.StatusNoContent, resp.Code)
+	resp = authRequest(t, "POST", "/auth/token/refresh", &RefreshTokenRequest{RefreshToken: third.RefreshToken}, nil)
+	checkResponseCode(t, http.StatusUnauthorized, resp.Code)
+
+	resp = authRequest(t, "POST", "/auth/token/refresh", &RefreshTokenRequest{RefreshToken: "made-up"}, nil)
+	checkResponseCode(t, http.StatusUnauthorized, resp.Code)
+	requireErrorCode(t, resp, CodeUnauthorized)
+}
+
+func TestBearerTokens_Disabled(t *testing.T) {
+	clearTable()
+	keys := app.TokenKeys
+	app.TokenKeys = nil
+	defer func() { app.TokenKeys = keys }()
+
+	resp := authRequest(t, "POST", "/auth/token", &TokenRequest{Email: "tiny@cat.com", Password: "correct horse"}, nil)
+	checkResponseCode(t, http.StatusNotFound, resp.Code)
+	checkResponseCode(t, http.StatusUnauthorized, bearerRequest(t, "/auth/me", "anything"))
+}
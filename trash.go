@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gavinc95/go-blog/authz"
+	"github.com/gavinc95/go-blog/db/models"
+	"github.com/gorilla/mux"
+)
+
+type GetTrashResponse struct {
+	Users []*models.User `json:"users"`
+	Posts []*models.Post `json:"posts"`
+}
+
+type RestoreResponse struct {
+	ID string `json:"id"`
+}
+
+// HandleGetTrash lists what the caller could restore: their own deleted
+// posts, everyone's if they can delete any post, and deleted users if they
+// can delete any user
+func (a *App) HandleGetTrash(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+	if !a.authorize(w, r, authz.Delete, authz.UserPosts(userID)) {
+		return
+	}
+
+	anyPost, err := a.can(r, authz.Delete, authz.AllPosts())
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	anyUser, err := a.can(r, authz.Delete, authz.AllUsers())
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	res := GetTrashResponse{Users: []*models.User{}, Posts: []*models.Post{}}
+	if anyPost {
+		userID = ""
+	}
+	posts, err := a.BlogStore.GetDeletedPosts(userID)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if posts != nil {
+		res.Posts = posts
+	}
+	if anyUser {
+		users, err := a.BlogStore.GetDeletedUsers()
+		if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		if users != nil {
+			res.Users = users
+		}
+	}
+	writeJSON(w, r, http.StatusOK, res)
+}
+
+// HandleRestorePost takes a post out of the trash. Whoever could delete it
+// can restore it, but not while its author is deleted.
+func (a *App) HandleRestorePost(w http.ResponseWriter, r *http.Request) {
+	postID := mux.Vars(r)["id"]
+
+	post, err := a.BlogStore.GetDeletedPost(postID)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if post == nil {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "post not found in the trash")
+		return
+	}
+	if !a.authorize(w, r, authz.Delete, authz.Post(post)) {
+		return
+	}
+
+	id, err := a.BlogStore.RestorePost(postID)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	res := RestoreResponse{ID: id}
+	writeJSON(w, r, http.StatusOK, res)
+}
+
+// HandleRestoreUser takes a user out of the trash along with the posts that
+// were deleted with them. A deleted user can't log in, so in practice only
+// those who can delete any user can restore one.
+func (a *App) HandleRestoreUser(w http.ResponseWriter, r *http.Request) {
+	userID := mux.Vars(r)["id"]
+	if !a.authorize(w, r, authz.Delete, authz.User(userID)) {
+		return
+	}
+
+	id, err := a.BlogStore.RestoreUser(userID)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	res := RestoreResponse{ID: id}
+	writeJSON(w, r, http.StatusOK, res)
+}
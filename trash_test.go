@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gavinc95/go-blog/db/models"
+	"github.com/stretchr/testify/require"
+)
+
+func getTrash(t *testing.T, userID string) GetTrashResponse {
+	resp := authedRequest(t, "GET", "/trash", userID, nil)
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	var res GetTrashResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &res))
+	return res
+}
+
+func TestTrash(t *testing.T) {
+	clearTable()
+
+	authorID, err := app.BlogStore.CreateUser("tiny cat", "tiny@cat.com", "author")
+	require.NoError(t, err)
+	otherID, err := app.BlogStore.CreateUser("other cat", "other@cat.com", "author")
+	require.NoError(t, err)
+	adminID := createTestAdmin(t)
+	postID, err := app.BlogStore.CreatePost(authorID, "title", "content", models.FormatPlain, models.PostPublished, time.Time{})
+	require.NoError(t, err)
+	otherPostID, err := app.BlogStore.CreatePost(otherID, "other", "content", models.FormatPlain, models.PostPublished, time.Time{})
+	require.NoError(t, err)
+
+	resp := authedRequest(t, "DELETE", "/posts/"+postID, authorID, nil)
+	checkResponseCode(t, http.StatusNoContent, resp.Code)
+	resp = authedRequest(t, "DELETE", "/posts/"+otherPostID, otherID, nil)
+	checkResponseCode(t, http.StatusNoContent, resp.Code)
+	resp = getTestPost(t, postID)
+	checkResponseCode(t, http.StatusNotFound, resp.Code)
+
+	// authors see their own deleted posts, admins everything
+	resp = authedRequest(t, "GET", "/trash", "", nil)
+	checkResponseCode(t, http.StatusUnauthorized, resp.Code)
+	trash := getTrash(t, authorID)
+	require.Len(t, trash.Posts, 1)
+	require.Equal(t, postID, trash.Posts[0].ID)
+	require.NotNil(t, trash.Posts[0].DeletedAt)
+	require.Empty(t, trash.Users)
+	require.Len(t, getTrash(t, adminID).Posts, 2)
+
+	resp = authedRequest(t, "POST", "/posts/"+otherPostID+"/restore", authorID, nil)
+	checkResponseCode(t, http.StatusForbidden, resp.Code)
+	resp = authedRequest(t, "POST", "/posts/"+postID+"/restore", authorID, nil)
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	resp = getTestPost(t, postID)
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	resp = authedRequest(t, "POST", "/posts/"+postID+"/restore", authorID, nil)
+	checkResponseCode(t, http.StatusNotFound, resp.Code)
+	requireErrorCode(t, resp, CodeNotFound)
+
+	// a deleted user takes their posts with them, and comes back with them
+	resp = authedRequest(t, "DELETE", "/users/"+authorID, adminID, nil)
+	checkResponseCode(t, http.StatusNoContent, resp.Code)
+	resp = getTestUser(t, authorID)
+	checkResponseCode(t, http.StatusNotFound, resp.Code)
+	trash = getTrash(t, adminID)
+	require.Len(t, trash.Users, 1)
+	require.Equal(t, authorID, trash.Users[0].ID)
+	require.Len(t, trash.Posts, 2)
+
+	resp = authedRequest(t, "POST", "/posts/"+postID+"/restore", adminID, nil)
+	checkResponseCode(t, http.StatusConflict, resp.Code)
+	resp = authedRequest(t, "POST", "/users/"+authorID+"/restore", otherID, nil)
+	checkResponseCode(t, http.StatusForbidden, resp.Code)
+	resp = authedRequest(t, "POST", "/users/"+authorID+"/restore", adminID, nil)
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	resp = getTestUser(t, authorID)
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	resp = getTestPost(t, postID)
+	checkResponseCode(t, http.StatusOK, resp.Code)
+	resp = authedRequest(t, "POST", "/users/"+authorID+"/restore", adminID, nil)
+	checkResponseCode(t, http.StatusNotFound, resp.Code)
+}